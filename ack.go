@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// The callback ID of the buttons added to Slack alerts for acknowledging them
+const slackAckCallbackID = "consul-alerting-ack"
+
+// How old a signed Slack request can be before it's rejected, to stop replays
+const slackSignatureMaxAge = 5 * time.Minute
+
+// AlertAck records that an incident was acknowledged from a chat or paging tool. It's
+// stored next to the alert state, and only applies while the incident it names is open.
+type AlertAck struct {
+	IncidentID string    `json:"incident_id"`
+	By         string    `json:"by"`
+	Source     string    `json:"source"`
+	At         time.Time `json:"at"`
+}
+
+// Returns the KV path the ack for an alert is stored at, given the alert's path
+func ackKVPath(alertPath string) string {
+	return strings.TrimSuffix(alertPath, "alert") + "ack"
+}
+
+// Records an ack for the open incident with the given ID, which can be the incident's ID or
+// the key it was sent to PagerDuty with. Returns false if no open incident has that ID.
+func ackIncident(client *api.Client, incidentID, by, source string, now time.Time) (bool, error) {
+	paths, err := findAlertPaths(client)
+	if err != nil {
+		return false, err
+	}
+
+	for _, path := range paths {
+		alert, err := getAlertState(path, client)
+		if err != nil {
+			return false, err
+		}
+		if alert == nil || alert.Status == api.HealthPassing || alert.IncidentID == "" {
+			continue
+		}
+		if alert.IncidentID != incidentID && pagerdutyIncidentKey(alert) != incidentID {
+			continue
+		}
+
+		ack := &AlertAck{IncidentID: alert.IncidentID, By: by, Source: source, At: now}
+		serialized, err := json.Marshal(ack)
+		if err != nil {
+			return false, err
+		}
+		if _, err := client.KV().Put(&api.KVPair{Key: ackKVPath(path), Value: serialized}, nil); err != nil {
+			return false, fmt.Errorf("Error storing ack: %s", err)
+		}
+		log.Infof("Incident for '%s' acknowledged by %s from %s", alert.Message, by, source)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Returns the ack for the alert's current incident, or nil if it hasn't been acknowledged
+func getAlertAck(client *api.Client, alertPath string, alert *AlertState) (*AlertAck, error) {
+	pair, _, err := client.KV().Get(ackKVPath(alertPath), nil)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+
+	var ack AlertAck
+	if err := json.Unmarshal(pair.Value, &ack); err != nil {
+		return nil, err
+	}
+	if alert.IncidentID == "" || ack.IncidentID != alert.IncidentID {
+		return nil, nil
+	}
+	return &ack, nil
+}
+
+// Reads a callback's body, giving up on bodies over 1MB
+func readCallbackBody(r *http.Request) ([]byte, error) {
+	return ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+}
+
+// Returns true if the request has a valid Slack signature for the body, made with the
+// signing secret less than slackSignatureMaxAge ago
+func verifySlackSignature(secret string, r *http.Request, body []byte, now time.Time) bool {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || math.Abs(now.Sub(time.Unix(seconds, 0)).Seconds()) > slackSignatureMaxAge.Seconds() {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature")))
+}
+
+// Returns true if any of the signatures in a PagerDuty webhook's signature header are
+// valid for the body. There can be more than one while the secret is being rotated.
+func verifyPagerDutySignature(secret, header string, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "v1=" + hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range strings.Split(header, ",") {
+		if hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature))) {
+			return true
+		}
+	}
+	return false
+}
+
+// The parts of a Slack interactive message or block actions payload we use
+type slackActionPayload struct {
+	CallbackID string `json:"callback_id"`
+	User       struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// Returns the endpoint Slack calls when an Acknowledge button on an alert is clicked
+func slackAckEndpoint(config *Config, client *api.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := readCallbackBody(r)
+		if err != nil || !verifySlackSignature(config.AckSlackSigningSecret, r, body, time.Now()) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		form, err := url.ParseQuery(string(body))
+		var payload slackActionPayload
+		if err == nil {
+			err = json.Unmarshal([]byte(form.Get("payload")), &payload)
+		}
+		if err != nil || len(payload.Actions) == 0 {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		action := payload.Actions[0]
+		if payload.CallbackID != slackAckCallbackID && action.ActionID != slackAckCallbackID {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		by := payload.User.Name
+		if by == "" {
+			by = payload.User.Username
+		}
+
+		text := fmt.Sprintf("Acknowledged by %s", by)
+		if ok, err := ackIncident(client, action.Value, by, "slack", time.Now()); err != nil {
+			log.Errorf("Error acknowledging incident %s from Slack: %s", action.Value, err)
+			http.Error(w, "error storing ack", http.StatusInternalServerError)
+			return
+		} else if !ok {
+			text = "This incident has already been resolved"
+		}
+		writeJSON(w, map[string]interface{}{
+			"response_type":    "in_channel",
+			"replace_original": false,
+			"text":             text,
+		}, true)
+	}
+}
+
+// The parts of a PagerDuty V3 webhook we use
+type pagerDutyWebhook struct {
+	Event struct {
+		EventType string `json:"event_type"`
+		Agent     struct {
+			Summary string `json:"summary"`
+		} `json:"agent"`
+		Data struct {
+			IncidentKey string `json:"incident_key"`
+		} `json:"data"`
+	} `json:"event"`
+}
+
+// Returns the endpoint PagerDuty's V3 webhooks are sent to. Only incident.acknowledged
+// events are acted on.
+func pagerDutyAckEndpoint(config *Config, client *api.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := readCallbackBody(r)
+		if err != nil || !verifyPagerDutySignature(config.AckPagerDutySecret, r.Header.Get("X-PagerDuty-Signature"), body) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var webhook pagerDutyWebhook
+		if err := json.Unmarshal(body, &webhook); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		event := webhook.Event
+		if event.EventType != "incident.acknowledged" || event.Data.IncidentKey == "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if _, err := ackIncident(client, event.Data.IncidentKey, event.Agent.Summary, "pagerduty", time.Now()); err != nil {
+			log.Errorf("Error acknowledging incident %s from PagerDuty: %s", event.Data.IncidentKey, err)
+			http.Error(w, "error storing ack", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
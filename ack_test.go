@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a Slack callback request for the payload, signed with the secret at the given time
+func testSlackCallback(secret, payload string, at time.Time) *http.Request {
+	body := url.Values{"payload": {payload}}.Encode()
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	req := httptest.NewRequest("POST", "/v1/ack/slack", strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestAck_signatures(t *testing.T) {
+	now := time.Now()
+	req := testSlackCallback("secret", "{}", now)
+	body := []byte(url.Values{"payload": {"{}"}}.Encode())
+	if !verifySlackSignature("secret", req, body, now) {
+		t.Error("expected a valid Slack signature")
+	}
+	if verifySlackSignature("other", req, body, now) {
+		t.Error("expected a signature with the wrong secret to be rejected")
+	}
+	if verifySlackSignature("secret", req, body, now.Add(10*time.Minute)) {
+		t.Error("expected an old signature to be rejected")
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(`{"event":{}}`))
+	header := "v1=0000, v1=" + hex.EncodeToString(mac.Sum(nil))
+	if !verifyPagerDutySignature("secret", header, []byte(`{"event":{}}`)) {
+		t.Error("expected a valid PagerDuty signature")
+	}
+	if verifyPagerDutySignature("secret", "v1=0000", []byte(`{"event":{}}`)) {
+		t.Error("expected an invalid PagerDuty signature to be rejected")
+	}
+}
+
+// Make sure clicking Acknowledge in Slack records an ack for the alert's open incident
+func TestAck_slack(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	path := alertingKVRoot + "/service/redis/alert"
+	alert := &AlertState{Service: "redis", Status: "critical", LastAlerted: "critical", IncidentID: "abc"}
+	setAlertState(path, alert, client)
+
+	config := &Config{AckSlackSigningSecret: "secret"}
+	endpoint := slackAckEndpoint(config, client)
+	payload := `{"callback_id":"consul-alerting-ack","user":{"name":"alice"},"actions":[{"name":"ack","value":"abc"}]}`
+
+	w := httptest.NewRecorder()
+	endpoint(w, testSlackCallback("wrong", payload, time.Now()))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an unsigned callback to be rejected, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	endpoint(w, testSlackCallback("secret", payload, time.Now()))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Acknowledged by alice") {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	ack, err := getAlertAck(client, path, alert)
+	if err != nil || ack == nil || ack.By != "alice" || ack.Source != "slack" {
+		t.Fatalf("expected an ack by alice, got %v %v", ack, err)
+	}
+
+	// The ack doesn't carry over to the next incident
+	alert.IncidentID = "def"
+	if ack, _ := getAlertAck(client, path, alert); ack != nil {
+		t.Error("expected the ack not to apply to a new incident")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// Returns true if the error is Consul refusing a request because our ACL token doesn't
+// have permission for it
+func isPermissionDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(err.Error(), "Unexpected response code: 403") || strings.Contains(err.Error(), "Permission denied")
+}
+
+// The requests for a watch that Consul's ACLs can deny, tracked separately so one being
+// allowed doesn't clear the other being denied
+const (
+	aclSourceLock  = "lock"
+	aclSourceQuery = "query"
+)
+
+// Marks the given source of the watch's requests as being blocked by ACLs, sending a
+// meta-alert about it the first time so the missing permission gets noticed. For the query,
+// only the instance holding the watch's lock sends it, since every instance sees the same
+// error. Nobody can hold the lock while it's being denied, so each instance denied it sends
+// its own.
+func (s *WatchState) permissionDenied(source string, err error, opts *WatchOptions) {
+	s.Lock()
+	_, alreadyDenied := s.denied[source]
+	s.denied[source] = err.Error()
+	s.Error = err.Error()
+	sendAlert := (source == aclSourceLock || s.leader()) && !s.deniedAlerted[source]
+	if sendAlert {
+		s.deniedAlerted[source] = true
+	}
+	s.Unlock()
+
+	if !alreadyDenied {
+		log.Errorf("Consul denied access to the %s for %s, check the ACL token's policy: %s", source, s.Name, err)
+	}
+	if sendAlert {
+		s.sendMetaAlert(opts, api.HealthWarning, fmt.Sprintf("[%s] Unable to monitor %s, permission denied by Consul ACLs", opts.config.ConsulDatacenter, s.Name), err.Error())
+	}
+}
+
+// Clears the ACL error for the given source of the watch's requests if it had one, letting
+// the handlers know monitoring has resumed if they were told it stopped and nothing else
+// they were told about is still denied
+func (s *WatchState) permissionRestored(source string, opts *WatchOptions) {
+	s.Lock()
+	_, wasDenied := s.denied[source]
+	delete(s.denied, source)
+	s.Error = ""
+	for _, err := range s.denied {
+		s.Error = err
+	}
+	sendAlert := s.deniedAlerted[source]
+	delete(s.deniedAlerted, source)
+	for other := range s.deniedAlerted {
+		if _, stillDenied := s.denied[other]; stillDenied {
+			sendAlert = false
+		}
+	}
+	s.Unlock()
+
+	if wasDenied {
+		log.Infof("Consul is allowing access to the %s for %s again", source, s.Name)
+	}
+	if sendAlert {
+		s.sendMetaAlert(opts, api.HealthPassing, fmt.Sprintf("[%s] Monitoring of %s has resumed", opts.config.ConsulDatacenter, s.Name), "")
+	}
+}
+
+// Sends an alert about the watch itself, rather than the health of the node/service, to
+// the handlers for the watch. Alerts are sent in the background, in the order they were
+// queued.
+func (s *WatchState) sendMetaAlert(opts *WatchOptions, status string, message string, details string) {
+	alert := &AlertState{
+		Status:     status,
+		Node:       opts.node,
+		Service:    opts.service,
+		Tag:        opts.tag,
+		Datacenter: opts.config.ConsulDatacenter,
+		Message:    message,
+		Details:    details,
+		EventID:    alertEventID(watchKVPath(opts.node, opts.service, opts.tag)+"meta", time.Now().UnixNano(), status),
+		Meta:       true,
+	}
+
+	s.metaLock.Lock()
+	defer s.metaLock.Unlock()
+
+	s.metaQueue = append(s.metaQueue, alert)
+	if s.metaSending {
+		return
+	}
+	s.metaSending = true
+
+	go func() {
+		for {
+			s.metaLock.Lock()
+			if len(s.metaQueue) == 0 {
+				s.metaSending = false
+				s.metaLock.Unlock()
+				return
+			}
+			next := s.metaQueue[0]
+			s.metaQueue = s.metaQueue[1:]
+			s.metaLock.Unlock()
+
+			sendAlert(opts.config, opts.service, next, func(string) {})
+		}
+	}()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestACL_isPermissionDenied(t *testing.T) {
+	if !isPermissionDenied(errors.New("Unexpected response code: 403 (Permission denied)")) {
+		t.Error("expected a 403 to be treated as permission denied")
+	}
+	if isPermissionDenied(errors.New("Unexpected response code: 500 (No cluster leader)")) {
+		t.Error("expected a 500 not to be treated as permission denied")
+	}
+	if isPermissionDenied(nil) {
+		t.Error("expected nil not to be treated as permission denied")
+	}
+}
+
+// Make sure a meta-alert is sent once when a watch is denied access, and again when
+// access is restored
+func TestACL_metaAlerts(t *testing.T) {
+	alertCh := make(chan *AlertState, 2)
+	opts := &WatchOptions{
+		service: "redis",
+		config:  &Config{ConsulDatacenter: "dc1", Handlers: map[string]AlertHandler{"test": testHandler{alertCh}}},
+	}
+	state := newWatchState(watchName("", "redis", ""), "", "redis", "")
+	state.lock = &LockHelper{}
+
+	// Only the instance holding the lock should send meta-alerts
+	denied := errors.New("Unexpected response code: 403 (Permission denied)")
+	state.permissionDenied(aclSourceQuery, denied, opts)
+	select {
+	case alert := <-alertCh:
+		t.Fatalf("expected no meta-alert without the lock, got %q", alert.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	state.lock.acquired = true
+	state.permissionDenied(aclSourceQuery, denied, opts)
+	state.permissionDenied(aclSourceQuery, denied, opts)
+
+	if snapshot := state.snapshot(); snapshot.Error != denied.Error() {
+		t.Errorf("expected error to be shown in the status, got %q", snapshot.Error)
+	}
+
+	expected := []string{"warning", "passing"}
+	state.permissionRestored(aclSourceQuery, opts)
+	for _, status := range expected {
+		select {
+		case alert := <-alertCh:
+			if alert.Status != status || !alert.Meta {
+				t.Errorf("expected %s meta-alert, got %s (meta: %v)", status, alert.Status, alert.Meta)
+			}
+			if alertTargetKey(alert) != "redis---meta" {
+				t.Errorf("expected meta-alert to have its own key, got %s", alertTargetKey(alert))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s meta-alert", status)
+		}
+	}
+
+	select {
+	case alert := <-alertCh:
+		t.Fatalf("expected only one meta-alert per transition, got %q", alert.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Make sure the lock being denied is alerted on without anyone holding the lock, and isn't
+// resolved by the watch's queries succeeding
+func TestACL_lockDenied(t *testing.T) {
+	alertCh := make(chan *AlertState, 2)
+	opts := &WatchOptions{
+		service: "redis",
+		config:  &Config{ConsulDatacenter: "dc1", Handlers: map[string]AlertHandler{"test": testHandler{alertCh}}},
+	}
+	state := newWatchState(watchName("", "redis", ""), "", "redis", "")
+	state.lock = &LockHelper{}
+
+	denied := errors.New("Unexpected response code: 403 (Permission denied)")
+	state.permissionDenied(aclSourceLock, denied, opts)
+	select {
+	case alert := <-alertCh:
+		if alert.Status != "warning" {
+			t.Fatalf("expected a warning meta-alert, got %s", alert.Status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a meta-alert for the denied lock")
+	}
+
+	state.permissionRestored(aclSourceQuery, opts)
+	select {
+	case alert := <-alertCh:
+		t.Fatalf("expected the lock denial not to be resolved by a query, got %q", alert.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if snapshot := state.snapshot(); snapshot.Error != denied.Error() {
+		t.Errorf("expected the lock error to still be shown in the status, got %q", snapshot.Error)
+	}
+
+	state.permissionRestored(aclSourceLock, opts)
+	select {
+	case alert := <-alertCh:
+		if alert.Status != "passing" {
+			t.Fatalf("expected a passing meta-alert, got %s", alert.Status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a meta-alert once the lock is allowed again")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Returns the percentage of a service's instances that have to be failing before it's
+// alerted on, or 0 if any failing instance is alerted on
+func (c *Config) serviceFailingPercent(service string) int {
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		return serviceConfig.FailingPercent
+	}
+	return 0
+}
+
+// Computes the health of a node/service from its node/checkID statuses. If the service has
+// a failing_percent, it's only unhealthy while more than that percentage of its instances
+// are failing, in which case it has the worst of their statuses.
+func (c *Config) serviceHealth(service string, checks map[string]string) string {
+	percent := c.serviceFailingPercent(service)
+	if service == "" || percent <= 0 {
+		return computeHealth(checks)
+	}
+
+	failing, total := instanceCounts(checks)
+	if total == 0 || failing*100 <= percent*total {
+		return api.HealthPassing
+	}
+	return computeHealth(checks)
+}
+
+// Returns the number of instances with a failing check and the total number of instances,
+// given the statuses of their checks keyed by node/checkID. Instances are told apart by
+// node.
+func instanceCounts(checks map[string]string) (failing, total int) {
+	instances := make(map[string]map[string]string)
+	for key, status := range checks {
+		node := strings.SplitN(key, "/", 2)[0]
+		if instances[node] == nil {
+			instances[node] = make(map[string]string)
+		}
+		instances[node][key] = status
+	}
+
+	for _, instanceChecks := range instances {
+		if computeHealth(instanceChecks) != api.HealthPassing {
+			failing++
+		}
+	}
+	return failing, len(instances)
+}
+
+// Returns a line for a service alert's details with how many of its instances are failing,
+// if the service has a failing_percent
+func aggregateDetails(config *Config, service string, checks []*api.HealthCheck) string {
+	if config.serviceFailingPercent(service) <= 0 {
+		return ""
+	}
+
+	statuses := make(map[string]string)
+	for _, check := range checks {
+		statuses[check.Node+"/"+check.CheckID] = check.Status
+	}
+	failing, total := instanceCounts(statuses)
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d of %d instances failing (%d%%, alerting above %d%%)", failing, total, failing*100/total, config.serviceFailingPercent(service))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestAggregate_serviceHealth(t *testing.T) {
+	config, err := ParseConfig(`
+	service "web" {
+		failing_percent = 40
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		checks   map[string]string
+		expected string
+	}{
+		// 1 of 5 instances failing
+		{map[string]string{"n1/web": "critical", "n2/web": "passing", "n3/web": "passing", "n4/web": "passing", "n5/web": "passing"}, "passing"},
+		// 2 of 5 is at the limit, not above it
+		{map[string]string{"n1/web": "critical", "n2/web": "warning", "n3/web": "passing", "n4/web": "passing", "n5/web": "passing"}, "passing"},
+		// 3 of 5, with the worst status among them
+		{map[string]string{"n1/web": "critical", "n2/web": "warning", "n3/web": "warning", "n4/web": "passing", "n5/web": "passing"}, "critical"},
+		{map[string]string{"n1/web": "warning", "n1/disk": "passing", "n2/web": "passing", "n2/disk": "warning"}, "warning"},
+		{map[string]string{}, "passing"},
+	}
+	for _, c := range cases {
+		if health := config.serviceHealth("web", c.checks); health != c.expected {
+			t.Errorf("expected %s for %v, got %s", c.expected, c.checks, health)
+		}
+	}
+
+	// Other services and nodes still alert on any failing check
+	if health := config.serviceHealth("redis", cases[0].checks); health != api.HealthCritical {
+		t.Errorf("expected critical for a service without failing_percent, got %s", health)
+	}
+	if health := config.serviceHealth("", cases[0].checks); health != api.HealthCritical {
+		t.Errorf("expected critical for a node, got %s", health)
+	}
+
+	if _, err := ParseConfig(`service "web" { failing_percent = 100 }`); err == nil || !strings.Contains(err.Error(), "failing_percent") {
+		t.Errorf("expected an error for failing_percent = 100, got %v", err)
+	}
+}
+
+func TestAggregate_details(t *testing.T) {
+	config, err := ParseConfig(`
+	service "web" {
+		failing_percent = 25
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []*api.HealthCheck{
+		{Node: "n1", CheckID: "service:web", Status: "critical"},
+		{Node: "n2", CheckID: "service:web", Status: "passing"},
+		{Node: "n3", CheckID: "service:web", Status: "passing"},
+	}
+	expected := "1 of 3 instances failing (33%, alerting above 25%)"
+	if details := aggregateDetails(config, "web", checks); details != expected {
+		t.Errorf("expected %q, got %q", expected, details)
+	}
+	if details := aggregateDetails(config, "redis", checks); details != "" {
+		t.Errorf("expected no details for a service without failing_percent, got %q", details)
+	}
+}
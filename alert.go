@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"regexp"
 	"strings"
 	"time"
+	"unicode"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/hashicorp/consul/api"
@@ -15,10 +19,177 @@ type AlertState struct {
 	Node        string `json:"node"`
 	Service     string `json:"service"`
 	Tag         string `json:"tag"`
+	Job         string `json:"job,omitempty"`
+	Datacenter  string `json:"datacenter"`
 	UpdateIndex int64  `json:"update_index"`
 	LastAlerted string `json:"last_alerted"`
 	Message     string `json:"message"`
 	Details     string `json:"details"`
+	Link        string `json:"link,omitempty"`
+
+	// The alert's severity (ok, warning, critical or unknown), from its status or the
+	// severity rules matching its checks, and the severity of the last alert sent
+	Severity     string `json:"severity,omitempty"`
+	LastSeverity string `json:"last_severity,omitempty"`
+
+	// The alert's category (e.g. "disk"), from the output rules matching its checks, and the
+	// category of the last alert sent
+	Category     string `json:"category,omitempty"`
+	LastCategory string `json:"last_category,omitempty"`
+
+	// The checks that were failing when the alert was triggered
+	Checks []AlertCheck `json:"checks,omitempty"`
+
+	// When the last alert for the node/service was sent
+	LastAlertedAt time.Time `json:"last_alerted_at"`
+
+	// A unique ID for the status transition this alert is about, the same on every
+	// instance, so receivers can deduplicate it
+	EventID string `json:"event_id"`
+
+	// The handlers that have already been sent (or queued to retry) this transition, so
+	// failover or a crash can't cause a handler to be sent it twice
+	Delivered []string `json:"delivered"`
+
+	// The handlers the last alert sent was delivered to, so its recovery goes to the same
+	// ones even if the routes would send it elsewhere now, e.g. once a route's schedule ends
+	LastDelivered []string `json:"last_delivered"`
+
+	// The event ID of the alert that opened the current incident (the first non-passing
+	// alert since the last passing one), for handlers that track incidents by a single key
+	IncidentID string `json:"incident_id,omitempty"`
+
+	// The worst status and severity reached during the current incident, so handlers that
+	// only get severe alerts can still be told when an incident they were sent is resolved
+	IncidentStatus   string `json:"incident_status,omitempty"`
+	IncidentSeverity string `json:"incident_severity,omitempty"`
+
+	// When the current incident was opened, and the checks that failed during it, for
+	// describing the incident once it's resolved
+	IncidentStart  time.Time    `json:"incident_start"`
+	IncidentChecks []AlertCheck `json:"incident_checks,omitempty"`
+
+	// The recent changes of the alert's status, within the flap window, and whether it's
+	// flapping: changing status too often for each change to be worth an alert. FlapStatus
+	// is the worst status it's been while flapping, and FlapNotified is set once the alert
+	// saying it's flapping has been sent.
+	StatusChanges []time.Time `json:"status_changes,omitempty"`
+	Flapping      bool        `json:"flapping,omitempty"`
+	FlapStatus    string      `json:"flap_status,omitempty"`
+	FlapNotified  bool        `json:"flap_notified,omitempty"`
+
+	// How many reminders have been sent about the alert since it was last sent, and when the
+	// last one was
+	Reminders  int       `json:"reminders,omitempty"`
+	RemindedAt time.Time `json:"reminded_at"`
+
+	// Extra fields added by a handler's middleware, e.g. the owning team
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// Set on alerts about the watch itself (e.g. ACLs blocking it) rather than the health of
+	// the node/service
+	Meta bool `json:"meta,omitempty"`
+
+	// Set on alerts going between warning and critical: "escalated" from warning to
+	// critical, or "partial_recovery" from critical to warning
+	Transition string `json:"transition,omitempty"`
+
+	// Set on meta-alerts about a service having fewer healthy instances than its
+	// min_healthy_instances
+	Capacity bool `json:"capacity,omitempty"`
+
+	// Set on alerts summarizing several others, like digests, which aren't about any one
+	// node/service
+	Summary bool `json:"summary,omitempty"`
+
+	// How urgent the alert is, scored from its severity, the criticality of its service and
+	// the number of nodes it's failing on, and the priority of the last alert sent. A
+	// passing alert has a priority of 0.
+	Priority     int    `json:"priority,omitempty"`
+	LastPriority int    `json:"last_priority,omitempty"`
+	Criticality  string `json:"criticality,omitempty"`
+}
+
+// Returns a key identifying what the alert is about, for handlers that track incidents by
+// key. Meta-alerts get a key of their own, so they can't open or resolve the incident for
+// the node/service's health.
+func alertTargetKey(alert *AlertState) string {
+	key := alert.Service + "-" + alert.Tag + "-" + alert.Node
+	if alert.Capacity {
+		key = key + "-capacity"
+	} else if alert.Meta {
+		key = key + "-meta"
+	}
+	return key
+}
+
+// Records the alert's event as the start of a new incident if it's the first non-passing
+// alert since the last passing one, and keeps track of the incident's worst status and
+// severity and the checks that failed during it. Called just before the alert is sent.
+func (a *AlertState) updateIncident() {
+	if a.LastAlerted == api.HealthPassing && a.Status != api.HealthPassing {
+		a.IncidentID = a.EventID
+		a.IncidentStatus = a.Status
+		a.IncidentSeverity = ""
+		a.IncidentStart = time.Now()
+		a.IncidentChecks = nil
+	}
+	if a.Status == api.HealthPassing {
+		return
+	}
+	if order, ok := statusOrder[a.IncidentStatus]; !ok || statusOrder[a.Status] < order {
+		a.IncidentStatus = a.Status
+	}
+	severity := alertSeverity(a.Severity, a.Status)
+	if order, ok := severityOrder[a.IncidentSeverity]; !ok || severityOrder[severity] < order {
+		a.IncidentSeverity = severity
+	}
+
+	// Keep the latest result of each check that's failed, in the order they first failed
+	for _, check := range a.Checks {
+		found := false
+		for i, existing := range a.IncidentChecks {
+			if existing.Node == check.Node && existing.CheckID == check.CheckID {
+				a.IncidentChecks[i] = check
+				found = true
+			}
+		}
+		if !found {
+			a.IncidentChecks = append(a.IncidentChecks, check)
+		}
+	}
+}
+
+// Rewrites a passing alert that resolves an incident to say so explicitly, with how long
+// the incident lasted and the checks that failed during it. Other alerts are left alone.
+func (a *AlertState) describeRecovery(now time.Time) {
+	if a.Status != api.HealthPassing || a.IncidentStart.IsZero() {
+		return
+	}
+
+	duration := now.Sub(a.IncidentStart).Round(time.Second)
+	a.Message = fmt.Sprintf("RESOLVED: %s (was %s for %s)", a.Message, a.IncidentStatus, duration)
+
+	lines := []string{fmt.Sprintf("Resolved after being %s for %s, since %s.", a.IncidentStatus, duration, a.IncidentStart.Format(time.RFC1123))}
+	if len(a.IncidentChecks) > 0 {
+		lines = append(lines, "", "Checks that were failing:")
+		for _, check := range a.IncidentChecks {
+			line := fmt.Sprintf("- %s (%s) was %s", check.Name, check.Node, check.Status)
+			if check.Output != "" {
+				line += ": " + strings.TrimSpace(check.Output)
+			}
+			lines = append(lines, line)
+		}
+	}
+	a.Details = strings.TrimSpace(strings.Join(lines, "\n") + "\n\n" + a.Details)
+}
+
+// Returns the event ID for a transition of the alert at the given path. The update index is
+// only ever incremented under the watch's lock, so it identifies the transition across
+// instances and restarts.
+func alertEventID(kvPath string, updateIndex int64, status string) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s/%d/%s", kvPath, updateIndex, status)))
+	return fmt.Sprintf("%x", sum[:16])
 }
 
 // Parses a CheckState from a given Consul K/V path
@@ -69,15 +240,99 @@ func setAlertState(kvPath string, alert *AlertState, client *api.Client) {
 }
 
 // Waits for changeThreshold duration, then alerts if LastUpdated has not
-// changed in the meantime (which would indicate another alert resetting the timer)
+// changed in the meantime (which would indicate another alert resetting the timer).
+// The wait uses the monotonic clock and the check compares update indexes rather than
+// timestamps, so wall clock jumps can't make an alert fire early or late.
 func tryAlert(kvPath string, update AlertState, watchOpts *WatchOptions) {
+	updateIndex, ok := queueAlert(kvPath, update, watchOpts)
+	if !ok {
+		return
+	}
+	waitAlert(kvPath, update, updateIndex, watchOpts)
+}
+
+// Stores the update and registers it as pending on the watch, returning its update index.
+// Watches call this while holding handoffLock, so a handoff to a new process can't start
+// between the update being stored and the pending alert being passed along with the state.
+func queueAlert(kvPath string, update AlertState, watchOpts *WatchOptions) (int64, bool) {
+	updateIndex, ok := startAlert(kvPath, update, watchOpts)
+	if !ok {
+		return 0, false
+	}
+
+	if watchOpts.state != nil {
+		watchOpts.state.addPending(&PendingAlert{
+			Path:        kvPath,
+			Alert:       update,
+			UpdateIndex: updateIndex,
+			FireAt:      time.Now().Add(alertChangeThreshold(watchOpts, &update)),
+		})
+	}
+	return updateIndex, true
+}
+
+// Waits out the change threshold for an alert queued with queueAlert, then sends it if
+// nothing has changed since
+func waitAlert(kvPath string, update AlertState, updateIndex int64, watchOpts *WatchOptions) {
+	log.Debugf("Starting timer for alert: '%s'", update.Message)
+	time.Sleep(alertChangeThreshold(watchOpts, &update))
+
+	// Only failures are held for a rollout; a recovery means the rollout went fine
+	if update.Status != api.HealthPassing {
+		waitForRollout(watchOpts.config, watchOpts.client, watchOpts.service)
+	}
+
+	finishAlert(kvPath, update, updateIndex, watchOpts)
+}
+
+// Returns how long a status has to hold before it's alerted on for the watch
+func alertChangeThreshold(watchOpts *WatchOptions, update *AlertState) time.Duration {
+	return time.Duration(watchOpts.config.alertChangeThreshold(watchOpts.service, update)) * time.Second
+}
+
+// Checks a pending alert again at the given time, keeping it registered as pending until then
+func delayAlert(kvPath string, update AlertState, updateIndex int64, at time.Time, watchOpts *WatchOptions) {
+	if watchOpts.state != nil {
+		watchOpts.state.addPending(&PendingAlert{
+			Path:        kvPath,
+			Alert:       update,
+			UpdateIndex: updateIndex,
+			FireAt:      at,
+		})
+	}
+
+	go runRecovered("delayed alert timer for "+update.Message, func() {
+		time.Sleep(at.Sub(time.Now()))
+		finishAlert(kvPath, update, updateIndex, watchOpts)
+	})
+}
+
+// Continues the timer for an alert that was started by a previous process before it handed
+// off to us
+func resumeAlert(pending *PendingAlert, watchOpts *WatchOptions) {
+	// Restart the timer from the time remaining rather than the old process's FireAt, in
+	// case our wall clocks disagree
+	pending.FireAt = time.Now().Add(pending.Remaining)
+	watchOpts.state.addPending(pending)
+
+	log.Debugf("Resuming timer for alert: '%s'", pending.Alert.Message)
+	time.Sleep(pending.Remaining)
+
+	finishAlert(pending.Path, pending.Alert, pending.UpdateIndex, watchOpts)
+}
+
+// Stores the update in the alert state, incrementing its update index to reset any timers
+// already running. Returns the new update index.
+func startAlert(kvPath string, update AlertState, watchOpts *WatchOptions) (int64, bool) {
 	// Lock the mutex while reading or writing the alert state to avoid race conditions
 	watchOpts.alertLock.Lock()
+	defer watchOpts.alertLock.Unlock()
+
 	alert, err := getAlertState(kvPath, watchOpts.client)
 
 	if err != nil {
 		log.Error("Error fetching alert state: ", err)
-		return
+		return 0, false
 	}
 
 	// Create a new alert state if there's no pre-existing one
@@ -89,25 +344,66 @@ func tryAlert(kvPath string, update AlertState, watchOpts *WatchOptions) {
 			LastAlerted: api.HealthPassing,
 		}
 	}
+	alert.Datacenter = watchOpts.config.ConsulDatacenter
+	alert.Job = watchOpts.job
+
+	// If the stored alert is for the same status and hasn't finished being sent (e.g. the
+	// previous leader died partway through), this is the same transition, so keep its event
+	// ID and the handlers it's been delivered to
+	sameTransition := alert.EventID != "" && alert.Status == update.Status && alert.LastAlerted != update.Status
+
+	if watchOpts.config.FlapThreshold > 0 && alert.Status != update.Status {
+		alert.recordStatusChange(update.Status, watchOpts.config, time.Now())
+	}
 
 	alert.Status = update.Status
+	alert.Severity = update.Severity
+	if alert.Severity == "" {
+		alert.Severity = statusSeverity(update.Status)
+	}
 	alert.Message = update.Message
 	alert.Details = update.Details
+	alert.Checks = update.Checks
+	alert.Link = update.Link
 
 	// Increment the update index and store it, so we can check later to see if it changed
 	alert.UpdateIndex++
-	updateIndex := alert.UpdateIndex
+	if !sameTransition {
+		alert.EventID = alertEventID(kvPath, alert.UpdateIndex, alert.Status)
+		alert.Delivered = nil
+	}
 
 	// Set LastUpdated on the alert to reset the timer
 	setAlertState(kvPath, alert, watchOpts.client)
-	watchOpts.alertLock.Unlock()
+	if watchOpts.state != nil {
+		watchOpts.state.setFlapping(alert.Flapping)
+	}
 
-	changeThreshold := watchOpts.config.serviceChangeThreshold(watchOpts.service)
-	log.Debugf("Starting timer for alert: '%s'", update.Message)
-	time.Sleep(time.Duration(changeThreshold) * time.Second)
+	return alert.UpdateIndex, true
+}
+
+// Sends the alert to the handlers if no new updates were stored since the given update index
+func finishAlert(kvPath string, update AlertState, updateIndex int64, watchOpts *WatchOptions) {
+	// Wait for any handoff to a new process to finish; if it succeeds we'll exit here and
+	// the new process will send the alert instead
+	handoffLock.RLock()
+	defer handoffLock.RUnlock()
+
+	// A flapping alert, or one held back because its nodes are down or it's inhibited, is
+	// checked again later, and stays pending until then so it's handed off along with the watch
+	var checkAgainAt time.Time
+	if watchOpts.state != nil {
+		defer func() {
+			if checkAgainAt.IsZero() {
+				watchOpts.state.removePending(updateIndex)
+			}
+		}()
+	}
 
 	watchOpts.alertLock.Lock()
-	alert, err = getAlertState(kvPath, watchOpts.client)
+	defer watchOpts.alertLock.Unlock()
+
+	alert, err := getAlertState(kvPath, watchOpts.client)
 
 	if err != nil {
 		log.Error("Error fetching alert state: ", err)
@@ -119,24 +415,160 @@ func tryAlert(kvPath string, update AlertState, watchOpts *WatchOptions) {
 		return
 	}
 
+	// While the status is flapping, send a single alert saying so instead of one for each
+	// change, until it's held long enough to be considered stable
+	if alert.UpdateIndex == updateIndex && alert.Flapping {
+		if at := alert.flapSettleAt(watchOpts.config); time.Now().Before(at) {
+			if !alert.FlapNotified {
+				sendFlapping(kvPath, alert, watchOpts)
+			}
+			checkAgainAt = at
+			delayAlert(kvPath, update, updateIndex, checkAgainAt, watchOpts)
+			return
+		}
+		alert.stopFlapping()
+		setAlertState(kvPath, alert, watchOpts.client)
+		if watchOpts.state != nil {
+			watchOpts.state.setFlapping(false)
+		}
+	}
+
+	// Hold back a service alert while the nodes it's failing on are down, since the nodes'
+	// alerts cover it. It's sent if it's still failing once they're back up.
+	if alert.UpdateIndex == updateIndex && update.Status != alert.LastAlerted && update.Status != api.HealthPassing {
+		if nodes := inhibitingNodes(watchOpts.config, watchOpts.client, alert); len(nodes) > 0 {
+			log.Infof("Holding back alert '%s' while node %s is down", alert.Message, strings.Join(nodes, ", "))
+			checkAgainAt = time.Now().Add(nodeDownRecheckInterval)
+			delayAlert(kvPath, update, updateIndex, checkAgainAt, watchOpts)
+			return
+		}
+	}
+
+	// Refresh the alert's checks and work out its severity, category and priority before the
+	// inhibit rules are checked, so they match it as it would be sent
+	if alert.UpdateIndex == updateIndex && update.Status != alert.LastAlerted {
+		correlateAlert(watchOpts, alert)
+		groupAlert(watchOpts, alert)
+		prioritizeAlert(watchOpts, alert)
+	}
+
+	// Hold back an alert while an alert that inhibits it is firing. It's sent if it's still
+	// failing once that alert is resolved.
+	if alert.UpdateIndex == updateIndex && update.Status != alert.LastAlerted && update.Status != api.HealthPassing {
+		if inhibitor := inhibitingAlert(watchOpts.config, watchOpts.client, alert); inhibitor != "" {
+			log.Infof("Holding back alert '%s', inhibited by %s", alert.Message, inhibitor)
+			checkAgainAt = time.Now().Add(inhibitRecheckInterval)
+			delayAlert(kvPath, update, updateIndex, checkAgainAt, watchOpts)
+			return
+		}
+	}
+
 	// If no new alerts were triggered during the sleep, send the alert to each handler to be processed
 	if alert.UpdateIndex == updateIndex && update.Status != alert.LastAlerted {
-		for _, handler := range watchOpts.config.serviceHandlers(watchOpts.service) {
-			handler.Alert(alert)
+		if claimed, err := claimTransition(watchOpts.config, watchOpts.client, kvPath, alert, time.Now()); err != nil {
+			// Keep the alert pending rather than dropping it, so it's sent once Consul is back
+			log.Errorf("%s, trying alert '%s' again in %s", err, alert.Message, claimRetryInterval)
+			checkAgainAt = time.Now().Add(claimRetryInterval)
+			delayAlert(kvPath, update, updateIndex, checkAgainAt, watchOpts)
+			return
+		} else if !claimed {
+			log.Infof("Alert '%s' is being sent by another instance, skipping", alert.Message)
+			return
 		}
+		alert.describeTransition()
+		alert.updateIncident()
+
+		// Record each delivery as it happens, so a crash partway through won't resend
+		// the alert to the handlers that already got it
+		sendAlert(watchOpts.config, watchOpts.service, alert, func(handler string) {
+			alert.Delivered = append(alert.Delivered, handler)
+			setAlertState(kvPath, alert, watchOpts.client)
+		})
 		alert.LastAlerted = update.Status
+		alert.LastSeverity = alert.Severity
+		alert.LastCategory = alert.Category
+		alert.LastPriority = alert.Priority
+		alert.LastDelivered = append([]string{}, alert.Delivered...)
+		alert.LastAlertedAt = time.Now()
+		alert.Reminders = 0
+		alert.RemindedAt = time.Time{}
 		setAlertState(kvPath, alert, watchOpts.client)
+		if err := releaseClaim(watchOpts.config, watchOpts.client, kvPath); err != nil {
+			log.Warn(err)
+		}
+		publishAlert(watchOpts.config, watchOpts.client, alert)
+	}
+}
+
+// Sends a passing alert for a watch whose target has gone away, if its last alert wasn't
+// passing, so incidents for it don't stay open forever
+func resolveRemoved(kvPath string, name string, watchOpts *WatchOptions) {
+	resolveAlert(kvPath, fmt.Sprintf("[%s] %s was removed, resolving its alert", watchOpts.config.ConsulDatacenter, name), watchOpts)
+}
+
+// Sends a passing alert with the given message right away, without waiting out the change
+// threshold, if the last alert wasn't passing
+func resolveAlert(kvPath, message string, watchOpts *WatchOptions) {
+	update := AlertState{
+		Status:  api.HealthPassing,
+		Message: message,
+	}
+
+	updateIndex, ok := startAlert(kvPath, update, watchOpts)
+	if !ok {
+		return
+	}
+	finishAlert(kvPath, update, updateIndex, watchOpts)
+}
+
+// Matches terminal escape sequences (colors, cursor movement) in check output
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+
+// Cleans up check output for use in a notification, removing terminal escape sequences and
+// control characters and truncating it to limit characters (if limit isn't 0)
+func sanitizeOutput(output string, limit int) string {
+	output = ansiEscape.ReplaceAllString(output, "")
+	output = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, output)
+	output = strings.TrimSpace(output)
+
+	if runes := []rune(output); limit > 0 && len(runes) > limit {
+		output = string(runes[:limit]) + "... (truncated)"
+	}
+	return output
+}
+
+// Returns a link to the node or service's page in the Consul UI, or an empty string if
+// ui_url isn't set
+func consulUILink(config *Config, node, service string) string {
+	if config.UIURL == "" {
+		return ""
+	}
+
+	link := strings.TrimRight(config.UIURL, "/") + "/" + url.PathEscape(config.ConsulDatacenter)
+	if service != "" {
+		link = link + "/services/" + url.PathEscape(service)
+	} else {
+		link = link + "/nodes/" + url.PathEscape(node)
+	}
+
+	if config.UINamespace != "" {
+		link = link + "?ns=" + url.QueryEscape(config.UINamespace)
 	}
-	watchOpts.alertLock.Unlock()
+	return link
 }
 
 // Returns each failing check and its output
-func nodeDetails(checks []*api.HealthCheck) string {
+func nodeDetails(checks []*api.HealthCheck, outputLimit int) string {
 	details := ""
 
 	for _, check := range checks {
 		if check.ServiceID == "" && (check.Status == api.HealthCritical || check.Status == api.HealthWarning) {
-			details = details + fmt.Sprintf("=> (check) %s:\n%s", check.Name, check.Output)
+			details = details + fmt.Sprintf("=> (check) %s:\n%s\n", check.Name, sanitizeOutput(check.Output, outputLimit))
 		}
 	}
 
@@ -149,7 +581,7 @@ func nodeDetails(checks []*api.HealthCheck) string {
 }
 
 // Returns each failing check and its output, grouped by node
-func serviceDetails(checks []*api.HealthCheck) string {
+func serviceDetails(checks []*api.HealthCheck, outputLimit int) string {
 	details := ""
 	// Make a map for combining the failing health check outputs on each node
 	nodeStatuses := make(map[string]string)
@@ -159,7 +591,7 @@ func serviceDetails(checks []*api.HealthCheck) string {
 			if _, ok := nodeStatuses[check.Node]; !ok {
 				nodeStatuses[check.Node] = ""
 			}
-			nodeStatuses[check.Node] = nodeStatuses[check.Node] + fmt.Sprintf("==> (check) %s:\n%s", check.Name, check.Output)
+			nodeStatuses[check.Node] = nodeStatuses[check.Node] + fmt.Sprintf("==> (check) %s:\n%s\n", check.Name, sanitizeOutput(check.Output, outputLimit))
 		}
 	}
 
@@ -146,3 +146,128 @@ func TestAlert_serviceHandler(t *testing.T) {
 	case <-time.After(1 * time.Second):
 	}
 }
+
+// Make sure event IDs are stable for a transition and differ between transitions
+func TestAlert_eventID(t *testing.T) {
+	first := alertEventID("consul-alerting/service/redis/alert", 4, "critical")
+	if first != alertEventID("consul-alerting/service/redis/alert", 4, "critical") {
+		t.Fatal("expected the same event ID for the same transition")
+	}
+
+	if first == alertEventID("consul-alerting/service/redis/alert", 5, "passing") {
+		t.Fatal("expected a different event ID for a new transition")
+	}
+}
+
+// Make sure restarting the timer for a transition that hasn't finished being sent keeps its
+// event ID and deliveries, and that a new transition gets a new one
+func TestAlert_startAlertSameTransition(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	config, _ := testAlertConfig()
+	opts := &WatchOptions{client: client, config: config, alertLock: &sync.Mutex{}}
+
+	startAlert(testAlertKVPath, AlertState{Status: api.HealthCritical}, opts)
+	alert, _ := getAlertState(testAlertKVPath, client)
+	alert.Delivered = []string{"test"}
+	setAlertState(testAlertKVPath, alert, client)
+	eventID := alert.EventID
+
+	startAlert(testAlertKVPath, AlertState{Status: api.HealthCritical}, opts)
+	alert, _ = getAlertState(testAlertKVPath, client)
+	if alert.EventID != eventID || len(alert.Delivered) != 1 {
+		t.Fatalf("expected event ID and deliveries to be kept, got %s %v", alert.EventID, alert.Delivered)
+	}
+
+	startAlert(testAlertKVPath, AlertState{Status: api.HealthWarning}, opts)
+	alert, _ = getAlertState(testAlertKVPath, client)
+	if alert.EventID == eventID || len(alert.Delivered) != 0 {
+		t.Fatalf("expected a new event ID for a new status, got %s %v", alert.EventID, alert.Delivered)
+	}
+}
+
+// Make sure the incident ID is set by the alert opening an incident and kept until it resolves
+func TestAlert_updateIncident(t *testing.T) {
+	alert := &AlertState{Status: api.HealthCritical, LastAlerted: api.HealthPassing, EventID: "a1"}
+	alert.updateIncident()
+
+	alert.LastAlerted, alert.Status, alert.EventID = api.HealthCritical, api.HealthWarning, "b2"
+	alert.updateIncident()
+	if alert.IncidentID != "a1" || alert.IncidentStatus != api.HealthCritical {
+		t.Fatalf("expected incident ID a1 with status critical, got %s %s", alert.IncidentID, alert.IncidentStatus)
+	}
+
+	alert.LastAlerted, alert.Status, alert.EventID = api.HealthWarning, api.HealthPassing, "c3"
+	alert.updateIncident()
+	if alert.IncidentID != "a1" || pagerdutyIncidentKey(alert) != "a1" {
+		t.Fatalf("expected resolve to use incident ID a1, got %s", alert.IncidentID)
+	}
+}
+
+// Make sure a resolved incident is described with its duration and the checks that failed
+func TestAlert_describeRecovery(t *testing.T) {
+	alert := &AlertState{
+		Status:      api.HealthWarning,
+		LastAlerted: api.HealthPassing,
+		Checks:      []AlertCheck{{Node: "node1", CheckID: "mem", Name: "memory", Status: api.HealthWarning, Output: "90% used"}},
+	}
+	alert.updateIncident()
+
+	alert.LastAlerted, alert.Status = api.HealthWarning, api.HealthCritical
+	alert.Checks = []AlertCheck{
+		{Node: "node1", CheckID: "mem", Name: "memory", Status: api.HealthCritical, Output: "99% used"},
+		{Node: "node1", CheckID: "disk", Name: "disk", Status: api.HealthWarning},
+	}
+	alert.updateIncident()
+
+	alert.LastAlerted, alert.Status, alert.Checks = api.HealthCritical, api.HealthPassing, nil
+	alert.Message = "[dc1] node node1 is passing"
+	alert.updateIncident()
+	alert.IncidentStart = time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	alert.describeRecovery(alert.IncidentStart.Add(750 * time.Second))
+
+	if alert.Message != "RESOLVED: [dc1] node node1 is passing (was critical for 12m30s)" {
+		t.Errorf("unexpected message %q", alert.Message)
+	}
+	expected := "Resolved after being critical for 12m30s, since Fri, 16 Oct 2026 09:00:00 UTC.\n\n" +
+		"Checks that were failing:\n- memory (node1) was critical: 99% used\n- disk (node1) was warning"
+	if alert.Details != expected {
+		t.Errorf("unexpected details %q", alert.Details)
+	}
+
+	// Alerts that aren't resolutions are left alone
+	alert = &AlertState{Status: api.HealthCritical, Message: "web is critical", IncidentStart: time.Now()}
+	alert.describeRecovery(time.Now())
+	if alert.Message != "web is critical" {
+		t.Errorf("expected a critical alert to be left alone, got %q", alert.Message)
+	}
+}
+
+func TestAlert_sanitizeOutput(t *testing.T) {
+	output := "\x1b[31mCRITICAL\x1b[0m: connection refused\r\n\x07"
+	if sanitized := sanitizeOutput(output, 0); sanitized != "CRITICAL: connection refused" {
+		t.Errorf("unexpected sanitized output %q", sanitized)
+	}
+
+	if truncated := sanitizeOutput("connection refused", 10); truncated != "connection... (truncated)" {
+		t.Errorf("unexpected truncated output %q", truncated)
+	}
+}
+
+func TestAlert_consulUILink(t *testing.T) {
+	config := &Config{ConsulDatacenter: "dc1"}
+	if link := consulUILink(config, "", "redis"); link != "" {
+		t.Errorf("expected no link without ui_url, got %s", link)
+	}
+
+	config.UIURL = "https://consul.example.com/ui/"
+	if link := consulUILink(config, "", "redis"); link != "https://consul.example.com/ui/dc1/services/redis" {
+		t.Errorf("unexpected service link %s", link)
+	}
+
+	config.UINamespace = "team-a"
+	if link := consulUILink(config, "node1", ""); link != "https://consul.example.com/ui/dc1/nodes/node1?ns=team-a" {
+		t.Errorf("unexpected node link %s", link)
+	}
+}
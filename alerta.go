@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The environment alerts are sent to by default, one Alerta allows out of the box
+const alertaDefaultEnvironment = "Production"
+
+// The events alerts are raised as, so Alerta deduplicates every alert about a node/service
+// into one and a recovery clears it
+const (
+	alertaEvent     = "ConsulHealthCheck"
+	alertaMetaEvent = "ConsulAlertingWatch"
+)
+
+// AlertaHandler creates and updates alerts in Alerta through its API. Each node/service is
+// an Alerta resource, and recoveries are sent with the normal severity to clear its alert.
+type AlertaHandler struct {
+	URL    string `mapstructure:"url"`
+	APIKey string `mapstructure:"api_key"`
+
+	// A template for the environment to raise alerts in, rendered with AlertTemplateData
+	Environment string `mapstructure:"environment"`
+
+	// Optional. Extra tags to add to every alert.
+	Tags []string `mapstructure:"tags"`
+}
+
+// The body of a request to Alerta's POST /alert
+type alertaAlert struct {
+	Resource    string            `json:"resource"`
+	Event       string            `json:"event"`
+	Environment string            `json:"environment"`
+	Severity    string            `json:"severity"`
+	Service     []string          `json:"service"`
+	Group       string            `json:"group"`
+	Value       string            `json:"value"`
+	Text        string            `json:"text"`
+	Tags        []string          `json:"tags"`
+	Attributes  map[string]string `json:"attributes"`
+	Origin      string            `json:"origin"`
+	Type        string            `json:"type"`
+	RawData     string            `json:"rawData,omitempty"`
+	// Zero stops Alerta expiring the alert, since we only send it again when it changes
+	Timeout int `json:"timeout"`
+}
+
+// Returns the Alerta severity for a Consul health status
+func alertaSeverity(status string) string {
+	switch status {
+	case api.HealthCritical:
+		return "critical"
+	case api.HealthWarning:
+		return "warning"
+	case api.HealthPassing:
+		return "normal"
+	}
+	return "indeterminate"
+}
+
+// Returns the Alerta resource for an alert's node or service
+func alertaResource(alert *AlertState) string {
+	if alert.Service == "" {
+		return alert.Node
+	}
+	if alert.Tag != "" {
+		return alert.Service + ":" + alert.Tag
+	}
+	return alert.Service
+}
+
+// Returns the alert to send to Alerta for an alert in the given environment
+func (a AlertaHandler) alert(alert *AlertState, environment string) alertaAlert {
+	event, group := alertaEvent, "Service"
+	if alert.Service == "" {
+		group = "Node"
+	}
+	if alert.Meta {
+		event = alertaMetaEvent
+	}
+
+	services := []string{alert.Service}
+	if alert.Service == "" {
+		services = []string{"consul"}
+	}
+
+	tags := []string{"dc:" + alert.Datacenter}
+	if alert.Tag != "" {
+		tags = append(tags, "tag:"+alert.Tag)
+	}
+	tags = append(tags, a.Tags...)
+
+	attributes := map[string]string{"datacenter": alert.Datacenter, "eventId": alert.EventID}
+	if alert.Node != "" {
+		attributes["node"] = alert.Node
+	}
+	if alert.IncidentID != "" {
+		attributes["incidentId"] = alert.IncidentID
+	}
+	if alert.Link != "" {
+		attributes["consulUrl"] = alert.Link
+	}
+
+	return alertaAlert{
+		Resource:    alertaResource(alert),
+		Event:       event,
+		Environment: environment,
+		Severity:    alertaSeverity(alert.Status),
+		Service:     services,
+		Group:       group,
+		Value:       alert.Status,
+		Text:        alert.Message,
+		Tags:        tags,
+		Attributes:  attributes,
+		Origin:      "consul-alerting",
+		Type:        "consulAlert",
+		RawData:     alert.Details,
+	}
+}
+
+func (a AlertaHandler) Alert(ctx context.Context, alert *AlertState) error {
+	text := a.Environment
+	if text == "" {
+		text = alertaDefaultEnvironment
+	}
+	tmpl, err := parseAlertTemplate("environment", text)
+	if err != nil {
+		return fmt.Errorf("Error rendering Alerta environment: %s", err)
+	}
+	environment, err := executeTemplate(tmpl, newAlertTemplateData("", alert, time.Now()))
+	if err != nil {
+		return fmt.Errorf("Error rendering Alerta environment: %s", err)
+	}
+
+	headers := map[string]string{}
+	if a.APIKey != "" {
+		headers["Authorization"] = "Key " + a.APIKey
+	}
+	if err := postJSON(ctx, strings.TrimRight(a.URL, "/")+"/alert", headers, a.alert(alert, environment)); err != nil {
+		return fmt.Errorf("Error sending alert to Alerta: %s", err)
+	}
+	return nil
+}
+
+func (a AlertaHandler) Validate() error {
+	if a.URL == "" {
+		return errors.New("no url given")
+	}
+	if _, err := parseAlertTemplate("environment", a.Environment); err != nil {
+		return fmt.Errorf("invalid environment template: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const alertmanagerDefaultAlertName = "ConsulHealthCheck"
+
+// How far ahead to set the end time of firing alerts. Alerts are only posted when their
+// status changes, so without an end time Alertmanager would resolve them itself after its
+// resolve_timeout; instead they stay firing until the recovery sets the real end time.
+const alertmanagerFiringDuration = 365 * 24 * time.Hour
+
+// AlertmanagerHandler forwards alerts to a Prometheus Alertmanager through its v2 API, so
+// its routing, grouping and silences can be used for Consul health alerts too
+type AlertmanagerHandler struct {
+	URL       string            `mapstructure:"url"`
+	AlertName string            `mapstructure:"alertname"`
+	Labels    map[string]string `mapstructure:"labels"`
+}
+
+// An alert in the body of a request to Alertmanager's POST /api/v2/alerts
+type alertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     string            `json:"startsAt,omitempty"`
+	EndsAt       string            `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// Returns the labels identifying an alert with the given severity in Alertmanager
+func (a AlertmanagerHandler) labels(alert *AlertState, severity string) map[string]string {
+	labels := make(map[string]string)
+	for key, value := range a.Labels {
+		labels[key] = value
+	}
+
+	labels["alertname"] = a.AlertName
+	if labels["alertname"] == "" {
+		labels["alertname"] = alertmanagerDefaultAlertName
+	}
+	labels["severity"] = severity
+	for key, value := range map[string]string{
+		"datacenter": alert.Datacenter,
+		"service":    alert.Service,
+		"tag":        alert.Tag,
+		"node":       alert.Node,
+	} {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+	if alert.Meta {
+		labels["meta"] = "true"
+	}
+	return labels
+}
+
+// Returns the annotations for an alert, with the output of each failing check
+func alertmanagerAnnotations(alert *AlertState) map[string]string {
+	annotations := map[string]string{"summary": alert.Message}
+	if alert.Details != "" {
+		annotations["description"] = alert.Details
+	}
+	if alert.Link != "" {
+		annotations["consul_ui"] = alert.Link
+	}
+
+	outputs := make([]string, 0, len(alert.Checks))
+	for _, check := range alert.Checks {
+		outputs = append(outputs, fmt.Sprintf("%s/%s (%s): %s", check.Node, check.Name, check.Status, check.Output))
+	}
+	if len(outputs) > 0 {
+		annotations["check_output"] = strings.Join(outputs, "\n")
+	}
+	return annotations
+}
+
+// Returns the alerts to post for a status change. Since the severity is one of the labels,
+// a change from one failing status to another resolves the old alert and starts a new one.
+func (a AlertmanagerHandler) alerts(alert *AlertState, now time.Time) []alertmanagerAlert {
+	alerts := make([]alertmanagerAlert, 0, 2)
+	annotations := alertmanagerAnnotations(alert)
+
+	previous := alert.LastAlerted
+	if previous != "" && previous != api.HealthPassing && previous != alert.Status {
+		alerts = append(alerts, alertmanagerAlert{
+			Labels:       a.labels(alert, previous),
+			Annotations:  annotations,
+			EndsAt:       now.Format(time.RFC3339),
+			GeneratorURL: alert.Link,
+		})
+	}
+
+	if alert.Status != api.HealthPassing {
+		alerts = append(alerts, alertmanagerAlert{
+			Labels:       a.labels(alert, alert.Status),
+			Annotations:  annotations,
+			StartsAt:     now.Format(time.RFC3339),
+			EndsAt:       now.Add(alertmanagerFiringDuration).Format(time.RFC3339),
+			GeneratorURL: alert.Link,
+		})
+	}
+	return alerts
+}
+
+func (a AlertmanagerHandler) Alert(ctx context.Context, alert *AlertState) error {
+	alerts := a.alerts(alert, time.Now())
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	if err := postJSON(ctx, strings.TrimRight(a.URL, "/")+"/api/v2/alerts", nil, alerts); err != nil {
+		return fmt.Errorf("Error sending alert to Alertmanager: %s", err)
+	}
+	return nil
+}
+
+func (a AlertmanagerHandler) Validate() error {
+	if a.URL == "" {
+		return errors.New("no url given")
+	}
+	return nil
+}
+
+// Checks that Alertmanager is reachable and serving the v2 API
+func (a AlertmanagerHandler) Probe(ctx context.Context) error {
+	req, err := http.NewRequest("GET", strings.TrimRight(a.URL, "/")+"/api/v2/status", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error reaching Alertmanager: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Alertmanager status check failed: got response code %d", resp.StatusCode)
+	}
+	return nil
+}
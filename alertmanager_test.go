@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAlertmanager_alerts(t *testing.T) {
+	handler := AlertmanagerHandler{Labels: map[string]string{"team": "data"}}
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	alert := &AlertState{
+		Status:      "critical",
+		Service:     "redis",
+		Tag:         "alpha",
+		Datacenter:  "dc1",
+		LastAlerted: "warning",
+		Message:     "[dc1] service redis (tag: alpha) is now critical",
+		Checks:      []AlertCheck{{Node: "node1", Name: "ping", Status: "critical", Output: "connection refused"}},
+	}
+
+	alerts := handler.alerts(alert, now)
+	if len(alerts) != 2 {
+		t.Fatalf("expected the warning to be resolved and a critical alert started, got %+v", alerts)
+	}
+
+	expected := map[string]string{
+		"alertname":  "ConsulHealthCheck",
+		"severity":   "warning",
+		"team":       "data",
+		"datacenter": "dc1",
+		"service":    "redis",
+		"tag":        "alpha",
+	}
+	if !reflect.DeepEqual(alerts[0].Labels, expected) || alerts[0].EndsAt != "2017-01-02T03:04:05Z" {
+		t.Errorf("unexpected resolved alert: %+v", alerts[0])
+	}
+	if alerts[1].Labels["severity"] != "critical" || alerts[1].StartsAt != "2017-01-02T03:04:05Z" || alerts[1].EndsAt != "2018-01-02T03:04:05Z" {
+		t.Errorf("unexpected firing alert: %+v", alerts[1])
+	}
+	if output := alerts[1].Annotations["check_output"]; output != "node1/ping (critical): connection refused" {
+		t.Errorf("unexpected check output annotation %q", output)
+	}
+
+	// Recoveries only resolve the last alert
+	alert.Status, alert.LastAlerted = "passing", "critical"
+	alerts = handler.alerts(alert, now)
+	if len(alerts) != 1 || alerts[0].Labels["severity"] != "critical" || alerts[0].EndsAt == "" {
+		t.Errorf("expected the critical alert to be resolved, got %+v", alerts)
+	}
+}
+
+func TestAlertmanager_post(t *testing.T) {
+	var path string
+	var body []alertmanagerAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	handler := AlertmanagerHandler{URL: server.URL + "/"}
+	alert := &AlertState{Status: "critical", Node: "node1", LastAlerted: "passing", Message: "node1 is critical"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/api/v2/alerts" || len(body) != 1 || body[0].Labels["node"] != "node1" || body[0].Annotations["summary"] != alert.Message {
+		t.Errorf("unexpected request to %s: %+v", path, body)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The bounds on how long to wait between retries after an error querying Consul
+const minErrorWaitTime = 1 * time.Second
+const maxErrorWaitTime = 30 * time.Second
+
+// Backoff computes exponentially increasing wait times between retries of a failing
+// operation, up to a limit
+type Backoff struct {
+	min      time.Duration
+	max      time.Duration
+	failures uint
+}
+
+func newBackoff() *Backoff {
+	return &Backoff{min: minErrorWaitTime, max: maxErrorWaitTime}
+}
+
+// Records a failure and returns how long to wait before retrying
+func (b *Backoff) next() time.Duration {
+	wait := b.min << b.failures
+	if wait > b.max || wait <= 0 {
+		wait = b.max
+	} else {
+		b.failures++
+	}
+	return wait
+}
+
+// Records a success, so the next failure starts from the minimum wait again
+func (b *Backoff) reset() {
+	b.failures = 0
+}
+
+// ConsulHealth tracks whether our queries to Consul have been failing, so we can keep running
+// in a degraded state (and report it) during an outage instead of exiting. Each watch and
+// discovery loop reports its own queries, so one loop succeeding doesn't hide another failing.
+type ConsulHealth struct {
+	sync.Mutex
+	// The last error from each loop whose most recent query failed, by loop name
+	failing map[string]error
+}
+
+var consulHealth = &ConsulHealth{failing: make(map[string]error)}
+
+// Records the result of a loop's query to Consul, logging when we lose or regain connectivity
+func (c *ConsulHealth) record(name string, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	_, wasFailing := c.failing[name]
+	if err != nil {
+		if len(c.failing) == 0 {
+			log.Warnf("Queries to Consul are failing, running in degraded mode until it recovers: %s", err)
+		}
+		c.failing[name] = err
+	} else if wasFailing {
+		delete(c.failing, name)
+		if len(c.failing) == 0 {
+			log.Info("Queries to Consul are succeeding again, leaving degraded mode")
+		}
+	}
+}
+
+// Stops tracking a loop that has exited
+func (c *ConsulHealth) remove(name string) {
+	c.record(name, nil)
+}
+
+// Returns true if any loop's most recent query to Consul failed, along with the error from
+// the first of them by name
+func (c *ConsulHealth) isDegraded() (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.failing) == 0 {
+		return false, nil
+	}
+
+	names := make([]string, 0, len(c.failing))
+	for name := range c.failing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return true, c.failing[names[0]]
+}
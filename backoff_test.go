@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff_next(t *testing.T) {
+	backoff := newBackoff()
+
+	expected := []time.Duration{1, 2, 4, 8, 16, 30, 30}
+	for _, seconds := range expected {
+		if wait := backoff.next(); wait != seconds*time.Second {
+			t.Fatalf("expected wait of %ds, got %s", seconds, wait)
+		}
+	}
+
+	backoff.reset()
+	if wait := backoff.next(); wait != minErrorWaitTime {
+		t.Fatalf("expected wait of %s after reset, got %s", minErrorWaitTime, wait)
+	}
+}
+
+// Make sure a loop that's succeeding doesn't clear the degraded state while another is failing
+func TestConsulHealth_record(t *testing.T) {
+	health := &ConsulHealth{failing: make(map[string]error)}
+
+	health.record("service redis", errors.New("connection refused"))
+	health.record("service nginx", nil)
+	if degraded, err := health.isDegraded(); !degraded || err == nil || err.Error() != "connection refused" {
+		t.Fatalf("expected to be degraded with the failing watch's error, got %v (%v)", degraded, err)
+	}
+
+	health.record("service redis", nil)
+	if degraded, _ := health.isDegraded(); degraded {
+		t.Fatal("expected to no longer be degraded")
+	}
+
+	health.record("service redis", errors.New("connection refused"))
+	health.remove("service redis")
+	if degraded, _ := health.isDegraded(); degraded {
+		t.Fatal("expected a removed watch to no longer count")
+	}
+}
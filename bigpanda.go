@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const bigPandaDefaultURL = "https://api.bigpanda.io/data/v2/alerts"
+
+// The checks alerts are sent as, so every alert about a node/service updates the same
+// BigPanda alert
+const (
+	bigPandaCheck     = "consul-health"
+	bigPandaMetaCheck = "consul-alerting-watch"
+)
+
+// BigPandaHandler sends alerts to BigPanda's alerts API. Recoveries are sent with the "ok"
+// status, so BigPanda resolves the incidents they were correlated into.
+type BigPandaHandler struct {
+	Token  string `mapstructure:"token"`
+	AppKey string `mapstructure:"app_key"`
+	URL    string `mapstructure:"url"`
+}
+
+// The body of a request to BigPanda's alerts API
+type bigPandaAlert struct {
+	AppKey      string `json:"app_key"`
+	Status      string `json:"status"`
+	Host        string `json:"host"`
+	Check       string `json:"check"`
+	Description string `json:"description"`
+	Timestamp   int64  `json:"timestamp"`
+	Cluster     string `json:"cluster,omitempty"`
+	Node        string `json:"node,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Tag         string `json:"tag,omitempty"`
+	Details     string `json:"details,omitempty"`
+	Link        string `json:"consul_url,omitempty"`
+	EventID     string `json:"event_id,omitempty"`
+}
+
+// Returns the BigPanda status for a Consul health status
+func bigPandaStatus(status string) string {
+	switch status {
+	case api.HealthCritical:
+		return "critical"
+	case api.HealthWarning:
+		return "warning"
+	}
+	return "ok"
+}
+
+// Returns the alert to send to BigPanda for an alert
+func (b BigPandaHandler) alert(alert *AlertState, now time.Time) bigPandaAlert {
+	check := bigPandaCheck
+	if alert.Meta {
+		check = bigPandaMetaCheck
+	}
+	host := alert.Node
+	if alert.Service != "" {
+		host = alert.Service
+		if alert.Tag != "" {
+			host = host + ":" + alert.Tag
+		}
+	}
+
+	return bigPandaAlert{
+		AppKey:      b.AppKey,
+		Status:      bigPandaStatus(alert.Status),
+		Host:        host,
+		Check:       check,
+		Description: alert.Message,
+		Timestamp:   now.Unix(),
+		Cluster:     alert.Datacenter,
+		Node:        alert.Node,
+		Service:     alert.Service,
+		Tag:         alert.Tag,
+		Details:     alert.Details,
+		Link:        alert.Link,
+		EventID:     alert.EventID,
+	}
+}
+
+func (b BigPandaHandler) Alert(ctx context.Context, alert *AlertState) error {
+	url := b.URL
+	if url == "" {
+		url = bigPandaDefaultURL
+	}
+
+	headers := map[string]string{"Authorization": "Bearer " + b.Token}
+	if err := postJSON(ctx, url, headers, b.alert(alert, time.Now())); err != nil {
+		return fmt.Errorf("Error sending alert to BigPanda: %s", err)
+	}
+	return nil
+}
+
+func (b BigPandaHandler) Validate() error {
+	if b.Token == "" {
+		return errors.New("no token given")
+	}
+	if b.AppKey == "" {
+		return errors.New("no app_key given")
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The states a circuit breaker can be in
+const breakerClosed = "closed"
+const breakerOpen = "open"
+const breakerHalfOpen = "half-open"
+
+// CircuitBreaker stops calls to a handler after it fails threshold times in a row, so we
+// don't keep waiting on an endpoint that's down. After the cooldown, a single call is let
+// through as a probe; if it succeeds the breaker closes again.
+type CircuitBreaker struct {
+	sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+// Returns true if a call to the handler should be attempted
+func (b *CircuitBreaker) allow(now time.Time) bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		log.Infof("Circuit breaker for handler %s is half-open, sending a probe", b.name)
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// Only the probe gets through until we know whether it succeeded
+		return false
+	}
+	return true
+}
+
+// Records the result of a call to the handler, opening or closing the breaker as needed
+func (b *CircuitBreaker) record(err error, now time.Time) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err == nil {
+		if b.state != breakerClosed {
+			log.Infof("Handler %s is working again, closing its circuit breaker", b.name)
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		log.Warnf("Opening circuit breaker for handler %s after %d failures, pausing calls for %s", b.name, b.failures, b.cooldown)
+		if b.state == breakerClosed {
+			metrics.Add(metricBreakerTrips, 1)
+		}
+		b.state = breakerOpen
+		b.openedAt = now
+	}
+}
+
+// Returns the current state of the breaker
+func (b *CircuitBreaker) current() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+// BreakerRegistry holds the circuit breaker for each handler
+type BreakerRegistry struct {
+	sync.Mutex
+	breakers map[string]*CircuitBreaker
+}
+
+var circuitBreakers = &BreakerRegistry{breakers: make(map[string]*CircuitBreaker)}
+
+// Returns the breaker for the named handler, creating it if needed
+func (r *BreakerRegistry) get(name string, options HandlerOptions) *CircuitBreaker {
+	r.Lock()
+	defer r.Unlock()
+
+	breaker, ok := r.breakers[name]
+	if !ok {
+		breaker = &CircuitBreaker{
+			name:      name,
+			threshold: options.BreakerThreshold,
+			cooldown:  time.Duration(options.BreakerCooldown) * time.Second,
+			state:     breakerClosed,
+		}
+		r.breakers[name] = breaker
+	}
+	return breaker
+}
+
+// Returns the state of each breaker that isn't closed, keyed by handler name
+func (r *BreakerRegistry) tripped() map[string]string {
+	r.Lock()
+	defer r.Unlock()
+
+	tripped := make(map[string]string)
+	for name, breaker := range r.breakers {
+		if state := breaker.current(); state != breakerClosed {
+			tripped[name] = state
+		}
+	}
+	return tripped
+}
+
+// Returns the sorted handler names from a map keyed by handler, such as the tripped breakers
+func handlerNames(handlers map[string]string) []string {
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
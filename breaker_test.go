@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Make sure the breaker opens after repeated failures, lets a single probe through after
+// the cooldown, and closes again once the probe succeeds
+func TestBreaker_openAndClose(t *testing.T) {
+	breaker := &CircuitBreaker{name: "test", threshold: 2, cooldown: time.Minute, state: breakerClosed}
+	now := time.Now()
+	failure := errors.New("connection refused")
+
+	breaker.record(failure, now)
+	if !breaker.allow(now) {
+		t.Fatal("expected breaker to stay closed after one failure")
+	}
+
+	breaker.record(failure, now)
+	if breaker.allow(now.Add(30 * time.Second)) {
+		t.Fatal("expected breaker to be open after two failures")
+	}
+
+	// After the cooldown only one probe should be let through
+	probeTime := now.Add(time.Minute)
+	if !breaker.allow(probeTime) {
+		t.Fatal("expected a probe to be allowed after the cooldown")
+	}
+	if breaker.allow(probeTime) {
+		t.Fatal("expected only one probe to be allowed")
+	}
+
+	// A failed probe opens the breaker again for another cooldown
+	breaker.record(failure, probeTime)
+	if breaker.allow(probeTime.Add(30*time.Second)) || breaker.current() != breakerOpen {
+		t.Fatal("expected breaker to reopen after a failed probe")
+	}
+
+	if !breaker.allow(probeTime.Add(time.Minute)) {
+		t.Fatal("expected a second probe to be allowed")
+	}
+	breaker.record(nil, probeTime.Add(time.Minute))
+	if breaker.current() != breakerClosed {
+		t.Fatalf("expected breaker to close after a successful probe, got %s", breaker.current())
+	}
+}
+
+// Make sure alerts go to the backup handler while a handler's breaker is open
+func TestBreaker_backupHandler(t *testing.T) {
+	failures, sent := 100, 0
+	alertCh := make(chan *AlertState, 1)
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"test.primary": flakyHandler{&failures, &sent},
+			"test.backup":  testHandler{alertCh},
+		},
+		HandlerOptions: map[string]HandlerOptions{
+			"test.primary": HandlerOptions{BreakerThreshold: 1, BreakerCooldown: 60, BackupHandler: "test.backup"},
+		},
+	}
+	defer func() { circuitBreakers = &BreakerRegistry{breakers: make(map[string]*CircuitBreaker)} }()
+
+	if err := invokeHandler(config, "test.primary", &AlertState{}); err == nil {
+		t.Fatal("expected the primary handler to fail")
+	}
+
+	if err := invokeHandler(config, "test.primary", &AlertState{Message: "redis is critical"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case alert := <-alertCh:
+		if alert.Message != "redis is critical" {
+			t.Errorf("unexpected alert sent to backup: %q", alert.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected alert to be sent to the backup handler")
+	}
+
+	if tripped := circuitBreakers.tripped(); tripped["test.primary"] != breakerOpen {
+		t.Errorf("expected test.primary's breaker to be reported as open, got %v", tripped)
+	}
+}
+
+// Make sure the global breaker settings apply to handlers that don't override them
+func TestBreaker_globalSettings(t *testing.T) {
+	config, err := ParseConfig(`
+	handler_breaker_threshold = 2
+	handler_breaker_cooldown = 300
+
+	handler "stdout" "default" {}
+	handler "stdout" "strict" {
+		breaker_threshold = 1
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options := config.handlerOptions("stdout.default"); options.BreakerThreshold != 2 || options.BreakerCooldown != 300 {
+		t.Errorf("expected the global breaker settings, got %d/%d", options.BreakerThreshold, options.BreakerCooldown)
+	}
+	if options := config.handlerOptions("stdout.strict"); options.BreakerThreshold != 1 || options.BreakerCooldown != 300 {
+		t.Errorf("expected the handler's threshold with the global cooldown, got %d/%d", options.BreakerThreshold, options.BreakerCooldown)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// Returns the KV path the capacity alert for a watch is stored at, given its alert's path
+func capacityKVPath(alertPath string) string {
+	return strings.TrimSuffix(alertPath, "alert") + "capacity"
+}
+
+// Returns the number of passing instances the service (or its tag, for a tag's watch) needs
+// to have, or 0 if it isn't checked
+func (c *Config) serviceMinHealthyInstances(service string) int {
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil {
+		return serviceConfig.MinHealthyInstances
+	}
+	return 0
+}
+
+// Checks the number of passing instances of the watch's service against its
+// min_healthy_instances, sending a capacity alert once it's been below the minimum for the
+// change threshold, and resolving it once it's back up. This is separate from the service's
+// own alert, so it's sent even if the failing instances were already alerted on. Called by
+// the watch after each query.
+func (s *WatchState) checkCapacity(alertPath string, opts *WatchOptions, now time.Time) {
+	min := opts.config.serviceMinHealthyInstances(opts.service)
+	if opts.service == "" || min <= 0 {
+		return
+	}
+
+	entries, _, err := opts.client.Health().Service(opts.service, opts.tag, true, nil)
+	if err != nil {
+		log.Errorf("Error counting the healthy instances of %s: %s", s.Name, err)
+		return
+	}
+	healthy := len(entries)
+
+	s.Lock()
+	if healthy >= min {
+		s.belowMinSince = time.Time{}
+	} else if s.belowMinSince.IsZero() {
+		s.belowMinSince = now
+	}
+	belowMinSince := s.belowMinSince
+	s.Unlock()
+
+	// Hold off while we're handing off to a new process, which will pick up from here
+	handoffLock.RLock()
+	defer handoffLock.RUnlock()
+
+	path := capacityKVPath(alertPath)
+	last, err := getAlertState(path, opts.client)
+	if err != nil {
+		log.Error("Error fetching capacity alert state: ", err)
+		return
+	}
+	open := last != nil && last.Status != "" && last.Status != api.HealthPassing
+
+	threshold := time.Duration(opts.config.serviceChangeThreshold(opts.service)) * time.Second
+	var status, message string
+	switch {
+	case healthy < min && !open && now.Sub(belowMinSince) >= threshold:
+		status = api.HealthCritical
+		message = fmt.Sprintf("[%s] %s has %d healthy instances, below the minimum of %d", opts.config.ConsulDatacenter, s.Name, healthy, min)
+	case healthy >= min && open:
+		status = api.HealthPassing
+		message = fmt.Sprintf("[%s] %s has %d healthy instances again, at least the minimum of %d", opts.config.ConsulDatacenter, s.Name, healthy, min)
+	default:
+		return
+	}
+
+	alert := &AlertState{
+		Status:      status,
+		Severity:    statusSeverity(status),
+		LastAlerted: api.HealthPassing,
+		Node:        opts.node,
+		Service:     opts.service,
+		Tag:         opts.tag,
+		Datacenter:  opts.config.ConsulDatacenter,
+		Message:     message,
+		Details:     fmt.Sprintf("Healthy instances: %d\nMinimum: %d", healthy, min),
+		EventID:     alertEventID(path, now.UnixNano(), status),
+		Meta:        true,
+		Capacity:    true,
+	}
+	if open {
+		alert.LastAlerted = last.Status
+		alert.IncidentID, alert.IncidentStatus, alert.IncidentStart = last.IncidentID, last.IncidentStatus, last.IncidentStart
+		alert.IncidentSeverity = last.IncidentSeverity
+	}
+	alert.updateIncident()
+	prioritizeAlert(opts, alert)
+
+	log.Infof("Sending capacity alert '%s'", message)
+	sendAlert(opts.config, opts.service, alert, func(string) {})
+
+	alert.LastAlerted, alert.LastSeverity, alert.LastPriority = status, alert.Severity, alert.Priority
+	if status == api.HealthPassing {
+		if _, err := opts.client.KV().Delete(path, nil); err != nil {
+			log.Errorf("Error deleting capacity alert state for %s: %s", s.Name, err)
+		}
+		return
+	}
+	setAlertState(path, alert, opts.client)
+}
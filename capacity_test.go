@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/consul/structs"
+)
+
+// Make sure a capacity alert is sent once the service has been below its
+// min_healthy_instances for the change threshold, and resolved once it's back up
+func TestCapacity_checkCapacity(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	server.AddService(testServiceName, structs.HealthCritical, nil)
+
+	config, err := ParseConfig(`
+	service "redis" {
+		change_threshold = 30
+		min_healthy_instances = 1
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConsulDatacenter = "dc1"
+	alertCh := make(chan *AlertState, 1)
+	config.Handlers = map[string]AlertHandler{"test": testHandler{alertCh}}
+
+	opts := &WatchOptions{service: testServiceName, config: config, client: client, alertLock: &sync.Mutex{}}
+	alertPath := watchKVPath("", testServiceName, "") + "alert"
+	state := newWatchState("service redis", "", testServiceName, "")
+
+	start := time.Now()
+	state.checkCapacity(alertPath, opts, start)
+	if len(alertCh) != 0 || state.belowMinSince.IsZero() {
+		t.Fatal("expected no capacity alert within the change threshold")
+	}
+
+	state.checkCapacity(alertPath, opts, start.Add(time.Minute))
+	select {
+	case alert := <-alertCh:
+		if alert.Status != api.HealthCritical || !alert.Capacity || !strings.Contains(alert.Message, "0 healthy instances, below the minimum of 1") {
+			t.Errorf("unexpected alert %+v", alert)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a capacity alert")
+	}
+
+	// It's only sent once
+	state.checkCapacity(alertPath, opts, start.Add(2*time.Minute))
+	if len(alertCh) != 0 {
+		t.Fatal("expected the capacity alert to only be sent once")
+	}
+
+	server.AddService(testServiceName, structs.HealthPassing, nil)
+	state.checkCapacity(alertPath, opts, start.Add(3*time.Minute))
+	select {
+	case alert := <-alertCh:
+		if alert.Status != api.HealthPassing || !strings.Contains(alert.Message, "healthy instances again") {
+			t.Errorf("unexpected alert %+v", alert)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the capacity alert to be resolved")
+	}
+}
+
+// Capacity alerts are tracked separately from the service's own alert and meta-alerts
+func TestCapacity_targetKey(t *testing.T) {
+	service := &AlertState{Service: "redis"}
+	meta := &AlertState{Service: "redis", Meta: true}
+	capacity := &AlertState{Service: "redis", Meta: true, Capacity: true}
+	if alertTargetKey(capacity) == alertTargetKey(service) || alertTargetKey(capacity) == alertTargetKey(meta) {
+		t.Errorf("expected capacity alerts to have their own key, got %s", alertTargetKey(capacity))
+	}
+}
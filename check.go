@@ -38,7 +38,7 @@ func getCheckStates(kvPath string, client *api.Client) (map[string]*CheckState,
 		}
 
 		keyName := strings.Split(path, "/")
-		if keyName[len(keyName)-1] != "alert" && keyName[len(keyName)-1] != "leader" {
+		if !contains([]string{"alert", "leader", "ack", "claim", "escalation", "capacity"}, keyName[len(keyName)-1]) {
 			checkName := keyName[len(keyName)-2] + "/" + keyName[len(keyName)-1]
 			checkStates[checkName] = checkState
 		}
@@ -47,6 +47,21 @@ func getCheckStates(kvPath string, client *api.Client) (map[string]*CheckState,
 	return checkStates, nil
 }
 
+// Deletes the stored states of the checks under the given KV prefix, so they aren't loaded
+// again when the watch's lock is next acquired
+func deleteCheckStates(kvPath string, client *api.Client) error {
+	checkStates, err := getCheckStates(kvPath, client)
+	if err != nil {
+		return err
+	}
+	for checkName := range checkStates {
+		if _, err := client.KV().Delete(kvPath+checkName, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Parses a CheckState from a given Consul K/V path
 func getCheckState(kvPath string, client *api.Client) (*CheckState, error) {
 	kvPair, _, err := client.KV().Get(kvPath, nil)
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// OutputRule classifies a service's failing checks by their output, since it often says
+// more than the status does: it can give them a category, change their severity, or
+// suppress them so they're treated as passing, e.g. while a deploy is in progress
+type OutputRule struct {
+	// The regular expression the check's output must match. Required.
+	Output string `mapstructure:"output"`
+
+	// An optional glob pattern the check's name must match
+	Check string `mapstructure:"check"`
+
+	// What to do with the matching checks. At least one is required.
+	Category string `mapstructure:"category"`
+	Severity string `mapstructure:"severity"`
+	Suppress bool   `mapstructure:"suppress"`
+
+	output *regexp.Regexp
+}
+
+// Parses and validates the rule's patterns and severity
+func (r *OutputRule) parse() error {
+	if r.Output == "" {
+		return fmt.Errorf("output must be set")
+	}
+	var err error
+	if r.output, err = regexp.Compile(r.Output); err != nil {
+		return fmt.Errorf("invalid output: %s", err)
+	}
+	if _, err := path.Match(r.Check, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", r.Check, err)
+	}
+	if _, ok := severityOrder[r.Severity]; r.Severity != "" && !ok {
+		return fmt.Errorf("invalid severity %q, must be ok, warning, critical or unknown", r.Severity)
+	}
+	if r.Category == "" && r.Severity == "" && !r.Suppress {
+		return fmt.Errorf("one of category, severity or suppress must be set")
+	}
+	return nil
+}
+
+// Returns true if the rule applies to a failing check with the given name and output
+func (r *OutputRule) matches(name, output string) bool {
+	if ok, _ := path.Match(r.Check, name); r.Check != "" && !ok {
+		return false
+	}
+	return r.output.MatchString(output)
+}
+
+// Returns the first of the service's output rules that applies to a failing check with the
+// given name and output, or nil if none do
+func (c *Config) outputRule(service, name, output string) *OutputRule {
+	serviceConfig := c.serviceConfig(service)
+	if serviceConfig == nil {
+		return nil
+	}
+	for i := range serviceConfig.OutputRules {
+		if rule := &serviceConfig.OutputRules[i]; rule.matches(name, output) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Returns the service's checks with the failing ones its output rules suppress marked as
+// passing, so they're ignored when its health is computed. The checks given are left as
+// they are.
+func (c *Config) suppressChecks(service string, checks []*api.HealthCheck) []*api.HealthCheck {
+	serviceConfig := c.serviceConfig(service)
+	if serviceConfig == nil || len(serviceConfig.OutputRules) == 0 {
+		return checks
+	}
+
+	result := make([]*api.HealthCheck, 0, len(checks))
+	for _, check := range checks {
+		if check.Status != api.HealthPassing {
+			if rule := c.outputRule(service, check.Name, check.Output); rule != nil && rule.Suppress {
+				log.Debugf("Suppressing failing check '%s' on node %s of service %s, its output matches %q", check.Name, check.Node, service, rule.Output)
+				suppressed := *check
+				suppressed.Status = api.HealthPassing
+				check = &suppressed
+			}
+		}
+		result = append(result, check)
+	}
+	return result
+}
+
+// Returns the category the service's output rules give a failing check, or "" if none do
+func (c *Config) checkCategory(service string, check AlertCheck) string {
+	if rule := c.outputRule(service, check.Name, check.Output); rule != nil {
+		return rule.Category
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestClassify_outputRules(t *testing.T) {
+	config, err := ParseConfig(`
+	severity_rule "disk-full" {
+		output = "disk"
+		severity = "critical"
+	}
+	service "api" {
+		output_rule {
+			output = "deploy in progress"
+			suppress = true
+		}
+		output_rule {
+			check = "Disk*"
+			output = "(9[5-9]|100)% used"
+			category = "disk"
+			severity = "warning"
+		}
+		output_rule {
+			output = "connection refused"
+			category = "network"
+		}
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []*api.HealthCheck{
+		{Node: "a", CheckID: "http", Name: "HTTP", Status: api.HealthCritical, Output: "503: deploy in progress"},
+		{Node: "b", CheckID: "http", Name: "HTTP", Status: api.HealthCritical, Output: "connection refused"},
+		{Node: "c", CheckID: "http", Name: "HTTP", Status: api.HealthPassing, Output: "deploy in progress"},
+	}
+	suppressed := config.suppressChecks("api", checks)
+	for i, status := range []string{api.HealthPassing, api.HealthCritical, api.HealthPassing} {
+		if suppressed[i].Status != status {
+			t.Errorf("check %d: expected %s, got %s", i, status, suppressed[i].Status)
+		}
+	}
+	if checks[0].Status != api.HealthCritical {
+		t.Error("expected the original checks to be left as they are")
+	}
+	if other := config.suppressChecks("web", checks); other[0].Status != api.HealthCritical {
+		t.Error("expected another service's checks not to be suppressed")
+	}
+
+	// The output rule's severity takes precedence over the severity rule, and the category
+	// comes from the worst categorized check
+	alert := &AlertState{Status: api.HealthCritical, Checks: []AlertCheck{
+		{Name: "Disk usage", Status: api.HealthCritical, Output: "disk 97% used"},
+		{Name: "HTTP", Status: api.HealthCritical, Output: "connection refused"},
+	}}
+	config.applySeverity("api", alert)
+	if alert.Checks[0].Severity != severityWarning || alert.Checks[0].Category != "disk" {
+		t.Errorf("expected the disk check to be a warning in the disk category, got %q %q", alert.Checks[0].Severity, alert.Checks[0].Category)
+	}
+	if alert.Severity != severityCritical || alert.Category != "network" {
+		t.Errorf("expected a critical alert in the network category, got %q %q", alert.Severity, alert.Category)
+	}
+
+	// Other services only get the severity rules
+	config.applySeverity("web", alert)
+	if alert.Checks[0].Severity != severityCritical || alert.Category != "" {
+		t.Errorf("expected a critical disk check without a category, got %q %q", alert.Checks[0].Severity, alert.Category)
+	}
+
+	matcher := &AlertMatcher{Category: []string{"network"}}
+	if !matcher.matches("api", &AlertState{Status: api.HealthCritical, Category: "network"}) {
+		t.Error("expected the network alert to match")
+	}
+	if !matcher.matches("api", &AlertState{Status: api.HealthPassing, LastCategory: "network"}) {
+		t.Error("expected the network alert's recovery to match")
+	}
+	if matcher.matches("api", &AlertState{Status: api.HealthCritical, Category: "disk"}) {
+		t.Error("expected the disk alert not to match")
+	}
+}
+
+func TestClassify_parseErrors(t *testing.T) {
+	for _, raw := range []string{
+		`service "api" { output_rule { suppress = true } }`,
+		`service "api" { output_rule { output = "(" suppress = true } }`,
+		`service "api" { output_rule { output = "x" } }`,
+		`service "api" { output_rule { output = "x" severity = "bad" } }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("expected an error for %q", raw)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// A subcommand that can be given as the first argument in place of running the daemon
+type Command struct {
+	// A one-line description, used in the usage text and shell completions
+	Synopsis string
+
+	// The names of the flags the command accepts, used for shell completions. Flags that
+	// take a value end in "=".
+	Flags []string
+
+	// The possible values for the command's positional argument, if it takes one
+	Args []string
+
+	// Runs the command with the remaining arguments, returning the process exit code
+	Run func(args []string) int
+}
+
+var commands map[string]Command
+
+// The flags accepted when running the daemon itself
+var daemonFlags = []string{"config=", "help", "json-errors"}
+
+// Set up the commands in init() since the completion command refers back to this map
+func init() {
+	commands = map[string]Command{
+		"healthcheck": Command{
+			Synopsis: "Check the health of a running daemon",
+			Flags:    []string{"config=", "address=", "timeout="},
+			Run:      healthcheckCommand,
+		},
+		"explain-routing": Command{
+			Synopsis: "Show which handlers an alert would be sent to",
+			Flags:    []string{"config=", "service=", "tag=", "node=", "datacenter=", "status=", "severity=", "category=", "priority="},
+			Run:      explainRoutingCommand,
+		},
+		"simulate": Command{
+			Synopsis: "Replay a scenario of health changes in dry-run mode",
+			Flags:    []string{"config="},
+			Run:      simulateCommand,
+		},
+		"state": Command{
+			Synopsis: "Wipe stored alert state and locks from Consul",
+			Flags:    []string{"config=", "all", "service=", "tag=", "node=", "dry-run"},
+			Args:     []string{"wipe"},
+			Run:      stateCommand,
+		},
+		"doctor": Command{
+			Synopsis: "Check stored alert state against live health in Consul",
+			Flags:    []string{"config=", "repair", "json"},
+			Run:      doctorCommand,
+		},
+		"dead-letter": Command{
+			Synopsis: "List or replay notifications that ran out of retries",
+			Flags:    []string{"config=", "file", "handler="},
+			Args:     []string{"list", "replay"},
+			Run:      deadLetterCommand,
+		},
+		"template": Command{
+			Synopsis: "Render a sample alert through a handler's templates",
+			Flags:    []string{"config=", "handler=", "title-file=", "body-file=", "status=", "service=", "tag=", "node="},
+			Args:     []string{"test"},
+			Run:      templateCommand,
+		},
+		"test-alert": Command{
+			Synopsis: "Send a test alert through the configured handlers",
+			Flags:    []string{"config=", "handler=", "all", "status=", "service=", "tag=", "node="},
+			Run:      testAlertCommand,
+		},
+		"top": Command{
+			Synopsis: "Show a live view of a running daemon's watches",
+			Flags:    []string{"config=", "address=", "interval="},
+			Run:      topCommand,
+		},
+		"completion": Command{
+			Synopsis: "Output a shell completion script",
+			Args:     []string{"bash", "zsh", "fish"},
+			Run:      completionCommand,
+		},
+	}
+}
+
+// Returns the command names in sorted order
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the usage text for running the daemon, including the list of commands
+func usageText() string {
+	text := usage + "\nCommands:\n\n"
+	for _, name := range commandNames() {
+		text += fmt.Sprintf("    %-18s%s\n", name, commands[name].Synopsis)
+	}
+	return text
+}
+
+// Returns the address of a running daemon's HTTP endpoints, either the one given or the
+// http_address from the config file
+func daemonAddress(configPath, address string) (string, error) {
+	if address == "" && configPath != "" {
+		config, err := ParseConfigFile(configPath)
+		if err != nil {
+			return "", err
+		}
+		address = config.HTTPAddress
+	}
+
+	if address == "" {
+		return "", fmt.Errorf("No address given; set http_address in the config or pass -address")
+	}
+
+	return address, nil
+}
+
+const healthcheckUsage = `Usage: consul-alerting healthcheck [options]
+
+  Queries the health endpoint of a running daemon, exiting 0 if it's healthy (or only
+  degraded because Consul is unreachable) and 1 otherwise. Intended for use in container
+  health checks.
+
+Options:
+
+    -config=<path>     The config file to read http_address from.
+    -address=<addr>    The address of the daemon's HTTP endpoint. Overrides the config.
+    -timeout=<dur>     How long to wait for a response. Defaults to 5s.
+`
+
+func healthcheckCommand(args []string) int {
+	var configPath, address string
+	var timeout time.Duration
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, healthcheckUsage) }
+	flags.StringVar(&configPath, "config", "", "")
+	flags.StringVar(&address, "address", "", "")
+	flags.DurationVar(&timeout, "timeout", 5*time.Second, "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	address, err := daemonAddress(configPath, address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get("http://" + address + "/v1/health")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error querying health endpoint: %s\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	var health HealthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing health response: %s\n", err)
+		return 1
+	}
+
+	if resp.StatusCode != http.StatusOK || health.Status == unhealthyStatus {
+		fmt.Printf("%s, stalled: %v\n", health.Status, health.Stalled)
+		return 1
+	}
+
+	// Being unable to reach Consul isn't something restarting us would fix
+	if health.Status == degradedStatus {
+		fmt.Printf("%s, consul error: %s\n", health.Status, health.ConsulError)
+		return 0
+	}
+
+	fmt.Println(health.Status)
+	return 0
+}
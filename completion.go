@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const completionUsage = `Usage: consul-alerting completion <bash|zsh|fish>
+
+  Outputs a completion script for the given shell. For example:
+
+    consul-alerting completion bash > /etc/bash_completion.d/consul-alerting
+`
+
+func completionCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, completionUsage)
+		return 1
+	}
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported shell: %s\n", args[0])
+		fmt.Fprint(os.Stderr, completionUsage)
+		return 1
+	}
+
+	return 0
+}
+
+// Returns the words to complete after the given command, or at the top level if
+// command is empty
+func completionWords(command string) []string {
+	words := make([]string, 0)
+	flags := daemonFlags
+
+	if command == "" {
+		words = append(words, commandNames()...)
+	} else {
+		flags = commands[command].Flags
+		words = append(words, commands[command].Args...)
+	}
+
+	for _, flag := range flags {
+		words = append(words, "-"+flag)
+	}
+
+	return words
+}
+
+func bashCompletion() string {
+	cases := ""
+	for _, name := range commandNames() {
+		cases += fmt.Sprintf("        %s) opts=%q ;;\n", name, strings.Join(completionWords(name), " "))
+	}
+
+	return fmt.Sprintf(`# bash completion for consul-alerting
+_consul_alerting() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local opts=%q
+
+    if [ "$COMP_CWORD" -gt 1 ]; then
+        case "${COMP_WORDS[1]}" in
+%s        esac
+    fi
+
+    COMPREPLY=( $(compgen -W "$opts" -- "$cur") )
+    if [[ "${COMPREPLY[0]}" == *= ]]; then
+        compopt -o nospace
+    fi
+}
+complete -F _consul_alerting consul-alerting
+`, strings.Join(completionWords(""), " "), cases)
+}
+
+func zshCompletion() string {
+	cases := ""
+	for _, name := range commandNames() {
+		cases += fmt.Sprintf("      %s) opts=(%s) ;;\n", name, strings.Join(completionWords(name), " "))
+	}
+
+	return fmt.Sprintf(`#compdef consul-alerting
+# zsh completion for consul-alerting
+_consul_alerting() {
+  local -a opts
+  opts=(%s)
+
+  if (( CURRENT > 2 )); then
+    case "${words[2]}" in
+%s    esac
+  fi
+
+  compadd -S '' -- ${(M)opts:#*=}
+  compadd -- ${opts:#*=}
+}
+compdef _consul_alerting consul-alerting
+`, strings.Join(completionWords(""), " "), cases)
+}
+
+func fishCompletion() string {
+	lines := []string{
+		"# fish completion for consul-alerting",
+		"complete -c consul-alerting -f",
+	}
+
+	for _, flag := range daemonFlags {
+		lines = append(lines, fmt.Sprintf("complete -c consul-alerting -n '__fish_use_subcommand' %s", fishFlag(flag)))
+	}
+
+	for _, name := range commandNames() {
+		command := commands[name]
+		lines = append(lines, fmt.Sprintf("complete -c consul-alerting -n '__fish_use_subcommand' -a %s -d %q", name, command.Synopsis))
+
+		condition := fmt.Sprintf("'__fish_seen_subcommand_from %s'", name)
+		for _, flag := range command.Flags {
+			lines = append(lines, fmt.Sprintf("complete -c consul-alerting -n %s %s", condition, fishFlag(flag)))
+		}
+		if len(command.Args) > 0 {
+			lines = append(lines, fmt.Sprintf("complete -c consul-alerting -n %s -a %q", condition, strings.Join(command.Args, " ")))
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Returns the fish completion options for a flag, requiring an argument if it takes a value
+func fishFlag(flag string) string {
+	if strings.HasSuffix(flag, "=") {
+		return "-o " + strings.TrimSuffix(flag, "=") + " -r"
+	}
+	return "-o " + flag
+}
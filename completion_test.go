@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompletion_words(t *testing.T) {
+	expected := []string{"-config=", "-address=", "-timeout="}
+	if words := completionWords("healthcheck"); !reflect.DeepEqual(words, expected) {
+		t.Errorf("expected %v, got %v", expected, words)
+	}
+
+	// Every command should be offered at the top level
+	words := completionWords("")
+	for _, name := range commandNames() {
+		if !contains(words, name) {
+			t.Errorf("expected command %s in top-level completions %v", name, words)
+		}
+	}
+}
+
+// Make sure every command shows up in each shell's script
+func TestCompletion_scripts(t *testing.T) {
+	scripts := map[string]string{
+		"bash": bashCompletion(),
+		"zsh":  zshCompletion(),
+		"fish": fishCompletion(),
+	}
+
+	for shell, script := range scripts {
+		for _, name := range commandNames() {
+			if !strings.Contains(script, name) {
+				t.Errorf("expected %s completion to include command %s", shell, name)
+			}
+		}
+	}
+}
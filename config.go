@@ -3,8 +3,13 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/hcl"
 	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/mitchellh/mapstructure"
@@ -14,26 +19,127 @@ const LocalMode = "local"
 const GlobalMode = "global"
 
 type Config struct {
-	ConsulAddress    string   `mapstructure:"consul_address"`
-	ConsulToken      string   `mapstructure:"consul_token"`
-	ConsulDatacenter string   `mapstructure:"datacenter"`
-	DevMode          bool     `mapstructure:"dev_mode"`
-	NodeWatch        string   `mapstructure:"node_watch"`
-	ServiceWatch     string   `mapstructure:"service_watch"`
-	ChangeThreshold  int      `mapstructure:"change_threshold"`
-	DefaultHandlers  []string `mapstructure:"default_handlers"`
-	LogLevel         string   `mapstructure:"log_level"`
-
-	Services map[string]ServiceConfig
-	Handlers map[string]AlertHandler
+	ConsulAddress            string   `mapstructure:"consul_address"`
+	ConsulToken              string   `mapstructure:"consul_token"`
+	ConsulDatacenter         string   `mapstructure:"datacenter"`
+	DevMode                  bool     `mapstructure:"dev_mode"`
+	NodeWatch                string   `mapstructure:"node_watch"`
+	ServiceWatch             string   `mapstructure:"service_watch"`
+	ChangeThreshold          int      `mapstructure:"change_threshold"`
+	ReminderInterval         int      `mapstructure:"reminder_interval"`
+	GroupWindow              int      `mapstructure:"group_window"`
+	CorrelationWindow        int      `mapstructure:"correlation_window"`
+	StaleAlertGrace          int      `mapstructure:"stale_alert_grace"`
+	NodeDownInhibit          bool     `mapstructure:"node_down_inhibit"`
+	FlapThreshold            int      `mapstructure:"flap_threshold"`
+	FlapWindow               int      `mapstructure:"flap_window"`
+	DedupTTL                 int      `mapstructure:"dedup_ttl"`
+	SilenceKVPrefix          string   `mapstructure:"silence_kv_prefix"`
+	DefaultHandlers          []string `mapstructure:"default_handlers"`
+	LogLevel                 string   `mapstructure:"log_level"`
+	PidFile                  string   `mapstructure:"pid_file"`
+	Umask                    string   `mapstructure:"umask"`
+	WorkingDir               string   `mapstructure:"working_dir"`
+	HTTPAddress              string   `mapstructure:"http_address"`
+	AckSlackSigningSecret    string   `mapstructure:"ack_slack_signing_secret"`
+	AckPagerDutySecret       string   `mapstructure:"ack_pagerduty_secret"`
+	StartupTimeout           int      `mapstructure:"startup_timeout"`
+	LeaderGracePeriod        int      `mapstructure:"leader_grace_period"`
+	RetryQueuePath           string   `mapstructure:"retry_queue_path"`
+	RetryMaxAge              int      `mapstructure:"retry_max_age"`
+	DeadLetterHandler        string   `mapstructure:"dead_letter_handler"`
+	DeadLetterPath           string   `mapstructure:"dead_letter_path"`
+	DeadLetterKVPrefix       string   `mapstructure:"dead_letter_kv_prefix"`
+	HandlerTimeout           int      `mapstructure:"handler_timeout"`
+	HandlerConnectTimeout    int      `mapstructure:"handler_connect_timeout"`
+	HandlerBreakerThreshold  int      `mapstructure:"handler_breaker_threshold"`
+	HandlerBreakerCooldown   int      `mapstructure:"handler_breaker_cooldown"`
+	DispatchWorkers          int      `mapstructure:"dispatch_workers"`
+	DispatchQueueSize        int      `mapstructure:"dispatch_queue_size"`
+	ProbeHandlers            bool     `mapstructure:"probe_handlers"`
+	HandlerProbeInterval     int      `mapstructure:"handler_probe_interval"`
+	ConsistencyCheckInterval int      `mapstructure:"consistency_check_interval"`
+	ConsistencyRepair        bool     `mapstructure:"consistency_repair"`
+	NomadAddress             string   `mapstructure:"nomad_address"`
+	NomadToken               string   `mapstructure:"nomad_token"`
+	NomadDeploymentThreshold int      `mapstructure:"nomad_deployment_threshold"`
+	K8sSyncTag               string   `mapstructure:"k8s_sync_tag"`
+	K8sRolloutWindow         int      `mapstructure:"k8s_rollout_window"`
+	K8sRolloutMaxHold        int      `mapstructure:"k8s_rollout_max_hold"`
+	PublishEvents            bool     `mapstructure:"publish_events"`
+	PublishKVPrefix          string   `mapstructure:"publish_kv_prefix"`
+	UIURL                    string   `mapstructure:"ui_url"`
+	UINamespace              string   `mapstructure:"ui_namespace"`
+	CheckOutputLimit         int      `mapstructure:"check_output_limit"`
+	PluginDir                string   `mapstructure:"plugin_dir"`
+	VaultAddress             string   `mapstructure:"vault_address"`
+	VaultToken               string   `mapstructure:"vault_token"`
+	VaultRefreshInterval     int      `mapstructure:"vault_refresh_interval"`
+	Timezone                 string   `mapstructure:"timezone"`
+
+	// Change thresholds for going to warning or critical, overriding change_threshold
+	WarningThreshold  *int `mapstructure:"warning_threshold"`
+	CriticalThreshold *int `mapstructure:"critical_threshold"`
+
+	// Warnings are held back during quiet hours, and sent as a summary once they end
+	QuietHours *Schedule `mapstructure:"quiet_hours"`
+
+	// The scores used to compute alerts' priorities, or nil to use the defaults
+	Priority *PriorityConfig `mapstructure:"-"`
+
+	Services       map[string]ServiceConfig
+	Handlers       map[string]AlertHandler
+	HandlerOptions map[string]HandlerOptions
+	Routes         []RouteConfig
+	SeverityRules  []SeverityRule
+	Escalations    []EscalationConfig
+	Silences       []Silence
+	InhibitRules   []InhibitRule
+
+	// The client used to read secrets from Vault, if any handlers use them
+	vault *VaultClient
 }
 
 type ServiceConfig struct {
-	Name            string
-	ChangeThreshold int      `mapstructure:"change_threshold"`
-	DistinctTags    bool     `mapstructure:"distinct_tags"`
-	IgnoredTags     []string `mapstructure:"ignored_tags"`
-	Handlers        []string `mapstructure:"handlers"`
+	Name              string
+	ChangeThreshold   int      `mapstructure:"change_threshold"`
+	ReminderInterval  int      `mapstructure:"reminder_interval"`
+	GroupWindow       int      `mapstructure:"group_window"`
+	CorrelationWindow int      `mapstructure:"correlation_window"`
+	DistinctTags      bool     `mapstructure:"distinct_tags"`
+	IgnoredTags       []string `mapstructure:"ignored_tags"`
+	Handlers          []string `mapstructure:"handlers"`
+
+	// Change thresholds for the service going to warning or critical, overriding the global
+	// ones and change_threshold
+	WarningThreshold  *int `mapstructure:"warning_threshold"`
+	CriticalThreshold *int `mapstructure:"critical_threshold"`
+
+	// Whether change_threshold was set in the service block rather than inherited, so it
+	// can take precedence over the global warning_threshold and critical_threshold
+	changeThresholdSet bool
+
+	// Change thresholds for individual checks, by check name or ID, overriding
+	// change_threshold while they're failing
+	CheckThresholds map[string]int `mapstructure:"check_thresholds"`
+
+	// Only alert while more than this percentage of the service's instances are failing,
+	// instead of whenever any are
+	FailingPercent int `mapstructure:"failing_percent"`
+
+	// Alert when fewer than this many of the service's instances (or its tag's, with
+	// distinct_tags) are passing
+	MinHealthyInstances int `mapstructure:"min_healthy_instances"`
+
+	// Overrides the global quiet_hours for the service
+	QuietHours *Schedule `mapstructure:"quiet_hours"`
+
+	// Rules classifying the service's failing checks by their output, checked in order
+	OutputRules []OutputRule `mapstructure:"output_rule"`
+
+	// How important the service is (e.g. tier1), for its alerts' priority. If unset, it's
+	// read from the criticality metadata of its instances in Consul.
+	Criticality string `mapstructure:"criticality"`
 }
 
 // Parses a given file path for config and returns a Config object and an array
@@ -83,14 +189,37 @@ func ParseConfig(raw string) (*Config, error) {
 	}
 	delete(m, "service")
 	delete(m, "handler")
+	delete(m, "route")
+	delete(m, "severity_rule")
+	delete(m, "escalation")
+	delete(m, "silence")
+	delete(m, "inhibit_rule")
 
 	// Set defaults for unset keys
 	defaultConfig := map[string]interface{}{
-		"consul_address":   "localhost:8500",
-		"node_watch":       "local",
-		"service_watch":    "local",
-		"change_threshold": 60,
-		"log_level":        "info",
+		"consul_address":             "localhost:8500",
+		"node_watch":                 "local",
+		"service_watch":              "local",
+		"change_threshold":           60,
+		"flap_window":                600,
+		"stale_alert_grace":          300,
+		"dedup_ttl":                  300,
+		"silence_kv_prefix":          "consul-alerting/silences/",
+		"log_level":                  "info",
+		"leader_grace_period":        30,
+		"retry_max_age":              3600,
+		"handler_timeout":            30,
+		"handler_connect_timeout":    10,
+		"handler_breaker_threshold":  5,
+		"handler_breaker_cooldown":   60,
+		"dispatch_workers":           8,
+		"dispatch_queue_size":        1000,
+		"consistency_check_interval": 600,
+		"nomad_deployment_threshold": 900,
+		"k8s_sync_tag":               "k8s",
+		"k8s_rollout_max_hold":       600,
+		"check_output_limit":         500,
+		"vault_refresh_interval":     300,
 	}
 	for k, v := range defaultConfig {
 		if _, ok := m[k]; !ok {
@@ -98,10 +227,41 @@ func ParseConfig(raw string) (*Config, error) {
 		}
 	}
 
+	// A quiet_hours block decodes as a list of objects, so unwrap it
+	if blocks, ok := m["quiet_hours"].([]map[string]interface{}); ok {
+		if len(blocks) != 1 {
+			return nil, fmt.Errorf("Only one quiet_hours block can be set")
+		}
+		m["quiet_hours"] = blocks[0]
+	}
+
+	// The priority block is decoded over the default scores separately
+	rawPriority := m["priority"]
+	delete(m, "priority")
+
 	// Decode the simple (non service/handler) objects into Config
 	if err := mapstructure.WeakDecode(&m, &config); err != nil {
 		return nil, err
 	}
+	if config.Timezone != "" {
+		if _, err := time.LoadLocation(config.Timezone); err != nil {
+			return nil, fmt.Errorf("Invalid value for timezone: %s", err)
+		}
+	}
+	if config.QuietHours != nil {
+		if err := config.QuietHours.parse(config.Timezone); err != nil {
+			return nil, fmt.Errorf("Invalid quiet_hours: %s", err)
+		}
+	}
+	if config.Priority, err = parsePriority(rawPriority); err != nil {
+		return nil, err
+	}
+
+	for _, secret := range []*string{&config.AckSlackSigningSecret, &config.AckPagerDutySecret} {
+		if *secret, err = resolveSecret(&config, *secret); err != nil {
+			return nil, err
+		}
+	}
 
 	// Use parser function for service blocks
 	config.Services = make(map[string]ServiceConfig)
@@ -114,6 +274,7 @@ func ParseConfig(raw string) (*Config, error) {
 
 	// Use parser function for handler blocks
 	config.Handlers = make(map[string]AlertHandler)
+	config.HandlerOptions = make(map[string]HandlerOptions)
 	if obj := list.Filter("handler"); len(obj.Items) > 0 {
 		err = parseHandlers(obj, &config)
 		if err != nil {
@@ -121,6 +282,46 @@ func ParseConfig(raw string) (*Config, error) {
 		}
 	}
 
+	// Use parser function for route blocks
+	if obj := list.Filter("route"); len(obj.Items) > 0 {
+		err = parseRoutes(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Use parser function for severity rule blocks
+	if obj := list.Filter("severity_rule"); len(obj.Items) > 0 {
+		err = parseSeverityRules(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Use parser function for escalation blocks
+	if obj := list.Filter("escalation"); len(obj.Items) > 0 {
+		err = parseEscalations(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Use parser function for silence blocks
+	if obj := list.Filter("silence"); len(obj.Items) > 0 {
+		err = parseSilences(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Use parser function for inhibit rule blocks
+	if obj := list.Filter("inhibit_rule"); len(obj.Items) > 0 {
+		err = parseInhibitRules(obj, &config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Validate config
 	validWatchModes := []string{LocalMode, GlobalMode}
 
@@ -132,6 +333,54 @@ func ParseConfig(raw string) (*Config, error) {
 		return nil, fmt.Errorf("Invalid value for service_watch: %s", config.ServiceWatch)
 	}
 
+	for key, threshold := range map[string]*int{"warning_threshold": config.WarningThreshold, "critical_threshold": config.CriticalThreshold} {
+		if threshold != nil && *threshold < 0 {
+			return nil, fmt.Errorf("Invalid value for %s: can't be negative", key)
+		}
+	}
+
+	if config.FlapThreshold > 0 && config.FlapWindow <= 0 {
+		return nil, fmt.Errorf("flap_window must be positive to use flap_threshold")
+	}
+
+	if config.Umask != "" {
+		if _, err := parseUmask(config.Umask); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := config.Handlers[config.DeadLetterHandler]; config.DeadLetterHandler != "" && !ok {
+		return nil, fmt.Errorf("Unknown handler for dead_letter_handler: %s", config.DeadLetterHandler)
+	}
+
+	for _, route := range config.Routes {
+		for _, name := range route.Handlers {
+			if _, ok := config.Handlers[name]; !ok {
+				return nil, fmt.Errorf("Unknown handler for route %s: %s", route.Name, name)
+			}
+		}
+	}
+
+	for _, escalation := range config.Escalations {
+		for _, step := range escalation.Steps {
+			for _, name := range step.Handlers {
+				if _, ok := config.Handlers[name]; !ok {
+					return nil, fmt.Errorf("Unknown handler for escalation %s: %s", escalation.Name, name)
+				}
+			}
+		}
+	}
+
+	for name, options := range config.HandlerOptions {
+		fallback := options.fallbackHandler()
+		if _, ok := config.Handlers[fallback]; fallback != "" && !ok {
+			return nil, fmt.Errorf("Unknown fallback for handler %s: %s", name, fallback)
+		}
+		if fallback == name {
+			return nil, fmt.Errorf("Handler %s can't be its own fallback", name)
+		}
+	}
+
 	return &config, nil
 }
 
@@ -148,15 +397,68 @@ func parseServices(list *ast.ObjectList, config *Config) error {
 			return err
 		}
 
-		if _, ok := m["change_threshold"]; !ok {
+		_, changeThresholdSet := m["change_threshold"]
+		if !changeThresholdSet {
 			m["change_threshold"] = config.ChangeThreshold
 		}
+		if _, ok := m["reminder_interval"]; !ok {
+			m["reminder_interval"] = config.ReminderInterval
+		}
+		if _, ok := m["group_window"]; !ok {
+			m["group_window"] = config.GroupWindow
+		}
+		if _, ok := m["correlation_window"]; !ok {
+			m["correlation_window"] = config.CorrelationWindow
+		}
+
+		// A quiet_hours block decodes as a list of objects, so unwrap it
+		if blocks, ok := m["quiet_hours"].([]map[string]interface{}); ok {
+			if len(blocks) != 1 {
+				return fmt.Errorf("Service %s can only have one quiet_hours block", name)
+			}
+			m["quiet_hours"] = blocks[0]
+		}
+
+		// A check_thresholds object decodes as a list of objects, so unwrap it
+		if blocks, ok := m["check_thresholds"].([]map[string]interface{}); ok {
+			if len(blocks) != 1 {
+				return fmt.Errorf("Service %s can only have one check_thresholds block", name)
+			}
+			m["check_thresholds"] = blocks[0]
+		}
 
 		if err := mapstructure.WeakDecode(m, &service); err != nil {
 			return err
 		}
+		for key, threshold := range map[string]*int{"warning_threshold": service.WarningThreshold, "critical_threshold": service.CriticalThreshold} {
+			if threshold != nil && *threshold < 0 {
+				return fmt.Errorf("Invalid %s for service %s: can't be negative", key, name)
+			}
+		}
+		if service.MinHealthyInstances < 0 {
+			return fmt.Errorf("Invalid min_healthy_instances for service %s: can't be negative", name)
+		}
+		if service.FailingPercent < 0 || service.FailingPercent >= 100 {
+			return fmt.Errorf("Invalid failing_percent for service %s: %d, must be between 0 and 99", name, service.FailingPercent)
+		}
+		for check, threshold := range service.CheckThresholds {
+			if threshold < 0 {
+				return fmt.Errorf("Invalid check_thresholds for service %s: %s can't be negative", name, check)
+			}
+		}
+		if service.QuietHours != nil {
+			if err := service.QuietHours.parse(config.Timezone); err != nil {
+				return fmt.Errorf("Invalid quiet_hours for service %s: %s", name, err)
+			}
+		}
+		for i := range service.OutputRules {
+			if err := service.OutputRules[i].parse(); err != nil {
+				return fmt.Errorf("Invalid output_rule %d for service %s: %s", i+1, name, err)
+			}
+		}
 
 		service.Name = name
+		service.changeThresholdSet = changeThresholdSet
 		config.Services[name] = service
 	}
 
@@ -166,6 +468,7 @@ func parseServices(list *ast.ObjectList, config *Config) error {
 // Parse the raw handler objects into the config
 func parseHandlers(list *ast.ObjectList, config *Config) error {
 	config.Handlers = make(map[string]AlertHandler)
+	config.HandlerOptions = make(map[string]HandlerOptions)
 
 	defaultConfig := map[string]map[string]interface{}{
 		"stdout": map[string]interface{}{
@@ -174,6 +477,40 @@ func parseHandlers(list *ast.ObjectList, config *Config) error {
 		"pagerduty": map[string]interface{}{
 			"max_retries": 5,
 		},
+		"email": map[string]interface{}{
+			"from": defaultEmailFrom,
+			"tls":  "starttls",
+		},
+		"twilio": map[string]interface{}{
+			"statuses": []string{"critical"},
+		},
+		"jira": map[string]interface{}{
+			"issue_type": "Task",
+		},
+		"nsca": map[string]interface{}{
+			"encryption": "none",
+		},
+		"syslog": map[string]interface{}{
+			"protocol": "udp",
+			"facility": "local0",
+		},
+		"snmp": map[string]interface{}{
+			"version":   "2c",
+			"community": "public",
+		},
+		"eventbridge": map[string]interface{}{
+			"event_bus":   "default",
+			"source":      "consul-alerting",
+			"detail_type": "Consul Health Alert",
+		},
+		"eventgrid": map[string]interface{}{
+			"source": "consul-alerting",
+			"type":   "consul-alerting.health",
+		},
+		"elasticsearch": map[string]interface{}{
+			"index":       "consul-alerts",
+			"date_format": "2006.01.02",
+		},
 	}
 
 	for _, s := range list.Items {
@@ -183,6 +520,12 @@ func parseHandlers(list *ast.ObjectList, config *Config) error {
 		handlerType := s.Keys[0].Token.Value().(string)
 		name := s.Keys[1].Token.Value().(string)
 		id := handlerType + "." + name
+		if strings.Contains(name, ".") {
+			return fmt.Errorf("Invalid name for handler %s at line %d: names can't contain dots", id, s.Pos().Line)
+		}
+		if _, ok := config.HandlerOptions[id]; ok {
+			return fmt.Errorf("Handler %s is defined more than once (again at line %d)", id, s.Pos().Line)
+		}
 
 		var m map[string]interface{}
 		if err := hcl.DecodeObject(&m, s.Val); err != nil {
@@ -198,43 +541,125 @@ func parseHandlers(list *ast.ObjectList, config *Config) error {
 			}
 		}
 
-		// Decode based on the handler type.
-		// TODO: look into a more compact way to do this when we have more handlers
-		switch handlerType {
-		case "stdout":
-			var handler StdoutHandler
-			if err := mapstructure.WeakDecode(m, &handler); err != nil {
-				return err
+		// A schedule block decodes as a list of objects, so unwrap it
+		if blocks, ok := m["schedule"].([]map[string]interface{}); ok {
+			if len(blocks) != 1 {
+				return fmt.Errorf("Handler %s can only have one schedule block", id)
 			}
-			config.Handlers[id] = handler
-		case "email":
-			var handler EmailHandler
-			if err := mapstructure.WeakDecode(m, &handler); err != nil {
-				return err
+			m["schedule"] = blocks[0]
+		}
+
+		// Decode the options common to all handlers, falling back to the global settings
+		resolved, err := resolveSecrets(config, m)
+		if err != nil {
+			return fmt.Errorf("Invalid config for handler %s: %s", id, err)
+		}
+		options := HandlerOptions{
+			Timeout:          config.HandlerTimeout,
+			ConnectTimeout:   config.HandlerConnectTimeout,
+			BreakerThreshold: config.HandlerBreakerThreshold,
+			BreakerCooldown:  config.HandlerBreakerCooldown,
+		}
+		if err := mapstructure.WeakDecode(resolved, &options); err != nil {
+			return err
+		}
+		if options.MessageTemplate != "" || options.MessageTemplateFile != "" {
+			if options.BodyTemplate != "" || options.BodyTemplateFile != "" {
+				return fmt.Errorf("Invalid message_template for handler %s: only one of body_template and message_template can be set", id)
 			}
-			config.Handlers[id] = handler
-		case "pagerduty":
-			var handler PagerdutyHandler
-			if err := mapstructure.WeakDecode(m, &handler); err != nil {
-				return err
+			options.BodyTemplate, options.BodyTemplateFile = options.MessageTemplate, options.MessageTemplateFile
+		}
+		if options.titleTemplate, err = loadAlertTemplate("title_template", options.TitleTemplate, options.TitleTemplateFile); err != nil {
+			return fmt.Errorf("Invalid title_template for handler %s: %s", id, err)
+		}
+		if options.bodyTemplate, err = loadAlertTemplate("body_template", options.BodyTemplate, options.BodyTemplateFile); err != nil {
+			return fmt.Errorf("Invalid body_template for handler %s: %s", id, err)
+		}
+		if options.MinSeverity != "" && !contains([]string{severityWarning, severityUnknown, severityCritical}, options.MinSeverity) {
+			return fmt.Errorf("Invalid min_severity for handler %s: %q, must be warning, unknown or critical", id, options.MinSeverity)
+		}
+		if options.Proxy != "" {
+			if options.proxyURL, err = parseProxyURL(options.Proxy); err != nil {
+				return fmt.Errorf("Invalid proxy for handler %s: %s", id, err)
 			}
-			config.Handlers[id] = handler
-		case "slack":
-			var handler SlackHandler
-			if err := mapstructure.WeakDecode(m, &handler); err != nil {
-				return err
+		}
+		if options.Schedule != nil {
+			if err := options.Schedule.parse(config.Timezone); err != nil {
+				return fmt.Errorf("Invalid schedule for handler %s: %s", id, err)
 			}
-			config.Handlers[id] = handler
+		}
+		if options.middleware, err = parseMiddleware(options.Middleware); err != nil {
+			return fmt.Errorf("Invalid middleware for handler %s: %s", id, err)
+		}
+		switch options.RateLimitOverflow {
+		case "", overflowDrop, overflowQueue, overflowSummary:
 		default:
-			return fmt.Errorf("Unknown handler type: %s", handlerType)
+			return fmt.Errorf("Invalid rate_limit_overflow for handler %s: %q, must be drop, queue or summary", id, options.RateLimitOverflow)
+		}
+		if options.RateLimit < 0 || options.RateLimitBurst < 0 {
+			return fmt.Errorf("Invalid rate limit for handler %s: rate_limit and rate_limit_burst can't be negative", id)
+		}
+		if options.RetryMaxWait > 0 && options.RetryMaxWait < options.RetryMinWait {
+			return fmt.Errorf("Invalid retry_max_wait for handler %s: it's less than retry_min_wait", id)
+		}
+		config.HandlerOptions[id] = options
+		for _, key := range []string{"timeout", "connect_timeout", "proxy", "min_severity", "digest_window", "recovery_details", "breaker_threshold", "breaker_cooldown", "fallback", "backup_handler",
+			"title_template", "body_template", "title_template_file", "body_template_file",
+			"message_template", "message_template_file", "middleware", "schedule",
+			"retry_max_attempts", "retry_max_age", "retry_min_wait", "retry_max_wait",
+			"rate_limit", "rate_limit_burst", "rate_limit_overflow"} {
+			delete(m, key)
 		}
 
+		handler, err := newHandler(config, id, handlerType, m)
+		if err != nil {
+			return err
+		}
+		// Handlers using secrets from Vault are rebuilt when the secrets are rotated
+		if referencesSecrets(m, "vault") {
+			handler = newVaultHandler(id, handlerType, m, handler)
+		}
+		config.Handlers[id] = handler
+
 		log.Infof("Loaded handler: %s", id)
 	}
 
 	return nil
 }
 
+// Builds a handler of the given type from its settings (without the options common to all
+// handlers), resolving any secrets they reference
+func newHandler(config *Config, id, handlerType string, m map[string]interface{}) (AlertHandler, error) {
+	m, err := resolveSecrets(config, m)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config for handler %s: %s", id, err)
+	}
+
+	// Decode into a new handler of the given type
+	handlerPrototype, ok := handlerTypes[handlerType]
+	if !ok {
+		// Fall back to a plugin for the type, passing it the block's options as its config
+		path, found := findHandlerPlugin(config.PluginDir, handlerType)
+		if !found {
+			return nil, fmt.Errorf("Unknown handler type: %s", handlerType)
+		}
+		handlerPrototype = PluginHandler{}
+		m = map[string]interface{}{"command": []string{path}, "config": m}
+	}
+	value := reflect.New(reflect.TypeOf(handlerPrototype))
+	if err := mapstructure.WeakDecode(m, value.Interface()); err != nil {
+		return nil, err
+	}
+	handler := value.Elem().Interface().(AlertHandler)
+
+	if validator, ok := handler.(HandlerValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid config for handler %s: %s", id, err)
+		}
+	}
+	return handler, nil
+}
+
 func (config *Config) serviceConfig(service string) *ServiceConfig {
 	if s, ok := config.Services[service]; ok {
 		return &s
@@ -246,20 +671,51 @@ func (config *Config) serviceConfig(service string) *ServiceConfig {
 // Loads the configured alert handlers for a given service, filtering if applicable
 func (c *Config) serviceHandlers(service string) []AlertHandler {
 	handlers := make([]AlertHandler, 0)
-	filters := make([]string, 0)
-	serviceConfig := c.serviceConfig(service)
-	if serviceConfig != nil {
-		filters = serviceConfig.Handlers
+	for _, name := range c.serviceHandlerNames(service) {
+		handlers = append(handlers, c.Handlers[name])
 	}
-	if len(filters) == 0 {
-		filters = c.DefaultHandlers
+	return handlers
+}
+
+// Returns the common options for the named handler, using the global settings if the
+// handler wasn't loaded from a config file
+func (c *Config) handlerOptions(name string) HandlerOptions {
+	if options, ok := c.HandlerOptions[name]; ok {
+		return options
 	}
-	for name, handler := range c.Handlers {
+	return HandlerOptions{
+		Timeout:          c.HandlerTimeout,
+		ConnectTimeout:   c.HandlerConnectTimeout,
+		BreakerThreshold: c.HandlerBreakerThreshold,
+		BreakerCooldown:  c.HandlerBreakerCooldown,
+	}
+}
+
+// Returns the sorted names of the alert handlers for a given service, filtering if applicable
+func (c *Config) serviceHandlerNames(service string) []string {
+	names := make([]string, 0)
+	filters := c.serviceHandlerFilters(service)
+	for name := range c.Handlers {
+		// The dead letter handler only gets alerts when it's asked for explicitly
+		if len(filters) == 0 && name == c.DeadLetterHandler {
+			continue
+		}
 		if len(filters) == 0 || contains(filters, name) {
-			handlers = append(handlers, handler)
+			names = append(names, name)
 		}
 	}
-	return handlers
+	sort.Strings(names)
+	return names
+}
+
+// Returns the handler names a service is restricted to, either from the service config or
+// default_handlers. An empty list means all handlers are used.
+func (c *Config) serviceHandlerFilters(service string) []string {
+	serviceConfig := c.serviceConfig(service)
+	if serviceConfig != nil && len(serviceConfig.Handlers) > 0 {
+		return serviceConfig.Handlers
+	}
+	return c.DefaultHandlers
 }
 
 // Compute the changeThreshold for alerts on a service, defaulting to the global threshold
@@ -274,3 +730,46 @@ func (c *Config) serviceChangeThreshold(service string) int {
 
 	return changeThreshold
 }
+
+// Compute the changeThreshold for an alert on a service: the shortest threshold of its
+// failing checks, using the service's check_thresholds for the checks listed there and the
+// threshold for the alert's status for the rest. Recoveries use the service's threshold.
+// A failing alert waits for at least its correlation_window, and a failing service alert for
+// at least the service's group_window.
+func (c *Config) alertChangeThreshold(service string, alert *AlertState) int {
+	threshold := c.checkChangeThreshold(service, alert)
+	if alert.Status == api.HealthPassing {
+		return threshold
+	}
+	if window := int(c.serviceCorrelationWindow(service).Seconds()); window > threshold {
+		threshold = window
+	}
+	if window := int(c.serviceGroupWindow(service).Seconds()); service != "" && window > threshold {
+		threshold = window
+	}
+	return threshold
+}
+
+// Returns the shortest change threshold of the alert's failing checks
+func (c *Config) checkChangeThreshold(service string, alert *AlertState) int {
+	changeThreshold := c.statusChangeThreshold(service, alert.Status)
+	serviceConfig := c.serviceConfig(service)
+	if serviceConfig == nil || len(serviceConfig.CheckThresholds) == 0 || len(alert.Checks) == 0 {
+		return changeThreshold
+	}
+
+	shortest := -1
+	for _, check := range alert.Checks {
+		threshold, ok := serviceConfig.CheckThresholds[check.Name]
+		if !ok {
+			threshold, ok = serviceConfig.CheckThresholds[check.CheckID]
+		}
+		if !ok {
+			threshold = changeThreshold
+		}
+		if shortest < 0 || threshold < shortest {
+			shortest = threshold
+		}
+	}
+	return shortest
+}
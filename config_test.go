@@ -55,6 +55,8 @@ func TestConfig_parseValues(t *testing.T) {
 	handler "pagerduty" "page_ops" {
 		service_key = "asdf1234"
 		max_retries = 10
+		timeout = 120
+		backup_handler = "email.admin"
 	}
 
 	handler "slack" "dev_channel" {
@@ -69,20 +71,39 @@ func TestConfig_parseValues(t *testing.T) {
 	}
 
 	expected := &Config{
-		ConsulAddress:    "localhost:8500",
-		ConsulToken:      "test_token",
-		ConsulDatacenter: "testdc",
-		NodeWatch:        "local",
-		ServiceWatch:     "global",
-		ChangeThreshold:  30,
-		DefaultHandlers:  []string{"stdout.warn", "email.admin"},
-		LogLevel:         "warn",
+		ConsulAddress:            "localhost:8500",
+		ConsulToken:              "test_token",
+		ConsulDatacenter:         "testdc",
+		NodeWatch:                "local",
+		ServiceWatch:             "global",
+		ChangeThreshold:          30,
+		DefaultHandlers:          []string{"stdout.warn", "email.admin"},
+		LogLevel:                 "warn",
+		LeaderGracePeriod:        30,
+		RetryMaxAge:              3600,
+		HandlerTimeout:           30,
+		HandlerConnectTimeout:    10,
+		HandlerBreakerThreshold:  5,
+		HandlerBreakerCooldown:   60,
+		DispatchWorkers:          8,
+		DispatchQueueSize:        1000,
+		ConsistencyCheckInterval: 600,
+		NomadDeploymentThreshold: 900,
+		K8sRolloutMaxHold:        600,
+		K8sSyncTag:               "k8s",
+		CheckOutputLimit:         500,
+		VaultRefreshInterval:     300,
+		FlapWindow:               600,
+		StaleAlertGrace:          300,
+		DedupTTL:                 300,
+		SilenceKVPrefix:          "consul-alerting/silences/",
 		Services: map[string]ServiceConfig{
 			"redis": ServiceConfig{
-				Name:            "redis",
-				ChangeThreshold: 15,
-				DistinctTags:    true,
-				IgnoredTags:     []string{"seed", "node"},
+				Name:               "redis",
+				ChangeThreshold:    15,
+				DistinctTags:       true,
+				IgnoredTags:        []string{"seed", "node"},
+				changeThresholdSet: true,
 			},
 			"webapp": ServiceConfig{
 				Name:            "webapp",
@@ -96,6 +117,8 @@ func TestConfig_parseValues(t *testing.T) {
 			},
 			"email.admin": EmailHandler{
 				Recipients: []string{"admin@example.com"},
+				From:       defaultEmailFrom,
+				TLS:        "starttls",
 			},
 			"pagerduty.page_ops": PagerdutyHandler{
 				ServiceKey: "asdf1234",
@@ -106,6 +129,12 @@ func TestConfig_parseValues(t *testing.T) {
 				ChannelName: "alerts",
 			},
 		},
+		HandlerOptions: map[string]HandlerOptions{
+			"stdout.warn":        HandlerOptions{Timeout: 30, ConnectTimeout: 10, BreakerThreshold: 5, BreakerCooldown: 60},
+			"email.admin":        HandlerOptions{Timeout: 30, ConnectTimeout: 10, BreakerThreshold: 5, BreakerCooldown: 60},
+			"pagerduty.page_ops": HandlerOptions{Timeout: 120, ConnectTimeout: 10, BreakerThreshold: 5, BreakerCooldown: 60, BackupHandler: "email.admin"},
+			"slack.dev_channel":  HandlerOptions{Timeout: 30, ConnectTimeout: 10, BreakerThreshold: 5, BreakerCooldown: 60},
+		},
 	}
 
 	if !reflect.DeepEqual(config, expected) {
@@ -163,3 +192,101 @@ func TestConfig_serviceHandlers(t *testing.T) {
 		t.Fatalf("expected \n%#v\n\n, got \n\n%#v\n\n", config.Handlers["stdout.warn"], config)
 	}
 }
+
+func TestConfig_invalidUmask(t *testing.T) {
+	_, err := ParseConfig(`umask = "0999"`)
+	if err == nil {
+		t.Fatal("expected error, but nothing was returned")
+	}
+
+	expected := "Invalid value for umask"
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q to include %q", err.Error(), expected)
+	}
+}
+
+// Make sure handlers of the same type are kept apart by name, and names can't be reused
+func TestConfig_namedHandlers(t *testing.T) {
+	config, err := ParseConfig(`
+	handler "slack" "payments_team" {
+		api_token = "token-a"
+		channel_name = "payments"
+	}
+
+	handler "slack" "search_team" {
+		api_token = "token-b"
+		channel_name = "search"
+	}
+
+	service "payments" {
+		handlers = ["slack.payments_team"]
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := config.serviceHandlerNames("payments"); !reflect.DeepEqual(names, []string{"slack.payments_team"}) {
+		t.Errorf("expected payments to use only its own team's handler, got %v", names)
+	}
+	if names := config.serviceHandlerNames("search"); !reflect.DeepEqual(names, []string{"slack.payments_team", "slack.search_team"}) {
+		t.Errorf("expected search to use every handler, got %v", names)
+	}
+	if config.Handlers["slack.payments_team"] == config.Handlers["slack.search_team"] {
+		t.Error("expected each slack handler to keep its own settings")
+	}
+
+	_, err = ParseConfig(`
+	handler "stdout" "ops" {}
+	handler "stdout" "ops" { log_level = "err" }
+	`)
+	if err == nil || !strings.Contains(err.Error(), "defined more than once") {
+		t.Errorf("expected an error for a duplicate handler, got %v", err)
+	}
+}
+
+// Make sure an alert's change threshold is the shortest of its failing checks' thresholds
+func TestConfig_checkThresholds(t *testing.T) {
+	config, err := ParseConfig(`
+	change_threshold = 60
+	service "api" {
+		check_thresholds {
+			"memory usage" = 300
+			"service:api" = 30
+		}
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		checks    []AlertCheck
+		threshold int
+	}{
+		{[]AlertCheck{{CheckID: "mem", Name: "memory usage"}}, 300},
+		{[]AlertCheck{{CheckID: "service:api", Name: "Service 'api' check"}}, 30},
+		{[]AlertCheck{{CheckID: "disk", Name: "disk usage"}}, 60},
+		{[]AlertCheck{{CheckID: "mem", Name: "memory usage"}, {CheckID: "disk", Name: "disk usage"}}, 60},
+		{nil, 60},
+	}
+	for _, c := range cases {
+		if threshold := config.alertChangeThreshold("api", &AlertState{Checks: c.checks}); threshold != c.threshold {
+			t.Errorf("expected a threshold of %d for %v, got %d", c.threshold, c.checks, threshold)
+		}
+	}
+	if threshold := config.alertChangeThreshold("web", &AlertState{Checks: cases[0].checks}); threshold != 60 {
+		t.Errorf("expected other services to use change_threshold, got %d", threshold)
+	}
+
+	_, err = ParseConfig(`
+	service "api" {
+		check_thresholds {
+			"memory usage" = -1
+		}
+	}
+	`)
+	if err == nil || !strings.Contains(err.Error(), "can't be negative") {
+		t.Errorf("expected an error for a negative threshold, got %v", err)
+	}
+}
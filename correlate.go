@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// Returns how long a node or service's alert is held after it starts failing so other
+// checks that fail in the meantime are listed in it, or 0 if it isn't
+func (c *Config) serviceCorrelationWindow(service string) time.Duration {
+	window := c.CorrelationWindow
+	if c.serviceConfig(service) != nil {
+		window = c.serviceConfig(service).CorrelationWindow
+	}
+	return time.Duration(window) * time.Second
+}
+
+// Brings a failing alert's checks and details up to date before it's sent, if it's been held
+// for a correlation_window, so it lists every check that failed during the window rather
+// than only those that were failing when its status changed
+func correlateAlert(watchOpts *WatchOptions, alert *AlertState) {
+	if alert.Status == api.HealthPassing || alert.Meta || watchOpts.config.serviceCorrelationWindow(watchOpts.service) <= 0 {
+		return
+	}
+
+	// A service with a group_window has its alert refreshed by groupAlert anyway
+	if watchOpts.service != "" && watchOpts.config.serviceGroupWindow(watchOpts.service) > 0 {
+		return
+	}
+	refreshAlert(watchOpts, alert)
+}
+
+// Returns the service checks on the nodes running the watch's service with its tag, the same
+// ones diffServiceChecks counts as the watch's. Returns the checks as they are if the watch
+// has no tag.
+func tagChecks(watchOpts *WatchOptions, checks []*api.HealthCheck) ([]*api.HealthCheck, error) {
+	if watchOpts.tag == "" {
+		return checks, nil
+	}
+	services, _, err := watchOpts.client.Catalog().Service(watchOpts.service, watchOpts.tag, nil)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]string, 0, len(services))
+	for _, service := range services {
+		nodes = append(nodes, service.Node)
+	}
+
+	tagged := make([]*api.HealthCheck, 0, len(checks))
+	for _, check := range checks {
+		if contains(nodes, check.Node) {
+			tagged = append(tagged, check)
+		}
+	}
+	return tagged, nil
+}
+
+// Replaces the alert's checks and details with the watch's current ones, returning the
+// checks. Leaves the alert as it is and returns nil if they can't be fetched, or none are
+// failing any more.
+func refreshAlert(watchOpts *WatchOptions, alert *AlertState) []*api.HealthCheck {
+	var checks []*api.HealthCheck
+	var err error
+	if watchOpts.service == "" {
+		checks, _, err = watchOpts.client.Health().Node(watchOpts.node, nil)
+	} else if checks, _, err = watchOpts.client.Health().Checks(watchOpts.service, nil); err == nil {
+		checks, err = tagChecks(watchOpts, checks)
+	}
+	if err != nil {
+		log.Warnf("Error refreshing the checks for alert '%s', sending it as it is: %s", alert.Message, err)
+		return nil
+	}
+	checks = watchOpts.config.suppressChecks(watchOpts.service, checks)
+
+	failing := failingChecks(watchOpts.config, checks, watchOpts.service == "")
+	if len(failing) == 0 {
+		return nil
+	}
+	alert.Checks = failing
+	watchOpts.config.applySeverity(watchOpts.service, alert)
+	alert.Details = alertDetails(watchOpts, checks, alert.Link)
+	return checks
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/consul/structs"
+)
+
+// Make sure a failing alert waits out its correlation window, but recoveries don't
+func TestCorrelate_window(t *testing.T) {
+	config, err := ParseConfig(`
+	change_threshold = 30
+	correlation_window = 90
+	service "api" {
+		group_window = 120
+	}
+	service "redis" {
+		correlation_window = 0
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service, status string
+		threshold       int
+	}{
+		{"", "critical", 90},
+		{"", "passing", 30},
+		{"web", "warning", 90},
+		{"api", "critical", 120},
+		{"redis", "critical", 30},
+	}
+	for _, c := range cases {
+		if threshold := config.alertChangeThreshold(c.service, &AlertState{Status: c.status}); threshold != c.threshold {
+			t.Errorf("%q %s: expected a threshold of %d, got %d", c.service, c.status, c.threshold, threshold)
+		}
+	}
+}
+
+// Make sure checks that fail during the correlation window are listed in the alert
+func TestCorrelate_refresh(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	config := DefaultConfig()
+	config.CorrelationWindow = 60
+	self, err := client.Agent().NodeName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &WatchOptions{node: self, config: config, client: client, alertLock: &sync.Mutex{}}
+
+	server.AddCheck("memory usage", "", structs.HealthCritical)
+	alert := &AlertState{Node: self, Status: "critical", Checks: []AlertCheck{{Node: self, CheckID: "memory usage", Name: "memory usage"}}}
+	server.AddCheck("disk usage", "", structs.HealthWarning)
+
+	correlateAlert(opts, alert)
+	if len(alert.Checks) != 2 {
+		t.Fatalf("expected both failing checks in the alert, got %+v", alert.Checks)
+	}
+	if !strings.Contains(alert.Details, "disk usage") || !strings.Contains(alert.Details, "memory usage") {
+		t.Errorf("expected both checks in the details, got %q", alert.Details)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Applies the process-level settings from the config (umask, working directory, PID file)
+// before we start doing any real work
+func setupDaemon(config *Config) error {
+	if config.Umask != "" {
+		umask, err := parseUmask(config.Umask)
+		if err != nil {
+			return err
+		}
+		setUmask(umask)
+	}
+
+	if config.WorkingDir != "" {
+		if err := os.Chdir(config.WorkingDir); err != nil {
+			return fmt.Errorf("Error changing to working directory: %s", err)
+		}
+		log.Infof("Using working directory %s", config.WorkingDir)
+	}
+
+	if config.PidFile != "" {
+		if err := writePidFile(config.PidFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Parses an octal umask, e.g. "022"
+func parseUmask(value string) (int, error) {
+	umask, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value for umask: %s", value)
+	}
+	return int(umask), nil
+}
+
+// The PID file we wrote, so it can be cleaned up if we exit on a fatal error
+var pidFilePath string
+
+// Writes our PID to the given path, refusing to overwrite the PID file of another
+// running instance
+func writePidFile(path string) error {
+	if contents, err := ioutil.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+		// The previous process will still be running if it's handing off to us
+		handingOff := inheritedState != nil && pid == os.Getppid()
+		if err == nil && pid != os.Getpid() && !handingOff && processExists(pid) {
+			return fmt.Errorf("PID file %s is in use by running process %d", path, pid)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("Error writing PID file: %s", err)
+	}
+	pidFilePath = path
+
+	return nil
+}
+
+// Removes the PID file at the given path if it still belongs to us
+func removePidFile(path string) {
+	if path == "" {
+		return
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(contents)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	if err := os.Remove(path); err != nil {
+		log.Error("Error removing PID file: ", err)
+	}
+}
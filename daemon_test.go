@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Make sure we write our PID and clean it up afterwards
+func TestDaemon_pidFile(t *testing.T) {
+	pidPath := path.Join(os.TempDir(), "consul-alerting-test.pid")
+	defer os.Remove(pidPath)
+
+	if err := writePidFile(pidPath); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(pidPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(contents)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("expected PID %d, got %s", os.Getpid(), contents)
+	}
+
+	removePidFile(pidPath)
+
+	if _, err := os.Stat(pidPath); !os.IsNotExist(err) {
+		t.Errorf("expected PID file to be removed, got %v", err)
+	}
+}
+
+// Make sure we don't remove a PID file belonging to another process
+func TestDaemon_foreignPidFile(t *testing.T) {
+	pidPath := path.Join(os.TempDir(), "consul-alerting-test.pid")
+	defer os.Remove(pidPath)
+
+	if err := ioutil.WriteFile(pidPath, []byte("999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	removePidFile(pidPath)
+
+	if _, err := os.Stat(pidPath); err != nil {
+		t.Errorf("expected PID file to be left alone, got %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Signals that trigger a handoff to a new process (see handoff.go)
+var handoffSignals = []os.Signal{syscall.SIGUSR2}
+
+func setUmask(mask int) {
+	syscall.Umask(mask)
+}
+
+// Returns true if a process with the given PID is running
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Runs the command in its own process group, and kills the whole group when the command's
+// context is cancelled, so anything a script started doesn't outlive it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
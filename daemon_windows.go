@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Handing off to a new process isn't supported on Windows
+var handoffSignals = []os.Signal{}
+
+func setUmask(mask int) {
+	log.Warn("Setting umask is not supported on Windows, ignoring")
+}
+
+// Returns true if a process with the given PID is running
+func processExists(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
+
+// Process groups aren't supported on Windows, so only the command itself is killed when its
+// context is cancelled
+func setProcessGroup(cmd *exec.Cmd) {}
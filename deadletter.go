@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// Records a notification we've given up on delivering, appending it to dead_letter_path,
+// storing it under dead_letter_kv_prefix and sending it to dead_letter_handler if any are
+// set, so it isn't silently lost
+func deadLetter(config *Config, client *api.Client, notification *QueuedNotification) {
+	metrics.Add(metricNotificationsDeadLettered, 1)
+
+	if config.DeadLetterPath != "" {
+		if err := appendDeadLetter(config.DeadLetterPath, notification); err != nil {
+			log.Error("Error writing to dead letter file: ", err)
+		}
+	}
+
+	if config.DeadLetterKVPrefix != "" && client != nil {
+		if err := storeDeadLetter(client, config.DeadLetterKVPrefix, notification); err != nil {
+			log.Error("Error storing dead letter in Consul: ", err)
+		}
+	}
+
+	if config.DeadLetterHandler != "" && config.DeadLetterHandler != notification.Handler {
+		alert := notification.Alert
+		alert.Message = fmt.Sprintf("Undeliverable alert via %s: %s", notification.Handler, alert.Message)
+		alert.Details = fmt.Sprintf("Gave up after %d attempts, last error: %s\n%s", notification.Attempts, notification.LastError, alert.Details)
+		if err := invokeHandler(config, config.DeadLetterHandler, &alert); err != nil {
+			log.Errorf("Error sending undeliverable alert to dead letter handler %s: %s", config.DeadLetterHandler, err)
+		}
+	}
+}
+
+// Appends the notification to the given file as a line of JSON
+func appendDeadLetter(path string, notification *QueuedNotification) error {
+	serialized, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(serialized, '\n'))
+	return err
+}
+
+// Returns the key under dead_letter_kv_prefix to store a notification at, by handler and
+// event ID
+func deadLetterKVPath(prefix string, notification *QueuedNotification) string {
+	id := notification.Alert.EventID
+	if id == "" {
+		id = strconv.FormatInt(notification.FirstAttempt.UnixNano(), 10)
+	}
+	return strings.TrimRight(prefix, "/") + "/" + notification.Handler + "/" + id
+}
+
+// Stores the notification in the Consul KV store as JSON
+func storeDeadLetter(client *api.Client, prefix string, notification *QueuedNotification) error {
+	serialized, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, err = client.KV().Put(&api.KVPair{Key: deadLetterKVPath(prefix, notification), Value: serialized}, nil)
+	return err
+}
+
+const deadLetterUsage = `Usage: consul-alerting dead-letter <list|replay> [options]
+
+  Lists or replays the notifications that were given up on after running out of retries,
+  from dead_letter_kv_prefix in Consul or, with -file, from dead_letter_path. Replaying
+  sends each one to its handler again and removes the ones that succeed.
+
+Options:
+
+    -config=<path>     The config file to read the handlers and dead letter settings from.
+    -file              Read dead letters from dead_letter_path instead of Consul.
+    -handler=<name>    Only list or replay dead letters for the given handler.
+`
+
+// A dead letter loaded for listing or replay, with the KV key it's stored at (if any)
+type storedDeadLetter struct {
+	key          string
+	notification QueuedNotification
+}
+
+func deadLetterCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "replay") {
+		fmt.Fprint(os.Stderr, deadLetterUsage)
+		return 1
+	}
+
+	var configPath, handler string
+	var fromFile bool
+	flags := flag.NewFlagSet("dead-letter "+args[0], flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, deadLetterUsage) }
+	flags.StringVar(&configPath, "config", "", "")
+	flags.BoolVar(&fromFile, "file", false, "")
+	flags.StringVar(&handler, "handler", "", "")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	if configPath == "" {
+		fmt.Fprintln(os.Stderr, "-config is required")
+		return 1
+	}
+	config, err := ParseConfigFile(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var client *api.Client
+	var letters []storedDeadLetter
+	if fromFile {
+		letters, err = readDeadLetterFile(config.DeadLetterPath)
+	} else {
+		if client, err = newConsulClient(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing client: %s\n", err)
+			return 1
+		}
+		letters, err = listDeadLetters(client, config.DeadLetterKVPrefix)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	selected := make([]storedDeadLetter, 0, len(letters))
+	for _, letter := range letters {
+		if handler == "" || letter.notification.Handler == handler {
+			selected = append(selected, letter)
+		}
+	}
+
+	if args[0] == "list" {
+		for _, letter := range selected {
+			n := letter.notification
+			fmt.Printf("%s  %s  %s (%d attempts, last error: %s)\n", n.FirstAttempt.Format(time.RFC3339), n.Handler, n.Alert.Message, n.Attempts, n.LastError)
+		}
+		return 0
+	}
+
+	failed, remaining := replayDeadLetters(config, letters, handler)
+	if fromFile {
+		if err := writeDeadLetterFile(config.DeadLetterPath, remaining); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		for _, letter := range letters {
+			if !containsDeadLetter(remaining, letter) {
+				if _, err := client.KV().Delete(letter.key, nil); err != nil {
+					fmt.Fprintf(os.Stderr, "Error removing replayed dead letter %s: %s\n", letter.key, err)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("Replayed %d of %d dead letters\n", len(selected)-failed, len(selected))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Sends each dead letter for the handler (or all of them if it's empty) to its handler again,
+// returning the number that failed and the dead letters that should be kept
+func replayDeadLetters(config *Config, letters []storedDeadLetter, handler string) (int, []storedDeadLetter) {
+	failed := 0
+	remaining := make([]storedDeadLetter, 0)
+	for _, letter := range letters {
+		n := letter.notification
+		if handler != "" && n.Handler != handler {
+			remaining = append(remaining, letter)
+			continue
+		}
+
+		if err := callHandler(config, n.Handler, &n.Alert); err != nil {
+			fmt.Fprintf(os.Stderr, "Error replaying alert '%s' with handler %s: %s\n", n.Alert.Message, n.Handler, err)
+			failed++
+			remaining = append(remaining, letter)
+			continue
+		}
+		fmt.Printf("Replayed alert '%s' with handler %s\n", n.Alert.Message, n.Handler)
+	}
+	return failed, remaining
+}
+
+func containsDeadLetter(letters []storedDeadLetter, letter storedDeadLetter) bool {
+	for _, l := range letters {
+		if l.key == letter.key {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads the dead letters stored under the given KV prefix
+func listDeadLetters(client *api.Client, prefix string) ([]storedDeadLetter, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("No dead_letter_kv_prefix set in the config, use -file to read dead_letter_path")
+	}
+
+	pairs, _, err := client.KV().List(strings.TrimRight(prefix, "/")+"/", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing dead letters: %s", err)
+	}
+	letters := make([]storedDeadLetter, 0, len(pairs))
+	for _, pair := range pairs {
+		letter := storedDeadLetter{key: pair.Key}
+		if err := json.Unmarshal(pair.Value, &letter.notification); err != nil {
+			return nil, fmt.Errorf("Error parsing dead letter %s: %s", pair.Key, err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// Loads the dead letters from a dead_letter_path file
+func readDeadLetterFile(path string) ([]storedDeadLetter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("No dead_letter_path set in the config")
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Error reading dead letters: %s", err)
+	}
+	letters := make([]storedDeadLetter, 0)
+	for i, line := range strings.Split(string(contents), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		letter := storedDeadLetter{key: strconv.Itoa(i)}
+		if err := json.Unmarshal([]byte(line), &letter.notification); err != nil {
+			return nil, fmt.Errorf("Error parsing line %d of %s: %s", i+1, path, err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// Replaces the contents of a dead_letter_path file with the given dead letters
+func writeDeadLetterFile(path string, letters []storedDeadLetter) error {
+	var contents []byte
+	for _, letter := range letters {
+		serialized, err := json.Marshal(letter.notification)
+		if err != nil {
+			return err
+		}
+		contents = append(append(contents, serialized...), '\n')
+	}
+
+	// Write to a temporary file first so a crash can't leave a truncated file behind
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, contents, 0600); err != nil {
+		return fmt.Errorf("Error writing dead letters: %s", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("Error writing dead letters: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Make sure undeliverable notifications are written to the dead letter file and sent
+// to the dead letter handler
+func TestDeadLetter_deadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alertCh := make(chan *AlertState, 2)
+	config := &Config{
+		DeadLetterPath:    path.Join(dir, "dead-letters.json"),
+		DeadLetterHandler: "test.fallback",
+		Handlers: map[string]AlertHandler{
+			"test.fallback": testHandler{alertCh},
+		},
+	}
+
+	notification := &QueuedNotification{
+		Handler:   "pagerduty.ops",
+		Alert:     AlertState{Service: "redis", Status: "critical", Message: "redis is now critical"},
+		Attempts:  12,
+		LastError: "service unavailable",
+	}
+	deadLetter(config, nil, notification)
+	deadLetter(config, nil, notification)
+
+	file, err := os.Open(config.DeadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var written QueuedNotification
+		if err := json.Unmarshal(scanner.Bytes(), &written); err != nil {
+			t.Fatal(err)
+		}
+		if written.Handler != "pagerduty.ops" {
+			t.Errorf("expected handler pagerduty.ops, got %s", written.Handler)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("expected 2 dead letters, got %d", lines)
+	}
+
+	select {
+	case alert := <-alertCh:
+		if !strings.Contains(alert.Message, "pagerduty.ops") || !strings.Contains(alert.Details, "service unavailable") {
+			t.Errorf("expected dead letter alert to describe the failure, got %q / %q", alert.Message, alert.Details)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected alert to be sent to the dead letter handler")
+	}
+}
+
+// Make sure the dead letter handler doesn't get regular alerts unless it's asked for
+func TestDeadLetter_excludedFromDefaults(t *testing.T) {
+	config, err := ParseConfig(`
+dead_letter_handler = "stdout.fallback"
+handler "stdout" "default" {}
+handler "stdout" "fallback" {}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := config.serviceHandlerNames(""); len(names) != 1 || names[0] != "stdout.default" {
+		t.Errorf("expected only stdout.default, got %v", names)
+	}
+
+	if _, err := ParseConfig(`dead_letter_handler = "stdout.missing"`); err == nil {
+		t.Error("expected an error for an unknown dead_letter_handler")
+	}
+}
+
+// Make sure dead letters are stored under dead_letter_kv_prefix, and can be loaded back
+func TestDeadLetter_kv(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	config := &Config{DeadLetterKVPrefix: "consul-alerting/dead-letters/"}
+	notification := &QueuedNotification{
+		Handler: "pagerduty.ops",
+		Alert:   AlertState{Service: "redis", Status: "critical", EventID: "abc123", Message: "redis is now critical"},
+	}
+	deadLetter(config, client, notification)
+
+	letters, err := listDeadLetters(client, config.DeadLetterKVPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].key != "consul-alerting/dead-letters/pagerduty.ops/abc123" || letters[0].notification.Alert.Message != notification.Alert.Message {
+		t.Fatalf("expected the dead letter to be stored, got %+v", letters)
+	}
+}
+
+// Make sure replaying dead letters keeps the ones that fail or are for other handlers
+func TestDeadLetter_replay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	failures, sent := 1, 0
+	config := &Config{
+		DeadLetterPath: path.Join(dir, "dead-letters.json"),
+		Handlers:       map[string]AlertHandler{"test.flaky": flakyHandler{&failures, &sent}},
+	}
+	for _, notification := range []*QueuedNotification{
+		{Handler: "test.flaky", Alert: AlertState{Service: "redis", EventID: "a1"}},
+		{Handler: "test.flaky", Alert: AlertState{Service: "redis", EventID: "b2"}},
+		{Handler: "test.other", Alert: AlertState{Service: "redis", EventID: "c3"}},
+	} {
+		deadLetter(config, nil, notification)
+	}
+
+	letters, err := readDeadLetterFile(config.DeadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, remaining := replayDeadLetters(config, letters, "test.flaky")
+	if failed != 1 || sent != 1 {
+		t.Fatalf("expected one replay to fail and one to be sent, failed: %d, sent: %d", failed, sent)
+	}
+	if err := writeDeadLetterFile(config.DeadLetterPath, remaining); err != nil {
+		t.Fatal(err)
+	}
+
+	letters, err = readDeadLetterFile(config.DeadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 || letters[0].notification.Alert.EventID != "a1" || letters[1].notification.Handler != "test.other" {
+		t.Errorf("expected the failed and unselected dead letters to be kept, got %+v", letters)
+	}
+}
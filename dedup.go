@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// How long to wait before trying again to claim a transition, if the claim couldn't be read
+// or stored
+const claimRetryInterval = 15 * time.Second
+
+// Identifies this process in the claims it makes on alert transitions
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// AlertClaim records which instance is sending an alert transition, so another instance
+// that's still processing the same change (e.g. one that lost the watch's lock while its
+// alert timer was running) doesn't send it too
+type AlertClaim struct {
+	Fingerprint string    `json:"fingerprint"`
+	Instance    string    `json:"instance"`
+	At          time.Time `json:"at"`
+}
+
+// Returns the KV path the claim for an alert is stored at, given the alert's path
+func claimKVPath(alertPath string) string {
+	return strings.TrimSuffix(alertPath, "alert") + "claim"
+}
+
+// Returns a fingerprint for the transition the alert is about to send: the node/service,
+// the status it was last alerted with and when, and the status it's going to. Every
+// instance working from the same stored state computes the same fingerprint for it.
+func alertFingerprint(kvPath string, alert *AlertState) string {
+	raw := fmt.Sprintf("%s|%s|%d|%s|%v", kvPath, alert.LastAlerted, alert.LastAlertedAt.UnixNano(), alert.Status, alert.Flapping)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(raw)))
+}
+
+// Claims the transition the alert is about to send for this instance, with a check-and-set
+// on the alert's claim key. Returns false if another instance has already claimed it within
+// dedup_ttl; once that passes, the claim can be taken over, in case its owner died partway
+// through sending (the handlers it already got to are skipped as usual). Always succeeds if
+// dedup_ttl is 0.
+func claimTransition(config *Config, client *api.Client, kvPath string, alert *AlertState, now time.Time) (bool, error) {
+	if config.DedupTTL <= 0 {
+		return true, nil
+	}
+
+	claim := AlertClaim{Fingerprint: alertFingerprint(kvPath, alert), Instance: instanceID, At: now}
+	pair, _, err := client.KV().Get(claimKVPath(kvPath), nil)
+	if err != nil {
+		return false, fmt.Errorf("Error reading alert claim: %s", err)
+	}
+
+	var modifyIndex uint64
+	if pair != nil {
+		modifyIndex = pair.ModifyIndex
+		var existing AlertClaim
+		if err := json.Unmarshal(pair.Value, &existing); err == nil && existing.Fingerprint == claim.Fingerprint {
+			if existing.Instance == instanceID {
+				return true, nil
+			}
+			if now.Sub(existing.At) < time.Duration(config.DedupTTL)*time.Second {
+				return false, nil
+			}
+		}
+	}
+
+	serialized, err := json.Marshal(claim)
+	if err != nil {
+		return false, err
+	}
+	ok, _, err := client.KV().CAS(&api.KVPair{Key: claimKVPath(kvPath), Value: serialized, ModifyIndex: modifyIndex}, nil)
+	if err != nil {
+		return false, fmt.Errorf("Error storing alert claim: %s", err)
+	}
+	return ok, nil
+}
+
+// Deletes this instance's claim on the alert's last transition, once it's been sent and
+// recorded in the alert's state, so claims don't pile up in the KV store. A claim another
+// instance has made since is left alone.
+func releaseClaim(config *Config, client *api.Client, kvPath string) error {
+	if config.DedupTTL <= 0 {
+		return nil
+	}
+
+	pair, _, err := client.KV().Get(claimKVPath(kvPath), nil)
+	if err != nil {
+		return fmt.Errorf("Error reading alert claim: %s", err)
+	}
+	if pair == nil {
+		return nil
+	}
+	var claim AlertClaim
+	if err := json.Unmarshal(pair.Value, &claim); err != nil || claim.Instance != instanceID {
+		return nil
+	}
+	if _, _, err := client.KV().DeleteCAS(pair, nil); err != nil {
+		return fmt.Errorf("Error deleting alert claim: %s", err)
+	}
+	return nil
+}
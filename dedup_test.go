@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Make sure only the first instance to claim a transition gets to send it, until the
+// claim expires
+func TestDedup_claimTransition(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	config := &Config{DedupTTL: 300}
+	path := alertingKVRoot + "/service/redis/alert"
+	alert := &AlertState{Service: "redis", Status: api.HealthCritical, LastAlerted: api.HealthPassing}
+	now := time.Now()
+
+	// Another instance claimed it first
+	other := AlertClaim{Fingerprint: alertFingerprint(path, alert), Instance: "other-1", At: now}
+	serialized, _ := json.Marshal(other)
+	if _, err := client.KV().Put(&api.KVPair{Key: claimKVPath(path), Value: serialized}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := claimTransition(config, client, path, alert, now.Add(time.Minute)); err != nil || ok {
+		t.Fatalf("expected the claim to be refused, got %v %v", ok, err)
+	}
+
+	// Its claim has expired, so we can take it over, and claiming it again is a no-op
+	if ok, err := claimTransition(config, client, path, alert, now.Add(10*time.Minute)); err != nil || !ok {
+		t.Fatalf("expected to take over the expired claim, got %v %v", ok, err)
+	}
+	if ok, err := claimTransition(config, client, path, alert, now.Add(11*time.Minute)); err != nil || !ok {
+		t.Fatalf("expected our own claim to be accepted, got %v %v", ok, err)
+	}
+
+	// A different transition gets a claim of its own
+	alert.LastAlerted, alert.LastAlertedAt, alert.Status = api.HealthCritical, now, api.HealthPassing
+	if ok, err := claimTransition(config, client, path, alert, now.Add(time.Minute)); err != nil || !ok {
+		t.Fatalf("expected a new transition to be claimed, got %v %v", ok, err)
+	}
+
+	// Once it's been sent, the claim is deleted
+	if err := releaseClaim(config, client, path); err != nil {
+		t.Fatal(err)
+	}
+	if pair, _, err := client.KV().Get(claimKVPath(path), nil); err != nil || pair != nil {
+		t.Fatalf("expected the claim to be deleted, got %v %v", pair, err)
+	}
+}
+
+func TestDedup_alertFingerprint(t *testing.T) {
+	now := time.Now()
+	alert := &AlertState{Status: api.HealthCritical, LastAlerted: api.HealthPassing, LastAlertedAt: now, Message: "a"}
+	same := &AlertState{Status: api.HealthCritical, LastAlerted: api.HealthPassing, LastAlertedAt: now, Message: "b", UpdateIndex: 7}
+	if alertFingerprint("p", alert) != alertFingerprint("p", same) {
+		t.Errorf("expected the same transition to have the same fingerprint")
+	}
+
+	for _, different := range []*AlertState{
+		{Status: api.HealthWarning, LastAlerted: api.HealthPassing, LastAlertedAt: now},
+		{Status: api.HealthCritical, LastAlerted: api.HealthPassing, LastAlertedAt: now.Add(time.Second)},
+		{Status: api.HealthCritical, LastAlerted: api.HealthPassing, LastAlertedAt: now, Flapping: true},
+	} {
+		if alertFingerprint("p", alert) == alertFingerprint("p", different) {
+			t.Errorf("expected %+v to have a different fingerprint", different)
+		}
+	}
+}
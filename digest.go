@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Digest collects the alerts for a handler over its digest window, so they can be sent as a
+// single notification listing everything that changed instead of one per alert
+type Digest struct {
+	sync.Mutex
+	name   string
+	window time.Duration
+
+	// The latest alert for each node/service that changed during the window, in the order
+	// they first changed, and whether the digest has been scheduled to be sent
+	alerts    []*AlertState
+	scheduled bool
+}
+
+// Adds the alert to the digest, replacing any earlier alert for the same node/service, and
+// schedules the digest to be sent at the end of the window if this is its first alert
+func (d *Digest) add(config *Config, alert *AlertState) {
+	d.Lock()
+	defer d.Unlock()
+
+	alertCopy := *alert
+	alertCopy.Delivered = nil
+	replaced := false
+	for i, existing := range d.alerts {
+		if alertTargetKey(existing) == alertTargetKey(alert) {
+			d.alerts[i] = &alertCopy
+			replaced = true
+		}
+	}
+	if !replaced {
+		d.alerts = append(d.alerts, &alertCopy)
+	}
+
+	if !d.scheduled {
+		d.scheduled = true
+		time.AfterFunc(d.window, func() { d.flush(config) })
+	}
+}
+
+// Takes the alerts collected so far, returning them as a single alert. Returns nil if there
+// aren't any. Must be called with the lock held.
+func (d *Digest) take(now time.Time) *AlertState {
+	alerts := d.alerts
+	d.alerts = nil
+	d.scheduled = false
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("%d alerts in the last %s", len(alerts), d.window)
+	return summarizeAlerts(alerts, message, "digest/"+d.name, now)
+}
+
+// Sends the alerts collected over the window to the handler, unless they've been moved to
+// the retry queue for a handoff
+func (d *Digest) flush(config *Config) {
+	handoffLock.RLock()
+	defer handoffLock.RUnlock()
+
+	d.Lock()
+	summary := d.take(time.Now())
+	d.Unlock()
+
+	if summary != nil {
+		log.Infof("Sending digest '%s' with handler %s", summary.Message, d.name)
+		deliverAlert(config, d.name, summary)
+	}
+}
+
+// DigestRegistry holds the digest for each handler that has a digest window
+type DigestRegistry struct {
+	sync.Mutex
+	digests map[string]*Digest
+}
+
+var digests = &DigestRegistry{digests: make(map[string]*Digest)}
+
+// Returns the digest for the named handler, creating it if needed
+func (r *DigestRegistry) get(name string, options HandlerOptions) *Digest {
+	r.Lock()
+	defer r.Unlock()
+
+	digest, ok := r.digests[name]
+	if !ok {
+		digest = &Digest{
+			name:   name,
+			window: time.Duration(options.DigestWindow) * time.Second,
+		}
+		r.digests[name] = digest
+	}
+	return digest
+}
+
+// Moves the digests that haven't been sent yet to the retry queue, so they're handed off
+// with it. Must be called with handoffLock held, so no digests can be sent meanwhile.
+func (r *DigestRegistry) drain() {
+	r.Lock()
+	defer r.Unlock()
+
+	names := make([]string, 0, len(r.digests))
+	for name := range r.digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		digest := r.digests[name]
+		digest.Lock()
+		summary := digest.take(time.Now())
+		digest.Unlock()
+
+		if summary != nil {
+			retryQueue.enqueue(name, summary, "handed off before digest was sent")
+		}
+	}
+}
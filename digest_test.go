@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Make sure alerts sent during the window are collapsed into one digest, keeping the latest
+// alert for each node/service
+func TestDigest_window(t *testing.T) {
+	alertCh := make(chan *AlertState, 3)
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"test.digest": testHandler{alertCh},
+		},
+	}
+	digest := &Digest{name: "test.digest", window: 50 * time.Millisecond}
+
+	for _, alert := range []*AlertState{
+		{Service: "redis", Status: "warning", Message: "redis is warning"},
+		{Service: "web", Status: "critical", Message: "web is critical"},
+		{Service: "redis", Status: "passing", Message: "redis is passing"},
+	} {
+		digest.add(config, alert)
+	}
+	if len(alertCh) != 0 {
+		t.Fatal("expected nothing to be sent before the window ends")
+	}
+
+	select {
+	case sent := <-alertCh:
+		if sent.Status != "critical" || sent.Message != "2 alerts in the last 50ms" {
+			t.Errorf("unexpected digest %s %q", sent.Status, sent.Message)
+		}
+		if sent.Details != "[passing] redis is passing\n[critical] web is critical" {
+			t.Errorf("unexpected digest details %q", sent.Details)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the digest to be sent at the end of the window")
+	}
+
+	// A window with a single alert just sends the alert
+	digest.add(config, &AlertState{Service: "db", Status: "critical", Message: "db is critical"})
+	select {
+	case sent := <-alertCh:
+		if sent.Message != "db is critical" {
+			t.Errorf("expected the alert itself to be sent, got %q", sent.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the alert to be sent at the end of the window")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sort"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -23,6 +24,11 @@ func discoverServices(nodeName string, config *Config, shutdownOpts *ShutdownOpt
 	// Used to store services we've already started watches for
 	services := make(map[string][]string)
 
+	// The channels used to stop the per-tag watches of distinct_tags services, by service and tag
+	tagWatches := make(map[string]map[string]chan struct{})
+	backoff := newBackoff()
+	tracker := newIndexTracker(serviceDiscoveryLoop)
+
 	// Loop indefinitely to run the watch, doing repeated blocking queries to Consul
 	for {
 		var queryMeta *api.QueryMeta
@@ -30,89 +36,150 @@ func discoverServices(nodeName string, config *Config, shutdownOpts *ShutdownOpt
 		var err error
 
 		// Watch either all services or just the local node's, depending on whether GlobalMode is set
+		queryStart := time.Now()
 		if config.ServiceWatch == GlobalMode {
 			currentServices, queryMeta, err = client.Catalog().Services(queryOpts)
 		} else {
 			var node *api.CatalogNode
 			node, queryMeta, err = client.Catalog().Node(nodeName, queryOpts)
 			if err == nil {
-				// Build the map of service:[tags]
+				// Build the map of service:[tags], combining the tags of every instance
 				for _, config := range node.Services {
-					if _, ok := currentServices[config.Service]; ok {
-						currentServices[config.Service] = config.Tags
-					} else {
-						currentServices[config.Service] = append(currentServices[config.Service], config.Tags...)
+					if _, ok := currentServices[config.Service]; !ok {
+						currentServices[config.Service] = make([]string, 0)
+					}
+					for _, tag := range config.Tags {
+						if !contains(currentServices[config.Service], tag) {
+							currentServices[config.Service] = append(currentServices[config.Service], tag)
+						}
 					}
 				}
 			}
 		}
 
+		heartbeats.beat(serviceDiscoveryLoop)
+		consulHealth.record(serviceDiscoveryLoop, err)
 		if err != nil {
-			log.Errorf("Error trying to watch services: %s, retrying in 10s...", err)
-			time.Sleep(errorWaitTime)
+			wait := backoff.next()
+			log.Errorf("Error trying to watch services: %s, retrying in %s...", err, wait)
+			time.Sleep(wait)
 			continue
 		}
+		backoff.reset()
 
-		// Update our WaitIndex for the next query
-		queryOpts.WaitIndex = queryMeta.LastIndex
+		// Update our WaitIndex for the next query, starting over if the index went backwards
+		var anomaly string
+		queryOpts.WaitIndex, anomaly = tracker.update(queryMeta.LastIndex, time.Since(queryStart))
+		if anomaly == indexFastReturn {
+			time.Sleep(minQueryInterval)
+		}
+		heartbeats.markReady(serviceDiscoveryLoop)
 
 		// Compare the new list of services with our stored one to see if we need to
 		// spawn any new watches
 		for service, tags := range currentServices {
 			serviceConfig := config.serviceConfig(service)
+			distinctTags := serviceConfig != nil && serviceConfig.DistinctTags
 
 			// See if we found a new service
 			if _, ok := services[service]; !ok {
 				log.Infof("Service found: %s, tags: %v", service, tags)
 				services[service] = tags
 
-				// Create a watch for each tag if DistinctTags is set
-				if serviceConfig != nil && len(tags) > 0 && serviceConfig.DistinctTags {
-					for _, tag := range tags {
-						if !contains(serviceConfig.IgnoredTags, tag) {
-							watchOpts := &WatchOptions{
-								service: service,
-								tag:     tag,
-								config:  config,
-								client:  client,
-								stopCh:  shutdownOpts.stopCh,
-							}
-							shutdownOpts.count++
-							go watch(watchOpts)
-						}
-					}
-				} else {
-					// If it isn't, just start one watch for the service
+				// Just start one watch for the service unless it has tags to watch separately
+				if !distinctTags || len(tags) == 0 {
 					watchOpts := &WatchOptions{
-						service: service,
-						config:  config,
-						client:  client,
-						stopCh:  shutdownOpts.stopCh,
+						service:  service,
+						config:   config,
+						client:   client,
+						stopCh:   shutdownOpts.stopCh,
+						shutdown: shutdownOpts,
 					}
-					shutdownOpts.count++
-					go watch(watchOpts)
+					go superviseWatch(watchOpts)
+					continue
 				}
-			} else {
-				// Check for new, non-ignored tags if DistinctTags is set
-				if serviceConfig != nil && len(tags) > 0 && serviceConfig.DistinctTags {
-					services[service] = tags
-
-					for _, tag := range tags {
-						if !contains(serviceConfig.IgnoredTags, tag) && !contains(services[service], tag) {
-							go watch(&WatchOptions{
-								service: service,
-								tag:     tag,
-								config:  config,
-								client:  client,
-								stopCh:  shutdownOpts.stopCh,
-							})
-							shutdownOpts.count++
-						}
-					}
+			}
+
+			if !distinctTags {
+				continue
+			}
+
+			// Start and stop per-tag watches as the service's tags change
+			if _, ok := tagWatches[service]; !ok {
+				tagWatches[service] = make(map[string]chan struct{})
+			}
+			added, removed := diffTags(tagWatches[service], tags, serviceConfig.IgnoredTags)
+
+			for _, tag := range added {
+				if len(tagWatches[service]) > 0 {
+					log.Infof("Service %s gained tag %s", service, tag)
 				}
+				removeCh := make(chan struct{})
+				tagWatches[service][tag] = removeCh
+				go superviseWatch(&WatchOptions{
+					service:  service,
+					tag:      tag,
+					config:   config,
+					client:   client,
+					stopCh:   shutdownOpts.stopCh,
+					removeCh: removeCh,
+					shutdown: shutdownOpts,
+				})
+			}
+
+			for _, tag := range removed {
+				log.Infof("Service %s lost tag %s, stopping its watch", service, tag)
+				close(tagWatches[service][tag])
+				delete(tagWatches[service], tag)
 			}
 		}
+
+		// Stop the per-tag watches of any services that have gone away entirely, since they
+		// aren't in the loop above. Each watch resolves its alert and stops being counted for
+		// shutdown once it exits.
+		for _, service := range goneServices(tagWatches, currentServices) {
+			log.Infof("Service %s was removed, stopping its tag watches", service)
+			for tag, removeCh := range tagWatches[service] {
+				close(removeCh)
+				delete(tagWatches[service], tag)
+			}
+			delete(tagWatches, service)
+		}
+	}
+}
+
+// Returns the services with per-tag watches running that aren't in the current services
+func goneServices(tagWatches map[string]map[string]chan struct{}, currentServices map[string][]string) []string {
+	gone := make([]string, 0)
+	for service := range tagWatches {
+		if _, ok := currentServices[service]; !ok {
+			gone = append(gone, service)
+		}
+	}
+	sort.Strings(gone)
+	return gone
+}
+
+// Returns the tags that need watches started and the watched tags that need their watches
+// stopped, given the tags currently being watched and the service's current tags
+func diffTags(watching map[string]chan struct{}, tags []string, ignoredTags []string) ([]string, []string) {
+	added := make([]string, 0)
+	for _, tag := range tags {
+		if _, ok := watching[tag]; !ok && !contains(ignoredTags, tag) && !contains(added, tag) {
+			added = append(added, tag)
+		}
+	}
+
+	removed := make([]string, 0)
+	for tag := range watching {
+		if !contains(tags, tag) {
+			removed = append(removed, tag)
+		}
 	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
 }
 
 // Queries the catalog for nodes and starts watches for them
@@ -124,19 +191,31 @@ func discoverNodes(config *Config, shutdownOpts *ShutdownOpts, client *api.Clien
 
 	// Used to store nodes we've already started watches for
 	nodes := make([]string, 0)
+	backoff := newBackoff()
+	tracker := newIndexTracker(nodeDiscoveryLoop)
 
 	// Loop indefinitely to run the watch, doing repeated blocking queries to Consul
 	for {
+		queryStart := time.Now()
 		currentNodes, queryMeta, err := client.Catalog().Nodes(queryOpts)
 
+		heartbeats.beat(nodeDiscoveryLoop)
+		consulHealth.record(nodeDiscoveryLoop, err)
 		if err != nil {
-			log.Errorf("Error trying to watch node list: %s, retrying in 10s...", err)
-			time.Sleep(errorWaitTime)
+			wait := backoff.next()
+			log.Errorf("Error trying to watch node list: %s, retrying in %s...", err, wait)
+			time.Sleep(wait)
 			continue
 		}
+		backoff.reset()
 
-		// Update our WaitIndex for the next query
-		queryOpts.WaitIndex = queryMeta.LastIndex
+		// Update our WaitIndex for the next query, starting over if the index went backwards
+		var anomaly string
+		queryOpts.WaitIndex, anomaly = tracker.update(queryMeta.LastIndex, time.Since(queryStart))
+		if anomaly == indexFastReturn {
+			time.Sleep(minQueryInterval)
+		}
+		heartbeats.markReady(nodeDiscoveryLoop)
 
 		// Compare the new list of nodes with our stored one to see if we need to
 		// spawn any new watches
@@ -145,14 +224,14 @@ func discoverNodes(config *Config, shutdownOpts *ShutdownOpts, client *api.Clien
 			if !contains(nodes, nodeName) {
 				log.Infof("Discovered new node: %s", nodeName)
 				opts := &WatchOptions{
-					node:   nodeName,
-					config: config,
-					client: client,
-					stopCh: shutdownOpts.stopCh,
+					node:     nodeName,
+					config:   config,
+					client:   client,
+					stopCh:   shutdownOpts.stopCh,
+					shutdown: shutdownOpts,
 				}
-				shutdownOpts.count++
 				nodes = append(nodes, nodeName)
-				go watch(opts)
+				go superviseWatch(opts)
 			}
 		}
 	}
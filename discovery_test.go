@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/hashicorp/consul/consul/structs"
 	"github.com/hashicorp/consul/testutil"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -167,3 +168,34 @@ func TestDiscovery_discoveredNode(t *testing.T) {
 
 	testWaitForAlert(t, alertCh, structs.HealthCritical, 5*time.Second)
 }
+
+// Make sure tag watches are started and stopped as a service's tags change
+func TestDiscovery_diffTags(t *testing.T) {
+	watching := map[string]chan struct{}{
+		"alpha": make(chan struct{}),
+		"beta":  make(chan struct{}),
+	}
+
+	added, removed := diffTags(watching, []string{"beta", "gamma", "seed", "gamma"}, []string{"seed"})
+	if !reflect.DeepEqual(added, []string{"gamma"}) {
+		t.Errorf("expected gamma to be added, got %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"alpha"}) {
+		t.Errorf("expected alpha to be removed, got %v", removed)
+	}
+}
+
+// Make sure services that disappear have their tag watches stopped
+func TestDiscovery_goneServices(t *testing.T) {
+	tagWatches := map[string]map[string]chan struct{}{
+		"redis": {"alpha": make(chan struct{})},
+		"nginx": {"beta": make(chan struct{})},
+		"mysql": {},
+	}
+	current := map[string][]string{"redis": {"alpha"}}
+
+	gone := goneServices(tagWatches, current)
+	if !reflect.DeepEqual(gone, []string{"mysql", "nginx"}) {
+		t.Errorf("expected mysql and nginx to be gone, got %v", gone)
+	}
+}
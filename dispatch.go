@@ -0,0 +1,149 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// A notification waiting for a dispatch worker to send it to a handler
+type dispatchJob struct {
+	handler string
+	alert   *AlertState
+	seq     uint64
+}
+
+// Returns the key identifying the target of the job, the same as a queued retry's
+func (j *dispatchJob) target() string {
+	return (&QueuedNotification{Handler: j.handler, Alert: *j.alert}).target()
+}
+
+type jobsBySeq []*dispatchJob
+
+func (j jobsBySeq) Len() int           { return len(j) }
+func (j jobsBySeq) Swap(a, b int)      { j[a], j[b] = j[b], j[a] }
+func (j jobsBySeq) Less(a, b int) bool { return j[a].seq < j[b].seq }
+
+// Dispatcher sends notifications to handlers from a fixed pool of workers, so watches can
+// go back to processing health updates without waiting on handlers, and the number of calls
+// to handlers in flight at once is capped. Notifications about the same node/service for
+// the same handler always go to the same worker, so they're sent in order.
+type Dispatcher struct {
+	sync.Mutex
+	config *Config
+	queues []chan *dispatchJob
+
+	// The jobs that haven't been started yet, so they can be handed off with the retry
+	// queue, and the last sequence number used to keep them in order
+	waiting map[*dispatchJob]bool
+	lastSeq uint64
+}
+
+// The dispatcher used by the daemon, if dispatch_workers is set. Alerts are sent from the
+// watch's goroutine if it isn't.
+var dispatcher *Dispatcher
+
+// Creates a dispatcher from the config and starts its workers
+func newDispatcher(config *Config) *Dispatcher {
+	d := &Dispatcher{
+		config:  config,
+		queues:  make([]chan *dispatchJob, config.DispatchWorkers),
+		waiting: make(map[*dispatchJob]bool),
+	}
+
+	size := config.DispatchQueueSize / config.DispatchWorkers
+	if size < 1 {
+		size = 1
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan *dispatchJob, size)
+		go d.work(d.queues[i])
+	}
+	return d
+}
+
+// Queues the alert to be sent to the handler by a worker. If the worker's queue is full,
+// the alert goes to the retry queue to be sent on its next run instead.
+func (d *Dispatcher) dispatch(handler string, alert *AlertState) {
+	// Give the job its own copy, since the watch keeps updating the alert
+	alertCopy := *alert
+	alertCopy.Delivered = append([]string(nil), alert.Delivered...)
+	job := &dispatchJob{handler: handler, alert: &alertCopy}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(job.target()))
+	queue := d.queues[hash.Sum32()%uint32(len(d.queues))]
+
+	d.Lock()
+	d.lastSeq++
+	job.seq = d.lastSeq
+	d.waiting[job] = true
+	d.Unlock()
+
+	select {
+	case queue <- job:
+	default:
+		d.Lock()
+		delete(d.waiting, job)
+		d.Unlock()
+		log.Warnf("Dispatch queue is full, queueing alert '%s' for handler %s to be retried", alert.Message, handler)
+		retryQueue.enqueue(handler, job.alert, "dispatch queue full")
+	}
+}
+
+// Sends the jobs from a queue until the process exits
+func (d *Dispatcher) work(queue chan *dispatchJob) {
+	for job := range queue {
+		d.send(job)
+	}
+}
+
+// Sends a single job, unless it's been moved to the retry queue for a handoff
+func (d *Dispatcher) send(job *dispatchJob) {
+	handoffLock.RLock()
+	defer handoffLock.RUnlock()
+
+	d.Lock()
+	waiting := d.waiting[job]
+	delete(d.waiting, job)
+	d.Unlock()
+
+	if waiting {
+		deliverAlert(d.config, job.handler, job.alert)
+	}
+}
+
+// Moves the jobs that haven't been started to the retry queue, in the order they were
+// dispatched, so they're handed off with it. Must be called with handoffLock held, so no
+// more jobs can start.
+func (d *Dispatcher) drain() {
+	if d == nil {
+		return
+	}
+
+	d.Lock()
+	jobs := make([]*dispatchJob, 0, len(d.waiting))
+	for job := range d.waiting {
+		jobs = append(jobs, job)
+	}
+	d.waiting = make(map[*dispatchJob]bool)
+	d.Unlock()
+
+	sort.Sort(jobsBySeq(jobs))
+	for _, job := range jobs {
+		retryQueue.enqueue(job.handler, job.alert, "handed off before dispatch")
+	}
+}
+
+// Returns the number of notifications waiting for a worker
+func (d *Dispatcher) len() int {
+	if d == nil {
+		return 0
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	return len(d.waiting)
+}
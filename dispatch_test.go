@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// A handler that waits for the gate to be opened before recording each alert
+type gatedHandler struct {
+	gate   chan struct{}
+	alerts chan *AlertState
+}
+
+func (g gatedHandler) Alert(ctx context.Context, alert *AlertState) error {
+	<-g.gate
+	g.alerts <- alert
+	return nil
+}
+
+// Make sure alerts for the same target are sent in the order they were dispatched
+func TestDispatch_order(t *testing.T) {
+	alertCh := make(chan *AlertState, 5)
+	config := &Config{
+		Handlers:          map[string]AlertHandler{"test.ordered": testHandler{alertCh}},
+		DispatchWorkers:   4,
+		DispatchQueueSize: 20,
+	}
+	d := newDispatcher(config)
+
+	for _, status := range []string{"warning", "critical", "warning", "passing"} {
+		d.dispatch("test.ordered", &AlertState{Service: "redis", Status: status, EventID: status})
+	}
+
+	for _, expected := range []string{"warning", "critical", "warning", "passing"} {
+		select {
+		case alert := <-alertCh:
+			if alert.Status != expected {
+				t.Fatalf("expected a %s alert, got %s", expected, alert.Status)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a %s alert to be sent", expected)
+		}
+	}
+}
+
+// Make sure alerts waiting for a worker are moved to the retry queue by a handoff, in order,
+// and aren't also sent by the worker afterwards
+func TestDispatch_drain(t *testing.T) {
+	gate := make(chan struct{})
+	alertCh := make(chan *AlertState, 3)
+	config := &Config{
+		Handlers:          map[string]AlertHandler{"test.slow": gatedHandler{gate, alertCh}},
+		DispatchWorkers:   1,
+		DispatchQueueSize: 10,
+	}
+	// Swap the retry queue under handoffLock, since other tests' workers may still be using it
+	handoffLock.Lock()
+	retryQueue = &RetryQueue{notifications: make([]*QueuedNotification, 0)}
+	handoffLock.Unlock()
+	defer func() {
+		handoffLock.Lock()
+		retryQueue = nil
+		handoffLock.Unlock()
+	}()
+	d := newDispatcher(config)
+
+	// The first alert holds up the only worker, so the others wait behind it
+	d.dispatch("test.slow", &AlertState{Service: "redis", EventID: "1"})
+	for d.len() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.dispatch("test.slow", &AlertState{Service: "web", EventID: "2"})
+	d.dispatch("test.slow", &AlertState{Service: "db", EventID: "3"})
+
+	// A handoff waits for the alert being sent, then stops the worker taking any more
+	drained := make(chan struct{})
+	go func() {
+		handoffLock.Lock()
+		d.drain()
+		handoffLock.Unlock()
+		close(drained)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	<-drained
+
+	queued := retryQueue.list()
+	if len(queued) != 2 || queued[0].Alert.EventID != "2" || queued[1].Alert.EventID != "3" {
+		t.Fatalf("expected the waiting alerts to be queued in order, got %v", queued)
+	}
+	if alert := <-alertCh; alert.EventID != "1" {
+		t.Fatalf("expected the first alert to be sent, got %s", alert.EventID)
+	}
+	select {
+	case alert := <-alertCh:
+		t.Fatalf("expected the drained alerts not to be sent by the worker, got %s", alert.EventID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// An alert whose stored state doesn't match the live health of its node/service
+type Inconsistency struct {
+	// The KV path of the alert state
+	Path string `json:"path"`
+
+	// The watch the alert belongs to
+	Name    string `json:"name"`
+	Node    string `json:"node,omitempty"`
+	Service string `json:"service,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+
+	// The status last sent to the handlers, and the current health in Consul
+	Alerted string `json:"alerted"`
+	Live    string `json:"live"`
+
+	// A description of what's wrong
+	Problem string `json:"problem"`
+
+	// The stored state, used when repairing
+	alert *AlertState
+	pair  *api.KVPair
+}
+
+// Returns the KV paths of every stored alert state
+func findAlertPaths(client *api.Client) ([]string, error) {
+	keys, _, err := client.KV().Keys(alertingKVRoot+"/", "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing alert state: %s", err)
+	}
+
+	paths := make([]string, 0)
+	for _, key := range keys {
+		// Only node and service watches can be checked against Consul's health
+		isWatch := strings.HasPrefix(key, alertingKVRoot+"/node/") || strings.HasPrefix(key, alertingKVRoot+"/service/")
+		if isWatch && strings.HasSuffix(key, "/alert") {
+			paths = append(paths, key)
+		}
+	}
+	return paths, nil
+}
+
+// Returns the node, service and tag an alert state path belongs to
+func parseAlertPath(path string) (node, service, tag string, err error) {
+	parts := strings.Split(strings.TrimPrefix(path, alertingKVRoot+"/"), "/")
+
+	switch {
+	case len(parts) == 3 && parts[0] == "node":
+		return parts[1], "", "", nil
+	case len(parts) == 3 && parts[0] == "service":
+		return "", parts[1], "", nil
+	case len(parts) == 4 && parts[0] == "service":
+		return "", parts[1], parts[2], nil
+	}
+	return "", "", "", fmt.Errorf("Unrecognized alert state path: %s", path)
+}
+
+// Returns the current health of the node/service/tag the same way its watch computes it,
+// and whether it still exists in the catalog
+func liveHealth(config *Config, client *api.Client, node, service, tag string) (string, bool, error) {
+	checks := make(map[string]string)
+
+	if service == "" {
+		catalogNode, _, err := client.Catalog().Node(node, nil)
+		if err != nil || catalogNode == nil {
+			return "", false, err
+		}
+
+		healthChecks, _, err := client.Health().Node(node, nil)
+		if err != nil {
+			return "", false, err
+		}
+		for _, check := range healthChecks {
+			if check.ServiceID == "" {
+				checks[check.CheckID] = check.Status
+			}
+		}
+		return computeHealth(checks), true, nil
+	}
+
+	entries, _, err := client.Health().Service(service, tag, false, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if len(entries) == 0 {
+		return "", false, nil
+	}
+
+	for _, entry := range entries {
+		for _, check := range config.suppressChecks(service, entry.Checks) {
+			if check.ServiceID == entry.Service.ID {
+				checks[check.Node+"/"+check.CheckID] = check.Status
+			}
+		}
+	}
+	return config.serviceHealth(service, checks), true, nil
+}
+
+// Compares the stored alert state at the given path with the live health of its node or
+// service, returning nil if they agree
+func checkAlertConsistency(config *Config, client *api.Client, path string) (*Inconsistency, error) {
+	node, service, tag, err := parseAlertPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, _, err := client.KV().Get(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading alert state: %s", err)
+	}
+	if pair == nil || len(pair.Value) == 0 {
+		return nil, nil
+	}
+
+	alert := &AlertState{}
+	if err := json.Unmarshal(pair.Value, alert); err != nil {
+		return &Inconsistency{
+			Path:    path,
+			Name:    watchName(node, service, tag),
+			Problem: fmt.Sprintf("alert state can't be parsed: %s", err),
+		}, nil
+	}
+
+	live, exists, err := liveHealth(config, client, node, service, tag)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting health for %s: %s", watchName(node, service, tag), err)
+	}
+
+	inconsistency := &Inconsistency{
+		Path:    path,
+		Name:    watchName(node, service, tag),
+		Node:    node,
+		Service: service,
+		Tag:     tag,
+		Alerted: alert.LastAlerted,
+		Live:    live,
+		alert:   alert,
+		pair:    pair,
+	}
+
+	switch {
+	case !exists && alert.LastAlerted != api.HealthPassing:
+		inconsistency.Live = "missing"
+		inconsistency.Problem = fmt.Sprintf("alert is %s but the %s no longer exists", alert.LastAlerted, watchTarget(service))
+	case !exists || alert.LastAlerted == live:
+		return nil, nil
+	case alert.Status == live:
+		// A timer may still be running for this change, but it should never take longer than
+		// the change threshold
+		inconsistency.Problem = fmt.Sprintf("alert is %s but the %s has been %s since the last update (pending change not sent)", alert.LastAlerted, watchTarget(service), live)
+	case live == api.HealthPassing:
+		inconsistency.Problem = fmt.Sprintf("alert is %s but the %s is healthy", alert.LastAlerted, watchTarget(service))
+	default:
+		inconsistency.Problem = fmt.Sprintf("alert is %s but the %s is %s", alert.LastAlerted, watchTarget(service), live)
+	}
+
+	return inconsistency, nil
+}
+
+// Returns "service" or "node" depending on what's being watched
+func watchTarget(service string) string {
+	if service == "" {
+		return NodeWatch
+	}
+	return ServiceWatch
+}
+
+// Stores the live status as the last alerted status and sends the alert to the handlers.
+// The update is done with a check-and-set first, so nothing is sent if a watch changed the
+// alert state in the meantime.
+func repairAlert(config *Config, client *api.Client, inconsistency *Inconsistency) error {
+	if inconsistency.alert == nil {
+		return fmt.Errorf("Can't repair unparseable alert state at %s, wipe it with the state command instead", inconsistency.Path)
+	}
+
+	status := inconsistency.Live
+	message := alertMessage(config.ConsulDatacenter, inconsistency.Name, status)
+	if status == "missing" {
+		status = api.HealthPassing
+		message = fmt.Sprintf("[%s] %s was removed, resolving its alert", config.ConsulDatacenter, inconsistency.Name)
+	}
+
+	alert := inconsistency.alert
+	alert.Status = status
+	alert.Severity = statusSeverity(status)
+	alert.Datacenter = config.ConsulDatacenter
+	alert.Message = message
+	alert.Details = ""
+	alert.UpdateIndex++
+	alert.EventID = alertEventID(inconsistency.Path, alert.UpdateIndex, status)
+	alert.updateIncident()
+	config.applyPriority(alert)
+	alert.LastAlerted = status
+	alert.LastSeverity, alert.LastPriority = alert.Severity, alert.Priority
+	alert.LastAlertedAt = time.Now()
+
+	// Every handler is either sent the alert or has it queued for retry below, so they can
+	// all be recorded as delivered up front
+	alert.Delivered = config.alertHandlerNames(inconsistency.Service, alert)
+	alert.LastDelivered = append([]string{}, alert.Delivered...)
+
+	serialized, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	inconsistency.pair.Value = serialized
+
+	ok, _, err := client.KV().CAS(inconsistency.pair, nil)
+	if err != nil {
+		return fmt.Errorf("Error storing repaired alert state: %s", err)
+	}
+	if !ok {
+		return fmt.Errorf("Alert state at %s changed while repairing it", inconsistency.Path)
+	}
+
+	send := *alert
+	send.Delivered = nil
+	sendAlert(config, inconsistency.Service, &send, func(string) {})
+	publishAlert(config, client, alert)
+	return nil
+}
+
+// Periodically checks the alerts for the watches we lead against the live health in
+// Consul, logging (and repairing, if consistency_repair is set) any that disagree
+func runConsistencyChecks(config *Config, client *api.Client) {
+	interval := time.Duration(config.ConsistencyCheckInterval) * time.Second
+	for range time.Tick(interval) {
+		for _, watch := range runningWatches.list() {
+			if !watch.leader() {
+				continue
+			}
+
+			// Leave watches that are in the middle of a change to their own timers, so a repair
+			// can't race with an alert that's about to be sent
+			threshold := time.Duration(config.serviceChangeThreshold(watch.Service)) * time.Second
+			if config.ConsistencyRepair && watch.settling(threshold, time.Now()) {
+				log.Debugf("Skipping consistency check for %s, its status changed recently", watch.Name)
+				continue
+			}
+
+			path := watchKVPath(watch.Node, watch.Service, watch.Tag) + "alert"
+			inconsistency, err := checkAlertConsistency(config, client, path)
+			if err != nil {
+				log.Warnf("Error checking alert consistency for %s: %s", watch.Name, err)
+				continue
+			}
+			if inconsistency == nil {
+				continue
+			}
+
+			log.Warnf("Inconsistent alert state for %s: %s", inconsistency.Name, inconsistency.Problem)
+			if config.ConsistencyRepair {
+				if err := repairAlert(config, client, inconsistency); err != nil {
+					log.Errorf("Error repairing alert for %s: %s", inconsistency.Name, err)
+					continue
+				}
+				log.Infof("Repaired alert state for %s, now %s", inconsistency.Name, inconsistency.alert.LastAlerted)
+			}
+		}
+	}
+}
+
+const doctorUsage = `Usage: consul-alerting doctor [options]
+
+  Checks the stored alert state for every node and service against its live health in
+  Consul, and reports any alerts that disagree, such as an alert left open for a service
+  that's healthy again. Exits 1 if any inconsistencies were found.
+
+Options:
+
+    -config=<path>    The config file to use for the Consul address/token and handlers.
+    -repair           Send the corrected alerts to the handlers and update the stored state.
+    -json             Output the inconsistencies as JSON.
+`
+
+func doctorCommand(args []string) int {
+	var configPath string
+	var repair, jsonOutput bool
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, doctorUsage) }
+	flags.StringVar(&configPath, "config", "", "")
+	flags.BoolVar(&repair, "repair", false, "")
+	flags.BoolVar(&jsonOutput, "json", false, "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	config := DefaultConfig()
+	if configPath != "" {
+		var err error
+		config, err = ParseConfigFile(configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	client, err := newConsulClient(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing client: %s\n", err)
+		return 1
+	}
+
+	if config.ConsulDatacenter == "" {
+		agentInfo, err := client.Agent().Self()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching datacenter from Consul: %s\n", err)
+			return 1
+		}
+		config.ConsulDatacenter = agentInfo["Config"]["Datacenter"].(string)
+	}
+
+	paths, err := findAlertPaths(client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	inconsistencies := make([]*Inconsistency, 0)
+	for _, path := range paths {
+		inconsistency, err := checkAlertConsistency(config, client, path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if inconsistency != nil {
+			inconsistencies = append(inconsistencies, inconsistency)
+		}
+	}
+
+	if jsonOutput {
+		out, _ := json.MarshalIndent(inconsistencies, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, inconsistency := range inconsistencies {
+			fmt.Printf("%s: %s\n", inconsistency.Name, inconsistency.Problem)
+		}
+		fmt.Printf("Checked %d alerts, found %d inconsistencies\n", len(paths), len(inconsistencies))
+	}
+
+	if len(inconsistencies) == 0 {
+		return 0
+	}
+
+	if repair {
+		failed := false
+		for _, inconsistency := range inconsistencies {
+			if err := repairAlert(config, client, inconsistency); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = true
+				continue
+			}
+			if !jsonOutput {
+				fmt.Printf("Repaired %s, alert is now %s\n", inconsistency.Name, inconsistency.alert.LastAlerted)
+			}
+		}
+		if !failed {
+			return 0
+		}
+	}
+
+	return 1
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestDoctor_parseAlertPath(t *testing.T) {
+	cases := []struct {
+		path, node, service, tag string
+	}{
+		{alertingKVRoot + "/node/foo/alert", "foo", "", ""},
+		{alertingKVRoot + "/service/redis/alert", "", "redis", ""},
+		{alertingKVRoot + "/service/redis/alpha/alert", "", "redis", "alpha"},
+	}
+
+	for _, c := range cases {
+		node, service, tag, err := parseAlertPath(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node != c.node || service != c.service || tag != c.tag {
+			t.Errorf("%s: expected (%q, %q, %q), got (%q, %q, %q)", c.path, c.node, c.service, c.tag, node, service, tag)
+		}
+
+		// The path should round trip through the watch's KV path
+		if path := watchKVPath(node, service, tag) + "alert"; path != c.path {
+			t.Errorf("expected %s, got %s", c.path, path)
+		}
+	}
+
+	if _, _, _, err := parseAlertPath(alertingKVRoot + "/node/foo/checks/bar"); err == nil {
+		t.Error("expected error for non-alert path")
+	}
+}
+
+// Make sure nothing is sent if the alert state changed since it was checked
+func TestDoctor_repairAlertCAS(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	config, alertCh := testAlertConfig()
+	path := alertingKVRoot + "/service/redis/alert"
+	setAlertState(path, &AlertState{Service: "redis", Status: "critical", LastAlerted: "critical"}, client)
+
+	pair, _, err := client.KV().Get(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := getAlertState(path, client)
+	inconsistency := &Inconsistency{Path: path, Name: "service redis", Service: "redis", Live: "passing", alert: stored, pair: pair}
+
+	// A watch updates the state before the repair
+	setAlertState(path, &AlertState{Service: "redis", Status: "passing", LastAlerted: "critical", UpdateIndex: 1}, client)
+	if err := repairAlert(config, client, inconsistency); err == nil {
+		t.Fatal("expected the repair to fail")
+	}
+	select {
+	case alert := <-alertCh:
+		t.Fatalf("expected no alert to be sent, got %q", alert.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	pair, _, _ = client.KV().Get(path, nil)
+	stored, _ = getAlertState(path, client)
+	inconsistency.alert, inconsistency.pair = stored, pair
+	if err := repairAlert(config, client, inconsistency); err != nil {
+		t.Fatal(err)
+	}
+	if alert := <-alertCh; alert.Status != api.HealthPassing {
+		t.Fatalf("expected passing alert, got %s", alert.Status)
+	}
+	if stored, _ = getAlertState(path, client); stored.LastAlerted != api.HealthPassing || len(stored.Delivered) != 1 {
+		t.Fatalf("expected repaired state to be stored, got %+v", stored)
+	}
+}
+
+// Make sure watches with a pending alert or a recent change are left alone
+func TestDoctor_settling(t *testing.T) {
+	now := time.Now()
+	state := newWatchState("service redis", "", "redis", "")
+	if state.settling(time.Minute, now) {
+		t.Fatal("expected a new watch not to be settling")
+	}
+
+	state.changedAt = now.Add(-30 * time.Second)
+	if !state.settling(time.Minute, now) {
+		t.Fatal("expected a recently changed watch to be settling")
+	}
+
+	state.changedAt = now.Add(-2 * time.Minute)
+	state.addPending(&PendingAlert{UpdateIndex: 1})
+	if !state.settling(time.Minute, now) {
+		t.Fatal("expected a watch with a pending alert to be settling")
+	}
+}
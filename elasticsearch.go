@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ElasticsearchHandler indexes every alert into an Elasticsearch or OpenSearch index, for a
+// searchable history of alerts. Indices are named by date so old ones can be dropped.
+type ElasticsearchHandler struct {
+	URL   string `mapstructure:"url"`
+	Index string `mapstructure:"index"`
+	// The Go time layout of the date appended to the index name, or empty for a single index
+	DateFormat string `mapstructure:"date_format"`
+
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	APIKey   string `mapstructure:"api_key"`
+}
+
+// An alert as indexed, with a timestamp for Kibana/OpenSearch Dashboards to sort by
+type elasticsearchDocument struct {
+	*AlertState
+	Timestamp string `json:"@timestamp"`
+}
+
+// Returns the name of the index to write an alert sent at the given time to
+func (e ElasticsearchHandler) indexName(now time.Time) string {
+	if e.DateFormat == "" {
+		return e.Index
+	}
+	return e.Index + "-" + now.UTC().Format(e.DateFormat)
+}
+
+func (e ElasticsearchHandler) Alert(ctx context.Context, alert *AlertState) error {
+	now := time.Now()
+
+	// Index the alert under its event ID so retries overwrite it instead of adding duplicates
+	endpoint := strings.TrimRight(e.URL, "/") + "/" + url.PathEscape(e.indexName(now)) + "/_doc"
+	if alert.EventID != "" {
+		endpoint += "/" + url.PathEscape(alert.EventID)
+	}
+
+	headers := map[string]string{}
+	if e.APIKey != "" {
+		headers["Authorization"] = "ApiKey " + e.APIKey
+	} else if e.Username != "" {
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(e.Username+":"+e.Password))
+	}
+
+	document := elasticsearchDocument{AlertState: alert, Timestamp: now.UTC().Format(time.RFC3339Nano)}
+	if err := postJSON(ctx, endpoint, headers, document); err != nil {
+		return fmt.Errorf("Error sending alert to Elasticsearch (%s): %s", e.URL, err)
+	}
+	return nil
+}
+
+func (e ElasticsearchHandler) Validate() error {
+	if e.URL == "" {
+		return errors.New("no url given")
+	}
+	if e.Index == "" {
+		return errors.New("no index given")
+	}
+	if name := e.indexName(time.Now()); name != strings.ToLower(name) {
+		return fmt.Errorf("invalid index %q, index names must be lowercase", name)
+	}
+	if e.APIKey != "" && e.Username != "" {
+		return errors.New("only one of api_key and username can be given")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Substrings of the errors Consul returns while its servers are electing a new leader. Other
+// 500s are real errors, so they aren't matched.
+var leaderElectionErrors = []string{
+	"No cluster leader",
+	"leadership lost",
+}
+
+// Returns true if the given query error looks like it was caused by a leader election
+// rather than a real problem with the agent or our request: one of leaderElectionErrors, or
+// an RPC error about the leader
+func isLeaderElectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, s := range leaderElectionErrors {
+		if strings.Contains(err.Error(), s) {
+			return true
+		}
+	}
+	return strings.Contains(err.Error(), "rpc error") && strings.Contains(strings.ToLower(err.Error()), "leader")
+}
+
+// ElectionGrace tracks a window after a leader election during which query results may be
+// incomplete, so watches can hold their alert state steady until the cluster settles
+type ElectionGrace struct {
+	period time.Duration
+	until  time.Time
+}
+
+func newElectionGrace(config *Config) *ElectionGrace {
+	return &ElectionGrace{period: time.Duration(config.LeaderGracePeriod) * time.Second}
+}
+
+// Starts (or extends) the grace period
+func (g *ElectionGrace) start() {
+	g.until = time.Now().Add(g.period)
+}
+
+// Returns true if we're still inside the grace period
+func (g *ElectionGrace) active() bool {
+	return time.Now().Before(g.until)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestElection_isLeaderElectionError(t *testing.T) {
+	cases := map[string]bool{
+		"Unexpected response code: 500 (No cluster leader)":                              true,
+		"Unexpected response code: 500 (rpc error: leadership lost while committing)":    true,
+		"Unexpected response code: 500 (rpc error: failed to get conn: no known leader)": true,
+		"Unexpected response code: 500 (rpc error: Permission denied)":                   false,
+		"Unexpected response code: 500 (failed to decode request body)":                  false,
+		"Unexpected response code: 403 (ACL not found)":                                  false,
+		"dial tcp 127.0.0.1:8500: connection refused":                                    false,
+	}
+
+	for msg, expected := range cases {
+		if actual := isLeaderElectionError(errors.New(msg)); actual != expected {
+			t.Errorf("%q: expected %v, got %v", msg, expected, actual)
+		}
+	}
+
+	if isLeaderElectionError(nil) {
+		t.Error("expected nil error not to be a leader election error")
+	}
+}
+
+func TestElection_gracePeriod(t *testing.T) {
+	grace := newElectionGrace(&Config{LeaderGracePeriod: 1})
+	if grace.active() {
+		t.Fatal("expected grace period to be inactive before starting")
+	}
+
+	grace.start()
+	if !grace.active() {
+		t.Fatal("expected grace period to be active after starting")
+	}
+
+	grace.until = time.Now().Add(-1 * time.Second)
+	if grace.active() {
+		t.Fatal("expected grace period to have expired")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// How often open alerts are checked for escalation steps that are due
+const escalationCheckInterval = 30 * time.Second
+
+// EscalationConfig sends the alerts it matches to further tiers of handlers while they stay
+// failing without being acknowledged, e.g. Slack, then PagerDuty, then a text message
+type EscalationConfig struct {
+	AlertMatcher `mapstructure:",squash"`
+
+	Name  string
+	Steps []EscalationStep `mapstructure:"step"`
+}
+
+// EscalationStep is a tier of an escalation policy, whose handlers are sent the alert once
+// it's gone unacknowledged for the step's delay (in seconds) after the previous tier
+type EscalationStep struct {
+	Delay    int      `mapstructure:"delay"`
+	Handlers []string `mapstructure:"handlers"`
+}
+
+// Returns how long after an alert is sent the given step of the policy is due
+func (e *EscalationConfig) stepDelay(step int) time.Duration {
+	var delay time.Duration
+	for _, s := range e.Steps[:step+1] {
+		delay += time.Duration(s.Delay) * time.Second
+	}
+	return delay
+}
+
+// Parse the raw escalation objects into the config, keeping them in the order they're
+// defined
+func parseEscalations(list *ast.ObjectList, config *Config) error {
+	for _, e := range list.Items {
+		if len(e.Keys) != 1 {
+			return fmt.Errorf("escalation block must have a name")
+		}
+		name := e.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var escalation EscalationConfig
+		if err := hcl.DecodeObject(&m, e.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &escalation); err != nil {
+			return fmt.Errorf("Invalid escalation %s: %s", name, err)
+		}
+		escalation.Name = name
+
+		if err := escalation.validate(); err != nil {
+			return fmt.Errorf("Invalid escalation %s: %s", name, err)
+		}
+		if len(escalation.Status) == 0 {
+			escalation.Status = []string{api.HealthCritical}
+		}
+		if len(escalation.Steps) == 0 {
+			return fmt.Errorf("Escalation %s has no steps", name)
+		}
+		for i, step := range escalation.Steps {
+			if step.Delay <= 0 {
+				return fmt.Errorf("Step %d of escalation %s must have a positive delay", i+1, name)
+			}
+			if len(step.Handlers) == 0 {
+				return fmt.Errorf("Step %d of escalation %s has no handlers", i+1, name)
+			}
+		}
+
+		config.Escalations = append(config.Escalations, escalation)
+	}
+
+	return nil
+}
+
+// EscalationState records the escalation steps an incident has been sent to, so they're
+// only sent once, and so the handlers they reached can be told when it's resolved
+type EscalationState struct {
+	IncidentID string `json:"incident_id"`
+
+	// The steps that have been sent, as <escalation>/<step number>
+	Steps []string `json:"steps"`
+
+	// The handlers the steps sent the incident to
+	Handlers []string `json:"handlers"`
+}
+
+// Returns the KV path the escalation state for an alert is stored at, given the alert's path
+func escalationKVPath(alertPath string) string {
+	return strings.TrimSuffix(alertPath, "alert") + "escalation"
+}
+
+func getEscalationState(client *api.Client, alertPath string) (*EscalationState, error) {
+	pair, _, err := client.KV().Get(escalationKVPath(alertPath), nil)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+
+	var state EscalationState
+	if err := json.Unmarshal(pair.Value, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func setEscalationState(client *api.Client, alertPath string, state *EscalationState) error {
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = client.KV().Put(&api.KVPair{Key: escalationKVPath(alertPath), Value: serialized}, nil)
+	return err
+}
+
+// Periodically sends the escalation steps that are due for the alerts of the watches this
+// instance is leading. Runs until the process exits.
+func runEscalations(config *Config, client *api.Client) {
+	for range time.Tick(escalationCheckInterval) {
+		for _, watch := range runningWatches.list() {
+			if !watch.leader() {
+				continue
+			}
+			path := watchKVPath(watch.Node, watch.Service, watch.Tag) + "alert"
+			if err := escalateAlert(config, client, path, watch.Service, time.Now()); err != nil {
+				log.Errorf("Error checking escalations for %s: %s", watch.Name, err)
+			}
+		}
+	}
+}
+
+// Sends the alert at the path to the escalation steps that are due for it: those of the
+// policies matching it, once it's gone unacknowledged for the steps' delays since it was
+// sent. Once it's resolved, the handlers the steps reached are sent the resolution. Both go
+// through the silences, min_severity, schedules and quiet hours other alerts do.
+func escalateAlert(config *Config, client *api.Client, path, service string, now time.Time) error {
+	// Hold off while we're handing off to a new process, which will pick up from here
+	handoffLock.RLock()
+	defer handoffLock.RUnlock()
+
+	alert, err := getAlertState(path, client)
+	if err != nil || alert == nil || alert.Meta {
+		return err
+	}
+	state, err := getEscalationState(client, path)
+	if err != nil {
+		return err
+	}
+
+	// Tell the handlers the incident was escalated to that it's over
+	if state != nil && (alert.LastAlerted == api.HealthPassing || alert.IncidentID != state.IncidentID) {
+		if alert.LastAlerted == api.HealthPassing && alert.IncidentID == state.IncidentID {
+			log.Infof("Sending resolution of escalated alert '%s' to handlers %s", alert.Message, strings.Join(state.Handlers, ", "))
+			sendAlertTo(config, service, alert, state.Handlers, func(string) {})
+		}
+		_, err := client.KV().Delete(escalationKVPath(path), nil)
+		return err
+	}
+
+	// Only escalate alerts that have been sent and haven't changed since
+	if alert.LastAlerted == api.HealthPassing || alert.Status != alert.LastAlerted || alert.IncidentID == "" {
+		return nil
+	}
+	ack, err := getAlertAck(client, path, alert)
+	if err != nil || ack != nil {
+		return err
+	}
+
+	if state == nil {
+		state = &EscalationState{IncidentID: alert.IncidentID}
+	}
+	unacknowledged := now.Sub(alert.LastAlertedAt)
+	escalated := false
+	for i := range config.Escalations {
+		escalation := &config.Escalations[i]
+		if !escalation.matches(service, alert) {
+			continue
+		}
+		for step := range escalation.Steps {
+			key := fmt.Sprintf("%s/%d", escalation.Name, step+1)
+			if contains(state.Steps, key) || unacknowledged < escalation.stepDelay(step) {
+				continue
+			}
+
+			escalatedAlert := *alert
+			escalatedAlert.Delivered = nil
+			escalatedAlert.EventID = alertEventID(path, alert.UpdateIndex, "escalation-"+key)
+			escalatedAlert.Message = fmt.Sprintf("%s (unacknowledged for %s, escalated by %s)", alert.Message, unacknowledged.Round(time.Minute), escalation.Name)
+			log.Infof("Escalating alert '%s' to handlers %s (step %d of %s)", alert.Message, strings.Join(escalation.Steps[step].Handlers, ", "), step+1, escalation.Name)
+			sendAlertTo(config, service, &escalatedAlert, escalation.Steps[step].Handlers, func(name string) {
+				if !contains(state.Handlers, name) {
+					state.Handlers = append(state.Handlers, name)
+				}
+			})
+			state.Steps = append(state.Steps, key)
+			escalated = true
+		}
+	}
+
+	if !escalated {
+		return nil
+	}
+	return setEscalationState(client, path, state)
+}
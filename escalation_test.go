@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const testEscalationConfig = `
+handler "stdout" "chat" {}
+handler "stdout" "pager" {}
+handler "stdout" "sms" {}
+default_handlers = ["stdout.chat"]
+
+escalation "oncall" {
+	service = "redis"
+	step {
+		delay = 600
+		handlers = ["stdout.pager"]
+	}
+	step {
+		delay = 900
+		handlers = ["stdout.sms"]
+	}
+}
+`
+
+func TestEscalation_parse(t *testing.T) {
+	config, err := ParseConfig(testEscalationConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Escalations) != 1 || len(config.Escalations[0].Steps) != 2 {
+		t.Fatalf("expected one escalation with two steps, got %+v", config.Escalations)
+	}
+	escalation := config.Escalations[0]
+	if len(escalation.Status) != 1 || escalation.Status[0] != api.HealthCritical {
+		t.Errorf("expected escalation to default to critical alerts, got %v", escalation.Status)
+	}
+	if escalation.stepDelay(0) != 10*time.Minute || escalation.stepDelay(1) != 25*time.Minute {
+		t.Errorf("expected step delays of 10m and 25m, got %s and %s", escalation.stepDelay(0), escalation.stepDelay(1))
+	}
+
+	for _, raw := range []string{
+		`escalation "a" {}`,
+		`escalation "a" { step { handlers = ["stdout.a"] } }`,
+		`escalation "a" { step { delay = 60 } }`,
+		`escalation "a" { step { delay = 60 handlers = ["stdout.missing"] } }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("expected an error parsing %s", raw)
+		}
+	}
+}
+
+// Make sure an unacknowledged alert is escalated through each step once, and the handlers
+// it reached are sent its resolution
+func TestEscalation_escalateAlert(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	config, err := ParseConfig(testEscalationConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pagerCh := make(chan *AlertState, 10)
+	smsCh := make(chan *AlertState, 10)
+	config.Handlers["stdout.pager"] = testHandler{pagerCh}
+	config.Handlers["stdout.sms"] = testHandler{smsCh}
+
+	path := alertingKVRoot + "/service/redis/alert"
+	sent := time.Now()
+	alert := &AlertState{Service: "redis", Status: api.HealthCritical, LastAlerted: api.HealthCritical, LastAlertedAt: sent, IncidentID: "abc"}
+	setAlertState(path, alert, client)
+
+	for _, at := range []time.Duration{5 * time.Minute, 11 * time.Minute, 12 * time.Minute} {
+		if err := escalateAlert(config, client, path, "redis", sent.Add(at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pagerCh) != 1 || len(smsCh) != 0 {
+		t.Fatalf("expected only the first step to be sent once, got %d and %d", len(pagerCh), len(smsCh))
+	}
+	<-pagerCh
+
+	// Acknowledging it stops the escalation
+	if _, err := ackIncident(client, "abc", "alice", "slack", sent); err != nil {
+		t.Fatal(err)
+	}
+	escalateAlert(config, client, path, "redis", sent.Add(30*time.Minute))
+	if len(smsCh) != 0 {
+		t.Fatal("expected an acknowledged alert not to be escalated")
+	}
+
+	// The resolution goes to the handlers it was escalated to
+	alert.Status, alert.LastAlerted = api.HealthPassing, api.HealthPassing
+	setAlertState(path, alert, client)
+	escalateAlert(config, client, path, "redis", sent.Add(31*time.Minute))
+	if len(pagerCh) != 1 || (<-pagerCh).Status != api.HealthPassing {
+		t.Fatal("expected the pager to be sent the resolution")
+	}
+	if state, err := getEscalationState(client, path); err != nil || state != nil {
+		t.Fatalf("expected the escalation state to be cleared, got %v %v", state, err)
+	}
+}
+
+// Make sure escalations are held back by silences like any other alert
+func TestEscalation_silenced(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	config, err := ParseConfig(testEscalationConfig + `
+	silence "maintenance" {
+		service = "redis"
+		end = "2099-01-01T00:00:00Z"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pagerCh := make(chan *AlertState, 10)
+	config.Handlers["stdout.pager"] = testHandler{pagerCh}
+
+	path := alertingKVRoot + "/service/redis/alert"
+	sent := time.Now()
+	alert := &AlertState{Service: "redis", Status: api.HealthCritical, LastAlerted: api.HealthCritical, LastAlertedAt: sent, IncidentID: "abc"}
+	setAlertState(path, alert, client)
+
+	if err := escalateAlert(config, client, path, "redis", sent.Add(11*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(pagerCh) != 0 {
+		t.Fatal("expected a silenced alert not to be escalated")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EventBridgeHandler puts alerts onto an Amazon EventBridge event bus, with the alert as
+// the event's detail, so rules can fan them out to other AWS services
+type EventBridgeHandler struct {
+	AWSCredentials `mapstructure:",squash"`
+
+	Region     string `mapstructure:"region"`
+	EventBus   string `mapstructure:"event_bus"`
+	Source     string `mapstructure:"source"`
+	DetailType string `mapstructure:"detail_type"`
+
+	// Optional. The EventBridge endpoint to use instead of the region's public one.
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+// An entry in the body of a PutEvents request
+type eventBridgeEntry struct {
+	EventBusName string `json:"EventBusName"`
+	Source       string `json:"Source"`
+	DetailType   string `json:"DetailType"`
+	Detail       string `json:"Detail"`
+	Time         int64  `json:"Time"`
+}
+
+// The parts of the PutEvents response we check, since it succeeds even if entries fail
+type eventBridgeResponse struct {
+	FailedEntryCount int `json:"FailedEntryCount"`
+	Entries          []struct {
+		ErrorCode    string `json:"ErrorCode"`
+		ErrorMessage string `json:"ErrorMessage"`
+	} `json:"Entries"`
+}
+
+// Returns the PutEvents request body for an alert
+func (e EventBridgeHandler) putEventsBody(alert *AlertState, now time.Time) ([]byte, error) {
+	detail, err := json.Marshal(alert)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := eventBridgeEntry{
+		EventBusName: e.EventBus,
+		Source:       e.Source,
+		DetailType:   e.DetailType,
+		Detail:       string(detail),
+		Time:         now.Unix(),
+	}
+	return json.Marshal(map[string][]eventBridgeEntry{"Entries": []eventBridgeEntry{entry}})
+}
+
+func (e EventBridgeHandler) Alert(ctx context.Context, alert *AlertState) error {
+	credentials, err := e.AWSCredentials.resolve()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	body, err := e.putEventsBody(alert, now)
+	if err != nil {
+		return err
+	}
+
+	endpoint := e.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://events.%s.amazonaws.com/", e.Region)
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSEvents.PutEvents")
+	signAWSRequest(req, body, credentials, e.Region, "events", now)
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Error sending alert to EventBridge: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Error sending alert to EventBridge: got response code %d (%s)", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var result eventBridgeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("Error sending alert to EventBridge: error decoding response: %s", err)
+	}
+	if result.FailedEntryCount > 0 && len(result.Entries) > 0 {
+		return fmt.Errorf("Error sending alert to EventBridge: %s (%s)", result.Entries[0].ErrorMessage, result.Entries[0].ErrorCode)
+	}
+	return nil
+}
+
+func (e EventBridgeHandler) Validate() error {
+	if e.Region == "" {
+		return errors.New("no region given")
+	}
+	if e.Source == "" || e.DetailType == "" {
+		return errors.New("source and detail_type must be given")
+	}
+	// Sources starting with aws. are reserved for AWS services
+	if strings.HasPrefix(e.Source, "aws.") {
+		return fmt.Errorf("invalid source %q, sources starting with aws. are reserved", e.Source)
+	}
+	if (e.AccessKey == "") != (e.SecretKey == "") {
+		return errors.New("access_key and secret_key must be given together")
+	}
+	return nil
+}
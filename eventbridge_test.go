@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventBridge_putEvents(t *testing.T) {
+	var body map[string][]eventBridgeEntry
+	var auth, target string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		target = r.Header.Get("X-Amz-Target")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"FailedEntryCount":0,"Entries":[{"EventId":"1"}]}`))
+	}))
+	defer server.Close()
+
+	handler := EventBridgeHandler{
+		AWSCredentials: AWSCredentials{AccessKey: "AKID", SecretKey: "secret"},
+		Region:         "us-east-1",
+		EventBus:       "alerts",
+		Source:         "consul-alerting",
+		DetailType:     "Consul Health Alert",
+		Endpoint:       server.URL,
+	}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: "critical", Service: "redis", Message: "redis is down"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(auth, "/us-east-1/events/aws4_request") || target != "AWSEvents.PutEvents" {
+		t.Errorf("unexpected request headers: %q %q", auth, target)
+	}
+	if len(body["Entries"]) != 1 {
+		t.Fatalf("expected one entry, got %v", body)
+	}
+	entry := body["Entries"][0]
+	if entry.EventBusName != "alerts" || entry.Source != "consul-alerting" || entry.DetailType != "Consul Health Alert" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+
+	var detail AlertState
+	if err := json.Unmarshal([]byte(entry.Detail), &detail); err != nil || detail.Message != alert.Message {
+		t.Errorf("unexpected detail %q: %v", entry.Detail, err)
+	}
+}
+
+func TestEventBridge_failedEntry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"FailedEntryCount":1,"Entries":[{"ErrorCode":"InternalFailure","ErrorMessage":"try again"}]}`))
+	}))
+	defer server.Close()
+
+	handler := EventBridgeHandler{
+		AWSCredentials: AWSCredentials{AccessKey: "AKID", SecretKey: "secret"},
+		Region:         "us-east-1",
+		Endpoint:       server.URL,
+	}
+	err := handler.Alert(context.Background(), &AlertState{Status: "critical", Node: "node1"})
+	if err == nil || !strings.Contains(err.Error(), "InternalFailure") {
+		t.Errorf("expected the failed entry's error, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+)
+
+// EventGridHandler publishes alerts to an Azure Event Grid topic as CloudEvents, with the
+// alert as the event's data
+type EventGridHandler struct {
+	// The topic endpoint, e.g. https://alerts.westus2-1.eventgrid.azure.net/api/events
+	Endpoint string `mapstructure:"endpoint"`
+	Key      string `mapstructure:"key"`
+	Source   string `mapstructure:"source"`
+	Type     string `mapstructure:"type"`
+}
+
+// A CloudEvents 1.0 event in the structured JSON format
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            string      `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            *AlertState `json:"data"`
+}
+
+// Returns the subject of the event for an alert, as a path that subscriptions can filter
+// on by prefix, e.g. dc1/services/redis/tags/alpha or dc1/nodes/node1
+func eventGridSubject(alert *AlertState) string {
+	subject := alert.Datacenter + "/nodes/" + alert.Node
+	if alert.Service != "" {
+		subject = alert.Datacenter + "/services/" + alert.Service
+		if alert.Tag != "" {
+			subject += "/tags/" + alert.Tag
+		}
+	}
+	return subject
+}
+
+// Returns the event to publish for an alert
+func (e EventGridHandler) alertEvent(alert *AlertState, now time.Time) (cloudEvent, error) {
+	// Use the alert's event ID so Event Grid subscribers can deduplicate retries
+	id := alert.EventID
+	if id == "" {
+		var err error
+		if id, err = uuid.GenerateUUID(); err != nil {
+			return cloudEvent{}, err
+		}
+	}
+
+	return cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          e.Source,
+		Type:            e.Type,
+		Subject:         eventGridSubject(alert),
+		Time:            now.UTC().Format(time.RFC3339),
+		DataContentType: "application/json",
+		Data:            alert,
+	}, nil
+}
+
+func (e EventGridHandler) Alert(ctx context.Context, alert *AlertState) error {
+	event, err := e.alertEvent(alert, time.Now())
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"aeg-sas-key":  e.Key,
+		"Content-Type": "application/cloudevents-batch+json; charset=utf-8",
+	}
+	if err := postJSON(ctx, e.Endpoint, headers, []cloudEvent{event}); err != nil {
+		return fmt.Errorf("Error sending alert to Event Grid: %s", err)
+	}
+	return nil
+}
+
+func (e EventGridHandler) Validate() error {
+	if e.Endpoint == "" {
+		return errors.New("no endpoint given")
+	}
+	if e.Key == "" {
+		return errors.New("no key given")
+	}
+	if e.Source == "" || e.Type == "" {
+		return errors.New("source and type must be given")
+	}
+	return nil
+}
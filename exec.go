@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The most output from a failed command to include in its error
+const execOutputLimit = 512
+
+// How long to wait for a killed command's output to close, in case something it started
+// is still holding it open
+const execWaitDelay = 5 * time.Second
+
+// ExecHandler runs a command for each alert, with the alert as JSON on stdin and its fields
+// in CONSUL_ALERT_* environment variables. The command is killed if it runs past the
+// handler's timeout.
+type ExecHandler struct {
+	Command    []string          `mapstructure:"command"`
+	WorkingDir string            `mapstructure:"working_dir"`
+	Env        map[string]string `mapstructure:"env"`
+}
+
+// Returns the environment to run the command with for an alert
+func (e ExecHandler) environment(alert *AlertState) []string {
+	env := os.Environ()
+	for key, value := range e.Env {
+		env = append(env, key+"="+value)
+	}
+	for key, value := range map[string]string{
+		"STATUS":     alert.Status,
+		"NODE":       alert.Node,
+		"SERVICE":    alert.Service,
+		"TAG":        alert.Tag,
+		"DATACENTER": alert.Datacenter,
+		"MESSAGE":    alert.Message,
+		"DETAILS":    alert.Details,
+		"LINK":       alert.Link,
+		"EVENT_ID":   alert.EventID,
+	} {
+		env = append(env, "CONSUL_ALERT_"+key+"="+value)
+	}
+	return env
+}
+
+func (e ExecHandler) Alert(ctx context.Context, alert *AlertState) error {
+	if len(e.Command) == 0 || e.Command[0] == "" {
+		return errors.New("Error running command: no command given")
+	}
+
+	input, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	cmd.Dir = e.WorkingDir
+	cmd.Env = e.environment(alert)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = execWaitDelay
+	setProcessGroup(cmd)
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		message := strings.TrimSpace(output.String())
+		if len(message) > execOutputLimit {
+			message = message[len(message)-execOutputLimit:]
+		}
+		return fmt.Errorf("Error running command %s: %s (output: %s)", e.Command[0], err, message)
+	}
+
+	if output.Len() > 0 {
+		log.Debugf("Output of command %s: %s", e.Command[0], strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+func (e ExecHandler) Validate() error {
+	if len(e.Command) == 0 || e.Command[0] == "" {
+		return errors.New("no command given")
+	}
+	// Commands given as a path are run relative to working_dir, so only look up bare names
+	if filepath.Base(e.Command[0]) == e.Command[0] {
+		if _, err := exec.LookPath(e.Command[0]); err != nil {
+			return fmt.Errorf("command %s not found in PATH", e.Command[0])
+		}
+	}
+	if e.WorkingDir != "" {
+		if info, err := os.Stat(e.WorkingDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("working_dir %s is not a directory", e.WorkingDir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec_alert(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+
+	dir, err := ioutil.TempDir("", "exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler := ExecHandler{
+		Command:    []string{"sh", "-c", `echo "$CONSUL_ALERT_STATUS $CONSUL_ALERT_SERVICE $TEAM" > env; cat > alert.json`},
+		WorkingDir: dir,
+		Env:        map[string]string{"TEAM": "data"},
+	}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: "critical", Service: "redis", Message: "redis is critical"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	env, _ := ioutil.ReadFile(filepath.Join(dir, "env"))
+	if strings.TrimSpace(string(env)) != "critical redis data" {
+		t.Errorf("unexpected environment: %q", env)
+	}
+	var input AlertState
+	contents, _ := ioutil.ReadFile(filepath.Join(dir, "alert.json"))
+	if err := json.Unmarshal(contents, &input); err != nil || input.Message != alert.Message {
+		t.Errorf("unexpected stdin %q: %v", contents, err)
+	}
+}
+
+func TestExec_failure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+
+	handler := ExecHandler{Command: []string{"sh", "-c", "echo 'no route to pager' >&2; exit 3"}}
+	err := handler.Alert(context.Background(), &AlertState{})
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "no route to pager") {
+		t.Errorf("expected error with the command's output, got %v", err)
+	}
+
+	// Commands are killed when the handler times out
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	handler = ExecHandler{Command: []string{"sleep", "10"}}
+	if err := handler.Alert(ctx, &AlertState{}); err != context.DeadlineExceeded || time.Since(start) > 5*time.Second {
+		t.Errorf("expected the command to be killed, got %v", err)
+	}
+
+	// Along with anything it started that's holding its output open
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	handler = ExecHandler{Command: []string{"sh", "-c", "sleep 10 & wait"}}
+	if err := handler.Alert(ctx, &AlertState{}); err != context.DeadlineExceeded || time.Since(start) > 5*time.Second {
+		t.Errorf("expected the command's children to be killed, got %v", err)
+	}
+
+	// A handler that skipped validation shouldn't panic
+	if err := (ExecHandler{}).Alert(context.Background(), &AlertState{}); err == nil {
+		t.Errorf("expected an error for an empty command")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Exit codes for the daemon, so wrappers and orchestration tools can tell failures
+// apart without parsing log output
+const (
+	exitOK             = 0
+	exitError          = 1
+	exitConfigError    = 2
+	exitConsulError    = 3
+	exitPartialStartup = 4
+)
+
+var exitCodeNames = map[int]string{
+	exitOK:             "ok",
+	exitError:          "error",
+	exitConfigError:    "config_error",
+	exitConsulError:    "consul_unreachable",
+	exitPartialStartup: "partial_startup",
+}
+
+// Set by the -json-errors flag to write fatal errors to stderr as JSON instead of a log line
+var jsonErrors bool
+
+// The JSON object written to stderr for fatal errors when -json-errors is set
+type ErrorOutput struct {
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// Reports a fatal error and exits with the given code, removing our PID file if we wrote one
+func fatal(code int, err error) {
+	if jsonErrors {
+		out, _ := json.Marshal(ErrorOutput{
+			Error:    err.Error(),
+			Code:     exitCodeNames[code],
+			ExitCode: code,
+		})
+		fmt.Fprintln(os.Stderr, string(out))
+	} else {
+		log.Error(err)
+	}
+
+	removePidFile(pidFilePath)
+	os.Exit(code)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Describes how an alert would be routed to handlers, and why
+type RoutingExplanation struct {
+	// The routing decisions that were made for the alert, in order
+	Steps []string
+
+	// The names of the handlers that would be sent the alert
+	Handlers []string
+}
+
+// Works out which handlers a given alert would be sent to, recording each decision along the way
+func explainRouting(config *Config, alert *AlertState) *RoutingExplanation {
+	explanation := &RoutingExplanation{}
+
+	if silence := config.silencedBy(alert.Service, alert, time.Now()); silence != nil {
+		explanation.Steps = append(explanation.Steps, fmt.Sprintf("silenced by %q until %s, so it wouldn't be sent", silence.Name, silence.end.Format(time.RFC3339)))
+		return explanation
+	}
+
+	// Routes take precedence over the service's handlers when any of them match
+	matched := false
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if !route.matches(alert.Service, alert) {
+			continue
+		}
+		if !route.Schedule.active(time.Now()) {
+			explanation.Steps = append(explanation.Steps, fmt.Sprintf("route %q matches but is outside its schedule (%s), skipping it", route.Name, route.Schedule))
+			continue
+		}
+		matched = true
+		explanation.Steps = append(explanation.Steps, fmt.Sprintf("matched route %q, sending to %v", route.Name, route.Handlers))
+		if !route.Continue {
+			break
+		}
+		explanation.Steps = append(explanation.Steps, fmt.Sprintf("route %q has continue set, checking later routes", route.Name))
+	}
+	if matched {
+		explanation.Handlers = config.alertHandlerNames(alert.Service, alert)
+		explainSchedules(config, explanation)
+		return explanation
+	}
+
+	if alert.Service != "" {
+		if serviceConfig := config.serviceConfig(alert.Service); serviceConfig != nil {
+			explanation.Steps = append(explanation.Steps, fmt.Sprintf("matched service block %q", alert.Service))
+			if len(serviceConfig.Handlers) > 0 {
+				explanation.Steps = append(explanation.Steps, fmt.Sprintf("service handlers restrict alerts to %v", serviceConfig.Handlers))
+			}
+		} else {
+			explanation.Steps = append(explanation.Steps, fmt.Sprintf("no service block for %q", alert.Service))
+		}
+	}
+
+	serviceConfig := config.serviceConfig(alert.Service)
+	if (serviceConfig == nil || len(serviceConfig.Handlers) == 0) && len(config.DefaultHandlers) > 0 {
+		explanation.Steps = append(explanation.Steps, fmt.Sprintf("default_handlers restrict alerts to %v", config.DefaultHandlers))
+	}
+
+	// Point out any handler names that were referenced but never defined
+	for _, name := range config.serviceHandlerFilters(alert.Service) {
+		if _, ok := config.Handlers[name]; !ok {
+			explanation.Steps = append(explanation.Steps, fmt.Sprintf("handler %q is referenced but not defined", name))
+		}
+	}
+
+	if config.DeadLetterHandler != "" && len(config.serviceHandlerFilters(alert.Service)) == 0 {
+		explanation.Steps = append(explanation.Steps, fmt.Sprintf("handler %q is the dead_letter_handler and only gets undeliverable alerts", config.DeadLetterHandler))
+	}
+
+	explanation.Handlers = config.serviceHandlerNames(alert.Service)
+	explainSchedules(config, explanation)
+
+	return explanation
+}
+
+// Notes which of the chosen handlers are only sent alerts at certain times
+func explainSchedules(config *Config, explanation *RoutingExplanation) {
+	for _, name := range explanation.Handlers {
+		if schedule := config.handlerOptions(name).Schedule; schedule != nil {
+			explanation.Steps = append(explanation.Steps, fmt.Sprintf("handler %q is only sent alerts %s", name, schedule))
+		}
+	}
+}
+
+const explainRoutingUsage = `Usage: consul-alerting explain-routing [options]
+
+  Shows which handlers a hypothetical alert would be sent to with the given config,
+  and why.
+
+Options:
+
+    -config=<path>      The config file to use. Defaults to the default config.
+    -service=<name>     The service the alert is for. Leave empty for a node alert.
+    -tag=<tag>          The service tag the alert is for.
+    -node=<name>        The node the alert is for.
+    -datacenter=<name>  The datacenter the alert is from, for matching routes.
+    -status=<status>    The alert status (passing, warning or critical). Defaults to critical.
+    -severity=<level>   The alert severity (ok, warning, critical or unknown). Defaults to
+                        the one the status maps to.
+    -category=<name>    The alert category, from the service's output rules.
+    -priority=<score>   The alert priority. Defaults to the one computed from its severity
+                        and the service's configured criticality, on one node.
+`
+
+func explainRoutingCommand(args []string) int {
+	var configPath string
+	var priority int
+	alert := &AlertState{}
+	flags := flag.NewFlagSet("explain-routing", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, explainRoutingUsage) }
+	flags.StringVar(&configPath, "config", "", "")
+	flags.StringVar(&alert.Service, "service", "", "")
+	flags.StringVar(&alert.Tag, "tag", "", "")
+	flags.StringVar(&alert.Node, "node", "", "")
+	flags.StringVar(&alert.Datacenter, "datacenter", "", "")
+	flags.StringVar(&alert.Status, "status", api.HealthCritical, "")
+	flags.StringVar(&alert.Severity, "severity", "", "")
+	flags.StringVar(&alert.Category, "category", "", "")
+	flags.IntVar(&priority, "priority", -1, "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if alert.Severity == "" {
+		alert.Severity = statusSeverity(alert.Status)
+	}
+
+	if alert.Service == "" && alert.Node == "" {
+		fmt.Fprintln(os.Stderr, "Must specify at least one of -service or -node")
+		return 1
+	}
+
+	config := DefaultConfig()
+	if configPath != "" {
+		var err error
+		config, err = ParseConfigFile(configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	alert.Priority = priority
+	if priority < 0 {
+		if serviceConfig := config.serviceConfig(alert.Service); serviceConfig != nil {
+			alert.Criticality = serviceConfig.Criticality
+		}
+		alert.Checks = []AlertCheck{{Node: alert.Node}}
+		config.applyPriority(alert)
+		alert.Checks = nil
+	}
+
+	explanation := explainRouting(config, alert)
+
+	fmt.Printf("Alert: %s\nPriority: %d\n\n", describeAlertTarget(alert), alert.Priority)
+	fmt.Println("Routing:")
+	for _, step := range explanation.Steps {
+		fmt.Printf("  - %s\n", step)
+	}
+	if len(explanation.Steps) == 0 {
+		fmt.Println("  - no routing restrictions, using all handlers")
+	}
+
+	fmt.Println("\nHandlers that would fire:")
+	for _, name := range explanation.Handlers {
+		fmt.Printf("  - %s\n", name)
+	}
+	if len(explanation.Handlers) == 0 {
+		fmt.Println("  (none)")
+	}
+
+	return 0
+}
+
+// Returns a short description of the alert's target and status, e.g. "service redis
+// (tag: alpha) is now critical"
+func describeAlertTarget(alert *AlertState) string {
+	parts := make([]string, 0)
+	if alert.Service != "" {
+		parts = append(parts, "service "+alert.Service)
+		if alert.Tag != "" {
+			parts = append(parts, fmt.Sprintf("(tag: %s)", alert.Tag))
+		}
+		if alert.Node != "" {
+			parts = append(parts, "on node "+alert.Node)
+		}
+	} else {
+		parts = append(parts, "node "+alert.Node)
+	}
+
+	return strings.Join(parts, " ") + " is now " + alert.Status
+}
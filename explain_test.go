@@ -0,0 +1,151 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExplain_serviceHandlers(t *testing.T) {
+	config := &Config{
+		DefaultHandlers: []string{"stdout.warn"},
+		Services: map[string]ServiceConfig{
+			"webapp": ServiceConfig{
+				Name:     "webapp",
+				Handlers: []string{"email.admin", "slack.missing"},
+			},
+		},
+		Handlers: map[string]AlertHandler{
+			"stdout.warn": StdoutHandler{},
+			"email.admin": EmailHandler{},
+		},
+	}
+
+	explanation := explainRouting(config, &AlertState{Service: "webapp", Status: "critical"})
+
+	expected := []string{"email.admin"}
+	if !reflect.DeepEqual(explanation.Handlers, expected) {
+		t.Errorf("expected handlers %v, got %v", expected, explanation.Handlers)
+	}
+
+	expectedSteps := []string{
+		`matched service block "webapp"`,
+		`service handlers restrict alerts to [email.admin slack.missing]`,
+		`handler "slack.missing" is referenced but not defined`,
+	}
+	if !reflect.DeepEqual(explanation.Steps, expectedSteps) {
+		t.Errorf("expected steps %#v, got %#v", expectedSteps, explanation.Steps)
+	}
+
+	// A node alert should fall back to default_handlers
+	explanation = explainRouting(config, &AlertState{Node: "node1", Status: "critical"})
+
+	expected = []string{"stdout.warn"}
+	if !reflect.DeepEqual(explanation.Handlers, expected) {
+		t.Errorf("expected handlers %v, got %v", expected, explanation.Handlers)
+	}
+}
+
+func TestExplain_routes(t *testing.T) {
+	config, err := ParseConfig(`
+	handler "stdout" "pager" {}
+	handler "stdout" "chat" {}
+	handler "stdout" "audit" {}
+
+	route "audit" {
+		datacenter = "prod-*"
+		handlers = ["stdout.audit"]
+		continue = true
+	}
+
+	route "payments-critical" {
+		service = "payments-*"
+		status = ["critical"]
+		handlers = ["stdout.pager"]
+	}
+
+	route "everything-else" {
+		handlers = ["stdout.chat"]
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		alert    *AlertState
+		expected []string
+	}{
+		{&AlertState{Service: "payments-api", Status: "critical", Datacenter: "prod-east"}, []string{"stdout.audit", "stdout.pager"}},
+		{&AlertState{Service: "payments-api", Status: "warning", Datacenter: "dev"}, []string{"stdout.chat"}},
+		{&AlertState{Service: "payments-api", Status: "passing", LastAlerted: "critical", Datacenter: "dev"}, []string{"stdout.pager"}},
+		{&AlertState{Node: "node1", Status: "critical", Datacenter: "dev"}, []string{"stdout.chat"}},
+	}
+	for _, c := range cases {
+		explanation := explainRouting(config, c.alert)
+		if !reflect.DeepEqual(explanation.Handlers, c.expected) {
+			t.Errorf("%s: expected handlers %v, got %v (%v)", describeAlertTarget(c.alert), c.expected, explanation.Handlers, explanation.Steps)
+		}
+	}
+
+	if _, err := ParseConfig(`route "broken" { handlers = ["slack.missing"] }`); err == nil {
+		t.Error("expected an error for a route with an unknown handler")
+	}
+}
+
+// Make sure routes only apply during their schedules, so warnings can go nowhere at night
+func TestExplain_routeSchedule(t *testing.T) {
+	config, err := ParseConfig(`
+	timezone = "Asia/Tokyo"
+	handler "stdout" "pager" {}
+	handler "stdout" "chat" {}
+
+	route "quiet-nights" {
+		status = ["warning"]
+		handlers = []
+		schedule {
+			hours = "22:00-07:00"
+		}
+	}
+
+	route "never" {
+		handlers = ["stdout.pager"]
+		schedule {
+			days = "sun-sat"
+			outside = true
+		}
+	}
+
+	route "everything-else" {
+		handlers = ["stdout.chat"]
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 23:00 and 12:00 in Tokyo
+	night, _ := time.Parse(time.RFC3339, "2026-10-16T14:00:00Z")
+	day, _ := time.Parse(time.RFC3339, "2026-10-16T03:00:00Z")
+	warning := &AlertState{Service: "web", Status: "warning"}
+	quietNights := &config.Routes[0]
+	if !quietNights.applies("web", warning, night) || quietNights.applies("web", warning, day) {
+		t.Error("expected the route's schedule to use the global timezone")
+	}
+
+	explanation := explainRouting(config, &AlertState{Service: "web", Status: "critical"})
+	if !reflect.DeepEqual(explanation.Handlers, []string{"stdout.chat"}) {
+		t.Errorf("expected the route outside its schedule to be skipped, got %v (%v)", explanation.Handlers, explanation.Steps)
+	}
+	if !strings.Contains(strings.Join(explanation.Steps, "\n"), "outside its schedule") {
+		t.Errorf("expected the skipped route to be explained, got %v", explanation.Steps)
+	}
+
+	if _, err := ParseConfig(`route "broken" { service = "web" }`); err == nil {
+		t.Error("expected an error for a route without handlers")
+	}
+	if _, err := ParseConfig(`timezone = "Mars/Olympus"`); err == nil {
+		t.Error("expected an error for an unknown timezone")
+	}
+}
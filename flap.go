@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// Returns the window status changes are counted over for flap detection
+func flapWindow(config *Config) time.Duration {
+	return time.Duration(config.FlapWindow) * time.Second
+}
+
+// Records a change of the alert's status to the given one, dropping the changes that have
+// fallen out of the flap window, and marks the alert as flapping if there have now been
+// flap_threshold changes within the window
+func (a *AlertState) recordStatusChange(status string, config *Config, now time.Time) {
+	window := flapWindow(config)
+	changes := make([]time.Time, 0, len(a.StatusChanges)+1)
+	for _, at := range a.StatusChanges {
+		if now.Sub(at) < window {
+			changes = append(changes, at)
+		}
+	}
+	if len(changes) == 0 && !a.Flapping {
+		a.FlapStatus = ""
+	}
+	a.StatusChanges = append(changes, now)
+
+	// Keep track of the worst status it's flapped between, to send the flapping alert with
+	for _, s := range []string{a.Status, status} {
+		if s == api.HealthPassing || s == "" {
+			continue
+		}
+		if order, ok := statusOrder[a.FlapStatus]; !ok || statusOrder[s] < order {
+			a.FlapStatus = s
+		}
+	}
+
+	if !a.Flapping && len(a.StatusChanges) >= config.FlapThreshold && a.FlapStatus != "" {
+		log.Infof("%s is flapping, %d status changes in the last %s", watchName(a.Node, a.Service, a.Tag), len(a.StatusChanges), window)
+		a.Flapping = true
+		a.FlapNotified = false
+	}
+}
+
+// Returns when a flapping alert will be considered stable, which is once its status has
+// held for the whole flap window
+func (a *AlertState) flapSettleAt(config *Config) time.Time {
+	if len(a.StatusChanges) == 0 {
+		return time.Time{}
+	}
+	return a.StatusChanges[len(a.StatusChanges)-1].Add(flapWindow(config))
+}
+
+// Clears the flapping state of an alert that has stabilized
+func (a *AlertState) stopFlapping() {
+	log.Infof("%s has stopped flapping, now %s", watchName(a.Node, a.Service, a.Tag), a.Status)
+	a.Flapping = false
+	a.FlapNotified = false
+	a.FlapStatus = ""
+	a.StatusChanges = nil
+}
+
+// Sends the single alert saying the node/service is flapping, in place of the alerts for its
+// individual status changes. It's sent with the worst status it flapped between, as a
+// transition of its own, so once it stabilizes the alert for its settled status goes out
+// (or not) the same way it would after any other alert.
+func sendFlapping(kvPath string, alert *AlertState, watchOpts *WatchOptions) {
+	status, message, severity := alert.Status, alert.Message, alert.Severity
+	alert.Status = alert.FlapStatus
+	alert.Severity = statusSeverity(alert.FlapStatus)
+	alert.Message = fmt.Sprintf("[%s] %s is flapping (%d status changes in the last %s), holding back its alerts until it's stable",
+		watchOpts.config.ConsulDatacenter, watchName(alert.Node, alert.Service, alert.Tag), len(alert.StatusChanges), flapWindow(watchOpts.config))
+
+	if claimed, err := claimTransition(watchOpts.config, watchOpts.client, kvPath, alert, time.Now()); err != nil || !claimed {
+		if err != nil {
+			log.Error(err)
+		} else {
+			log.Infof("Alert '%s' is being sent by another instance, skipping", alert.Message)
+		}
+		alert.Status, alert.Message, alert.Severity = status, message, severity
+		return
+	}
+
+	flapEventID := alertEventID(kvPath, alert.UpdateIndex, "flapping")
+	if alert.EventID != flapEventID {
+		alert.EventID = flapEventID
+		alert.Delivered = nil
+	}
+	alert.updateIncident()
+	prioritizeAlert(watchOpts, alert)
+
+	sendAlert(watchOpts.config, watchOpts.service, alert, func(handler string) {
+		alert.Delivered = append(alert.Delivered, handler)
+		setAlertState(kvPath, alert, watchOpts.client)
+	})
+	alert.LastAlerted = alert.Status
+	alert.LastSeverity = alert.Severity
+	alert.LastCategory = alert.Category
+	alert.LastPriority = alert.Priority
+	alert.LastDelivered = append([]string{}, alert.Delivered...)
+	alert.LastAlertedAt = time.Now()
+	publishAlert(watchOpts.config, watchOpts.client, alert)
+
+	// Go back to the real status change, so it can be sent once the alert is stable
+	alert.Status, alert.Message, alert.Severity = status, message, severity
+	alert.EventID = alertEventID(kvPath, alert.UpdateIndex, alert.Status)
+	alert.Delivered = nil
+	alert.FlapNotified = true
+	setAlertState(kvPath, alert, watchOpts.client)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestFlap_recordStatusChange(t *testing.T) {
+	config := DefaultConfig()
+	config.FlapThreshold = 4
+	config.FlapWindow = 600
+
+	start := time.Now()
+	alert := &AlertState{Service: "redis", Status: api.HealthPassing}
+	statuses := []string{api.HealthWarning, api.HealthPassing, api.HealthCritical, api.HealthPassing}
+	for i, status := range statuses {
+		at := start.Add(time.Duration(i) * 2 * time.Minute)
+		alert.recordStatusChange(status, config, at)
+		alert.Status = status
+		if flapping := i == len(statuses)-1; alert.Flapping != flapping {
+			t.Fatalf("change %d: expected flapping to be %v", i, flapping)
+		}
+	}
+	if alert.FlapStatus != api.HealthCritical {
+		t.Errorf("expected flap status critical, got %q", alert.FlapStatus)
+	}
+	if settleAt := alert.flapSettleAt(config); !settleAt.Equal(start.Add(16 * time.Minute)) {
+		t.Errorf("expected the alert to settle 10m after its last change, got %s", settleAt.Sub(start))
+	}
+
+	alert.stopFlapping()
+	if alert.Flapping || alert.FlapStatus != "" || len(alert.StatusChanges) != 0 {
+		t.Errorf("expected the flapping state to be cleared, got %+v", alert)
+	}
+
+	// Changes that have fallen out of the window aren't counted
+	alert = &AlertState{Service: "redis", Status: api.HealthPassing}
+	for i, status := range statuses {
+		alert.recordStatusChange(status, config, start.Add(time.Duration(i)*6*time.Minute))
+		alert.Status = status
+	}
+	if alert.Flapping || len(alert.StatusChanges) != 2 {
+		t.Errorf("expected 2 changes in the window and no flapping, got %d changes, flapping %v", len(alert.StatusChanges), alert.Flapping)
+	}
+}
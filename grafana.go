@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GrafanaHandler posts an annotation to Grafana for every alert, so outages show up on the
+// timeline of its dashboards
+type GrafanaHandler struct {
+	URL         string   `mapstructure:"url"`
+	APIKey      string   `mapstructure:"api_key"`
+	DashboardID int      `mapstructure:"dashboard_id"`
+	PanelID     int      `mapstructure:"panel_id"`
+	Tags        []string `mapstructure:"tags"`
+}
+
+// The body of a request to Grafana's annotations API
+type grafanaAnnotation struct {
+	DashboardID int      `json:"dashboardId,omitempty"`
+	PanelID     int      `json:"panelId,omitempty"`
+	Time        int64    `json:"time"`
+	Tags        []string `json:"tags"`
+	Text        string   `json:"text"`
+}
+
+// Returns the tags for an alert's annotation, which can be used to filter the annotations
+// shown on a dashboard
+func (g GrafanaHandler) annotationTags(alert *AlertState) []string {
+	tags := []string{"consul-alerting", "status:" + alert.Status}
+	if alert.Datacenter != "" {
+		tags = append(tags, "dc:"+alert.Datacenter)
+	}
+	if alert.Service != "" {
+		tags = append(tags, "service:"+alert.Service)
+	}
+	if alert.Tag != "" {
+		tags = append(tags, "tag:"+alert.Tag)
+	}
+	if alert.Node != "" {
+		tags = append(tags, "node:"+alert.Node)
+	}
+	return append(tags, g.Tags...)
+}
+
+func (g GrafanaHandler) Alert(ctx context.Context, alert *AlertState) error {
+	text := alert.Message
+	if alert.Details != "" {
+		text = text + "\n" + alert.Details
+	}
+
+	err := postJSON(ctx, strings.TrimRight(g.URL, "/")+"/api/annotations", map[string]string{"Authorization": "Bearer " + g.APIKey}, grafanaAnnotation{
+		DashboardID: g.DashboardID,
+		PanelID:     g.PanelID,
+		Time:        time.Now().UnixNano() / int64(time.Millisecond),
+		Tags:        g.annotationTags(alert),
+		Text:        text,
+	})
+	if err != nil {
+		return fmt.Errorf("Error sending annotation to Grafana: %s", err)
+	}
+	return nil
+}
+
+func (g GrafanaHandler) Validate() error {
+	if g.URL == "" {
+		return errors.New("no url given")
+	}
+	if g.APIKey == "" {
+		return errors.New("no api_key given")
+	}
+	return nil
+}
+
+// Checks that the API key can read annotations
+func (g GrafanaHandler) Probe(ctx context.Context) error {
+	req, err := http.NewRequest("GET", strings.TrimRight(g.URL, "/")+"/api/annotations?limit=1", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.APIKey)
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error reaching Grafana: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Grafana rejected the api_key: got response code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGrafana_annotation(t *testing.T) {
+	var annotation grafanaAnnotation
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/annotations" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	handler := GrafanaHandler{URL: server.URL + "/", APIKey: "key", DashboardID: 3, Tags: []string{"prod"}}
+	alert := &AlertState{
+		Status:     "critical",
+		Service:    "redis",
+		Tag:        "alpha",
+		Datacenter: "dc1",
+		Message:    "[dc1] service redis (tag: alpha) is now critical",
+		Details:    "Failing checks:",
+	}
+
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth != "Bearer key" {
+		t.Errorf("expected bearer auth, got %q", auth)
+	}
+	if annotation.DashboardID != 3 || annotation.Time == 0 || annotation.Text != alert.Message+"\n"+alert.Details {
+		t.Errorf("unexpected annotation: %+v", annotation)
+	}
+	expected := []string{"consul-alerting", "status:critical", "dc:dc1", "service:redis", "tag:alpha", "prod"}
+	if !reflect.DeepEqual(annotation.Tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, annotation.Tags)
+	}
+}
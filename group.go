@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The most nodes to name in a grouped alert's message
+const groupedNodesListed = 5
+
+// Returns how long a service's alert is held after it starts failing so instances that fail
+// in the meantime are grouped into it, or 0 if it isn't
+func (c *Config) serviceGroupWindow(service string) time.Duration {
+	window := c.GroupWindow
+	if c.serviceConfig(service) != nil {
+		window = c.serviceConfig(service).GroupWindow
+	}
+	return time.Duration(window) * time.Second
+}
+
+// Returns the nodes with a failing check out of the given checks, sorted, and the number of
+// nodes with any check
+func failingNodes(checks []*api.HealthCheck) ([]string, int) {
+	statuses := make(map[string]string)
+	for _, check := range checks {
+		if statuses[check.Node] == "" || check.Status != api.HealthPassing {
+			statuses[check.Node] = check.Status
+		}
+	}
+
+	failing := make([]string, 0)
+	for node, status := range statuses {
+		if status != api.HealthPassing {
+			failing = append(failing, node)
+		}
+	}
+	sort.Strings(failing)
+	return failing, len(statuses)
+}
+
+// Returns the message for a grouped alert, e.g. "[dc1] service api is now critical on 12/40
+// nodes: api-1, api-2, api-3, api-4, api-5 and 7 more"
+func groupedMessage(datacenter, name, status string, failing []string, total int) string {
+	listed := strings.Join(failing, ", ")
+	if len(failing) > groupedNodesListed {
+		listed = fmt.Sprintf("%s and %d more", strings.Join(failing[:groupedNodesListed], ", "), len(failing)-groupedNodesListed)
+	}
+	return fmt.Sprintf("%s on %d/%d nodes: %s", alertMessage(datacenter, name, status), len(failing), total, listed)
+}
+
+// Regroups a failing service alert that's about to be sent, if the service has a
+// group_window: its checks and details are brought up to date, so they cover every instance
+// that failed during the window rather than just the first, and its message says how many
+// nodes it's failing on.
+func groupAlert(watchOpts *WatchOptions, alert *AlertState) {
+	if watchOpts.service == "" || alert.Status == api.HealthPassing || alert.Meta || watchOpts.config.serviceGroupWindow(watchOpts.service) <= 0 {
+		return
+	}
+
+	checks := refreshAlert(watchOpts, alert)
+	if failing, total := failingNodes(checks); len(failing) > 0 {
+		alert.Message = groupedMessage(watchOpts.config.ConsulDatacenter, watchName(watchOpts.node, watchOpts.service, watchOpts.tag), alert.Status, failing, total)
+	}
+}
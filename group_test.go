@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestGroup_failingNodes(t *testing.T) {
+	checks := []*api.HealthCheck{
+		{Node: "api-2", CheckID: "service:api", Status: "critical"},
+		{Node: "api-1", CheckID: "service:api", Status: "passing"},
+		{Node: "api-1", CheckID: "api-latency", Status: "warning"},
+		{Node: "api-3", CheckID: "service:api", Status: "passing"},
+	}
+	failing, total := failingNodes(checks)
+	if !reflect.DeepEqual(failing, []string{"api-1", "api-2"}) || total != 3 {
+		t.Errorf("expected api-1 and api-2 failing out of 3, got %v out of %d", failing, total)
+	}
+}
+
+func TestGroup_message(t *testing.T) {
+	message := groupedMessage("dc1", "service api", "critical", []string{"api-1", "api-2"}, 40)
+	if expected := "[dc1] service api is now critical on 2/40 nodes: api-1, api-2"; message != expected {
+		t.Errorf("expected %q, got %q", expected, message)
+	}
+
+	failing := []string{"api-1", "api-2", "api-3", "api-4", "api-5", "api-6", "api-7"}
+	message = groupedMessage("dc1", "service api", "critical", failing, 40)
+	if expected := "[dc1] service api is now critical on 7/40 nodes: api-1, api-2, api-3, api-4, api-5 and 2 more"; message != expected {
+		t.Errorf("expected %q, got %q", expected, message)
+	}
+}
+
+// Make sure a failing service alert waits out the group window, but recoveries and nodes
+// don't
+func TestGroup_window(t *testing.T) {
+	config, err := ParseConfig(`
+	change_threshold = 30
+	group_window = 120
+	service "redis" {
+		group_window = 0
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service, status string
+		threshold       int
+	}{
+		{"api", "critical", 120},
+		{"api", "passing", 30},
+		{"", "critical", 30},
+		{"redis", "critical", 30},
+	}
+	for _, c := range cases {
+		if threshold := config.alertChangeThreshold(c.service, &AlertState{Status: c.status}); threshold != c.threshold {
+			t.Errorf("%q %s: expected a threshold of %d, got %d", c.service, c.status, c.threshold, threshold)
+		}
+	}
+}
+
+// Make sure a grouped alert for a tag only counts the nodes running the service with that tag
+func TestGroup_groupAlert(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	for _, instance := range []struct{ node, tag, status string }{
+		{"web-1", "blue", api.HealthCritical},
+		{"web-2", "blue", api.HealthPassing},
+		{"web-3", "green", api.HealthCritical},
+	} {
+		_, err := client.Catalog().Register(&api.CatalogRegistration{
+			Node:    instance.node,
+			Address: "127.0.0.1",
+			Service: &api.AgentService{ID: "api", Service: "api", Tags: []string{instance.tag}},
+			Check:   &api.AgentCheck{Node: instance.node, CheckID: "service:api", Name: "api health", Status: instance.status, ServiceID: "api"},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.ConsulDatacenter = "dc1"
+	config.GroupWindow = 60
+	opts := &WatchOptions{service: "api", tag: "blue", config: config, client: client, alertLock: &sync.Mutex{}}
+
+	alert := &AlertState{Service: "api", Tag: "blue", Status: api.HealthCritical, Checks: []AlertCheck{{Node: "web-1", CheckID: "service:api"}}}
+	groupAlert(opts, alert)
+	if !strings.HasSuffix(alert.Message, "on 1/2 nodes: web-1") {
+		t.Errorf("expected only the blue nodes to be counted, got %q", alert.Message)
+	}
+	if len(alert.Checks) != 1 || alert.Checks[0].Node != "web-1" {
+		t.Errorf("expected only the failing blue check in the alert, got %+v", alert.Checks)
+	}
+}
@@ -1,28 +1,76 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/bluele/slack"
-	"github.com/darkcrux/gopherduty"
 	"github.com/hashicorp/consul/api"
 	"gopkg.in/gomail.v2"
 )
 
 // AlertHandlers are responsible for alerting to some external endpoint
-// when given an alert (email, pagerduty, etc)
+// when given an alert (email, pagerduty, etc). Returning an error causes the
+// alert to be queued for retry. The context is cancelled once the handler's
+// timeout passes.
 type AlertHandler interface {
-	Alert(*AlertState)
+	Alert(context.Context, *AlertState) error
+}
+
+// The types of handler that can be configured, keyed by the type name used in handler
+// blocks. Each block is decoded into a new value of the same type as its entry.
+var handlerTypes = map[string]AlertHandler{
+	"stdout":        StdoutHandler{},
+	"email":         EmailHandler{},
+	"pagerduty":     PagerdutyHandler{},
+	"slack":         SlackHandler{},
+	"grafana":       GrafanaHandler{},
+	"victorops":     VictorOpsHandler{},
+	"webhook":       WebhookHandler{},
+	"sqs":           SQSHandler{},
+	"kafka":         KafkaHandler{},
+	"telegram":      TelegramHandler{},
+	"twilio":        TwilioHandler{},
+	"rocketchat":    RocketChatHandler{},
+	"alertmanager":  AlertmanagerHandler{},
+	"jira":          JiraHandler{},
+	"nsca":          NSCAHandler{},
+	"nrdp":          NRDPHandler{},
+	"syslog":        SyslogHandler{},
+	"exec":          ExecHandler{},
+	"json":          JSONHandler{},
+	"zulip":         ZulipHandler{},
+	"irc":           IRCHandler{},
+	"alerta":        AlertaHandler{},
+	"bigpanda":      BigPandaHandler{},
+	"squadcast":     SquadcastHandler{},
+	"snmp":          SNMPHandler{},
+	"eventbridge":   EventBridgeHandler{},
+	"pubsub":        PubSubHandler{},
+	"eventgrid":     EventGridHandler{},
+	"elasticsearch": ElasticsearchHandler{},
+	"sentry":        SentryHandler{},
+	"zenduty":       ZendutyHandler{},
+	"webex":         WebexHandler{},
+	"plugin":        PluginHandler{},
 }
 
 type StdoutHandler struct {
 	LogLevel string `mapstructure:"log_level"`
 }
 
-func (s StdoutHandler) Alert(alert *AlertState) {
+func (s StdoutHandler) Alert(ctx context.Context, alert *AlertState) error {
 	text := []string{alert.Message}
 	if alert.Details != "" {
 		text = append(text, strings.Split(alert.Details, "\n")...)
@@ -43,34 +91,248 @@ func (s StdoutHandler) Alert(alert *AlertState) {
 			log.Debug(line)
 		}
 	}
+	return nil
+}
+
+func (s StdoutHandler) Validate() error {
+	if _, err := log.ParseLevel(s.LogLevel); err != nil {
+		return fmt.Errorf("invalid log_level %q", s.LogLevel)
+	}
+	return nil
 }
 
+// The address emails are sent from if the handler doesn't set one
+const defaultEmailFrom = "consul-alerting@noreply.com"
+
+// EmailHandler sends alerts by email, either through an SMTP server or (if no server is
+// given) straight to each recipient's mail server
 type EmailHandler struct {
 	Recipients []string `mapstructure:"recipients"`
+	CC         []string `mapstructure:"cc"`
+	From       string   `mapstructure:"from"`
+
+	// The SMTP server to send through, and how to connect to it
+	Server        string `mapstructure:"server"`
+	Port          int    `mapstructure:"port"`
+	Username      string `mapstructure:"username"`
+	Password      string `mapstructure:"password"`
+	TLS           string `mapstructure:"tls"`
+	TLSSkipVerify bool   `mapstructure:"tls_skip_verify"`
 }
 
-func (e EmailHandler) Alert(alert *AlertState) {
-	for _, recipient := range e.Recipients {
-		// Get the mail server to use for this recipient
-		records, err := net.LookupMX(strings.Split(recipient, "@")[1])
+// Returns the email for an alert, with the alert's message as the subject
+func (e EmailHandler) message(alert *AlertState) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetAddressHeader("From", e.From, "Consul Alerting")
+	m.SetHeader("To", e.Recipients...)
+	if len(e.CC) > 0 {
+		m.SetHeader("Cc", e.CC...)
+	}
+
+	m.SetHeader("Subject", alert.Message)
+	m.SetBody("text/plain", alert.Details)
+	return m
+}
+
+func (e EmailHandler) Alert(ctx context.Context, alert *AlertState) error {
+	m := e.message(alert)
+	if e.Server != "" {
+		return e.sendSMTP(ctx, m)
+	}
+
+	var lastErr error
+	for _, recipient := range append(append([]string{}, e.Recipients...), e.CC...) {
+		// Only deliver to this recipient, since each one may have a different mail server
+		client, err := dialMX(ctx, recipient)
+		if err == nil {
+			err = sendMessage(client, e.From, []string{recipient}, m)
+			client.Close()
+		}
 		if err != nil {
-			log.Error("Error looking up email server: ", err)
-			continue
+			log.Error(err)
+			lastErr = err
 		}
+	}
+	return lastErr
+}
+
+// Connects to the mail server for a recipient's domain, giving up on connecting after the
+// connect timeout in the context and on the whole session once the context's deadline passes
+func dialMX(ctx context.Context, recipient string) (*smtp.Client, error) {
+	records, err := net.DefaultResolver.LookupMX(ctx, strings.Split(recipient, "@")[1])
+	if err != nil {
+		return nil, fmt.Errorf("error looking up email server for %s: %s", recipient, err)
+	}
 
-		m := gomail.NewMessage()
-		m.SetAddressHeader("From", "consul-alerting@noreply.com", "Consul Alerting")
-		m.SetAddressHeader("To", recipient, "")
+	connectTimeout, _ := ctx.Value(connectTimeoutKey).(time.Duration)
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(records[0].Host, "25"))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to email server for %s: %s", recipient, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
 
-		m.SetHeader("Subject", alert.Message)
-		m.SetBody("text/plain", alert.Details)
+	client, err := smtp.NewClient(conn, records[0].Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to email server for %s: %s", recipient, err)
+	}
+	return client, nil
+}
 
-		d := gomail.NewPlainDialer(records[0].Host, 25, "", "")
+// Returns the port to connect to the SMTP server on, based on the TLS mode if it isn't set
+func (e EmailHandler) port() int {
+	switch {
+	case e.Port != 0:
+		return e.Port
+	case e.TLS == "tls":
+		return 465
+	case e.TLS == "none":
+		return 25
+	}
+	return 587
+}
 
-		if err := d.DialAndSend(m); err != nil {
-			log.Error(err)
+// Connects to the handler's SMTP server, setting up TLS and authenticating as configured
+func (e EmailHandler) dialSMTP(ctx context.Context) (*smtp.Client, error) {
+	connectTimeout, _ := ctx.Value(connectTimeoutKey).(time.Duration)
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	address := net.JoinHostPort(e.Server, strconv.Itoa(e.port()))
+	tlsConfig := &tls.Config{ServerName: e.Server, InsecureSkipVerify: e.TLSSkipVerify}
+
+	var conn net.Conn
+	var err error
+	if e.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to SMTP server %s: %s", address, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, e.Server)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to SMTP server %s: %s", address, err)
+	}
+
+	if e.TLS == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("SMTP server %s doesn't support STARTTLS", address)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("error starting TLS with SMTP server %s: %s", address, err)
+		}
+	}
+
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Server)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("error authenticating with SMTP server %s: %s", address, err)
+		}
+	}
+	return client, nil
+}
+
+// Sends the email to all of its recipients through the handler's SMTP server
+func (e EmailHandler) sendSMTP(ctx context.Context, m *gomail.Message) error {
+	client, err := e.dialSMTP(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return sendMessage(client, e.From, append(append([]string{}, e.Recipients...), e.CC...), m)
+}
+
+// Sends the email to the given recipients over an SMTP connection
+func sendMessage(client *smtp.Client, from string, recipients []string, m *gomail.Message) error {
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("SMTP server rejected sender %s: %s", from, err)
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("SMTP server rejected recipient %s: %s", recipient, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := m.WriteTo(w); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected message: %s", err)
+	}
+	return client.Quit()
+}
+
+func (e EmailHandler) Validate() error {
+	if len(e.Recipients) == 0 {
+		return errors.New("no recipients given")
+	}
+	for _, recipient := range append(append([]string{e.From}, e.Recipients...), e.CC...) {
+		if parts := strings.Split(recipient, "@"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid recipient %q", recipient)
+		}
+	}
+	switch e.TLS {
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("invalid tls mode %q, must be starttls, tls or none", e.TLS)
+	}
+	if e.Username != "" && e.Server == "" {
+		return errors.New("username given without a server")
+	}
+	return nil
+}
+
+// Checks that the mail server accepts connections by sending it a NOOP, after logging in
+// if the handler uses an SMTP server, or for each recipient's mail server otherwise
+func (e EmailHandler) Probe(ctx context.Context) error {
+	if e.Server != "" {
+		client, err := e.dialSMTP(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		return smtpNoop(client)
+	}
+
+	for _, recipient := range e.Recipients {
+		client, err := dialMX(ctx, recipient)
+		if err != nil {
+			return err
+		}
+		err = smtpNoop(client)
+		client.Quit()
+		if err != nil {
+			return fmt.Errorf("email server for %s rejected NOOP: %s", recipient, err)
 		}
 	}
+	return nil
+}
+
+// Sends a NOOP command to an SMTP server
+func smtpNoop(client *smtp.Client) error {
+	id, err := client.Text.Cmd("NOOP")
+	if err != nil {
+		return err
+	}
+	client.Text.StartResponse(id)
+	defer client.Text.EndResponse(id)
+	_, _, err = client.Text.ReadResponse(250)
+	return err
 }
 
 type PagerdutyHandler struct {
@@ -78,20 +340,130 @@ type PagerdutyHandler struct {
 	MaxRetries int    `mapstructure:"max_retries"`
 }
 
-func (p PagerdutyHandler) Alert(alert *AlertState) {
-	client := gopherduty.NewClient(p.ServiceKey)
-	client.MaxRetry = p.MaxRetries
-	incidentKey := alert.Service + "-" + alert.Tag + "-" + alert.Node
-	if alert.Status != api.HealthPassing {
-		client.Trigger(incidentKey, alert.Message, "", "", alert.Details)
-	} else {
-		client.Resolve(incidentKey, alert.Message, alert.Details)
+// The PagerDuty events API endpoint, replaced in tests
+var pagerdutyEventsURL = "https://events.pagerduty.com/generic/2010-04-15/create_event.json"
+
+// How long to wait before the first retry of a failed PagerDuty request, doubling each time
+const pagerdutyRetryWait = 10 * time.Second
+
+// An event for PagerDuty's v1 events API
+type pagerdutyEvent struct {
+	ServiceKey  string `json:"service_key"`
+	EventType   string `json:"event_type"`
+	IncidentKey string `json:"incident_key"`
+	Description string `json:"description"`
+	Details     string `json:"details,omitempty"`
+}
+
+func (p PagerdutyHandler) Alert(ctx context.Context, alert *AlertState) error {
+	event := pagerdutyEvent{
+		ServiceKey:  p.ServiceKey,
+		EventType:   "trigger",
+		IncidentKey: pagerdutyIncidentKey(alert),
+		Description: alert.Message,
+		Details:     alert.Details,
+	}
+	if alert.Status == api.HealthPassing {
+		event.EventType = "resolve"
+	}
+
+	// Each attempt finishes (or is cancelled along with ctx) before the next one starts,
+	// so a slow request can't race a retry of itself
+	backoff := &Backoff{min: pagerdutyRetryWait, max: maxRetryWaitTime}
+	for attempt := 0; ; attempt++ {
+		err := postJSON(ctx, pagerdutyEventsURL, nil, event)
+		if err == nil {
+			return nil
+		}
+		if attempt >= p.MaxRetries {
+			return fmt.Errorf("Error sending alert to PagerDuty: %s", err)
+		}
+
+		wait := backoff.next()
+		log.Warnf("Error sending alert to PagerDuty: %s, retrying in %s", err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("Error sending alert to PagerDuty: %s", err)
+		}
 	}
 }
 
+// Returns the incident key for an alert: the event ID of the alert that opened the incident,
+// so PagerDuty deduplicates it if it's sent again after a failover. Meta-alerts, and incidents
+// opened before incident IDs were recorded, use a key based on the node/service instead.
+func pagerdutyIncidentKey(alert *AlertState) string {
+	if alert.IncidentID != "" && !alert.Meta {
+		return alert.IncidentID
+	}
+	return alertTargetKey(alert)
+}
+
+func (p PagerdutyHandler) Validate() error {
+	if p.ServiceKey == "" {
+		return errors.New("no service_key given")
+	}
+	if p.MaxRetries < 0 {
+		return errors.New("max_retries can't be negative")
+	}
+	return nil
+}
+
+// Checks that the PagerDuty events API is reachable. The v1 events API has no way to check
+// a service key without creating an incident, so this only covers connectivity.
+func (p PagerdutyHandler) Probe(ctx context.Context) error {
+	req, err := http.NewRequest("HEAD", "https://events.pagerduty.com/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error reaching PagerDuty: %s", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SlackHandler posts alerts to a Slack channel, either through the API with a bot token or
+// through an incoming webhook
 type SlackHandler struct {
 	Token       string `mapstructure:"api_token"`
+	WebhookURL  string `mapstructure:"webhook_url"`
 	ChannelName string `mapstructure:"channel_name"`
+	Username    string `mapstructure:"username"`
+	IconEmoji   string `mapstructure:"icon_emoji"`
+	IconURL     string `mapstructure:"icon_url"`
+
+	// If true, alerts get an Acknowledge button, handled by the /v1/ack/slack endpoint
+	AckButton bool `mapstructure:"ack_button"`
+}
+
+// A Slack message attachment holding an Acknowledge button for an alert's incident
+type slackAckAttachment struct {
+	Fallback   string           `json:"fallback"`
+	CallbackID string           `json:"callback_id"`
+	Actions    []slackAckButton `json:"actions"`
+}
+
+type slackAckButton struct {
+	Name  string `json:"name"`
+	Text  string `json:"text"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Returns the attachments to send with the alert: an Acknowledge button if it's enabled and
+// the alert is part of an open incident
+func (p SlackHandler) attachments(alert *AlertState) []slackAckAttachment {
+	if !p.AckButton || alert.Status == api.HealthPassing || alert.IncidentID == "" {
+		return nil
+	}
+	return []slackAckAttachment{{
+		Fallback:   "Acknowledge this alert in consul-alerting",
+		CallbackID: slackAckCallbackID,
+		Actions:    []slackAckButton{{Name: "ack", Text: "Acknowledge", Type: "button", Value: alert.IncidentID}},
+	}}
 }
 
 const slackMessageFormat = `
@@ -99,11 +471,119 @@ const slackMessageFormat = `
 %s
 `
 
-func (p SlackHandler) Alert(alert *AlertState) {
-	api := slack.New(p.Token)
-	err := api.ChatPostMessage(p.ChannelName, fmt.Sprintf(slackMessageFormat, alert.Message, alert.Details), nil)
+func (p SlackHandler) Alert(ctx context.Context, alert *AlertState) error {
+	text := fmt.Sprintf(slackMessageFormat, alert.Message, alert.Details)
+	attachments := p.attachments(alert)
+	if p.WebhookURL != "" {
+		return p.postWebhook(ctx, text, attachments)
+	}
+
+	params := map[string]string{
+		"channel":    p.ChannelName,
+		"text":       text,
+		"username":   p.Username,
+		"icon_emoji": p.IconEmoji,
+		"icon_url":   p.IconURL,
+	}
+	if attachments != nil {
+		encoded, err := json.Marshal(attachments)
+		if err != nil {
+			return err
+		}
+		params["attachments"] = string(encoded)
+	}
+	err := p.callAPI(ctx, "chat.postMessage", params)
+	if err != nil {
+		return fmt.Errorf("Error sending alert to Slack (channel: %s): %s", p.ChannelName, err)
+	}
+	return nil
+}
+
+// The base URL of Slack's web API, replaced in tests
+var slackAPIURL = "https://slack.com/api/"
+
+// Calls a Slack web API method with the handler's token. Slack reports most errors with a
+// 200 response and ok set to false, so the body is checked as well as the status code.
+func (p SlackHandler) callAPI(ctx context.Context, method string, params map[string]string) error {
+	form := url.Values{}
+	for key, value := range params {
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+
+	req, err := http.NewRequest("POST", slackAPIURL+method, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+p.Token)
 
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
 	if err != nil {
-		log.Errorf("Error sending alert to Slack (channel: %s): %s", p.ChannelName, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("got response code %d", resp.StatusCode)
+	}
+	if !result.OK {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// Posts the message to the handler's incoming webhook. The channel, username and icon are
+// only overrides; webhooks have their own defaults set in Slack.
+func (p SlackHandler) postWebhook(ctx context.Context, text string, attachments []slackAckAttachment) error {
+	err := postJSON(ctx, p.WebhookURL, nil, &struct {
+		*slack.WebHookPostPayload
+		Attachments []slackAckAttachment `json:"attachments,omitempty"`
+	}{
+		WebHookPostPayload: &slack.WebHookPostPayload{
+			Text:      text,
+			Channel:   p.ChannelName,
+			Username:  p.Username,
+			IconEmoji: p.IconEmoji,
+			IconUrl:   p.IconURL,
+		},
+		Attachments: attachments,
+	})
+	if err != nil {
+		return fmt.Errorf("Error sending alert to Slack webhook: %s", err)
+	}
+	return nil
+}
+
+func (p SlackHandler) Validate() error {
+	if p.Token == "" && p.WebhookURL == "" {
+		return errors.New("no api_token or webhook_url given")
+	}
+	if p.Token != "" && p.WebhookURL != "" {
+		return errors.New("only one of api_token and webhook_url can be given")
+	}
+	if p.Token != "" && p.ChannelName == "" {
+		return errors.New("no channel_name given")
+	}
+	if p.IconEmoji != "" && p.IconURL != "" {
+		return errors.New("only one of icon_emoji and icon_url can be given")
+	}
+	return nil
+}
+
+// Checks that the api token is valid using Slack's auth.test method. Webhooks can't be
+// checked without posting to them, so they're skipped.
+func (p SlackHandler) Probe(ctx context.Context) error {
+	if p.WebhookURL != "" {
+		return nil
+	}
+	if err := p.callAPI(ctx, "auth.test", nil); err != nil {
+		return fmt.Errorf("Slack auth test failed: %s", err)
 	}
+	return nil
 }
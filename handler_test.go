@@ -0,0 +1,709 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bluele/slack"
+)
+
+func TestHandler_slackWebhook(t *testing.T) {
+	var payload slack.WebHookPostPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	handler := SlackHandler{WebhookURL: server.URL, ChannelName: "#ops", Username: "consul", IconEmoji: ":fire:"}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Message: "[dc1] service redis is now critical", Details: "Failing checks:"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.Channel != "#ops" || payload.Username != "consul" || payload.IconEmoji != ":fire:" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+	if !strings.Contains(payload.Text, alert.Message) || !strings.Contains(payload.Text, alert.Details) {
+		t.Errorf("expected message and details in text, got %q", payload.Text)
+	}
+}
+
+// Make sure alerts for an open incident get an Acknowledge button when it's enabled
+func TestHandler_slackAckButton(t *testing.T) {
+	var payload struct {
+		Attachments []slackAckAttachment `json:"attachments"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload.Attachments = nil
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	handler := SlackHandler{WebhookURL: server.URL, AckButton: true}
+	if err := handler.Alert(context.Background(), &AlertState{Status: "critical", IncidentID: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Attachments) != 1 || payload.Attachments[0].CallbackID != slackAckCallbackID || payload.Attachments[0].Actions[0].Value != "abc" {
+		t.Errorf("expected an Acknowledge button for incident abc, got %+v", payload.Attachments)
+	}
+
+	if err := handler.Alert(context.Background(), &AlertState{Status: "passing", IncidentID: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Attachments) != 0 {
+		t.Errorf("expected no button on a passing alert, got %+v", payload.Attachments)
+	}
+}
+
+// Webhook errors should be returned so the alert is retried
+func TestHandler_slackWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := SlackHandler{WebhookURL: server.URL}.Alert(context.Background(), &AlertState{})
+	if err == nil || !strings.Contains(err.Error(), "invalid_token") {
+		t.Errorf("expected webhook error, got %v", err)
+	}
+}
+
+// Make sure the Slack API is called with the token and that errors in the response body are
+// returned
+func TestHandler_slackAPI(t *testing.T) {
+	var auth string
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		r.ParseForm()
+		form = r.PostForm
+		if form.Get("channel") == "#missing" {
+			w.Write([]byte(`{"ok": false, "error": "channel_not_found"}`))
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	defaultURL := slackAPIURL
+	slackAPIURL = server.URL + "/"
+	defer func() { slackAPIURL = defaultURL }()
+
+	handler := SlackHandler{Token: "xoxb-1", ChannelName: "#ops", Username: "consul"}
+	alert := &AlertState{Message: "[dc1] service redis is now critical", Details: "Failing checks:"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer xoxb-1" || form.Get("channel") != "#ops" || form.Get("username") != "consul" || !strings.Contains(form.Get("text"), alert.Message) {
+		t.Errorf("unexpected request: %s %v", auth, form)
+	}
+
+	handler.ChannelName = "#missing"
+	if err := handler.Alert(context.Background(), alert); err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("expected channel_not_found error, got %v", err)
+	}
+}
+
+// Make sure PagerDuty events use the alert's incident key, and that retries stop once the
+// handler's context is cancelled
+func TestHandler_pagerduty(t *testing.T) {
+	var events []pagerdutyEvent
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerdutyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		events = append(events, event)
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	defaultURL := pagerdutyEventsURL
+	pagerdutyEventsURL = server.URL
+	defer func() { pagerdutyEventsURL = defaultURL }()
+
+	handler := PagerdutyHandler{ServiceKey: "key", MaxRetries: 3}
+	alert := &AlertState{Service: "redis", Node: "node1", Status: "critical", Message: "[dc1] service redis is now critical"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	alert.Status = "passing"
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].EventType != "trigger" || events[1].EventType != "resolve" ||
+		events[0].IncidentKey != "redis--node1" || events[1].IncidentKey != events[0].IncidentKey {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	events = nil
+	fail = true
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := handler.Alert(ctx, alert); err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("expected error from PagerDuty, got %v", err)
+	}
+	if len(events) != 1 || time.Since(start) > pagerdutyRetryWait {
+		t.Errorf("expected retries to stop with the context, got %d attempts in %s", len(events), time.Since(start))
+	}
+}
+
+func TestHandler_victorOps(t *testing.T) {
+	var path string
+	var body victorOpsAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	config, err := ParseConfig(`
+handler "victorops" "ops" {
+  api_key = "key"
+  routing_key = "ops"
+  routing_keys {
+    redis = "database"
+  }
+  url = "` + server.URL + `/alert/"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := config.Handlers["victorops.ops"]
+
+	alert := &AlertState{Status: "passing", Service: "redis", Message: "[dc1] service redis is now passing"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/alert/key/database" {
+		t.Errorf("expected the service's routing key, got path %s", path)
+	}
+	if body.MessageType != "RECOVERY" || body.EntityID != "redis--" {
+		t.Errorf("unexpected alert body: %+v", body)
+	}
+
+	alert = &AlertState{Status: "warning", Node: "node1"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/alert/key/ops" || body.MessageType != "WARNING" {
+		t.Errorf("expected a warning with the default routing key, got %s %s", path, body.MessageType)
+	}
+}
+
+// A minimal SMTP server that records the envelope and message of the emails sent to it
+func fakeSMTPServer(t *testing.T) (net.Listener, chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 localhost ESMTP")
+		lines := make([]string, 0)
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			lines = append(lines, line)
+
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				text.PrintfLine("250 localhost")
+			case line == "DATA":
+				text.PrintfLine("354 go ahead")
+				data, _ := text.ReadDotLines()
+				lines = append(lines, data...)
+				text.PrintfLine("250 ok")
+			case line == "QUIT":
+				text.PrintfLine("221 bye")
+				received <- lines
+				return
+			default:
+				text.PrintfLine("250 ok")
+			}
+		}
+	}()
+
+	return listener, received
+}
+
+func TestHandler_emailSMTP(t *testing.T) {
+	listener, received := fakeSMTPServer(t)
+	defer listener.Close()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	handler := EmailHandler{
+		Recipients: []string{"ops@example.com"},
+		CC:         []string{"dev@example.com"},
+		From:       "alerts@example.com",
+		Server:     host,
+		Port:       portNum,
+		TLS:        "none",
+	}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Message: "[dc1] service redis is now critical", Details: "Failing checks:"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	session := strings.Join(<-received, "\n")
+	for _, expected := range []string{
+		"MAIL FROM:<alerts@example.com>",
+		"RCPT TO:<ops@example.com>",
+		"RCPT TO:<dev@example.com>",
+		"Cc: dev@example.com",
+		"Subject: [dc1] service redis is now critical",
+	} {
+		if !strings.Contains(session, expected) {
+			t.Errorf("expected %q in SMTP session:\n%s", expected, session)
+		}
+	}
+}
+
+// Sending should fail rather than fall back to plaintext if STARTTLS is required
+func TestHandler_emailRequireStartTLS(t *testing.T) {
+	listener, _ := fakeSMTPServer(t)
+	defer listener.Close()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	handler := EmailHandler{Recipients: []string{"ops@example.com"}, From: defaultEmailFrom, Server: host, Port: portNum, TLS: "starttls"}
+
+	err := handler.Alert(context.Background(), &AlertState{})
+	if err == nil || !strings.Contains(err.Error(), "doesn't support STARTTLS") {
+		t.Errorf("expected STARTTLS error, got %v", err)
+	}
+}
+
+func TestHandler_telegram(t *testing.T) {
+	var path string
+	var body telegramMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body = telegramMessage{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	handler := TelegramHandler{BotToken: "123:abc", ChatID: "-1001", SilentWarnings: true, URL: server.URL}
+	alert := &AlertState{Status: "warning", Message: "[dc1] service redis_cache is now warning", Details: "Failing checks:\n`redis-cli ping` failed"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/bot123:abc/sendMessage" {
+		t.Errorf("unexpected path %s", path)
+	}
+	if body.ChatID != "-1001" || body.ParseMode != "Markdown" || !body.DisableNotification {
+		t.Errorf("unexpected message: %+v", body)
+	}
+	expected := "*\\[dc1] service redis\\_cache is now warning*\n```\nFailing checks:\n'redis-cli ping' failed\n```"
+	if body.Text != expected {
+		t.Errorf("expected text %q, got %q", expected, body.Text)
+	}
+
+	alert.Status = "critical"
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if body.DisableNotification {
+		t.Errorf("expected critical alerts to notify")
+	}
+}
+
+// Make sure long details are cut by characters, and left out if the message leaves no room
+func TestHandler_telegramTruncate(t *testing.T) {
+	alert := &AlertState{Message: "redis is down", Details: strings.Repeat("é", telegramMaxLength)}
+	text := telegramText(alert)
+	if !utf8.ValidString(text) || utf8.RuneCountInString(text) != telegramMaxLength || !strings.HasSuffix(text, "...\n```") {
+		t.Errorf("expected valid text truncated to %d characters, got %d", telegramMaxLength, utf8.RuneCountInString(text))
+	}
+
+	alert.Message = strings.Repeat("a", telegramMaxLength)
+	if text := telegramText(alert); strings.Contains(text, "```") {
+		t.Errorf("expected details to be left out")
+	}
+}
+
+func TestHandler_twilio(t *testing.T) {
+	var sent []string
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		auth = user + ":" + pass
+		r.ParseForm()
+		if r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" || r.PostForm.Get("From") != "+15550000" {
+			t.Errorf("unexpected request to %s: %v", r.URL.Path, r.PostForm)
+		}
+		if r.PostForm.Get("To") == "+15559999" {
+			http.Error(w, `{"message": "invalid number"}`, http.StatusBadRequest)
+			return
+		}
+		sent = append(sent, r.PostForm.Get("To")+" "+r.PostForm.Get("Body"))
+	}))
+	defer server.Close()
+
+	config, err := ParseConfig(`
+handler "twilio" "oncall" {
+  account_sid = "AC123"
+  auth_token = "token"
+  from = "+15550000"
+  to = ["+15551111", "+15559999", "+15552222"]
+  url = "` + server.URL + `"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := config.Handlers["twilio.oncall"]
+
+	// Warnings are filtered out by default
+	if err := handler.Alert(context.Background(), &AlertState{Status: "warning", Message: "redis is warning"}); err != nil || len(sent) > 0 {
+		t.Fatalf("expected warning to be skipped, got %v %v", sent, err)
+	}
+
+	alert := &AlertState{Status: "critical", Message: "redis is critical"}
+	err = callHandler(config, "twilio.oncall", alert)
+	if err == nil || !strings.Contains(err.Error(), "+15559999") || !strings.Contains(err.Error(), "invalid number") {
+		t.Errorf("expected error for the bad number, got %v", err)
+	}
+	if auth != "AC123:token" {
+		t.Errorf("unexpected credentials %q", auth)
+	}
+	if len(sent) != 2 || sent[0] != "+15551111 redis is critical" || sent[1] != "+15552222 redis is critical" {
+		t.Errorf("expected texts to the other numbers, got %v", sent)
+	}
+
+	// Retrying should only text the number that failed
+	sent = nil
+	if err := callHandler(config, "twilio.oncall", alert); err == nil || len(sent) != 0 {
+		t.Errorf("expected only the failed number to be retried, got %v %v", sent, err)
+	}
+}
+
+func TestHandler_rocketChat(t *testing.T) {
+	var body rocketChatMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	handler := RocketChatHandler{WebhookURL: server.URL, Channel: "#ops", Alias: "consul", Emoji: ":fire:"}
+	alert := &AlertState{Message: "[dc1] service redis is now critical", Details: "Failing checks:"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Channel != "#ops" || body.Alias != "consul" || body.Emoji != ":fire:" || body.Avatar != "" {
+		t.Errorf("unexpected message: %+v", body)
+	}
+	if !strings.Contains(body.Text, "*"+alert.Message+"*") || !strings.Contains(body.Text, alert.Details) {
+		t.Errorf("expected message and details in text, got %q", body.Text)
+	}
+}
+
+func TestHandler_zulip(t *testing.T) {
+	var form url.Values
+	var user string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ = r.BasicAuth()
+		r.ParseForm()
+		form = r.PostForm
+		if r.URL.Path != "/api/v1/messages" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	handler := ZulipHandler{URL: server.URL, Email: "alerts-bot@example.com", APIKey: "key", Stream: "ops"}
+	alert := &AlertState{Status: "critical", Service: "redis", Tag: "alpha", Message: "redis is critical", Details: "Failing checks:"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if user != "alerts-bot@example.com" || form.Get("to") != "ops" || form.Get("topic") != "redis" {
+		t.Errorf("unexpected message from %s: %v", user, form)
+	}
+	if form.Get("content") != "**redis is critical**\n```\nFailing checks:\n```" {
+		t.Errorf("unexpected content %q", form.Get("content"))
+	}
+
+	handler.Topic = "{{.Datacenter}} {{.Service}}/{{.Tag}}"
+	alert.Datacenter = "dc1"
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("topic") != "dc1 redis/alpha" {
+		t.Errorf("expected templated topic, got %q", form.Get("topic"))
+	}
+}
+
+func TestHandler_alerta(t *testing.T) {
+	var body alertaAlert
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if r.URL.Path != "/api/alert" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	handler := AlertaHandler{URL: server.URL + "/api/", APIKey: "key", Environment: "{{.Datacenter}}", Tags: []string{"team:data"}}
+	alert := &AlertState{Status: "critical", Service: "redis", Tag: "alpha", Node: "node1", Datacenter: "dc1", Message: "redis is critical", Details: "Failing checks:"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Key key" {
+		t.Errorf("unexpected authorization %q", auth)
+	}
+	if body.Resource != "redis:alpha" || body.Event != "ConsulHealthCheck" || body.Environment != "dc1" || body.Severity != "critical" {
+		t.Errorf("unexpected alert %+v", body)
+	}
+	if len(body.Tags) != 3 || body.Tags[2] != "team:data" || body.Attributes["node"] != "node1" || body.RawData != "Failing checks:" {
+		t.Errorf("unexpected tags/attributes %v %v", body.Tags, body.Attributes)
+	}
+
+	// Recoveries clear the same alert
+	alert.Status = "passing"
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if body.Resource != "redis:alpha" || body.Event != "ConsulHealthCheck" || body.Severity != "normal" {
+		t.Errorf("expected the alert to be cleared, got %+v", body)
+	}
+}
+
+func TestHandler_bigPanda(t *testing.T) {
+	var body bigPandaAlert
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	handler := BigPandaHandler{Token: "token", AppKey: "app", URL: server.URL}
+	alert := &AlertState{Status: "warning", Service: "redis", Tag: "alpha", Datacenter: "dc1", Message: "redis is warning"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer token" || body.AppKey != "app" {
+		t.Errorf("unexpected credentials %q %q", auth, body.AppKey)
+	}
+	if body.Status != "warning" || body.Host != "redis:alpha" || body.Check != "consul-health" || body.Cluster != "dc1" {
+		t.Errorf("unexpected alert %+v", body)
+	}
+
+	// Recoveries resolve the same alert
+	alert.Status = "passing"
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "ok" || body.Host != "redis:alpha" || body.Check != "consul-health" {
+		t.Errorf("expected an ok status for the same alert, got %+v", body)
+	}
+}
+
+func TestHandler_squadcast(t *testing.T) {
+	var path string
+	var body squadcastEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body = squadcastEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	handler := SquadcastHandler{Token: "token", URL: server.URL + "/incidents/"}
+	alert := &AlertState{Status: "critical", Service: "redis", Datacenter: "dc1", Message: "redis is critical"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/incidents/token" || body.Status != "trigger" || body.Priority != "P1" || body.Tags["service"] != "redis" {
+		t.Errorf("unexpected event to %s: %+v", path, body)
+	}
+	eventID := body.EventID
+
+	alert.Status = "passing"
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "resolve" || body.EventID != eventID || body.Priority != "" {
+		t.Errorf("expected the incident to be resolved, got %+v", body)
+	}
+}
+
+func TestHandler_eventGrid(t *testing.T) {
+	var key, contentType string
+	var events []cloudEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("aeg-sas-key")
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&events)
+	}))
+	defer server.Close()
+
+	handler := EventGridHandler{Endpoint: server.URL + "/api/events", Key: "secret", Source: "consul-alerting", Type: "consul-alerting.health"}
+	alert := &AlertState{Status: "critical", Service: "redis", Tag: "alpha", Datacenter: "dc1", EventID: "abc123", Message: "redis is down"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "secret" || !strings.HasPrefix(contentType, "application/cloudevents-batch+json") {
+		t.Errorf("unexpected request headers: %q %q", key, contentType)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %v", events)
+	}
+	event := events[0]
+	if event.SpecVersion != "1.0" || event.ID != "abc123" || event.Type != "consul-alerting.health" || event.Subject != "dc1/services/redis/tags/alpha" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if event.Data == nil || event.Data.Message != alert.Message {
+		t.Errorf("unexpected event data: %+v", event.Data)
+	}
+}
+
+func TestHandler_elasticsearch(t *testing.T) {
+	var path, auth string
+	var document map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&document)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	handler := ElasticsearchHandler{URL: server.URL, Index: "consul-alerts", DateFormat: "2006.01.02", APIKey: "key123"}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	alert := &AlertState{Status: "critical", Service: "redis", EventID: "abc123", Message: "redis is down"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "/consul-alerts-" + time.Now().UTC().Format("2006.01.02") + "/_doc/abc123"
+	if path != expected {
+		t.Errorf("expected the alert to be indexed at %s, got %s", expected, path)
+	}
+	if auth != "ApiKey key123" {
+		t.Errorf("unexpected authorization header: %q", auth)
+	}
+	if document["message"] != alert.Message || document["@timestamp"] == nil {
+		t.Errorf("unexpected document: %v", document)
+	}
+}
+
+func TestHandler_zenduty(t *testing.T) {
+	var path string
+	var body zendutyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body = zendutyEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	handler := ZendutyHandler{IntegrationKey: "key123", URL: server.URL + "/api/events"}
+	alert := &AlertState{Status: "critical", Service: "redis", Datacenter: "dc1", Message: "redis is critical", Link: "http://consul/ui"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/api/events/key123/" || body.AlertType != "critical" || body.Payload["service"] != "redis" || len(body.URLs) != 1 {
+		t.Errorf("unexpected event to %s: %+v", path, body)
+	}
+	entityID := body.EntityID
+
+	alert.Status = "passing"
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if body.AlertType != "resolved" || body.EntityID != entityID {
+		t.Errorf("expected the incident to be resolved, got %+v", body)
+	}
+}
+
+func TestHandler_webex(t *testing.T) {
+	var auth string
+	var body webexMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	handler := WebexHandler{BotToken: "token", RoomID: "room1", URL: server.URL}
+	alert := &AlertState{Status: "critical", Service: "redis", Message: "redis is critical", Details: "ping: timeout"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer token" || body.RoomID != "room1" || body.Text != alert.Message {
+		t.Errorf("unexpected message: %s %+v", auth, body)
+	}
+	if expected := "\U0001f534 **redis is critical**\n```\nping: timeout\n```"; body.Markdown != expected {
+		t.Errorf("expected markdown %q, got %q", expected, body.Markdown)
+	}
+
+	// Long details are cut to fit, without splitting a rune
+	alert.Details = strings.Repeat("é", webexMaxLength)
+	if markdown := webexMarkdown(alert); len(markdown) > webexMaxLength || !utf8.ValidString(markdown) {
+		t.Errorf("expected valid markdown of at most %d bytes, got %d", webexMaxLength, len(markdown))
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// How long to wait for the new process to finish starting up before giving up on a handoff
+const handoffTimeout = 60 * time.Second
+
+// Set in the environment of the new process so it knows to read the handoff state from
+// its extra file descriptors (3 for the state, 4 for the acknowledgement)
+const handoffEnv = "CONSUL_ALERTING_HANDOFF"
+
+// Held for writing while a handoff is in progress, so watches and alert timers stop making
+// changes that the new process wouldn't know about
+var handoffLock sync.RWMutex
+
+// The state handed off to us by a previous process, if we were started by one
+var inheritedState *HandoffState
+
+// The in-memory state passed from the old process to the new one during a handoff
+type HandoffState struct {
+	sync.Mutex
+	Watches map[string]*HandoffWatch `json:"watches"`
+
+	// Notifications waiting to be retried
+	RetryQueue []*QueuedNotification `json:"retry_queue"`
+
+	// Used to tell the old process we've taken over
+	ack *os.File
+}
+
+// The state of a single watch being handed off
+type HandoffWatch struct {
+	// The session holding the watch's lock
+	Session string            `json:"session"`
+	Checks  map[string]string `json:"checks"`
+	Status  string            `json:"status"`
+	Pending []*PendingAlert   `json:"pending"`
+
+	sessionDone chan struct{}
+}
+
+// Starts the new binary and passes it the state of our watches, including the sessions holding
+// their locks, so it can take over without a gap in monitoring. Returns nil once the new process
+// has taken over, at which point we should exit without releasing anything.
+func handoff() error {
+	handoffLock.Lock()
+	defer handoffLock.Unlock()
+
+	// Alerts still waiting to be dispatched, sent in a digest or held for quiet hours are
+	// handed off as part of the retry queue
+	dispatcher.drain()
+	digests.drain()
+	quietHolds.drain()
+
+	state := &HandoffState{
+		Watches:    make(map[string]*HandoffWatch),
+		RetryQueue: retryQueue.list(),
+	}
+	for _, watch := range runningWatches.list() {
+		if !watch.leader() {
+			continue
+		}
+
+		session, err := watch.lock.heldSession()
+		if err != nil || session == "" {
+			log.Warnf("Couldn't find session for %s, it will need to reacquire its lock: %v", watch.Name, err)
+			continue
+		}
+
+		watch.Lock()
+		handoffWatch := &HandoffWatch{
+			Session: session,
+			Checks:  watch.Checks,
+			Status:  watch.Status,
+		}
+		now := time.Now()
+		for _, pending := range watch.Pending {
+			handoffWatch.Pending = append(handoffWatch.Pending, pending.handoffCopy(now))
+		}
+		watch.Unlock()
+
+		state.Watches[watch.Name] = handoffWatch
+	}
+
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("Error serializing handoff state: %s", err)
+	}
+
+	stateReader, stateWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	ackReader, ackWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ackReader.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), handoffEnv+"=1")
+	cmd.ExtraFiles = []*os.File{stateReader, ackWriter}
+
+	log.Infof("Handing off %d watches to new process %s", len(state.Watches), os.Args[0])
+	err = cmd.Start()
+	stateReader.Close()
+	ackWriter.Close()
+	if err != nil {
+		stateWriter.Close()
+		return fmt.Errorf("Error starting new process: %s", err)
+	}
+
+	go func() {
+		stateWriter.Write(serialized)
+		stateWriter.Close()
+	}()
+
+	// Wait for the new process to tell us it's taken over. If it dies, the pipe will be closed.
+	ackCh := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(ackReader).ReadString('\n')
+		ackCh <- strings.TrimSpace(line)
+	}()
+
+	select {
+	case ack := <-ackCh:
+		if ack != "ok" {
+			cmd.Process.Kill()
+			return fmt.Errorf("New process exited before taking over")
+		}
+	case <-time.After(handoffTimeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("Timed out waiting for new process to take over")
+	}
+
+	log.Infof("New process (pid %d) has taken over", cmd.Process.Pid)
+	return nil
+}
+
+// Reads the handoff state from the previous process if we were started by one
+func receiveHandoff() (*HandoffState, error) {
+	if os.Getenv(handoffEnv) == "" {
+		return nil, nil
+	}
+	os.Unsetenv(handoffEnv)
+
+	stateFile := os.NewFile(3, "handoff-state")
+	defer stateFile.Close()
+
+	state := &HandoffState{}
+	if err := json.NewDecoder(stateFile).Decode(state); err != nil {
+		return nil, fmt.Errorf("Error reading handoff state: %s", err)
+	}
+	state.ack = os.NewFile(4, "handoff-ack")
+
+	return state, nil
+}
+
+// Starts renewing the sessions we were handed so they stay valid until our watches start up
+func (h *HandoffState) renewSessions(client *api.Client) {
+	if h == nil {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	for name, watch := range h.Watches {
+		log.Debugf("Renewing handed off session %s for %s", watch.Session, name)
+		watch.sessionDone = make(chan struct{})
+		go client.Session().RenewPeriodic(api.DefaultLockSessionTTL, watch.Session, nil, watch.sessionDone)
+	}
+}
+
+// Returns and removes the handed off state for the named watch, if there is any
+func (h *HandoffState) take(name string) *HandoffWatch {
+	if h == nil {
+		return nil
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	watch, ok := h.Watches[name]
+	if !ok {
+		return nil
+	}
+	delete(h.Watches, name)
+
+	if watch.Checks == nil {
+		watch.Checks = make(map[string]string)
+	}
+
+	return watch
+}
+
+// Tells the previous process we've taken over so it can exit
+func (h *HandoffState) complete() {
+	if h == nil || h.ack == nil {
+		return
+	}
+
+	// Let systemd know we're the main process now
+	sdNotify(fmt.Sprintf("MAINPID=%d", os.Getpid()))
+
+	fmt.Fprintln(h.ack, "ok")
+	h.ack.Close()
+	h.ack = nil
+
+	// Release any sessions for watches we didn't end up starting, so other instances can
+	// pick them up
+	h.Lock()
+	for name, watch := range h.Watches {
+		log.Infof("No watch started for %s, releasing its handed off lock", name)
+		close(watch.sessionDone)
+		delete(h.Watches, name)
+	}
+	h.Unlock()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Make sure the handoff state survives serialization and each watch can only be taken once
+func TestHandoff_take(t *testing.T) {
+	expected := &HandoffWatch{
+		Session: "abcd-1234",
+		Checks:  map[string]string{"node1/service:redis": "critical"},
+		Status:  "critical",
+		Pending: []*PendingAlert{
+			{
+				Path:        watchKVPath("", "redis", "") + "alert",
+				Alert:       AlertState{Status: "critical", Service: "redis"},
+				UpdateIndex: 3,
+				FireAt:      time.Unix(1000, 0).UTC(),
+			},
+		},
+	}
+
+	serialized, err := json.Marshal(&HandoffState{Watches: map[string]*HandoffWatch{"service redis": expected}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := &HandoffState{}
+	if err := json.Unmarshal(serialized, state); err != nil {
+		t.Fatal(err)
+	}
+
+	watch := state.take("service redis")
+	if !reflect.DeepEqual(watch, expected) {
+		t.Errorf("expected \n%#v\n\n, got \n\n%#v\n\n", expected, watch)
+	}
+
+	if watch := state.take("service redis"); watch != nil {
+		t.Errorf("expected watch to only be taken once, got %#v", watch)
+	}
+
+	// A process that wasn't handed anything shouldn't find any watches
+	var inherited *HandoffState
+	if watch := inherited.take("service redis"); watch != nil {
+		t.Errorf("expected no watch, got %#v", watch)
+	}
+}
+
+// Make sure pending alerts are handed off with the time left on their timers
+func TestHandoff_pendingRemaining(t *testing.T) {
+	now := time.Now()
+	pending := &PendingAlert{UpdateIndex: 3, FireAt: now.Add(45 * time.Second)}
+
+	handoff := pending.handoffCopy(now)
+	if handoff.Remaining != 45*time.Second {
+		t.Errorf("expected 45s remaining, got %s", handoff.Remaining)
+	}
+	if pending.Remaining != 0 {
+		t.Error("expected the original pending alert to be left alone")
+	}
+
+	// Timers that should already have fired are handed off to fire right away
+	if overdue := pending.handoffCopy(now.Add(time.Minute)); overdue.Remaining != 0 {
+		t.Errorf("expected no time remaining, got %s", overdue.Remaining)
+	}
+}
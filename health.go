@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// HandlerHealthRegistry holds the result of the last periodic probe of each handler, so
+// alerts can go to a handler's fallback while it's down instead of waiting on it to fail
+type HandlerHealthRegistry struct {
+	sync.Mutex
+	// The error from the last probe of each handler that failed it
+	failures map[string]string
+}
+
+var handlerHealth = &HandlerHealthRegistry{failures: make(map[string]string)}
+
+// Records the result of probing a handler, logging when it goes down or comes back
+func (r *HandlerHealthRegistry) record(name string, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	_, wasFailing := r.failures[name]
+	if err == nil {
+		if wasFailing {
+			log.Infof("Handler %s passed its health probe again", name)
+			delete(r.failures, name)
+		}
+		return
+	}
+	if !wasFailing {
+		log.Warnf("Handler %s failed its health probe: %s", name, err)
+	}
+	r.failures[name] = err.Error()
+}
+
+// Returns false if the handler failed its last probe
+func (r *HandlerHealthRegistry) healthy(name string) bool {
+	r.Lock()
+	defer r.Unlock()
+	_, failing := r.failures[name]
+	return !failing
+}
+
+// Returns the last probe error of each handler that's failing, keyed by handler name
+func (r *HandlerHealthRegistry) unhealthy() map[string]string {
+	r.Lock()
+	defer r.Unlock()
+
+	unhealthy := make(map[string]string, len(r.failures))
+	for name, err := range r.failures {
+		unhealthy[name] = err
+	}
+	return unhealthy
+}
+
+// Probes every handler that supports it each handler_probe_interval, recording which ones
+// are down
+func runHandlerProbes(config *Config) {
+	interval := time.Duration(config.HandlerProbeInterval) * time.Second
+	for range time.Tick(interval) {
+		for _, name := range sortedHandlerNames(config) {
+			if prober, ok := unwrapHandler(config.Handlers[name]).(HandlerProber); ok {
+				handlerHealth.record(name, probeHandler(config, name, prober))
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Make sure alerts go to the fallback handler while a handler is failing its health probe
+func TestHealth_fallback(t *testing.T) {
+	primaryCh, fallbackCh := make(chan *AlertState, 1), make(chan *AlertState, 1)
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"test.primary":  testHandler{primaryCh},
+			"test.fallback": testHandler{fallbackCh},
+		},
+		HandlerOptions: map[string]HandlerOptions{
+			"test.primary": HandlerOptions{Fallback: "test.fallback"},
+		},
+	}
+	defer func() { handlerHealth = &HandlerHealthRegistry{failures: make(map[string]string)} }()
+
+	handlerHealth.record("test.primary", errors.New("connection refused"))
+	if unhealthy := handlerHealth.unhealthy(); unhealthy["test.primary"] != "connection refused" {
+		t.Errorf("expected test.primary to be reported as unhealthy, got %v", unhealthy)
+	}
+	if err := invokeHandler(config, "test.primary", &AlertState{Message: "redis is critical"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case alert := <-fallbackCh:
+		if alert.Message != "redis is critical" {
+			t.Errorf("unexpected alert sent to fallback: %q", alert.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected alert to be sent to the fallback handler")
+	}
+
+	// Once the probe passes again, alerts go back to the primary
+	handlerHealth.record("test.primary", nil)
+	if err := invokeHandler(config, "test.primary", &AlertState{Message: "redis is passing"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-primaryCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected alert to be sent to the primary handler")
+	}
+}
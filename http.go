@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// The response body for the health endpoint
+type HealthResponse struct {
+	Status      string   `json:"status"`
+	Stalled     []string `json:"stalled,omitempty"`
+	ConsulError string   `json:"consul_error,omitempty"`
+}
+
+const healthyStatus = "healthy"
+const unhealthyStatus = "unhealthy"
+
+// Consul is unreachable but we're still running and will pick back up when it recovers
+const degradedStatus = "degraded"
+
+// Starts the HTTP server for the daemon's own endpoints on the configured address. Returns
+// an error if we couldn't listen on the address, otherwise serves in the background.
+func serveHTTP(config *Config, client *api.Client) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", healthEndpoint)
+	mux.HandleFunc("/v1/status", statusEndpoint)
+	mux.HandleFunc("/v1/metrics", metricsEndpoint)
+
+	// The callbacks for acknowledging incidents are only served when they can be verified
+	if config.AckSlackSigningSecret != "" {
+		mux.HandleFunc("/v1/ack/slack", slackAckEndpoint(config, client))
+	}
+	if config.AckPagerDutySecret != "" {
+		mux.HandleFunc("/v1/ack/pagerduty", pagerDutyAckEndpoint(config, client))
+	}
+
+	listener, err := net.Listen("tcp", config.HTTPAddress)
+	if err != nil {
+		return fmt.Errorf("Error listening on http_address: %s", err)
+	}
+
+	log.Infof("Serving HTTP endpoints on %s", config.HTTPAddress)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Error("Error serving HTTP endpoints: ", err)
+		}
+	}()
+
+	return nil
+}
+
+// Reports whether all of the discovery loops and watches are still making progress
+func healthEndpoint(w http.ResponseWriter, r *http.Request) {
+	health := HealthResponse{Status: healthyStatus}
+	if degraded, err := consulHealth.isDegraded(); degraded {
+		health.Status = degradedStatus
+		health.ConsulError = err.Error()
+	}
+	if stalled := heartbeats.stalled(); len(stalled) > 0 {
+		health.Status = unhealthyStatus
+		health.Stalled = stalled
+	}
+
+	writeJSON(w, health, health.Status != unhealthyStatus)
+}
+
+// Writes the given value as the JSON response, with a 503 status code if ok is false
+func writeJSON(w http.ResponseWriter, v interface{}, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Error writing HTTP response: ", err)
+	}
+}
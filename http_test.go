@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTP_health(t *testing.T) {
+	heartbeats.beat("service redis")
+	defer heartbeats.remove("service redis")
+
+	server := httptest.NewServer(http.HandlerFunc(healthEndpoint))
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "http://")
+	if code := healthcheckCommand([]string{"-address", address}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	// Make the watch look wedged
+	heartbeats.Lock()
+	heartbeats.last["service redis"] = time.Now().Add(-2 * heartbeatTimeout)
+	heartbeats.Unlock()
+
+	if code := healthcheckCommand([]string{"-address", address}); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+}
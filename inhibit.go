@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// How often an alert held back by an inhibit rule is checked again
+const inhibitRecheckInterval = 30 * time.Second
+
+// The alert fields an inhibit rule can require the source and target alerts to share
+var inhibitEqualFields = []string{"service", "tag", "node", "datacenter"}
+
+// InhibitRule holds back the alerts matching its target while an alert matching its source
+// is firing, e.g. backend alerts while the load balancer in front of them is down, since the
+// source's alert covers them
+type InhibitRule struct {
+	Name string
+
+	Source AlertMatcher `mapstructure:"source"`
+	Target AlertMatcher `mapstructure:"target"`
+
+	// Fields the source and target alerts must have in common, e.g. ["datacenter"]. Two
+	// alerts have the same node if they're failing on any of the same nodes.
+	Equal []string `mapstructure:"equal"`
+}
+
+// Parse the raw inhibit rule objects into the config
+func parseInhibitRules(list *ast.ObjectList, config *Config) error {
+	for _, r := range list.Items {
+		if len(r.Keys) != 1 {
+			return fmt.Errorf("inhibit_rule block must have a name")
+		}
+		name := r.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var rule InhibitRule
+		if err := hcl.DecodeObject(&m, r.Val); err != nil {
+			return err
+		}
+
+		// The source and target blocks decode as lists of objects, so unwrap them
+		for _, key := range []string{"source", "target"} {
+			blocks, ok := m[key].([]map[string]interface{})
+			if !ok || len(blocks) != 1 {
+				return fmt.Errorf("Inhibit rule %s must have one %s block", name, key)
+			}
+			m[key] = blocks[0]
+		}
+
+		if err := mapstructure.WeakDecode(m, &rule); err != nil {
+			return fmt.Errorf("Invalid inhibit rule %s: %s", name, err)
+		}
+		rule.Name = name
+
+		if err := rule.Source.validate(); err != nil {
+			return fmt.Errorf("Invalid source for inhibit rule %s: %s", name, err)
+		}
+		if err := rule.Target.validate(); err != nil {
+			return fmt.Errorf("Invalid target for inhibit rule %s: %s", name, err)
+		}
+		for _, field := range rule.Equal {
+			if !contains(inhibitEqualFields, field) {
+				return fmt.Errorf("Invalid equal field %q for inhibit rule %s, must be one of %s", field, name, strings.Join(inhibitEqualFields, ", "))
+			}
+		}
+
+		config.InhibitRules = append(config.InhibitRules, rule)
+	}
+
+	return nil
+}
+
+// Returns the nodes an alert is about: its node, or the nodes of its failing checks
+func alertNodes(alert *AlertState) []string {
+	if alert.Node != "" {
+		return []string{alert.Node}
+	}
+	nodes := make([]string, 0)
+	for _, check := range alert.Checks {
+		if !contains(nodes, check.Node) {
+			nodes = append(nodes, check.Node)
+		}
+	}
+	return nodes
+}
+
+// Returns true if the source and target alerts have the same values for the rule's equal
+// fields
+func (r *InhibitRule) equal(source, target *AlertState) bool {
+	for _, field := range r.Equal {
+		switch field {
+		case "service":
+			if source.Service != target.Service {
+				return false
+			}
+		case "tag":
+			if source.Tag != target.Tag {
+				return false
+			}
+		case "datacenter":
+			if source.Datacenter != target.Datacenter {
+				return false
+			}
+		case "node":
+			shared := false
+			for _, node := range alertNodes(source) {
+				shared = shared || contains(alertNodes(target), node)
+			}
+			if !shared {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the rule and firing alert that inhibit the given alert, out of the firing alerts,
+// or nil if none do. An alert can't be inhibited by its own watch's alerts, including its
+// capacity alert.
+func (c *Config) inhibitedBy(alert *AlertState, firing []*AlertState) (*InhibitRule, *AlertState) {
+	for i := range c.InhibitRules {
+		rule := &c.InhibitRules[i]
+		if !rule.Target.matches(alert.Service, alert) {
+			continue
+		}
+		for _, source := range firing {
+			if source.Service == alert.Service && source.Tag == alert.Tag && source.Node == alert.Node {
+				continue
+			}
+			if rule.Source.matches(source.Service, source) && rule.equal(source, alert) {
+				return rule, source
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Returns the stored alerts that are firing: their last alert sent was a failing one. Each
+// is returned as it was last sent, with the status, severity, priority and category of that
+// alert. Only the keys are listed, so the rest of the state under the root isn't fetched.
+func firingAlerts(client *api.Client) ([]*AlertState, error) {
+	keys, _, err := client.KV().Keys(alertingKVRoot+"/", "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing alert state: %s", err)
+	}
+
+	firing := make([]*AlertState, 0)
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/alert") && !strings.HasSuffix(key, "/capacity") {
+			continue
+		}
+		pair, _, err := client.KV().Get(key, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading alert state at %s: %s", key, err)
+		}
+		var alert AlertState
+		if pair == nil || len(pair.Value) == 0 || json.Unmarshal(pair.Value, &alert) != nil {
+			continue
+		}
+		if alert.LastAlerted == "" || alert.LastAlerted == api.HealthPassing {
+			continue
+		}
+		alert.Status, alert.Severity, alert.Priority = alert.LastAlerted, alert.LastSeverity, alert.LastPriority
+		alert.Category = alert.LastCategory
+		firing = append(firing, &alert)
+	}
+	return firing, nil
+}
+
+// Returns a description of what's inhibiting a failing alert, e.g. `inhibit rule "lb-down"
+// (service haproxy is critical)`, or "" if it isn't inhibited or there are no inhibit rules
+func inhibitingAlert(config *Config, client *api.Client, alert *AlertState) string {
+	if len(config.InhibitRules) == 0 || alert.Status == api.HealthPassing {
+		return ""
+	}
+
+	firing, err := firingAlerts(client)
+	if err != nil {
+		log.Warnf("Error checking whether alert '%s' is inhibited: %s", alert.Message, err)
+		return ""
+	}
+	rule, source := config.inhibitedBy(alert, firing)
+	if rule == nil {
+		return ""
+	}
+	return fmt.Sprintf("inhibit rule %q (%s is %s)", rule.Name, watchName(source.Node, source.Service, source.Tag), source.Status)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestInhibit_inhibitedBy(t *testing.T) {
+	config, err := ParseConfig(`
+	inhibit_rule "lb-down" {
+		source {
+			service = "haproxy"
+			status = ["critical"]
+		}
+		target {
+			service = "backend-*"
+		}
+		equal = ["datacenter"]
+	}
+	inhibit_rule "node-down" {
+		source {
+			node = "*"
+		}
+		target {
+			service = "*"
+		}
+		equal = ["node"]
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	haproxy := &AlertState{Service: "haproxy", Datacenter: "dc1", Status: "critical", Checks: []AlertCheck{{Node: "lb-1"}}}
+	node := &AlertState{Node: "web-1", Datacenter: "dc1", Status: "critical"}
+
+	cases := []struct {
+		alert  *AlertState
+		firing []*AlertState
+		rule   string
+	}{
+		{&AlertState{Service: "backend-api", Datacenter: "dc1", Status: "critical", Checks: []AlertCheck{{Node: "web-2"}}}, []*AlertState{haproxy}, "lb-down"},
+		{&AlertState{Service: "backend-api", Datacenter: "dc2", Status: "critical", Checks: []AlertCheck{{Node: "web-2"}}}, []*AlertState{haproxy}, ""},
+		{&AlertState{Service: "backend-api", Datacenter: "dc1", Status: "warning", Checks: []AlertCheck{{Node: "web-2"}}}, []*AlertState{{Service: "haproxy", Datacenter: "dc1", Status: "warning"}}, ""},
+		{&AlertState{Service: "redis", Datacenter: "dc1", Status: "critical", Checks: []AlertCheck{{Node: "web-1"}}}, []*AlertState{node}, "node-down"},
+		{&AlertState{Service: "redis", Datacenter: "dc1", Status: "critical", Checks: []AlertCheck{{Node: "web-3"}}}, []*AlertState{node}, ""},
+		{haproxy, []*AlertState{haproxy}, ""},
+		{haproxy, []*AlertState{{Service: "haproxy", Datacenter: "dc1", Status: "critical", Capacity: true, Checks: []AlertCheck{{Node: "lb-1"}}}}, ""},
+	}
+	for i, c := range cases {
+		rule, _ := config.inhibitedBy(c.alert, c.firing)
+		name := ""
+		if rule != nil {
+			name = rule.Name
+		}
+		if name != c.rule {
+			t.Errorf("case %d: expected to be inhibited by %q, got %q", i, c.rule, name)
+		}
+	}
+}
+
+func TestInhibit_parseErrors(t *testing.T) {
+	for _, raw := range []string{
+		`inhibit_rule "a" { target { service = "api" } }`,
+		`inhibit_rule "a" {
+			source { service = "lb" }
+			target { status = ["broken"] }
+		}`,
+		`inhibit_rule "a" {
+			source { service = "lb" }
+			target { service = "api" }
+			equal = ["check"]
+		}`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("expected an error for %q", raw)
+		}
+	}
+}
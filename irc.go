@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const ircDefaultNick = "consul-alerting"
+
+// The most lines of details to announce for an alert, to avoid flooding the channel
+const ircMaxDetailLines = 4
+
+// Long enough for a message to fit in IRC's 512 byte line limit once it's prefixed with
+// the command, channel and our hostmask
+const ircMaxLineLength = 400
+
+// How long to wait for anything from the server before assuming the connection is dead.
+// Servers ping idle clients well within this.
+const ircReadTimeout = 10 * time.Minute
+
+// IRCHandler announces alerts in an IRC channel. It keeps a connection to the server open
+// between alerts, reconnecting (and rejoining) if it drops.
+type IRCHandler struct {
+	Server           string `mapstructure:"server"`
+	TLS              bool   `mapstructure:"tls"`
+	TLSSkipVerify    bool   `mapstructure:"tls_skip_verify"`
+	Password         string `mapstructure:"password"`
+	Nick             string `mapstructure:"nick"`
+	Channel          string `mapstructure:"channel"`
+	ChannelKey       string `mapstructure:"channel_key"`
+	NickServPassword string `mapstructure:"nickserv_password"`
+}
+
+// The IRC connections for each handler config, started when its first alert is sent
+var ircClients = struct {
+	sync.Mutex
+	clients map[IRCHandler]*ircClient
+}{clients: make(map[IRCHandler]*ircClient)}
+
+func (i IRCHandler) Alert(ctx context.Context, alert *AlertState) error {
+	ircClients.Lock()
+	client, ok := ircClients.clients[i]
+	if !ok {
+		client = newIRCClient(i)
+		ircClients.clients[i] = client
+		go client.run()
+	}
+	ircClients.Unlock()
+
+	if err := client.say(ctx, ircAlertLines(alert)); err != nil {
+		return fmt.Errorf("Error sending alert to IRC (%s %s): %s", i.Server, i.Channel, err)
+	}
+	return nil
+}
+
+func (i IRCHandler) Validate() error {
+	if i.Server == "" {
+		return errors.New("no server given")
+	}
+	if _, _, err := net.SplitHostPort(i.Server); err != nil {
+		return fmt.Errorf("invalid server %q, must be host:port", i.Server)
+	}
+	if !strings.HasPrefix(i.Channel, "#") && !strings.HasPrefix(i.Channel, "&") {
+		return fmt.Errorf("invalid channel %q, must start with # or &", i.Channel)
+	}
+	if strings.ContainsAny(i.Nick, " :") {
+		return fmt.Errorf("invalid nick %q", i.Nick)
+	}
+	return nil
+}
+
+// Returns the lines to announce for an alert: the message, and the start of the details
+func ircAlertLines(alert *AlertState) []string {
+	lines := []string{alert.Message}
+	if alert.Details == "" {
+		return lines
+	}
+
+	details := strings.Split(strings.TrimSpace(alert.Details), "\n")
+	if len(details) > ircMaxDetailLines {
+		remaining := len(details) - ircMaxDetailLines + 1
+		details = append(details[:ircMaxDetailLines-1], fmt.Sprintf("... (%d more lines)", remaining))
+	}
+	return append(lines, details...)
+}
+
+// A connection to an IRC server for a handler
+type ircClient struct {
+	handler IRCHandler
+
+	sync.Mutex
+	// The current connection, once it's registered and joined the channel
+	conn net.Conn
+	// Closed when the client next joins the channel
+	joined chan struct{}
+}
+
+func newIRCClient(handler IRCHandler) *ircClient {
+	if handler.Nick == "" {
+		handler.Nick = ircDefaultNick
+	}
+	return &ircClient{handler: handler, joined: make(chan struct{})}
+}
+
+// Keeps the client connected, reconnecting with a backoff when the connection drops
+func (c *ircClient) run() {
+	backoff := newBackoff()
+	for {
+		err := c.session(backoff)
+		wait := backoff.next()
+		log.Warnf("Disconnected from IRC server %s: %s, reconnecting in %s", c.handler.Server, err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// Connects to the server, joins the channel and answers pings until the connection fails
+func (c *ircClient) session(backoff *Backoff) error {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if c.handler.TLS {
+		host, _, _ := net.SplitHostPort(c.handler.Server)
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.handler.Server, &tls.Config{ServerName: host, InsecureSkipVerify: c.handler.TLSSkipVerify})
+	} else {
+		conn, err = dialer.Dial("tcp", c.handler.Server)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.Lock()
+		c.conn = nil
+		c.Unlock()
+		conn.Close()
+	}()
+
+	nick := c.handler.Nick
+	if c.handler.Password != "" {
+		c.write(conn, "PASS %s", c.handler.Password)
+	}
+	c.write(conn, "NICK %s", nick)
+	c.write(conn, "USER %s 0 * :consul-alerting", nick)
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(ircReadTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		prefix, command, params := parseIRCLine(line)
+
+		switch command {
+		case "PING":
+			c.write(conn, "PONG :%s", strings.Join(params, " "))
+		case "001":
+			// Registered; identify before joining, since some channels require it
+			if c.handler.NickServPassword != "" {
+				c.write(conn, "PRIVMSG NickServ :IDENTIFY %s", c.handler.NickServPassword)
+			}
+			if c.handler.ChannelKey != "" {
+				c.write(conn, "JOIN %s %s", c.handler.Channel, c.handler.ChannelKey)
+			} else {
+				c.write(conn, "JOIN %s", c.handler.Channel)
+			}
+		case "433":
+			// Our nick is in use, possibly by our own last connection that hasn't timed out
+			nick = nick + "_"
+			c.write(conn, "NICK %s", nick)
+		case "471", "473", "474", "475":
+			return fmt.Errorf("couldn't join %s: %s", c.handler.Channel, strings.Join(params, " "))
+		case "JOIN":
+			if strings.SplitN(prefix, "!", 2)[0] == nick && len(params) > 0 && strings.EqualFold(params[0], c.handler.Channel) {
+				log.Infof("Joined %s on IRC server %s", c.handler.Channel, c.handler.Server)
+				backoff.reset()
+				c.Lock()
+				c.conn = conn
+				close(c.joined)
+				c.joined = make(chan struct{})
+				c.Unlock()
+			}
+		case "KICK":
+			if len(params) > 1 && params[1] == nick {
+				return fmt.Errorf("kicked from %s", c.handler.Channel)
+			}
+		case "ERROR":
+			return fmt.Errorf("server error: %s", strings.Join(params, " "))
+		}
+	}
+}
+
+// Announces lines in the channel, waiting for the client to be connected if it isn't
+func (c *ircClient) say(ctx context.Context, lines []string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	for c.conn == nil {
+		joined := c.joined
+		c.Unlock()
+		select {
+		case <-joined:
+		case <-ctx.Done():
+			c.Lock()
+			return errors.New("not connected to the server")
+		}
+		c.Lock()
+	}
+
+	for _, line := range lines {
+		if err := c.write(c.conn, "PRIVMSG %s :%s", c.handler.Channel, ircSanitize(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes a line to the server
+func (c *ircClient) write(conn net.Conn, format string, args ...interface{}) error {
+	conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := fmt.Fprintf(conn, format+"\r\n", args...)
+	return err
+}
+
+// Removes line breaks from a line and truncates it to fit in a message
+func ircSanitize(line string) string {
+	line = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return ' '
+		}
+		return r
+	}, line)
+	if len(line) > ircMaxLineLength {
+		line = line[:ircMaxLineLength-3] + "..."
+	}
+	if line == "" {
+		line = " "
+	}
+	return line
+}
+
+// Splits a line from the server into its prefix, command and parameters
+func parseIRCLine(line string) (string, string, []string) {
+	line = strings.TrimRight(line, "\r\n")
+
+	prefix := ""
+	if strings.HasPrefix(line, ":") {
+		split := strings.SplitN(line[1:], " ", 2)
+		prefix = split[0]
+		if len(split) < 2 {
+			return prefix, "", nil
+		}
+		line = split[1]
+	}
+
+	var trailing []string
+	if index := strings.Index(line, " :"); index >= 0 {
+		trailing = []string{line[index+2:]}
+		line = line[:index]
+	} else if strings.HasPrefix(line, ":") {
+		trailing = []string{line[1:]}
+		line = ""
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return prefix, "", trailing
+	}
+	return prefix, strings.ToUpper(fields[0]), append(fields[1:], trailing...)
+}
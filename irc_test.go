@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIRC_parseLine(t *testing.T) {
+	cases := map[string][]string{
+		"PING :irc.example.com\r\n":                         {"", "PING", "irc.example.com"},
+		":nick!user@host JOIN #ops\r\n":                     {"nick!user@host", "JOIN", "#ops"},
+		":irc.example.com 001 consul-alerting :Welcome\r\n": {"irc.example.com", "001", "consul-alerting", "Welcome"},
+		":op!u@h KICK #ops consul-alerting :bye now":        {"op!u@h", "KICK", "#ops", "consul-alerting", "bye now"},
+	}
+	for line, expected := range cases {
+		prefix, command, params := parseIRCLine(line)
+		if actual := append([]string{prefix, command}, params...); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%q: expected %v, got %v", line, expected, actual)
+		}
+	}
+}
+
+// Plays the server side of a connection: welcomes the client, confirms its join and
+// returns the lines it sends
+func fakeIRCSession(t *testing.T, conn net.Conn, lines chan string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		lines <- line
+
+		switch {
+		case strings.HasPrefix(line, "USER "):
+			fmt.Fprintf(conn, ":irc.example.com 001 alerts :Welcome\r\n")
+			fmt.Fprintf(conn, "PING :irc.example.com\r\n")
+		case strings.HasPrefix(line, "JOIN "):
+			fmt.Fprintf(conn, ":alerts!alerts@host JOIN %s\r\n", strings.Fields(line)[1])
+		case strings.HasPrefix(line, "PRIVMSG #ops :... "):
+			// Hang up after the last line of the first alert, to test reconnecting
+			return
+		}
+	}
+}
+
+func TestIRC_announce(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	lines := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fakeIRCSession(t, conn, lines)
+		}
+	}()
+
+	handler := IRCHandler{Server: listener.Addr().String(), Nick: "alerts", Channel: "#ops", ChannelKey: "key", NickServPassword: "hunter2"}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alert := &AlertState{Message: "redis is critical", Details: "Failing checks:\n=> (node) node1\n==> (check) ping:\nconnection refused\nretrying"}
+	if err := handler.Alert(ctx, alert); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"NICK alerts",
+		"USER alerts 0 * :consul-alerting",
+		"PRIVMSG NickServ :IDENTIFY hunter2",
+		"JOIN #ops key",
+		"PONG :irc.example.com",
+		"PRIVMSG #ops :redis is critical",
+		"PRIVMSG #ops :Failing checks:",
+		"PRIVMSG #ops :=> (node) node1",
+		"PRIVMSG #ops :==> (check) ping:",
+		"PRIVMSG #ops :... (2 more lines)",
+	}
+	for _, line := range expected {
+		if actual := <-lines; actual != line {
+			t.Fatalf("expected %q, got %q", line, actual)
+		}
+	}
+
+	// The server hung up, so the next alert should be sent after reconnecting
+	waitFor := func(expected string) {
+		for {
+			select {
+			case line := <-lines:
+				if line == expected {
+					return
+				}
+			case <-ctx.Done():
+				t.Fatalf("timed out waiting for %q", expected)
+			}
+		}
+	}
+	waitFor("NICK alerts")
+	if err := handler.Alert(ctx, &AlertState{Message: "redis is passing"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("PRIVMSG #ops :redis is passing")
+}
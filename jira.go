@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// JiraHandler opens a Jira issue when a service or node goes critical, and comments on
+// (and optionally transitions) the issue when it recovers
+type JiraHandler struct {
+	URL      string `mapstructure:"url"`
+	Username string `mapstructure:"username"`
+	APIToken string `mapstructure:"api_token"`
+
+	Project   string   `mapstructure:"project"`
+	IssueType string   `mapstructure:"issue_type"`
+	Labels    []string `mapstructure:"labels"`
+
+	// Optional. The name of the transition to move issues through on recovery, e.g. "Done"
+	ResolveTransition string `mapstructure:"resolve_transition"`
+
+	// Optional. "cloud" or "server" (for Server/Data Center), which use different search
+	// APIs. Defaults to cloud for atlassian.net URLs and server otherwise.
+	Deployment string `mapstructure:"deployment"`
+}
+
+// Matches the characters that aren't allowed in Jira labels
+var jiraLabelInvalid = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Returns the label used to find the issue for an alert's node/service/tag again when it
+// recovers
+func jiraAlertLabel(alert *AlertState) string {
+	label := "consul-alerting"
+	for _, part := range []string{alert.Datacenter, alert.Service, alert.Tag, alert.Node} {
+		if part != "" {
+			label = label + "_" + jiraLabelInvalid.ReplaceAllString(part, "-")
+		}
+	}
+	if alert.Meta {
+		label = label + "_meta"
+	}
+	return label
+}
+
+func (j JiraHandler) Alert(ctx context.Context, alert *AlertState) error {
+	var err error
+	switch alert.Status {
+	case api.HealthCritical:
+		err = j.open(ctx, alert)
+	case api.HealthPassing:
+		err = j.resolve(ctx, alert)
+	}
+	if err != nil {
+		return fmt.Errorf("Error sending alert to Jira: %s", err)
+	}
+	return nil
+}
+
+// Opens an issue for the alert, or comments on the existing one if it's still open
+func (j JiraHandler) open(ctx context.Context, alert *AlertState) error {
+	keys, err := j.openIssues(ctx, alert)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return j.comment(ctx, keys[0], alert)
+	}
+
+	issue := map[string]interface{}{
+		"fields": map[string]interface{}{
+			"project":     map[string]string{"key": j.Project},
+			"issuetype":   map[string]string{"name": j.IssueType},
+			"summary":     alert.Message,
+			"description": jiraBody(alert),
+			"labels":      append([]string{jiraAlertLabel(alert)}, j.Labels...),
+		},
+	}
+	return j.request(ctx, "POST", "/rest/api/2/issue", issue, nil)
+}
+
+// Comments on the alert's open issues, and transitions them if resolve_transition is set
+func (j JiraHandler) resolve(ctx context.Context, alert *AlertState) error {
+	keys, err := j.openIssues(ctx, alert)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := j.comment(ctx, key, alert); err != nil {
+			return err
+		}
+		if j.ResolveTransition == "" {
+			continue
+		}
+		if err := j.transition(ctx, key, j.ResolveTransition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the keys of the unresolved issues for the alert's node/service/tag
+func (j JiraHandler) openIssues(ctx context.Context, alert *AlertState) ([]string, error) {
+	jql := fmt.Sprintf(`project = "%s" AND labels = "%s" AND statusCategory != Done ORDER BY created DESC`, j.Project, jiraAlertLabel(alert))
+
+	var result struct {
+		Issues []struct {
+			Key string `json:"key"`
+		} `json:"issues"`
+	}
+	// Jira Cloud removed the v2 search API in favour of /search/jql, which Server/Data
+	// Center doesn't have
+	path := "/rest/api/2/search"
+	if j.cloud() {
+		path = "/rest/api/3/search/jql"
+	}
+	if err := j.request(ctx, "GET", path+"?fields=key&jql="+url.QueryEscape(jql), nil, &result); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(result.Issues))
+	for _, issue := range result.Issues {
+		keys = append(keys, issue.Key)
+	}
+	return keys, nil
+}
+
+// Returns whether the handler is talking to Jira Cloud rather than Server/Data Center
+func (j JiraHandler) cloud() bool {
+	if j.Deployment != "" {
+		return j.Deployment == "cloud"
+	}
+	parsed, err := url.Parse(j.URL)
+	return err == nil && strings.HasSuffix(strings.ToLower(parsed.Hostname()), ".atlassian.net")
+}
+
+func (j JiraHandler) comment(ctx context.Context, key string, alert *AlertState) error {
+	return j.request(ctx, "POST", "/rest/api/2/issue/"+url.PathEscape(key)+"/comment", map[string]string{"body": jiraBody(alert)}, nil)
+}
+
+// Moves an issue through the transition with the given name
+func (j JiraHandler) transition(ctx context.Context, key string, name string) error {
+	path := "/rest/api/2/issue/" + url.PathEscape(key) + "/transitions"
+
+	var result struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	if err := j.request(ctx, "GET", path, nil, &result); err != nil {
+		return err
+	}
+
+	for _, transition := range result.Transitions {
+		if strings.EqualFold(transition.Name, name) {
+			return j.request(ctx, "POST", path, map[string]interface{}{"transition": map[string]string{"id": transition.ID}}, nil)
+		}
+	}
+	return fmt.Errorf("issue %s has no transition named %q", key, name)
+}
+
+// Returns the description/comment text for an alert, with the details preformatted
+func jiraBody(alert *AlertState) string {
+	body := alert.Message
+	if alert.Details != "" {
+		body = body + "\n{noformat}\n" + alert.Details + "\n{noformat}"
+	}
+	if alert.Link != "" {
+		body = body + "\n" + alert.Link
+	}
+	return body
+}
+
+// Makes a request to the Jira REST API, decoding the response into out if it isn't nil
+func (j JiraHandler) request(ctx context.Context, method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(j.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.SetBasicAuth(j.Username, j.APIToken)
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: got response code %d (%s)", method, path, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func (j JiraHandler) Validate() error {
+	if j.URL == "" {
+		return errors.New("no url given")
+	}
+	if j.Username == "" || j.APIToken == "" {
+		return errors.New("username and api_token must be given")
+	}
+	if j.Project == "" {
+		return errors.New("no project given")
+	}
+	switch j.Deployment {
+	case "", "cloud", "server":
+	default:
+		return fmt.Errorf("invalid deployment %q, must be cloud or server", j.Deployment)
+	}
+	for _, label := range j.Labels {
+		if strings.ContainsAny(label, " \t") {
+			return fmt.Errorf("invalid label %q, labels can't contain spaces", label)
+		}
+	}
+	return nil
+}
+
+// Checks that the credentials are valid and can see the project
+func (j JiraHandler) Probe(ctx context.Context) error {
+	if err := j.request(ctx, "GET", "/rest/api/2/project/"+url.PathEscape(j.Project), nil, nil); err != nil {
+		return fmt.Errorf("error looking up Jira project: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A fake Jira API that records the issues created and the comments/transitions made on them
+type fakeJira struct {
+	issues      map[string]bool
+	labels      map[string][]string
+	comments    map[string]int
+	transitions []string
+	// The search API paths used
+	searches []string
+}
+
+func (f *fakeJira) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch {
+	case r.URL.Path == "/rest/api/2/search" || r.URL.Path == "/rest/api/3/search/jql":
+		f.searches = append(f.searches, r.URL.Path)
+		jql := r.URL.Query().Get("jql")
+		issues := make([]map[string]string, 0)
+		for key, open := range f.issues {
+			for _, label := range f.labels[key] {
+				if open && strings.Contains(jql, `labels = "`+label+`"`) {
+					issues = append(issues, map[string]string{"key": key})
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"issues": issues})
+	case r.URL.Path == "/rest/api/2/issue" && r.Method == "POST":
+		fields := body["fields"].(map[string]interface{})
+		key := "OPS-" + strconv.Itoa(len(f.issues)+1)
+		f.issues[key] = true
+		for _, label := range fields["labels"].([]interface{}) {
+			f.labels[key] = append(f.labels[key], label.(string))
+		}
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasSuffix(r.URL.Path, "/comment"):
+		f.comments[strings.Split(r.URL.Path, "/")[5]]++
+	case strings.HasSuffix(r.URL.Path, "/transitions") && r.Method == "GET":
+		w.Write([]byte(`{"transitions": [{"id": "11", "name": "In Progress"}, {"id": "31", "name": "Done"}]}`))
+	case strings.HasSuffix(r.URL.Path, "/transitions"):
+		key := strings.Split(r.URL.Path, "/")[5]
+		f.transitions = append(f.transitions, key+" "+body["transition"].(map[string]interface{})["id"].(string))
+		f.issues[key] = false
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestJira_lifecycle(t *testing.T) {
+	jira := &fakeJira{issues: make(map[string]bool), labels: make(map[string][]string), comments: make(map[string]int)}
+	server := httptest.NewServer(jira)
+	defer server.Close()
+
+	handler := JiraHandler{
+		URL:               server.URL,
+		Username:          "alerting",
+		APIToken:          "token",
+		Project:           "OPS",
+		IssueType:         "Task",
+		Labels:            []string{"consul"},
+		ResolveTransition: "done",
+		Deployment:        "server",
+	}
+	alert := &AlertState{Status: "critical", Service: "redis", Tag: "alpha", Datacenter: "dc1", Message: "redis is critical"}
+
+	// Going critical twice should only open one issue
+	for i := 0; i < 2; i++ {
+		if err := handler.Alert(context.Background(), alert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(jira.issues) != 1 || jira.comments["OPS-1"] != 1 {
+		t.Fatalf("expected one issue with a comment, got %v %v", jira.issues, jira.comments)
+	}
+	if labels := jira.labels["OPS-1"]; len(labels) != 2 || labels[0] != "consul-alerting_dc1_redis_alpha" || labels[1] != "consul" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+
+	// Warnings are ignored
+	alert.Status = "warning"
+	if err := handler.Alert(context.Background(), alert); err != nil || jira.comments["OPS-1"] != 1 {
+		t.Errorf("expected warning to be ignored, got %v", err)
+	}
+
+	alert.Status = "passing"
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if jira.comments["OPS-1"] != 2 || len(jira.transitions) != 1 || jira.transitions[0] != "OPS-1 31" {
+		t.Errorf("expected the issue to be commented on and resolved, got %v %v", jira.comments, jira.transitions)
+	}
+
+	// A new failure opens a new issue, since the old one is resolved
+	alert.Status = "critical"
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if len(jira.issues) != 2 || !jira.issues["OPS-2"] {
+		t.Errorf("expected a second issue, got %v", jira.issues)
+	}
+	for _, path := range jira.searches {
+		if path != "/rest/api/2/search" {
+			t.Errorf("expected Jira Server to be searched with the v2 API, got %s", path)
+		}
+	}
+}
+
+// Jira Cloud should be searched with the /search/jql API, since it removed the v2 search
+func TestJira_cloudSearch(t *testing.T) {
+	jira := &fakeJira{issues: make(map[string]bool), labels: make(map[string][]string), comments: make(map[string]int)}
+	server := httptest.NewServer(jira)
+	defer server.Close()
+
+	handler := JiraHandler{URL: server.URL, Username: "alerting", APIToken: "token", Project: "OPS", IssueType: "Task", Deployment: "cloud"}
+	alert := &AlertState{Status: "critical", Service: "redis", Datacenter: "dc1", Message: "redis is critical"}
+	for i := 0; i < 2; i++ {
+		if err := handler.Alert(context.Background(), alert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(jira.issues) != 1 || jira.comments["OPS-1"] != 1 {
+		t.Fatalf("expected one issue with a comment, got %v %v", jira.issues, jira.comments)
+	}
+	if len(jira.searches) != 2 || jira.searches[0] != "/rest/api/3/search/jql" {
+		t.Errorf("expected searches with the /search/jql API, got %v", jira.searches)
+	}
+
+	// Atlassian-hosted URLs default to cloud
+	if !(JiraHandler{URL: "https://example.atlassian.net"}).cloud() || (JiraHandler{URL: "https://jira.example.com"}).cloud() {
+		t.Errorf("expected the deployment to be detected from the URL")
+	}
+}
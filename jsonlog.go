@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Serializes writes from JSONHandlers, so lines from concurrent alerts don't interleave
+var jsonHandlerLock sync.Mutex
+
+// JSONHandler writes each alert as a line of JSON to stdout or a file, for log shippers
+// to pick up
+type JSONHandler struct {
+	Path string `mapstructure:"path"`
+}
+
+// A line written by the JSON handler
+type jsonAlertLine struct {
+	Timestamp time.Time `json:"timestamp"`
+	*AlertState
+}
+
+func (j JSONHandler) Alert(ctx context.Context, alert *AlertState) error {
+	line, err := json.Marshal(jsonAlertLine{Timestamp: time.Now().UTC(), AlertState: alert})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	jsonHandlerLock.Lock()
+	defer jsonHandlerLock.Unlock()
+
+	if j.Path == "" {
+		_, err = os.Stdout.Write(line)
+		return err
+	}
+
+	// Open the file for each alert, so it can be rotated out from under us
+	file, err := os.OpenFile(j.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Error writing alert to %s: %s", j.Path, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("Error writing alert to %s: %s", j.Path, err)
+	}
+	return nil
+}
+
+func (j JSONHandler) Validate() error {
+	if j.Path == "" {
+		return nil
+	}
+	if info, err := os.Stat(filepath.Dir(j.Path)); err != nil || !info.IsDir() {
+		return fmt.Errorf("directory for path %s doesn't exist", j.Path)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONHandler_file(t *testing.T) {
+	dir, err := ioutil.TempDir("", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler := JSONHandler{Path: filepath.Join(dir, "alerts.log")}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []string{"critical", "passing"} {
+		if err := handler.Alert(context.Background(), &AlertState{Status: status, Service: "redis"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(handler.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 2 || lines[0]["status"] != "critical" || lines[1]["status"] != "passing" || lines[1]["service"] != "redis" {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+	if _, ok := lines[0]["timestamp"]; !ok {
+		t.Errorf("expected a timestamp, got %v", lines[0])
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// The node name consul-k8s registers synced services under by default
+const k8sSyncNode = "k8s-sync"
+
+// How long to wait for a catalog lookup before going without Kubernetes info
+const k8sLookupTimeout = 10 * time.Second
+
+// Fetches the catalog entries for a service, with the service metadata consul-k8s uses to
+// describe where an instance came from
+func catalogServiceMeta(client *api.Client, service string) ([]*api.CatalogService, error) {
+	type result struct {
+		entries []*api.CatalogService
+		err     error
+	}
+
+	// The API client has no request timeouts, so give up on a slow lookup and leave it to
+	// finish in the background
+	resultCh := make(chan result, 1)
+	go func() {
+		entries, _, err := client.Catalog().Service(service, "", &api.QueryOptions{AllowStale: true})
+		resultCh <- result{entries, err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		return r.entries, r.err
+	case <-time.After(k8sLookupTimeout):
+		return nil, fmt.Errorf("catalog lookup for %s timed out after %s", service, k8sLookupTimeout)
+	}
+}
+
+// Returns true if the instance was registered by consul-k8s, either by catalog sync or
+// connect injection
+func k8sSynced(entry *api.CatalogService, syncTag string) bool {
+	if entry.ServiceMeta["external-source"] == "kubernetes" || strings.HasPrefix(entry.ServiceMeta["managed-by"], "consul-k8s") {
+		return true
+	}
+	return entry.Node == k8sSyncNode || (syncTag != "" && contains(entry.ServiceTags, syncTag))
+}
+
+// Returns the Kubernetes namespace and pod of an instance, if consul-k8s recorded them
+func k8sLocation(entry *api.CatalogService) (string, string) {
+	namespace := entry.ServiceMeta["k8s-namespace"]
+	if namespace == "" {
+		namespace = entry.ServiceMeta["external-k8s-ns"]
+	}
+	return namespace, entry.ServiceMeta["pod-name"]
+}
+
+// Returns the Kubernetes namespace/pod of each Kubernetes-registered instance with a failing
+// check, to add to the details of an alert
+func k8sDetails(entries []*api.CatalogService, checks []*api.HealthCheck, syncTag string) string {
+	failing := make(map[string]bool)
+	for _, check := range checks {
+		if check.Status == api.HealthCritical || check.Status == api.HealthWarning {
+			failing[check.Node+"/"+check.ServiceID] = true
+		}
+	}
+
+	lines := make([]string, 0)
+	for _, entry := range entries {
+		if !k8sSynced(entry, syncTag) || !failing[entry.Node+"/"+entry.ServiceID] {
+			continue
+		}
+
+		namespace, pod := k8sLocation(entry)
+		if namespace == "" && pod == "" {
+			continue
+		}
+		if pod == "" {
+			pod = entry.ServiceID
+		}
+		lines = append(lines, fmt.Sprintf("=> (pod) %s in namespace %s (%s)", pod, namespace, entry.ServiceAddress))
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	sort.Strings(lines)
+	return "Kubernetes:\n" + strings.Join(lines, "\n")
+}
+
+// Adds the Kubernetes location of failing instances to the details of a service alert, if
+// the service was registered by consul-k8s
+func addK8sDetails(config *Config, client *api.Client, service string, checks []*api.HealthCheck, details string) string {
+	entries, err := catalogServiceMeta(client, service)
+	if err != nil {
+		log.Warnf("Error looking up Kubernetes info for service %s: %s", service, err)
+		return details
+	}
+
+	k8s := k8sDetails(entries, checks, config.K8sSyncTag)
+	if k8s == "" {
+		return details
+	}
+	if details == "" {
+		return k8s
+	}
+	return details + "\n" + k8s
+}
+
+// RolloutTracker watches for registration churn in services (instances coming and going),
+// which for services synced from Kubernetes means pods are being replaced by a rolling update
+type RolloutTracker struct {
+	sync.Mutex
+	instances map[string]map[string]bool
+	changed   map[string]time.Time
+}
+
+var rollouts = &RolloutTracker{
+	instances: make(map[string]map[string]bool),
+	changed:   make(map[string]time.Time),
+}
+
+// Records the instances of a service seen in a health query, noting when the set changes
+func (r *RolloutTracker) observe(service string, checks []*api.HealthCheck, now time.Time) {
+	current := make(map[string]bool)
+	for _, check := range checks {
+		if check.ServiceID != "" {
+			current[check.Node+"/"+check.ServiceID] = true
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	last, ok := r.instances[service]
+	r.instances[service] = current
+	if !ok || len(last) == len(current) && containsAll(last, current) {
+		return
+	}
+	r.changed[service] = now
+}
+
+// Returns how much longer a service should be considered mid-rollout, given how long
+// after the last registration change a rollout is assumed to be over
+func (r *RolloutTracker) remaining(service string, window time.Duration, now time.Time) time.Duration {
+	r.Lock()
+	defer r.Unlock()
+
+	changed, ok := r.changed[service]
+	if !ok {
+		return 0
+	}
+	if remaining := changed.Add(window).Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+func containsAll(set map[string]bool, other map[string]bool) bool {
+	for key := range other {
+		if !set[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// Holds a failing alert for a Kubernetes-synced service until its instances have stopped
+// churning for k8s_rollout_window, so failures from pods being replaced resolve before we
+// page anyone. Gives up after k8s_rollout_max_hold, so a service that never stops churning
+// still alerts.
+func waitForRollout(config *Config, client *api.Client, service string) {
+	window := time.Duration(config.K8sRolloutWindow) * time.Second
+	if service == "" || window <= 0 || rollouts.remaining(service, window, time.Now()) == 0 {
+		return
+	}
+
+	entries, err := catalogServiceMeta(client, service)
+	if err != nil {
+		log.Warnf("Error checking whether service %s is synced from Kubernetes: %s", service, err)
+		return
+	}
+	synced := false
+	for _, entry := range entries {
+		if k8sSynced(entry, config.K8sSyncTag) {
+			synced = true
+		}
+	}
+	if !synced {
+		return
+	}
+
+	maxHold := time.Duration(config.K8sRolloutMaxHold) * time.Second
+	start := time.Now()
+	for wait := rollouts.remaining(service, window, time.Now()); wait > 0; wait = rollouts.remaining(service, window, time.Now()) {
+		if maxHold > 0 {
+			left := maxHold - time.Since(start)
+			if left <= 0 {
+				log.Warnf("Service %s is still churning after %s, sending its alert anyway", service, maxHold)
+				return
+			}
+			if wait > left {
+				wait = left
+			}
+		}
+		log.Debugf("Service %s looks like it's mid-rollout, holding its alert for %s", service, wait)
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestK8s_details(t *testing.T) {
+	entries := []*api.CatalogService{
+		{
+			Node:           "node1",
+			ServiceID:      "web-abc",
+			ServiceAddress: "10.0.0.1",
+			ServiceMeta:    map[string]string{"managed-by": "consul-k8s-endpoints-controller", "k8s-namespace": "prod", "pod-name": "web-7d9f-abc"},
+		},
+		{
+			Node:           k8sSyncNode,
+			ServiceID:      "web-def",
+			ServiceAddress: "10.0.0.2",
+			ServiceMeta:    map[string]string{"external-source": "kubernetes", "external-k8s-ns": "prod"},
+		},
+		{
+			Node:      "node2",
+			ServiceID: "web",
+		},
+	}
+	checks := []*api.HealthCheck{
+		{Node: "node1", ServiceID: "web-abc", Status: api.HealthCritical},
+		{Node: k8sSyncNode, ServiceID: "web-def", Status: api.HealthWarning},
+		{Node: "node2", ServiceID: "web", Status: api.HealthCritical},
+	}
+
+	expected := `Kubernetes:
+=> (pod) web-7d9f-abc in namespace prod (10.0.0.1)
+=> (pod) web-def in namespace prod (10.0.0.2)`
+	if details := k8sDetails(entries, checks, "k8s"); details != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, details)
+	}
+
+	// Services that didn't come from Kubernetes shouldn't get any extra details
+	if details := k8sDetails(entries[2:], checks, "k8s"); details != "" {
+		t.Errorf("expected no details, got %q", details)
+	}
+}
+
+func TestK8s_rolloutTracker(t *testing.T) {
+	tracker := &RolloutTracker{instances: make(map[string]map[string]bool), changed: make(map[string]time.Time)}
+	window := 2 * time.Minute
+	start := time.Now()
+
+	old := []*api.HealthCheck{{Node: "k8s-sync", ServiceID: "web-abc"}}
+	replaced := []*api.HealthCheck{{Node: "k8s-sync", ServiceID: "web-def"}}
+
+	// The first time we see the service isn't a change
+	tracker.observe("web", old, start)
+	if remaining := tracker.remaining("web", window, start); remaining != 0 {
+		t.Fatalf("expected no rollout, got %s remaining", remaining)
+	}
+
+	tracker.observe("web", replaced, start.Add(time.Minute))
+	if remaining := tracker.remaining("web", window, start.Add(time.Minute)); remaining != window {
+		t.Fatalf("expected %s remaining, got %s", window, remaining)
+	}
+
+	// A stable set of instances lets the window run out
+	tracker.observe("web", replaced, start.Add(2*time.Minute))
+	if remaining := tracker.remaining("web", window, start.Add(4*time.Minute)); remaining != 0 {
+		t.Fatalf("expected rollout to be over, got %s remaining", remaining)
+	}
+}
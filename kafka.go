@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// The client ID we identify ourselves to Kafka brokers with
+const kafkaClientID = "consul-alerting"
+
+// How long to wait for the brokers when the handler has no timeout of its own
+const kafkaDefaultTimeout = 30 * time.Second
+
+// KafkaHandler publishes alerts as JSON messages to a Kafka topic, keyed by service/node so
+// all the alerts for a watch land in the same partition. It can connect over TLS and
+// authenticate with SASL PLAIN or SCRAM.
+type KafkaHandler struct {
+	Brokers       []string `mapstructure:"brokers"`
+	Topic         string   `mapstructure:"topic"`
+	TLS           bool     `mapstructure:"tls"`
+	TLSSkipVerify bool     `mapstructure:"tls_skip_verify"`
+	Username      string   `mapstructure:"username"`
+	Password      string   `mapstructure:"password"`
+
+	// Optional. The SASL mechanism to authenticate with: PLAIN, SCRAM-SHA-256 or
+	// SCRAM-SHA-512. Defaults to PLAIN.
+	SASLMechanism string `mapstructure:"sasl_mechanism"`
+
+	// Optional. The Kafka protocol version to use with the brokers, e.g. "3.6.0". Defaults
+	// to the client's default, which brokers up to Kafka 4 accept.
+	Version string `mapstructure:"version"`
+}
+
+// Creates the producer used to send alerts, overridden in tests
+var newKafkaProducer = sarama.NewSyncProducer
+
+// The producers for each handler config, kept between alerts so each alert doesn't have to
+// connect to the brokers and fetch their metadata again
+var kafkaProducers = struct {
+	sync.Mutex
+	producers map[string]sarama.SyncProducer
+}{producers: make(map[string]sarama.SyncProducer)}
+
+// Returns the key the handler's producer is kept under
+func (k KafkaHandler) producerKey() string {
+	return fmt.Sprintf("%#v", k)
+}
+
+// Returns the producer for the handler, creating it if it doesn't have one yet
+func (k KafkaHandler) producer(ctx context.Context) (sarama.SyncProducer, error) {
+	kafkaProducers.Lock()
+	defer kafkaProducers.Unlock()
+	if producer, ok := kafkaProducers.producers[k.producerKey()]; ok {
+		return producer, nil
+	}
+
+	config, err := k.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := newKafkaProducer(k.Brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	kafkaProducers.producers[k.producerKey()] = producer
+	return producer, nil
+}
+
+// Closes and forgets the handler's producer, if it's still the given one, so the next alert
+// starts over with a new connection
+func (k KafkaHandler) discardProducer(producer sarama.SyncProducer) {
+	kafkaProducers.Lock()
+	defer kafkaProducers.Unlock()
+	if kafkaProducers.producers[k.producerKey()] == producer {
+		delete(kafkaProducers.producers, k.producerKey())
+		producer.Close()
+	}
+}
+
+// Returns the message key for an alert
+func kafkaMessageKey(alert *AlertState) string {
+	return alert.Service + "/" + alert.Node
+}
+
+func (k KafkaHandler) Alert(ctx context.Context, alert *AlertState) error {
+	value, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	producer, err := k.producer(ctx)
+	if err != nil {
+		return fmt.Errorf("Error sending alert to Kafka: %s", err)
+	}
+
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{
+		Topic: k.Topic,
+		Key:   sarama.StringEncoder(kafkaMessageKey(alert)),
+		Value: sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		k.discardProducer(producer)
+		return fmt.Errorf("Error sending alert to Kafka: %s", err)
+	}
+	return nil
+}
+
+// Returns the client config for the handler, with timeouts taken from the handler's context.
+// The producer keeps the timeouts of the alert it was created for.
+func (k KafkaHandler) config(ctx context.Context) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.ClientID = kafkaClientID
+
+	if k.Version != "" {
+		version, err := sarama.ParseKafkaVersion(k.Version)
+		if err != nil {
+			return nil, err
+		}
+		config.Version = version
+	}
+
+	timeout := kafkaDefaultTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	config.Net.DialTimeout = timeout
+	if connectTimeout, _ := ctx.Value(connectTimeoutKey).(time.Duration); connectTimeout > 0 {
+		config.Net.DialTimeout = connectTimeout
+	}
+	config.Net.ReadTimeout = timeout
+	config.Net.WriteTimeout = timeout
+	config.Metadata.Retry.Max = 1
+
+	if k.TLS {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = &tls.Config{InsecureSkipVerify: k.TLSSkipVerify}
+		if len(k.Brokers) > 0 {
+			host, _, _ := net.SplitHostPort(k.Brokers[0])
+			config.Net.TLS.Config.ServerName = host
+		}
+	}
+
+	if k.Username != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.User = k.Username
+		config.Net.SASL.Password = k.Password
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		switch k.SASLMechanism {
+		case sarama.SASLTypeSCRAMSHA256:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &kafkaSCRAMClient{hash: scram.SHA256} }
+		case sarama.SASLTypeSCRAMSHA512:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &kafkaSCRAMClient{hash: scram.SHA512} }
+		}
+	}
+
+	// Wait for the leader to write the message, and partition by key the same way as
+	// Kafka's Java client so the alerts for a watch stay in order
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Timeout = timeout
+	config.Producer.Retry.Max = 1
+	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = sarama.NewMurmur2Partitioner
+
+	return config, config.Validate()
+}
+
+func (k KafkaHandler) Validate() error {
+	if len(k.Brokers) == 0 {
+		return errors.New("no brokers given")
+	}
+	if k.Topic == "" {
+		return errors.New("no topic given")
+	}
+	if k.Password != "" && k.Username == "" {
+		return errors.New("password given without a username")
+	}
+	switch k.SASLMechanism {
+	case "", sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+	default:
+		return fmt.Errorf("invalid sasl_mechanism %q, must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", k.SASLMechanism)
+	}
+	if k.Version != "" {
+		if _, err := sarama.ParseKafkaVersion(k.Version); err != nil {
+			return fmt.Errorf("invalid version %q", k.Version)
+		}
+	}
+	return nil
+}
+
+// Checks that a broker can be reached and the topic exists
+func (k KafkaHandler) Probe(ctx context.Context) error {
+	config, err := k.config(ctx)
+	if err != nil {
+		return err
+	}
+	client, err := sarama.NewClient(k.Brokers, config)
+	if err != nil {
+		return fmt.Errorf("error connecting to Kafka: %s", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Partitions(k.Topic); err != nil {
+		return fmt.Errorf("error looking up topic %s: %s", k.Topic, err)
+	}
+	return nil
+}
+
+// Runs the client side of a SCRAM conversation for sarama
+type kafkaSCRAMClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *kafkaSCRAMClient) Begin(userName, password, authzID string) error {
+	client, err := c.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *kafkaSCRAMClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *kafkaSCRAMClient) Done() bool {
+	return c.conversation.Done()
+}
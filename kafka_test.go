@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/xdg-go/scram"
+)
+
+func TestKafka_produce(t *testing.T) {
+	var config *sarama.Config
+	var sent *sarama.ProducerMessage
+	var created int
+	defaultProducer := newKafkaProducer
+	defer func() { newKafkaProducer = defaultProducer }()
+	kafkaProducers.producers = make(map[string]sarama.SyncProducer)
+	newKafkaProducer = func(brokers []string, c *sarama.Config) (sarama.SyncProducer, error) {
+		config = c
+		created++
+		producer := mocks.NewSyncProducer(t, nil)
+		for i := 0; i < 2; i++ {
+			producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+				sent = msg
+				return nil
+			})
+		}
+		return producer, nil
+	}
+
+	handler := KafkaHandler{
+		Brokers:       []string{"kafka:9093"},
+		Topic:         "alerts",
+		TLS:           true,
+		Username:      "alerting",
+		Password:      "hunter2",
+		SASLMechanism: "SCRAM-SHA-512",
+	}
+	alert := &AlertState{Status: "critical", Service: "redis", Node: "node1", Message: "redis is down"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	// The producer is kept for the next alert
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 {
+		t.Errorf("expected one producer to be created, got %d", created)
+	}
+
+	if !config.Net.TLS.Enable || config.Net.TLS.Config.ServerName != "kafka" {
+		t.Errorf("expected TLS to be enabled for the broker's host, got %+v", config.Net.TLS)
+	}
+	if !config.Net.SASL.Enable || config.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA512 || config.Net.SASL.User != "alerting" {
+		t.Errorf("unexpected SASL config %+v", config.Net.SASL)
+	}
+
+	key, _ := sent.Key.Encode()
+	value, _ := sent.Value.Encode()
+	if sent.Topic != "alerts" || string(key) != "redis/node1" {
+		t.Errorf("expected message to alerts with key redis/node1, got %s %q", sent.Topic, key)
+	}
+	var decoded AlertState
+	if err := json.Unmarshal(value, &decoded); err != nil || decoded.Message != alert.Message {
+		t.Errorf("unexpected message %q: %v", value, err)
+	}
+}
+
+// Make sure the SCRAM client can authenticate with a SCRAM server
+func TestKafka_scram(t *testing.T) {
+	client, err := scram.SHA256.NewClient("alerting", "hunter2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials := client.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096})
+	server, err := scram.SHA256.NewServer(func(string) (scram.StoredCredentials, error) { return credentials, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation := server.NewConversation()
+
+	scramClient := &kafkaSCRAMClient{hash: scram.SHA256}
+	if err := scramClient.Begin("alerting", "hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	challenge := ""
+	for !scramClient.Done() {
+		response, err := scramClient.Step(challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conversation.Done() {
+			break
+		}
+		if challenge, err = conversation.Step(response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !conversation.Valid() {
+		t.Errorf("expected the server to accept the client")
+	}
+}
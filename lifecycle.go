@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The transitions between failing statuses, set on alerts as Transition
+const (
+	transitionEscalated       = "escalated"
+	transitionPartialRecovery = "partial_recovery"
+)
+
+// Returns the change threshold for a node/service going to the given status: the service's
+// warning_threshold or critical_threshold, then its own change_threshold if it sets one,
+// then the global warning_threshold or critical_threshold, then the global change_threshold
+func (c *Config) statusChangeThreshold(service, status string) int {
+	serviceConfig := c.serviceConfig(service)
+
+	var global, override *int
+	switch status {
+	case api.HealthWarning:
+		global = c.WarningThreshold
+		if serviceConfig != nil {
+			override = serviceConfig.WarningThreshold
+		}
+	case api.HealthCritical:
+		global = c.CriticalThreshold
+		if serviceConfig != nil {
+			override = serviceConfig.CriticalThreshold
+		}
+	}
+
+	if override != nil {
+		return *override
+	}
+	if serviceConfig != nil && serviceConfig.changeThresholdSet {
+		return serviceConfig.ChangeThreshold
+	}
+	if global != nil {
+		return *global
+	}
+	return c.serviceChangeThreshold(service)
+}
+
+// Marks an alert going between warning and critical as an escalation or a partial recovery,
+// and says so in its message, so it isn't mistaken for a new incident or the end of one
+func (a *AlertState) describeTransition() {
+	switch {
+	case a.LastAlerted == api.HealthWarning && a.Status == api.HealthCritical:
+		a.Transition = transitionEscalated
+		a.Message = fmt.Sprintf("%s (escalated from warning)", a.Message)
+	case a.LastAlerted == api.HealthCritical && a.Status == api.HealthWarning:
+		a.Transition = transitionPartialRecovery
+		a.Message = fmt.Sprintf("%s (partially recovered from critical)", a.Message)
+	default:
+		a.Transition = ""
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLifecycle_statusChangeThreshold(t *testing.T) {
+	config, err := ParseConfig(`
+	change_threshold = 60
+	critical_threshold = 10
+	service "api" {
+		change_threshold = 30
+		warning_threshold = 300
+	}
+	service "db" {
+		critical_threshold = 0
+	}
+	service "web" {}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service, status string
+		threshold       int
+	}{
+		{"api", "warning", 300},
+		{"api", "critical", 30},
+		{"api", "passing", 30},
+		{"db", "critical", 0},
+		{"db", "warning", 60},
+		{"web", "critical", 10},
+		{"", "critical", 10},
+		{"", "warning", 60},
+	}
+	for _, c := range cases {
+		if threshold := config.alertChangeThreshold(c.service, &AlertState{Status: c.status}); threshold != c.threshold {
+			t.Errorf("%q %s: expected a threshold of %d, got %d", c.service, c.status, c.threshold, threshold)
+		}
+	}
+
+	if _, err := ParseConfig(`warning_threshold = -1`); err == nil {
+		t.Error("expected an error for a negative warning_threshold")
+	}
+}
+
+func TestLifecycle_describeTransition(t *testing.T) {
+	cases := []struct {
+		lastAlerted, status string
+		transition, message string
+	}{
+		{"warning", "critical", transitionEscalated, "redis is now critical (escalated from warning)"},
+		{"critical", "warning", transitionPartialRecovery, "redis is now warning (partially recovered from critical)"},
+		{"passing", "critical", "", "redis is now critical"},
+		{"critical", "passing", "", "redis is now passing"},
+	}
+	for _, c := range cases {
+		alert := &AlertState{LastAlerted: c.lastAlerted, Status: c.status, Message: "redis is now " + c.status}
+		alert.describeTransition()
+		if alert.Transition != c.transition || alert.Message != c.message {
+			t.Errorf("%s -> %s: expected %q %q, got %q %q", c.lastAlerted, c.status, c.transition, c.message, alert.Transition, alert.Message)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -18,6 +19,15 @@ type LockHelper struct {
 	lockCh   chan struct{}
 	callback func()
 	acquired bool
+
+	// Optional. Called with any error from trying to acquire the lock.
+	errorCallback func(error)
+
+	// A session handed off to us by a previous process that already holds the lock, and
+	// the channel used to stop renewing it
+	session     string
+	sessionDone chan struct{}
+	sessionLock sync.Mutex
 }
 
 func (l *LockHelper) start() {
@@ -27,6 +37,16 @@ func (l *LockHelper) start() {
 		case <-l.stopCh:
 			clean = true
 		default:
+			// Take over the lock with the handed off session if we have one
+			l.sessionLock.Lock()
+			if l.session != "" {
+				lock, err := l.client.LockOpts(&api.LockOptions{Key: l.path, Session: l.session})
+				if err == nil {
+					l.lock = lock
+				}
+			}
+			l.sessionLock.Unlock()
+
 			log.Infof("Waiting to acquire lock on %s...", l.target)
 			intChan, err := l.lock.Lock(l.lockCh)
 			if intChan != nil {
@@ -38,10 +58,15 @@ func (l *LockHelper) start() {
 				log.Infof("Lost lock for %s", l.target)
 				l.lock.Unlock()
 				l.lock.Destroy()
+				l.releaseSession()
 			} else {
 				if err != nil {
 					log.Warnf("Error getting lock for %s: %s", l.target, err)
+					if l.errorCallback != nil {
+						l.errorCallback(err)
+					}
 				}
+				l.releaseSession()
 				time.Sleep(lockWaitTime)
 			}
 		}
@@ -54,4 +79,41 @@ func (l *LockHelper) stop() {
 	l.lock.Unlock()
 	l.lock.Destroy()
 	l.acquired = false
+	l.releaseSession()
+}
+
+// Stops renewing (and destroys) any handed off session and goes back to using a lock
+// with its own session
+func (l *LockHelper) releaseSession() {
+	l.sessionLock.Lock()
+	defer l.sessionLock.Unlock()
+
+	if l.session == "" {
+		return
+	}
+
+	close(l.sessionDone)
+	l.session = ""
+	l.sessionDone = nil
+
+	lock, err := l.client.LockKey(l.path)
+	if err != nil {
+		log.Errorf("Error initializing lock for %s: %s", l.target, err)
+		return
+	}
+	l.lock = lock
+}
+
+// Returns the ID of the session holding the lock, if we hold it
+func (l *LockHelper) heldSession() (string, error) {
+	if !l.acquired {
+		return "", nil
+	}
+
+	pair, _, err := l.client.KV().Get(l.path, nil)
+	if err != nil || pair == nil {
+		return "", err
+	}
+
+	return pair.Session, nil
 }
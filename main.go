@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,10 +17,19 @@ import (
 )
 
 const usage = `Usage: consul-alerting [--help] [options]
+       consul-alerting <command> [options]
 
 Options:
 
     -config=<path>    Sets the path to a configuration file on disk.
+    -json-errors      Write fatal errors to stderr as JSON objects.
+
+Exit codes:
+
+    1    Unexpected error
+    2    Invalid configuration
+    3    Consul agent unreachable (see startup_timeout)
+    4    Partial startup (e.g. couldn't write the PID file or bind http_address)
 `
 
 func init() {
@@ -32,15 +42,23 @@ func init() {
 }
 
 func main() {
+	// Run a subcommand instead of the daemon if one was given
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command.Run(os.Args[2:]))
+		}
+	}
+
 	// Parse command line options
 	var config_path string
 	var help bool
 	flag.StringVar(&config_path, "config", "", "")
 	flag.BoolVar(&help, "help", false, "")
+	flag.BoolVar(&jsonErrors, "json-errors", false, "")
 	flag.Parse()
 
 	if help {
-		fmt.Print(usage)
+		fmt.Print(usageText())
 		os.Exit(0)
 	}
 
@@ -51,8 +69,7 @@ func main() {
 		var err error
 		config, err = ParseConfigFile(config_path)
 		if err != nil {
-			log.Fatal(err)
-			os.Exit(2)
+			fatal(exitConfigError, err)
 		}
 	} else {
 		config = DefaultConfig()
@@ -61,35 +78,75 @@ func main() {
 	// Set log level
 	level, err := log.ParseLevel(config.LogLevel)
 	if err != nil {
-		log.Errorf("Error setting loglevel '%s': %s", level, err)
-		os.Exit(2)
+		fatal(exitConfigError, fmt.Errorf("Error setting loglevel '%s': %s", config.LogLevel, err))
 	}
 	log.SetLevel(level)
 
+	// Make sure the handlers can actually reach their endpoints before we rely on them
+	if config.ProbeHandlers {
+		if err := probeHandlers(config); err != nil {
+			fatal(exitConfigError, err)
+		}
+	}
+
+	// Pick up the state from the previous process if it's handing off to us
+	inheritedState, err = receiveHandoff()
+	if err != nil {
+		fatal(exitPartialStartup, err)
+	}
+
+	if err := setupDaemon(config); err != nil {
+		fatal(exitPartialStartup, err)
+	}
+
 	// Initialize Consul client
-	clientConfig := api.DefaultConfig()
-	clientConfig.Address = config.ConsulAddress
-	addressSplit := strings.Split(config.ConsulAddress, "://")
-	if len(addressSplit) > 1 {
-		clientConfig.Address = addressSplit[1]
-		clientConfig.Scheme = addressSplit[0]
+	client, err := newConsulClient(config)
+	if err != nil {
+		fatal(exitConfigError, fmt.Errorf("Error initializing client: %s", err))
 	}
-	clientConfig.Token = config.ConsulToken
 
-	log.Infof("Using Consul agent at %s", clientConfig.Address)
-	client, err := api.NewClient(clientConfig)
+	// Load any notifications left waiting to be retried and start retrying them
+	retryQueue, err = newRetryQueue(config, client)
 	if err != nil {
-		log.Fatal("Error initializing client: ", err)
+		fatal(exitPartialStartup, err)
+	}
+	go retryQueue.run(config)
+
+	// Send alerts from a pool of workers, so slow handlers can't hold up the watches
+	if config.DispatchWorkers > 0 {
+		dispatcher = newDispatcher(config)
+	}
+
+	// Keep the handlers' Vault secrets from expiring, and pick up any that are rotated
+	if config.vault != nil {
+		go refreshVaultSecrets(config)
+	}
+
+	inheritedState.renewSessions(client)
+
+	// Give up on reaching the agent after startup_timeout, if it's set
+	var startupDeadline time.Time
+	if config.StartupTimeout > 0 {
+		startupDeadline = time.Now().Add(time.Duration(config.StartupTimeout) * time.Second)
+	}
+	backoff := newBackoff()
+	retryConnect := func(action string, err error) {
+		wait := backoff.next()
+		if !startupDeadline.IsZero() && time.Now().Add(wait).After(startupDeadline) {
+			fatal(exitConsulError, fmt.Errorf("Error %s, giving up after %ds: %s", action, config.StartupTimeout, err))
+		}
+		log.Errorf("Error %s: %s", action, err)
+		log.Errorf("Retrying in %s...", wait)
+		time.Sleep(wait)
 	}
+
 	var nodeName string
 	for {
 		nodeName, err = client.Agent().NodeName()
 		if err == nil {
 			break
 		}
-		log.Error("Error connecting to Consul agent: ", err)
-		log.Error("Retrying in 10s...")
-		time.Sleep(10 * time.Second)
+		retryConnect("connecting to Consul agent", err)
 	}
 
 	// Get datacenter info if it wasn't specified in the config
@@ -97,10 +154,8 @@ func main() {
 		agentInfo, err := client.Agent().Self()
 
 		for err != nil {
+			retryConnect("fetching datacenter from Consul", err)
 			agentInfo, err = client.Agent().Self()
-			log.Error("Error fetching datacenter from Consul: ", err)
-			log.Error("Retrying in 10s...")
-			time.Sleep(10 * time.Second)
 		}
 
 		config.ConsulDatacenter = agentInfo["Config"]["Datacenter"].(string)
@@ -111,35 +166,91 @@ func main() {
 		registerTestServices(client)
 	}
 
+	if config.HTTPAddress != "" {
+		if err := serveHTTP(config, client); err != nil {
+			fatal(exitPartialStartup, err)
+		}
+	}
+
 	shutdownOpts := &ShutdownOpts{
 		stopCh: make(chan struct{}, 0),
 	}
 
+	// Periodically probe the handlers, so alerts can skip to a fallback while one is down
+	if config.HandlerProbeInterval > 0 {
+		go runHandlerProbes(config)
+	}
+
+	// Escalate the alerts we're responsible for that go unacknowledged
+	if len(config.Escalations) > 0 {
+		go runEscalations(config, client)
+	}
+
+	// Periodically make sure the alerts we're responsible for match the live health in Consul
+	if config.ConsistencyCheckInterval > 0 {
+		go runConsistencyChecks(config, client)
+	}
+
 	go discoverServices(nodeName, config, shutdownOpts, client)
+	readyLoops := []string{serviceDiscoveryLoop}
 
 	// If NodeWatch is set to global mode, monitor the catalog for new nodes
 	if config.NodeWatch == GlobalMode {
 		log.Info("Discovering nodes from catalog")
 		go discoverNodes(config, shutdownOpts, client)
+		readyLoops = append(readyLoops, nodeDiscoveryLoop)
 	} else {
 		log.Infof("Monitoring local node (%s)'s checks", nodeName)
 		// We're in local mode so we don't need to discover the local node; it won't change
 		opts := &WatchOptions{
-			node:   nodeName,
-			config: config,
-			client: client,
-			stopCh: shutdownOpts.stopCh,
+			node:     nodeName,
+			config:   config,
+			client:   client,
+			stopCh:   shutdownOpts.stopCh,
+			shutdown: shutdownOpts,
 		}
-		shutdownOpts.count++
-		go watch(opts)
+		go superviseWatch(opts)
+	}
+
+	// Apply the silences deploy tooling stores in the KV store as they change
+	if config.SilenceKVPrefix != "" {
+		go watchKVSilences(config, client)
+		readyLoops = append(readyLoops, silenceWatchLoop)
+	}
+
+	// Watch Nomad's jobs alongside the Consul services if it's configured
+	if config.NomadAddress != "" {
+		go watchNomad(config, client, shutdownOpts)
 	}
 
-	// Set up signal handling for graceful shutdown
+	// Let systemd know we're up once the initial discovery has finished, and start
+	// pinging its watchdog if it asked us to
+	go func() {
+		heartbeats.waitFor(readyLoops...)
+		inheritedState.complete()
+		if err := sdNotify("READY=1"); err != nil {
+			log.Error("Error notifying systemd of readiness: ", err)
+		}
+	}()
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go sdWatchdog(interval)
+	}
+
+	// Set up signal handling for graceful shutdown. Only listen for the signals we act on, so
+	// supervisors (and the Go runtime) sending us other signals don't cause any noise.
 	c := make(chan os.Signal, 1)
 
-	signal.Notify(c)
+	signal.Notify(c, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT}, handoffSignals...)...)
 
 	for sig := range c {
+		if containsSignal(handoffSignals, sig) {
+			if err := handoff(); err != nil {
+				log.Error("Handoff to new process failed, continuing: ", err)
+				continue
+			}
+			os.Exit(0)
+		}
+
 		switch sig {
 		case syscall.SIGINT:
 			shutdown(client, config, shutdownOpts)
@@ -156,14 +267,61 @@ func main() {
 	}
 }
 
+func containsSignal(signals []os.Signal, sig os.Signal) bool {
+	for _, s := range signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates a Consul client using the address and token from the config
+func newConsulClient(config *Config) (*api.Client, error) {
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = config.ConsulAddress
+	addressSplit := strings.Split(config.ConsulAddress, "://")
+	if len(addressSplit) > 1 {
+		clientConfig.Address = addressSplit[1]
+		clientConfig.Scheme = addressSplit[0]
+	}
+	clientConfig.Token = config.ConsulToken
+
+	log.Infof("Using Consul agent at %s", clientConfig.Address)
+	return api.NewClient(clientConfig)
+}
+
 // Used to shutdown gracefully by releasing any held locks
 type ShutdownOpts struct {
+	sync.Mutex
 	stopCh chan struct{}
-	count  int
+
+	// The number of watches running with a lock set up, each of which receives twice from
+	// stopCh when shutting down: once to stop, and once after releasing its lock
+	count int
+}
+
+// Adjusts the count of running watches. Safe to call on a nil ShutdownOpts, for watches
+// started outside of the daemon.
+func (s *ShutdownOpts) add(delta int) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.count += delta
+	s.Unlock()
+}
+
+// Returns the number of running watches
+func (s *ShutdownOpts) running() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.count
 }
 
 func shutdown(client *api.Client, config *Config, opts *ShutdownOpts) {
 	log.Info("Got interrupt signal, shutting down")
+	sdNotify("STOPPING=1")
 	if config.DevMode {
 		client.Agent().CheckDeregister("memory usage")
 		client.Agent().ServiceDeregister("redis")
@@ -171,10 +329,22 @@ func shutdown(client *api.Client, config *Config, opts *ShutdownOpts) {
 	}
 
 	log.Info("Releasing locks...")
-	for i := 0; i < opts.count*2; i++ {
+	// Watches uncount themselves as they exit, so take the count before stopping any
+	count := opts.running()
+	for i := 0; i < count*2; i++ {
 		opts.stopCh <- struct{}{}
 	}
 
+	// Hold off any alerts still being sent, and move the ones waiting for a worker, in a
+	// digest or held for quiet hours to the retry queue, since they're already recorded as
+	// delivered. The lock is never released, as we're exiting.
+	handoffLock.Lock()
+	dispatcher.drain()
+	digests.drain()
+	quietHolds.drain()
+	retryQueue.persist()
+
+	removePidFile(config.PidFile)
 	os.Exit(0)
 }
 
@@ -191,7 +361,7 @@ func registerTestServices(client *api.Client) {
 			case 2:
 				health = "fail"
 			}
-			err := client.Agent().UpdateTTL(name, "example " + health + "ing check output", health)
+			err := client.Agent().UpdateTTL(name, "example "+health+"ing check output", health)
 			if err != nil {
 				log.Error(err)
 			}
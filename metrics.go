@@ -0,0 +1,35 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// Counters for the daemon's notification pipeline, published through expvar
+var metrics = expvar.NewMap("consul_alerting")
+
+// Metric names
+const metricNotificationsSent = "notifications_sent"
+const metricNotificationsFailed = "notifications_failed"
+const metricNotificationsDeadLettered = "notifications_dead_lettered"
+const metricBreakerTrips = "circuit_breaker_trips"
+const metricNotificationsRateLimited = "notifications_rate_limited"
+const metricNotificationsSilenced = "notifications_silenced"
+const metricWatchPanics = "watch_panics"
+
+// Writes all published expvar variables as a JSON object, the same as the standard
+// /debug/vars handler
+func metricsEndpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/mapstructure"
+)
+
+// AlertMiddleware processes an alert on its way to a handler. Each handler's middleware runs
+// in the order it's configured, on the handler's own copy of the alert, before the handler's
+// templates are rendered. Process can change the alert, or return false to stop it being
+// sent to the handler at all.
+type AlertMiddleware interface {
+	Process(alert *AlertState, now time.Time) bool
+}
+
+// The middleware that can be used in a handler's pipeline, by type. Each takes the settings
+// from its entry in the handler's middleware list.
+var middlewareTypes = map[string]func(map[string]interface{}) (AlertMiddleware, error){
+	"redact":   newRedactMiddleware,
+	"inject":   newInjectMiddleware,
+	"filter":   newFilterMiddleware,
+	"throttle": newThrottleMiddleware,
+}
+
+// Builds a handler's middleware pipeline from its config, in order
+func parseMiddleware(configs []map[string]interface{}) ([]AlertMiddleware, error) {
+	var pipeline []AlertMiddleware
+	for i, m := range configs {
+		middlewareType, _ := m["type"].(string)
+		build, ok := middlewareTypes[middlewareType]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q for middleware %d", middlewareType, i+1)
+		}
+		delete(m, "type")
+
+		middleware, err := build(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s middleware: %s", middlewareType, err)
+		}
+		pipeline = append(pipeline, middleware)
+	}
+	return pipeline, nil
+}
+
+// Runs the alert through the pipeline, returning false if any middleware filtered it out
+func runMiddleware(pipeline []AlertMiddleware, alert *AlertState, now time.Time) bool {
+	for _, middleware := range pipeline {
+		if !middleware.Process(alert, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// Replaces text matching any of the patterns in the alert's message, details and check
+// output, e.g. to keep credentials that show up in check output out of chat channels
+type RedactMiddleware struct {
+	Patterns    []string `mapstructure:"patterns"`
+	Replacement string   `mapstructure:"replacement"`
+
+	patterns []*regexp.Regexp
+}
+
+func newRedactMiddleware(m map[string]interface{}) (AlertMiddleware, error) {
+	r := &RedactMiddleware{Replacement: "[REDACTED]"}
+	if err := mapstructure.WeakDecode(m, r); err != nil {
+		return nil, err
+	}
+	if len(r.Patterns) == 0 {
+		return nil, errors.New("no patterns given")
+	}
+	for _, pattern := range r.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+func (r *RedactMiddleware) Process(alert *AlertState, now time.Time) bool {
+	redact := func(s string) string {
+		for _, re := range r.patterns {
+			s = re.ReplaceAllString(s, r.Replacement)
+		}
+		return s
+	}
+
+	alert.Message = redact(alert.Message)
+	alert.Details = redact(alert.Details)
+	checks := make([]AlertCheck, len(alert.Checks))
+	for i, check := range alert.Checks {
+		check.Output = redact(check.Output)
+		checks[i] = check
+	}
+	alert.Checks = checks
+	return true
+}
+
+// Adds fixed fields to the alert, e.g. the owning team, which templates can use as .Fields
+// and handlers that send the alert as JSON include
+type InjectMiddleware struct {
+	Fields map[string]string `mapstructure:"fields"`
+}
+
+func newInjectMiddleware(m map[string]interface{}) (AlertMiddleware, error) {
+	i := &InjectMiddleware{}
+	if err := mapstructure.WeakDecode(m, i); err != nil {
+		return nil, err
+	}
+	if len(i.Fields) == 0 {
+		return nil, errors.New("no fields given")
+	}
+	return i, nil
+}
+
+func (i *InjectMiddleware) Process(alert *AlertState, now time.Time) bool {
+	fields := make(map[string]string, len(alert.Fields)+len(i.Fields))
+	for key, value := range alert.Fields {
+		fields[key] = value
+	}
+	for key, value := range i.Fields {
+		fields[key] = value
+	}
+	alert.Fields = fields
+	return true
+}
+
+// Only lets through alerts that match, using the same settings as a route block. With
+// exclude set, the alerts that match are dropped instead.
+type FilterMiddleware struct {
+	AlertMatcher `mapstructure:",squash"`
+
+	Exclude bool `mapstructure:"exclude"`
+}
+
+func newFilterMiddleware(m map[string]interface{}) (AlertMiddleware, error) {
+	f := &FilterMiddleware{}
+	if err := mapstructure.WeakDecode(m, f); err != nil {
+		return nil, err
+	}
+	if err := f.AlertMatcher.validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FilterMiddleware) Process(alert *AlertState, now time.Time) bool {
+	return f.matches(alert.Service, alert) != f.Exclude
+}
+
+// Drops alerts for a node/service that come less than the window after the last one sent
+// for it. An alert resolving one that was sent is always let through, so incidents opened
+// by the handler still get closed.
+type ThrottleMiddleware struct {
+	Window int `mapstructure:"window"`
+
+	sync.Mutex
+	// The time and status of the last alert let through for each node/service
+	last map[string]throttledAlert
+}
+
+type throttledAlert struct {
+	at     time.Time
+	status string
+}
+
+func newThrottleMiddleware(m map[string]interface{}) (AlertMiddleware, error) {
+	t := &ThrottleMiddleware{last: make(map[string]throttledAlert)}
+	if err := mapstructure.WeakDecode(m, t); err != nil {
+		return nil, err
+	}
+	if t.Window <= 0 {
+		return nil, errors.New("window must be greater than 0")
+	}
+	return t, nil
+}
+
+func (t *ThrottleMiddleware) Process(alert *AlertState, now time.Time) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	key := alertTargetKey(alert)
+	last, ok := t.last[key]
+	resolves := alert.Status == api.HealthPassing && last.status != api.HealthPassing
+	if ok && !resolves && now.Sub(last.at) < time.Duration(t.Window)*time.Second {
+		return false
+	}
+	t.last[key] = throttledAlert{at: now, status: alert.Status}
+	return true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Make sure a handler's middleware runs in order on its own copy of the alert
+func TestMiddleware_pipeline(t *testing.T) {
+	config, err := ParseConfig(`
+	handler "stdout" "ops" {
+		middleware = [
+			{ type = "filter", service = "redis*" },
+			{ type = "redact", patterns = ["password=\\S+"] },
+			{ type = "inject", fields = { team = "storage" } },
+		]
+		title_template = "{{.Fields.team}}: {{.Message}}"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alertCh := make(chan *AlertState, 1)
+	config.Handlers["stdout.ops"] = testHandler{alertCh}
+
+	alert := &AlertState{
+		Service: "redis",
+		Status:  "critical",
+		Message: "redis is critical",
+		Checks:  []AlertCheck{{Name: "auth", Output: "login failed for password=hunter2"}},
+	}
+	if err := callHandler(config, "stdout.ops", alert); err != nil {
+		t.Fatal(err)
+	}
+	sent := <-alertCh
+	if sent.Message != "storage: redis is critical" {
+		t.Errorf("unexpected message %q", sent.Message)
+	}
+	if sent.Checks[0].Output != "login failed for [REDACTED]" {
+		t.Errorf("expected the check output to be redacted, got %q", sent.Checks[0].Output)
+	}
+	if alert.Checks[0].Output != "login failed for password=hunter2" || alert.Fields != nil {
+		t.Error("expected the original alert to be left alone")
+	}
+
+	// Alerts the filter doesn't match aren't sent
+	if err := callHandler(config, "stdout.ops", &AlertState{Service: "web", Status: "critical"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(alertCh) != 0 {
+		t.Error("expected the web alert to be filtered out")
+	}
+
+	if _, err := ParseConfig(`handler "stdout" "ops" { middleware = [{ type = "compress" }] }`); err == nil {
+		t.Error("expected an error for an unknown middleware type")
+	}
+}
+
+func TestMiddleware_throttle(t *testing.T) {
+	throttle, err := newThrottleMiddleware(map[string]interface{}{"window": 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	var sent []string
+	for _, step := range []struct {
+		status string
+		after  time.Duration
+	}{
+		{"critical", 0},
+		{"warning", 10 * time.Second},
+		{"passing", 20 * time.Second},
+		{"critical", 30 * time.Second},
+		{"critical", 90 * time.Second},
+	} {
+		if throttle.Process(&AlertState{Service: "redis", Status: step.status}, now.Add(step.after)) {
+			sent = append(sent, step.status)
+		}
+	}
+
+	// The warning is throttled, the recovery always goes through, and the next critical has
+	// to wait out the window after it
+	expected := []string{"critical", "passing", "critical"}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("expected %v to be let through, got %v", expected, sent)
+	}
+}
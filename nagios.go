@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The host passive results for service alerts are submitted under by default, since
+// a service's checks span many nodes
+const nagiosDefaultHost = "consul"
+
+// NagiosTarget maps alerts onto Nagios hosts and services. Node alerts become host check
+// results for the node, and service alerts become service check results on a single host.
+type NagiosTarget struct {
+	Host string `mapstructure:"host"`
+}
+
+// Returns the Nagios host and service description for an alert. The description is empty
+// for host check results.
+func (n NagiosTarget) target(alert *AlertState) (string, string) {
+	if alert.Service == "" {
+		return alert.Node, ""
+	}
+
+	host := n.Host
+	if host == "" {
+		host = nagiosDefaultHost
+	}
+	if alert.Tag != "" {
+		return host, alert.Service + ":" + alert.Tag
+	}
+	return host, alert.Service
+}
+
+// Returns the Nagios return code for a Consul health status
+func nagiosState(status string) int {
+	switch status {
+	case api.HealthPassing:
+		return 0
+	case api.HealthWarning:
+		return 1
+	case api.HealthCritical:
+		return 2
+	}
+	return 3
+}
+
+// Returns the plugin output for an alert, on one line since that's all Nagios shows
+// in most places
+func nagiosOutput(alert *AlertState) string {
+	output := alert.Message
+	if alert.Details != "" {
+		output = output + " - " + strings.Replace(strings.TrimSpace(alert.Details), "\n", " ", -1)
+	}
+	return output
+}
+
+// The sizes of the fields in an NSCA data packet, as compiled into NSCA 2.x by default
+const (
+	nscaIVSize          = 128
+	nscaHostSize        = 64
+	nscaDescriptionSize = 128
+	nscaOutputSize      = 512
+	nscaPacketSize      = 2 + 2 + 4 + 4 + 2 + nscaHostSize + nscaDescriptionSize + nscaOutputSize + 2
+)
+
+// NSCAHandler submits passive check results to an NSCA daemon, to feed an existing Nagios
+// or Icinga install. Only the "none" and "xor" encryption methods are supported.
+type NSCAHandler struct {
+	NagiosTarget `mapstructure:",squash"`
+
+	Address    string `mapstructure:"address"`
+	Encryption string `mapstructure:"encryption"`
+	Password   string `mapstructure:"password"`
+}
+
+// Returns the encoded data packet for a check result, before encryption
+func nscaPacket(host, description string, state int, output string, timestamp uint32) []byte {
+	packet := make([]byte, nscaPacketSize)
+	binary.BigEndian.PutUint16(packet[0:], 3) // packet version
+	binary.BigEndian.PutUint32(packet[8:], timestamp)
+	binary.BigEndian.PutUint16(packet[12:], uint16(state))
+
+	// Strings are null-terminated within their fixed-size fields
+	offset := 14
+	for _, field := range []struct {
+		value string
+		size  int
+	}{{host, nscaHostSize}, {description, nscaDescriptionSize}, {output, nscaOutputSize}} {
+		value := field.value
+		if len(value) > field.size-1 {
+			value = value[:field.size-1]
+		}
+		copy(packet[offset:], value)
+		offset += field.size
+	}
+
+	binary.BigEndian.PutUint32(packet[4:], crc32.ChecksumIEEE(packet))
+	return packet
+}
+
+// Applies NSCA's XOR encryption (which is its own inverse) to a packet, using the IV the
+// server sent and the password
+func nscaXOR(packet, iv []byte, password string) {
+	for i := range packet {
+		packet[i] ^= iv[i%len(iv)]
+	}
+	if password == "" {
+		return
+	}
+	for i := range packet {
+		packet[i] ^= password[i%len(password)]
+	}
+}
+
+func (n NSCAHandler) Alert(ctx context.Context, alert *AlertState) error {
+	// Meta-alerts aren't about the host or service's health, so there's no result to submit
+	if alert.Meta {
+		return nil
+	}
+	if err := n.send(ctx, alert); err != nil {
+		return fmt.Errorf("Error sending alert to NSCA (%s): %s", n.Address, err)
+	}
+	return nil
+}
+
+func (n NSCAHandler) send(ctx context.Context, alert *AlertState) error {
+	connectTimeout, _ := ctx.Value(connectTimeoutKey).(time.Duration)
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.Dial("tcp", n.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// The server starts by sending the IV to encrypt with, and its timestamp
+	init := make([]byte, nscaIVSize+4)
+	if _, err := io.ReadFull(conn, init); err != nil {
+		return fmt.Errorf("error reading initialization packet: %s", err)
+	}
+
+	host, description := n.target(alert)
+	packet := nscaPacket(host, description, nagiosState(alert.Status), nagiosOutput(alert), binary.BigEndian.Uint32(init[nscaIVSize:]))
+	if n.Encryption == "xor" {
+		nscaXOR(packet, init[:nscaIVSize], n.Password)
+	}
+
+	_, err = conn.Write(packet)
+	return err
+}
+
+func (n NSCAHandler) Validate() error {
+	if n.Address == "" {
+		return errors.New("no address given")
+	}
+	if _, _, err := net.SplitHostPort(n.Address); err != nil {
+		return fmt.Errorf("invalid address %q, must be host:port", n.Address)
+	}
+	switch n.Encryption {
+	case "none", "xor":
+	default:
+		return fmt.Errorf("unsupported encryption %q, must be none or xor", n.Encryption)
+	}
+	return nil
+}
+
+// NRDPHandler submits passive check results to an NRDP server over HTTP
+type NRDPHandler struct {
+	NagiosTarget `mapstructure:",squash"`
+
+	URL   string `mapstructure:"url"`
+	Token string `mapstructure:"token"`
+}
+
+// The XML check results NRDP's submitcheck command takes
+type nrdpCheckResults struct {
+	XMLName xml.Name          `xml:"checkresults"`
+	Results []nrdpCheckResult `xml:"checkresult"`
+}
+
+type nrdpCheckResult struct {
+	Type        string `xml:"type,attr"`
+	Host        string `xml:"hostname"`
+	Description string `xml:"servicename,omitempty"`
+	State       int    `xml:"state"`
+	Output      string `xml:"output"`
+}
+
+func (n NRDPHandler) Alert(ctx context.Context, alert *AlertState) error {
+	if alert.Meta {
+		return nil
+	}
+	host, description := n.target(alert)
+	result := nrdpCheckResult{
+		Type:        "service",
+		Host:        host,
+		Description: description,
+		State:       nagiosState(alert.Status),
+		Output:      nagiosOutput(alert),
+	}
+	if description == "" {
+		result.Type = "host"
+	}
+
+	data, err := xml.Marshal(nrdpCheckResults{Results: []nrdpCheckResult{result}})
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set("token", n.Token)
+	form.Set("cmd", "submitcheck")
+	form.Set("XMLDATA", string(data))
+
+	req, err := http.NewRequest("POST", n.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Error sending alert to NRDP: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// NRDP responds with 200 even when it rejects the submission, with the status in the body
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	var response struct {
+		Status  int    `xml:"status"`
+		Message string `xml:"message"`
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Error sending alert to NRDP: got response code %d (%s)", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := xml.Unmarshal(body, &response); err == nil && response.Status != 0 {
+		return fmt.Errorf("Error sending alert to NRDP: %s", response.Message)
+	}
+	return nil
+}
+
+func (n NRDPHandler) Validate() error {
+	if n.URL == "" {
+		return errors.New("no url given")
+	}
+	if n.Token == "" {
+		return errors.New("no token given")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNagios_target(t *testing.T) {
+	target := NagiosTarget{}
+	if host, description := target.target(&AlertState{Node: "node1"}); host != "node1" || description != "" {
+		t.Errorf("expected a host result for node1, got %q %q", host, description)
+	}
+	target.Host = "consul-dc1"
+	if host, description := target.target(&AlertState{Service: "redis", Tag: "alpha"}); host != "consul-dc1" || description != "redis:alpha" {
+		t.Errorf("expected a service result on consul-dc1, got %q %q", host, description)
+	}
+}
+
+func TestNagios_nsca(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	iv := bytes.Repeat([]byte{0x5a, 0x13}, nscaIVSize/2)
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		init := make([]byte, nscaIVSize+4)
+		copy(init, iv)
+		binary.BigEndian.PutUint32(init[nscaIVSize:], 1500000000)
+		conn.Write(init)
+
+		packet := make([]byte, nscaPacketSize)
+		io.ReadFull(conn, packet)
+		received <- packet
+	}()
+
+	handler := NSCAHandler{Address: listener.Addr().String(), Encryption: "xor", Password: "secret"}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	alert := &AlertState{Status: "critical", Service: "redis", Message: "redis is critical", Details: "Failing checks:\nping"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	packet := <-received
+	nscaXOR(packet, iv, "secret")
+
+	crc := binary.BigEndian.Uint32(packet[4:])
+	binary.BigEndian.PutUint32(packet[4:], 0)
+	if crc32.ChecksumIEEE(packet) != crc {
+		t.Errorf("bad packet checksum")
+	}
+	if version, timestamp, state := binary.BigEndian.Uint16(packet), binary.BigEndian.Uint32(packet[8:]), binary.BigEndian.Uint16(packet[12:]); version != 3 || timestamp != 1500000000 || state != 2 {
+		t.Errorf("unexpected packet header: version %d, timestamp %d, state %d", version, timestamp, state)
+	}
+
+	field := func(offset, size int) string {
+		return strings.TrimRight(string(packet[offset:offset+size]), "\x00")
+	}
+	host := field(14, nscaHostSize)
+	description := field(14+nscaHostSize, nscaDescriptionSize)
+	output := field(14+nscaHostSize+nscaDescriptionSize, nscaOutputSize)
+	if host != "consul" || description != "redis" || output != "redis is critical - Failing checks: ping" {
+		t.Errorf("unexpected check result: %q %q %q", host, description, output)
+	}
+}
+
+func TestNagios_nrdp(t *testing.T) {
+	var token, data string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		token, data = r.PostForm.Get("token"), r.PostForm.Get("XMLDATA")
+		w.Write([]byte("<result><status>0</status><message>OK</message></result>"))
+	}))
+	defer server.Close()
+
+	handler := NRDPHandler{URL: server.URL, Token: "token"}
+	if err := handler.Alert(context.Background(), &AlertState{Status: "warning", Node: "node1", Message: "node1 is warning"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<checkresults><checkresult type="host"><hostname>node1</hostname><state>1</state><output>node1 is warning</output></checkresult></checkresults>`
+	if token != "token" || data != expected {
+		t.Errorf("unexpected submission with token %q: %s", token, data)
+	}
+}
+
+func TestNagios_nrdpRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<result><status>-1</status><message>BAD TOKEN</message></result>"))
+	}))
+	defer server.Close()
+
+	err := NRDPHandler{URL: server.URL, Token: "wrong"}.Alert(context.Background(), &AlertState{Status: "critical", Node: "node1"})
+	if err == nil || !strings.Contains(err.Error(), "BAD TOKEN") {
+		t.Errorf("expected rejection error, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// The ID of the check Consul uses for a node's agent being reachable
+const serfHealthCheckID = "serfHealth"
+
+// How often a service alert held back because its nodes are down is checked again
+const nodeDownRecheckInterval = 30 * time.Second
+
+// Returns true if the node's serfHealth check is critical, i.e. its agent is unreachable
+func nodeDown(checks []*api.HealthCheck) bool {
+	for _, check := range checks {
+		if check.CheckID == serfHealthCheckID && check.ServiceID == "" {
+			return check.Status == api.HealthCritical
+		}
+	}
+	return false
+}
+
+// Returns the down nodes a failing service alert is about, if every node it has failing
+// checks on is down, so the alert can be held back in favor of the alerts for the nodes.
+// Returns nil if node_down_inhibit isn't set or any failing check is on a node that's up.
+func inhibitingNodes(config *Config, client *api.Client, alert *AlertState) []string {
+	if !config.NodeDownInhibit || alert.Service == "" || len(alert.Checks) == 0 {
+		return nil
+	}
+
+	nodes := make([]string, 0)
+	for _, check := range alert.Checks {
+		if contains(nodes, check.Node) {
+			continue
+		}
+		checks, _, err := client.Health().Node(check.Node, nil)
+		if err != nil {
+			log.Warnf("Error checking whether node %s is down: %s", check.Node, err)
+			return nil
+		}
+		if !nodeDown(checks) {
+			return nil
+		}
+		nodes = append(nodes, check.Node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Returns a line for a node alert's details listing the services on the node, if its agent
+// is down and node_down_inhibit is set, since their alerts are held back while it's down
+func nodeDownDetails(config *Config, client *api.Client, node string, checks []*api.HealthCheck) string {
+	if !config.NodeDownInhibit || !nodeDown(checks) {
+		return ""
+	}
+
+	catalogNode, _, err := client.Catalog().Node(node, nil)
+	if err != nil || catalogNode == nil {
+		return ""
+	}
+	services := make([]string, 0, len(catalogNode.Services))
+	for _, service := range catalogNode.Services {
+		if !contains(services, service.Service) {
+			services = append(services, service.Service)
+		}
+	}
+	if len(services) == 0 {
+		return ""
+	}
+	sort.Strings(services)
+	return fmt.Sprintf("Affected services: %s", strings.Join(services, ", "))
+}
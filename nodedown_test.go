@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestNodeDown_nodeDown(t *testing.T) {
+	cases := []struct {
+		checks []*api.HealthCheck
+		down   bool
+	}{
+		{[]*api.HealthCheck{{CheckID: "serfHealth", Status: api.HealthCritical}}, true},
+		{[]*api.HealthCheck{{CheckID: "serfHealth", Status: api.HealthPassing}, {CheckID: "disk", Status: api.HealthCritical}}, false},
+		{[]*api.HealthCheck{{CheckID: "serfHealth", ServiceID: "web", Status: api.HealthCritical}}, false},
+		{nil, false},
+	}
+	for i, tc := range cases {
+		if down := nodeDown(tc.checks); down != tc.down {
+			t.Errorf("case %d: expected down to be %v", i, tc.down)
+		}
+	}
+}
+
+// Make sure a service alert is only held back when every node it's failing on is down
+func TestNodeDown_inhibitingNodes(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	config := DefaultConfig()
+	alert := &AlertState{Service: "redis", Status: api.HealthCritical, Checks: []AlertCheck{{Node: server.Config.NodeName, CheckID: "redis"}}}
+	if nodes := inhibitingNodes(config, client, alert); nodes != nil {
+		t.Errorf("expected no inhibition without node_down_inhibit, got %v", nodes)
+	}
+
+	// The test server's own node is up
+	config.NodeDownInhibit = true
+	if nodes := inhibitingNodes(config, client, alert); nodes != nil {
+		t.Errorf("expected no inhibition while the node is up, got %v", nodes)
+	}
+
+	// Once the alert's only node has a critical serfHealth check, it's held back for that node
+	_, err := client.Catalog().Register(&api.CatalogRegistration{
+		Node:    "db1",
+		Address: "127.0.0.1",
+		Check:   &api.AgentCheck{Node: "db1", CheckID: "serfHealth", Name: "Serf Health Status", Status: api.HealthCritical},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := &AlertState{Service: "redis", Status: api.HealthCritical, Checks: []AlertCheck{{Node: "db1", CheckID: "redis"}}}
+	if nodes := inhibitingNodes(config, client, down); !reflect.DeepEqual(nodes, []string{"db1"}) {
+		t.Errorf("expected the alert to be held back for node db1, got %v", nodes)
+	}
+
+	// It isn't held back while it's also failing on a node that's up
+	down.Checks = append(down.Checks, AlertCheck{Node: server.Config.NodeName, CheckID: "redis"})
+	if nodes := inhibitingNodes(config, client, down); nodes != nil {
+		t.Errorf("expected no inhibition while one of the nodes is up, got %v", nodes)
+	}
+}
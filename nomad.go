@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+// The name of the Nomad watcher, used for its lock, heartbeats and status
+const nomadWatchName = "nomad jobs"
+
+// The subset of a Nomad job stub (from /v1/jobs) that we alert on
+type NomadJob struct {
+	ID     string
+	Name   string
+	Type   string
+	Status string
+	Stop   bool
+}
+
+// The subset of a Nomad allocation stub that we alert on
+type NomadAllocation struct {
+	ID             string
+	Name           string
+	NodeID         string
+	TaskGroup      string
+	DesiredStatus  string
+	ClientStatus   string
+	NextAllocation string
+}
+
+// The subset of a Nomad deployment that we alert on
+type NomadDeployment struct {
+	ID                string
+	Status            string
+	StatusDescription string
+}
+
+// A minimal client for the parts of the Nomad HTTP API we need
+type NomadClient struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+func newNomadClient(config *Config) *NomadClient {
+	return &NomadClient{
+		address: strings.TrimRight(config.NomadAddress, "/"),
+		token:   config.NomadToken,
+		client:  cleanhttp.DefaultClient(),
+	}
+}
+
+// Does a GET request against the Nomad API, decoding the response into out. If index is
+// non-zero the request blocks for up to watchWaitTime until the data changes past it.
+// Returns the index of the response.
+func (n *NomadClient) get(path string, index uint64, out interface{}) (uint64, error) {
+	query := url.Values{}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(watchWaitTime.Seconds())))
+	}
+
+	req, err := http.NewRequest("GET", n.address+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if n.token != "" {
+		req.Header.Set("X-Nomad-Token", n.token)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("Unexpected response code from Nomad: %d (%s)", resp.StatusCode, path)
+	}
+
+	// Deployment lookups return null if the job has never had one, which leaves out as nil
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("Error decoding Nomad response for %s: %s", path, err)
+	}
+
+	newIndex, _ := strconv.ParseUint(resp.Header.Get("X-Nomad-Index"), 10, 64)
+	return newIndex, nil
+}
+
+// Returns the health of a Nomad job along with the details of what's wrong with it. Jobs
+// that are dead (but not stopped on purpose), or that have failed allocations which weren't
+// replaced, are critical. Deployments that have been running for longer than the threshold
+// are a warning.
+func nomadJobHealth(job NomadJob, allocs []NomadAllocation, deployment *NomadDeployment, deployingFor, threshold time.Duration) (string, string) {
+	status := api.HealthPassing
+	details := make([]string, 0)
+
+	if job.Status == "dead" && !job.Stop && job.Type != "batch" {
+		status = api.HealthCritical
+		details = append(details, fmt.Sprintf("=> Job %s is dead", job.ID))
+	}
+
+	for _, alloc := range allocs {
+		if alloc.ClientStatus == "failed" && alloc.DesiredStatus == "run" && alloc.NextAllocation == "" {
+			status = api.HealthCritical
+			details = append(details, fmt.Sprintf("=> (allocation) %s failed on node %s", alloc.Name, alloc.NodeID))
+		}
+	}
+
+	if deployment != nil && deployment.Status == "running" && threshold > 0 && deployingFor > threshold {
+		if status == api.HealthPassing {
+			status = api.HealthWarning
+		}
+		details = append(details, fmt.Sprintf("=> (deployment) %s has been running for %s: %s",
+			deployment.ID, deployingFor-deployingFor%time.Second, deployment.StatusDescription))
+	}
+
+	if len(details) == 0 {
+		return status, ""
+	}
+	return status, "Problems:\n" + strings.Join(details, "\n")
+}
+
+// Returns the KV path used to store the alert state for a Nomad job
+func nomadAlertPath(job string) string {
+	return alertingKVRoot + "/nomad/" + job + "/alert"
+}
+
+// A job's allocations and current deployment, fetched from Nomad
+type nomadJobState struct {
+	job        NomadJob
+	allocs     []NomadAllocation
+	deployment *NomadDeployment
+}
+
+// Fetches the allocations and deployment of each job, skipping (and logging) any jobs
+// whose requests fail
+func (n *NomadClient) jobStates(jobs []NomadJob) []nomadJobState {
+	states := make([]nomadJobState, 0, len(jobs))
+	for _, job := range jobs {
+		state := nomadJobState{job: job}
+
+		if _, err := n.get("/v1/job/"+url.PathEscape(job.ID)+"/allocations", 0, &state.allocs); err != nil {
+			log.Errorf("Error getting allocations for Nomad job %s: %s", job.ID, err)
+			continue
+		}
+
+		if _, err := n.get("/v1/job/"+url.PathEscape(job.ID)+"/deployment", 0, &state.deployment); err != nil {
+			log.Errorf("Error getting deployment for Nomad job %s: %s", job.ID, err)
+			continue
+		}
+
+		states = append(states, state)
+	}
+	return states
+}
+
+// Watches the jobs in Nomad while holding the Nomad lock, alerting through the handlers for
+// the service with the same name as each job when it becomes unhealthy
+func watchNomad(config *Config, client *api.Client, shutdownOpts *ShutdownOpts) {
+	nomad := newNomadClient(config)
+	threshold := time.Duration(config.NomadDeploymentThreshold) * time.Second
+
+	state := newWatchState(nomadWatchName, "", "", "")
+
+	// The last computed health of each job, loaded from the stored alert state the first
+	// time we see the job after acquiring the lock
+	statuses := make(map[string]string)
+	var statusLock sync.Mutex
+
+	// The deployment each job is in the middle of, and when we first saw it running
+	deploying := make(map[string]*NomadDeployment)
+	deployingSince := make(map[string]time.Time)
+
+	lockPath := alertingKVRoot + "/nomad/leader"
+	apiLock, err := client.LockKey(lockPath)
+	if err != nil {
+		log.Errorf("Error initializing lock for %s, not starting watch: %s", nomadWatchName, err)
+		return
+	}
+
+	lock := LockHelper{
+		target: nomadWatchName,
+		path:   lockPath,
+		client: client,
+		lock:   apiLock,
+		stopCh: make(chan struct{}, 1),
+		lockCh: make(chan struct{}, 1),
+		callback: func() {
+			statusLock.Lock()
+			statuses = make(map[string]string)
+			statusLock.Unlock()
+		},
+	}
+	state.lock = &lock
+
+	// Each job is alerted on like a watch on the service of the same name
+	alertLock := &sync.Mutex{}
+	jobOpts := func(job string) *WatchOptions {
+		return &WatchOptions{
+			service:   job,
+			job:       job,
+			config:    config,
+			client:    client,
+			alertLock: alertLock,
+			state:     state,
+		}
+	}
+
+	if inherited := inheritedState.take(nomadWatchName); inherited != nil {
+		log.Infof("Resuming %s from previous process", nomadWatchName)
+		lock.session = inherited.Session
+		lock.sessionDone = inherited.sessionDone
+		for _, pending := range inherited.Pending {
+			pending := pending
+			go runRecovered("alert timer for "+nomadWatchName, func() {
+				resumeAlert(pending, jobOpts(pending.Alert.Service))
+			})
+		}
+	}
+
+	// Count the watch as running only once its lock is set up, like the Consul watches
+	shutdownOpts.add(1)
+	defer shutdownOpts.add(-1)
+
+	runningWatches.add(state)
+	go lock.start()
+
+	// Compares the jobs' health with their last known status and starts alert timers for any
+	// that changed, returning the jobs that were removed. Holds handoffLock throughout so a
+	// handoff can't miss a pending alert, releasing it with a defer in case of a panic.
+	processJobs := func(jobStates []nomadJobState) []string {
+		handoffLock.RLock()
+		defer handoffLock.RUnlock()
+
+		current := make(map[string]bool)
+		for _, jobState := range jobStates {
+			job := jobState.job
+			current[job.ID] = true
+
+			// Time deployments from when we first saw them running
+			var deployingFor time.Duration
+			deployment := jobState.deployment
+			if deployment != nil && deployment.Status == "running" {
+				if last, ok := deploying[job.ID]; !ok || last.ID != deployment.ID {
+					deploying[job.ID] = deployment
+					deployingSince[job.ID] = time.Now()
+				}
+				deployingFor = time.Since(deployingSince[job.ID])
+			} else {
+				delete(deploying, job.ID)
+				delete(deployingSince, job.ID)
+			}
+
+			status, details := nomadJobHealth(job, jobState.allocs, deployment, deployingFor, threshold)
+			name := "nomad job " + job.ID
+			path := nomadAlertPath(job.ID)
+
+			statusLock.Lock()
+			lastStatus, ok := statuses[job.ID]
+			if !ok {
+				lastStatus = api.HealthPassing
+				if stored, err := getAlertState(path, client); err == nil && stored != nil {
+					lastStatus = stored.Status
+				}
+			}
+			statuses[job.ID] = status
+			statusLock.Unlock()
+
+			if status != lastStatus {
+				alert := AlertState{
+					Service: job.ID,
+					Status:  status,
+					Message: alertMessage(config.ConsulDatacenter, name, status),
+					Details: details,
+				}
+				opts := jobOpts(job.ID)
+				if updateIndex, ok := queueAlert(path, alert, opts); ok {
+					go runRecovered("alert timer for "+name, func() { waitAlert(path, alert, updateIndex, opts) })
+				}
+			}
+		}
+
+		// Resolve the alerts of any jobs that were removed
+		statusLock.Lock()
+		defer statusLock.Unlock()
+		removed := make([]string, 0)
+		for job := range statuses {
+			if !current[job] {
+				removed = append(removed, job)
+				delete(statuses, job)
+				delete(deploying, job)
+				delete(deployingSince, job)
+			}
+		}
+		sort.Strings(removed)
+		return removed
+	}
+
+	log.Infof("Watching Nomad jobs at %s", nomad.address)
+	backoff := newBackoff()
+	var index uint64
+
+	for {
+		select {
+		case <-shutdownOpts.stopCh:
+			log.Infof("Shutting down watch for %s", nomadWatchName)
+			heartbeats.remove(nomadWatchName)
+			runningWatches.remove(nomadWatchName)
+			lock.stop()
+			<-shutdownOpts.stopCh
+			return
+		default:
+		}
+		heartbeats.beat(nomadWatchName)
+
+		if !lock.acquired {
+			index = 0
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		var jobs []NomadJob
+		newIndex, err := nomad.get("/v1/jobs", index, &jobs)
+		if err != nil {
+			wait := backoff.next()
+			state.Lock()
+			state.Error = err.Error()
+			state.Unlock()
+			log.Errorf("Error trying to watch %s: %s, retrying in %s...", nomadWatchName, err, wait)
+			time.Sleep(wait)
+			continue
+		}
+		backoff.reset()
+		state.Lock()
+		state.Error = ""
+		state.Unlock()
+
+		// Nomad indexes can go backwards when the cluster is restored from a snapshot
+		if newIndex < index {
+			newIndex = 0
+		}
+		index = newIndex
+
+		// Fetch the details of each job before taking handoffLock, so a slow Nomad can't
+		// hold up a handoff
+		removed := processJobs(nomad.jobStates(jobs))
+
+		for _, job := range removed {
+			log.Infof("Nomad job %s was removed", job)
+			resolveRemoved(nomadAlertPath(job), "nomad job "+job, jobOpts(job))
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestNomad_jobHealth(t *testing.T) {
+	running := NomadJob{ID: "web", Type: "service", Status: "running"}
+	deployment := &NomadDeployment{ID: "abc", Status: "running", StatusDescription: "Deployment is running"}
+
+	cases := []struct {
+		name         string
+		job          NomadJob
+		allocs       []NomadAllocation
+		deployingFor time.Duration
+		expected     string
+	}{
+		{"healthy", running, nil, 0, api.HealthPassing},
+		{"dead", NomadJob{ID: "web", Type: "service", Status: "dead"}, nil, 0, api.HealthCritical},
+		{"stopped", NomadJob{ID: "web", Type: "service", Status: "dead", Stop: true}, nil, 0, api.HealthPassing},
+		{"finished batch", NomadJob{ID: "backup", Type: "batch", Status: "dead"}, nil, 0, api.HealthPassing},
+		{"failed allocation", running, []NomadAllocation{{Name: "web.app[0]", DesiredStatus: "run", ClientStatus: "failed"}}, 0, api.HealthCritical},
+		{"replaced allocation", running, []NomadAllocation{{Name: "web.app[0]", DesiredStatus: "run", ClientStatus: "failed", NextAllocation: "def"}}, 0, api.HealthPassing},
+		{"slow deployment", running, nil, 20 * time.Minute, api.HealthWarning},
+		{"failed deployment", running, []NomadAllocation{{Name: "web.app[0]", DesiredStatus: "run", ClientStatus: "failed"}}, 20 * time.Minute, api.HealthCritical},
+	}
+
+	for _, c := range cases {
+		status, details := nomadJobHealth(c.job, c.allocs, deployment, c.deployingFor, 15*time.Minute)
+		if status != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, status)
+		}
+		if (status == api.HealthPassing) != (details == "") {
+			t.Errorf("%s: unexpected details for %s: %q", c.name, status, details)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// Settings that apply to every handler, regardless of its type
+type HandlerOptions struct {
+	// The time (in seconds) a single call to the handler can take before it's abandoned
+	Timeout int `mapstructure:"timeout"`
+
+	// The time (in seconds) to wait for a connection to the handler's endpoint
+	ConnectTimeout int `mapstructure:"connect_timeout"`
+
+	// Optional. The proxy HTTP-based handlers send requests through, as an http://,
+	// https:// or socks5:// URL, instead of the one from the HTTP_PROXY/HTTPS_PROXY env vars.
+	Proxy string `mapstructure:"proxy"`
+
+	// The number of failures in a row before the handler's circuit breaker opens. 0 disables
+	// the breaker.
+	BreakerThreshold int `mapstructure:"breaker_threshold"`
+
+	// The time (in seconds) to wait after the breaker opens before probing the handler again
+	BreakerCooldown int `mapstructure:"breaker_cooldown"`
+
+	// Optional. The time (in seconds) to collect the handler's alerts for before sending them
+	// as a single digest listing everything that changed. 0 sends each alert right away.
+	DigestWindow int `mapstructure:"digest_window"`
+
+	// Optional. If true, passing alerts that resolve an incident are sent to the handler as
+	// an explicit resolution, with how long the incident lasted and the checks that failed.
+	RecoveryDetails bool `mapstructure:"recovery_details"`
+
+	// Optional. The lowest severity (warning, unknown or critical) the handler is sent
+	// alerts for. Once it's been sent an alert for an incident, it gets the rest of the
+	// incident's alerts too, so it's told when the incident is resolved.
+	MinSeverity string `mapstructure:"min_severity"`
+
+	// Optional. A handler to send alerts to instead while this one's breaker is open or it's
+	// failing its health probe. BackupHandler is the older name for the same setting.
+	Fallback      string `mapstructure:"fallback"`
+	BackupHandler string `mapstructure:"backup_handler"`
+
+	// Optional. The number of alerts the handler can send a minute, and how many it can send
+	// in a burst (defaulting to the rate). What happens to alerts over the limit is set by
+	// the overflow: drop, queue (retry once there's room) or summary (send the held back
+	// alerts as one alert once there's room).
+	RateLimit         int    `mapstructure:"rate_limit"`
+	RateLimitBurst    int    `mapstructure:"rate_limit_burst"`
+	RateLimitOverflow string `mapstructure:"rate_limit_overflow"`
+
+	// Overrides for how failed alerts to the handler are retried. The max age and waits are
+	// in seconds, and 0 uses the global retry_max_age and the default waits. A max of 0
+	// attempts retries until the max age passes.
+	RetryMaxAttempts int `mapstructure:"retry_max_attempts"`
+	RetryMaxAge      int `mapstructure:"retry_max_age"`
+	RetryMinWait     int `mapstructure:"retry_min_wait"`
+	RetryMaxWait     int `mapstructure:"retry_max_wait"`
+
+	// Optional. Go templates to render the alert's message and details with for this
+	// handler, see AlertTemplateData.
+	TitleTemplate string `mapstructure:"title_template"`
+	BodyTemplate  string `mapstructure:"body_template"`
+
+	// Optional. Files to load the title/body templates from instead.
+	TitleTemplateFile string `mapstructure:"title_template_file"`
+	BodyTemplateFile  string `mapstructure:"body_template_file"`
+
+	// Other names for the body template and its file
+	MessageTemplate     string `mapstructure:"message_template"`
+	MessageTemplateFile string `mapstructure:"message_template_file"`
+
+	// Optional. When the handler is sent alerts. Passing alerts are sent at any time, so
+	// alerts sent during the schedule still get resolved.
+	Schedule *Schedule `mapstructure:"schedule"`
+
+	// Optional. The middleware to run alerts through before they're sent to the handler, in
+	// order, each with a type and its settings.
+	Middleware []map[string]interface{} `mapstructure:"middleware"`
+
+	titleTemplate *template.Template
+	bodyTemplate  *template.Template
+	middleware    []AlertMiddleware
+	proxyURL      *url.URL
+}
+
+// Returns the handler to send alerts to while this one is down, if any
+func (h HandlerOptions) fallbackHandler() string {
+	if h.Fallback != "" {
+		return h.Fallback
+	}
+	return h.BackupHandler
+}
+
+// Returns true if the alert meets the handler's min_severity: its severity is at least as
+// bad, or it's part of an incident that was
+func (h HandlerOptions) meetsSeverity(alert *AlertState) bool {
+	if h.MinSeverity == "" {
+		return true
+	}
+	meets := func(severity string) bool {
+		order, ok := severityOrder[severity]
+		return ok && order <= severityOrder[h.MinSeverity]
+	}
+	// Alerts saved before incident severities were tracked fall back to the last one sent
+	return meets(alertSeverity(alert.Severity, alert.Status)) || meets(alert.IncidentSeverity) ||
+		meets(alertSeverity(alert.LastSeverity, alert.LastAlerted))
+}
+
+type contextKey string
+
+// The context keys used to pass the handler's connect timeout and proxy to its Alert method
+const (
+	connectTimeoutKey contextKey = "connect_timeout"
+	proxyKey          contextKey = "proxy"
+)
+
+// Returns a context carrying the handler's connection settings, for calling or probing it
+func handlerContext(ctx context.Context, options HandlerOptions) context.Context {
+	ctx = context.WithValue(ctx, connectTimeoutKey, time.Duration(options.ConnectTimeout)*time.Second)
+	if options.proxyURL != nil {
+		ctx = context.WithValue(ctx, proxyKey, options.proxyURL)
+	}
+	return ctx
+}
+
+// Sends the alert to each handler it's routed to that it hasn't already been delivered
+// to, through the dispatcher if there is one, unless it's silenced. Calls delivered with
+// the name of each handler once it's been sent (or queued).
+func sendAlert(config *Config, service string, alert *AlertState, delivered func(handler string)) {
+	sendAlertTo(config, service, alert, config.alertHandlerNames(service, alert), delivered)
+}
+
+// Sends the alert to the given handlers the way sendAlert does, gated by the same silences,
+// min_severity, schedules and quiet hours, e.g. for the handlers an escalation step adds
+func sendAlertTo(config *Config, service string, alert *AlertState, handlers []string, delivered func(handler string)) {
+	if silence := config.silencedBy(service, alert, time.Now()); silence != nil {
+		metrics.Add(metricNotificationsSilenced, 1)
+		log.Infof("Alert '%s' is silenced by %s until %s, not sending it", alert.Message, silence.Name, silence.end.Format(time.RFC3339))
+		return
+	}
+
+	for _, name := range handlers {
+		if contains(alert.Delivered, name) {
+			log.Debugf("Alert '%s' (event %s) was already delivered to handler %s, skipping", alert.Message, alert.EventID, name)
+			continue
+		}
+		if !config.handlerOptions(name).meetsSeverity(alert) {
+			log.Debugf("Alert '%s' is below the min_severity of handler %s, skipping", alert.Message, name)
+			continue
+		}
+		if !config.handlerOptions(name).Schedule.active(time.Now()) && alert.Status != api.HealthPassing {
+			log.Debugf("Handler %s is outside its schedule, not sending it alert '%s'", name, alert.Message)
+			continue
+		}
+
+		if quietHours := config.serviceQuietHours(service); quietHours != nil && quietHours.active(time.Now()) && quietStatus(alert) {
+			log.Infof("Holding alert '%s' for handler %s until quiet hours end", alert.Message, name)
+			quietHolds.add(config, name, alert, quietHours)
+			delivered(name)
+			continue
+		}
+		quietHolds.discard(name, alert)
+
+		dispatchAlert(config, name, alert)
+		delivered(name)
+	}
+}
+
+// Hands the alert off to be sent to a handler: added to its digest if it has a digest
+// window, or through the dispatcher if there is one
+func dispatchAlert(config *Config, name string, alert *AlertState) {
+	if options := config.handlerOptions(name); options.DigestWindow > 0 {
+		log.Debugf("Adding alert '%s' to the digest for handler %s", alert.Message, name)
+		digests.get(name, options).add(config, alert)
+	} else if dispatcher != nil {
+		dispatcher.dispatch(name, alert)
+	} else {
+		deliverAlert(config, name, alert)
+	}
+}
+
+// Sends the alert to a handler, queueing it for retry if that fails. Any retries still
+// queued for earlier transitions are dropped, so they can't be delivered after this one,
+// unless the handler's rate limit dropped or summarized it instead of sending it.
+func deliverAlert(config *Config, name string, alert *AlertState) {
+	if err := invokeHandler(config, name, alert); err == errRateLimitOverflow {
+		return
+	} else if err != nil {
+		if _, limited := err.(*RateLimitError); limited {
+			log.Info(err)
+		} else {
+			metrics.Add(metricNotificationsFailed, 1)
+			log.Errorf("Error sending alert with handler %s: %s", name, err)
+		}
+		retryQueue.add(name, alert, err, config.handlerOptions(name))
+	} else {
+		metrics.Add(metricNotificationsSent, 1)
+		retryQueue.supersede(name, alert)
+	}
+}
+
+// Calls the named handler with the alert, failing over to its backup handler if its circuit
+// breaker is open
+func invokeHandler(config *Config, name string, alert *AlertState) error {
+	options := config.handlerOptions(name)
+	fallback := options.fallbackHandler()
+
+	// Skip a handler that's failing its health probe if there's somewhere else to send to,
+	// before the breaker is asked so a half-open breaker's probe isn't used up
+	if fallback != "" && !handlerHealth.healthy(name) {
+		log.Debugf("Handler %s is failing its health probe, sending alert to fallback handler %s", name, fallback)
+		return callHandler(config, fallback, alert)
+	}
+
+	// Check the rate limit before the breaker too, for the same reason
+	if ok, err := rateLimiters.get(name, options).allow(config, alert, time.Now()); !ok {
+		return err
+	}
+
+	breaker := circuitBreakers.get(name, options)
+	if !breaker.allow(time.Now()) {
+		if fallback != "" {
+			log.Debugf("Circuit breaker for handler %s is open, sending alert to fallback handler %s", name, fallback)
+			return callHandler(config, fallback, alert)
+		}
+		return fmt.Errorf("Circuit breaker for handler %s is open", name)
+	}
+
+	err := callHandler(config, name, alert)
+	breaker.record(err, time.Now())
+	return err
+}
+
+// Calls the named handler with the alert, giving up once the handler's timeout passes. The
+// handler's context is cancelled at that point, but handlers that ignore it are left to
+// finish in the background so they can't hold up the rest of the pipeline.
+func callHandler(config *Config, name string, alert *AlertState) error {
+	handler, ok := config.Handlers[name]
+	if !ok {
+		return fmt.Errorf("Handler %s not found", name)
+	}
+
+	options := config.handlerOptions(name)
+	ctx := handlerContext(context.Background(), options)
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(options.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	// Give the handler its own copy, since it may outlive this call
+	alertCopy := *alert
+	alertCopy.Delivered = append([]string(nil), alert.Delivered...)
+	if options.RecoveryDetails {
+		alertCopy.describeRecovery(time.Now())
+	}
+	if !runMiddleware(options.middleware, &alertCopy, time.Now()) {
+		log.Debugf("Alert '%s' was filtered out by the middleware for handler %s", alert.Message, name)
+		return nil
+	}
+	renderForHandler(options, name, &alertCopy)
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() { errCh <- err }()
+		defer recoverError("handler "+name, &err)
+		err = handler.Alert(ctx, &alertCopy)
+	}()
+
+	select {
+	case err := <-errCh:
+		// Keep any partial deliveries the handler recorded (such as the numbers it
+		// managed to text), so a retry can skip them
+		alert.Delivered = alertCopy.Delivered
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("Handler timed out after %ds", options.Timeout)
+	}
+}
+
+// Sends payload as JSON in a POST request to the given URL with the handler's HTTP client,
+// returning an error (with the start of the response body) unless it gets a 2xx response
+func postJSON(ctx context.Context, url string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("got response code %d (%s)", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// Returns an HTTP client for a handler to use, which gives up on connecting after the
+// connect timeout in the context, and goes through the handler's proxy if it has one.
+// Requests should be made with the context so they're cancelled along with it.
+func handlerHTTPClient(ctx context.Context) *http.Client {
+	connectTimeout, _ := ctx.Value(connectTimeoutKey).(time.Duration)
+	dialer := &net.Dialer{Timeout: connectTimeout}
+
+	proxy := http.ProxyFromEnvironment
+	if proxyURL, ok := ctx.Value(proxyKey).(*url.URL); ok {
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               proxy,
+			Dial:                dialer.Dial,
+			TLSHandshakeTimeout: connectTimeout,
+		},
+	}
+}
+
+// Parses a handler's proxy setting, which must be an http, https or socks5 URL with a host
+func parseProxyURL(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if !contains([]string{"http", "https", "socks5"}, proxyURL.Scheme) || proxyURL.Host == "" {
+		return nil, fmt.Errorf("must be a URL like http://proxy.example.com:3128 or socks5://proxy.example.com:1080")
+	}
+	return proxyURL, nil
+}
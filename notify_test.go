@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// A handler that blocks until its context is cancelled
+type hangingHandler struct {
+	cancelled chan struct{}
+}
+
+func (h hangingHandler) Alert(ctx context.Context, alert *AlertState) error {
+	<-ctx.Done()
+	close(h.cancelled)
+	return ctx.Err()
+}
+
+// Make sure a hung handler is abandoned after its timeout and its context is cancelled
+func TestNotify_handlerTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"test.hanging": hangingHandler{cancelled},
+		},
+		HandlerOptions: map[string]HandlerOptions{
+			"test.hanging": HandlerOptions{Timeout: 1},
+		},
+	}
+
+	start := time.Now()
+	if err := invokeHandler(config, "test.hanging", &AlertState{}); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected handler to be abandoned after 1s, took %s", elapsed)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected handler's context to be cancelled")
+	}
+}
+
+// Make sure the connect timeout is passed through to the handler's HTTP client
+func TestNotify_handlerHTTPClient(t *testing.T) {
+	ctx := context.WithValue(context.Background(), connectTimeoutKey, 5*time.Second)
+	client := handlerHTTPClient(ctx)
+
+	if timeout := client.Transport.(*http.Transport).TLSHandshakeTimeout; timeout != 5*time.Second {
+		t.Errorf("expected connect timeout of 5s, got %s", timeout)
+	}
+}
+
+// Make sure a handler's proxy is used for its requests
+func TestNotify_handlerProxy(t *testing.T) {
+	requested := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- r.URL.String()
+	}))
+	defer proxy.Close()
+
+	config, err := ParseConfig(`handler "webhook" "ops" {
+		url = "http://alerts.example.invalid/hook"
+		proxy = "` + proxy.URL + `"
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callHandler(config, "webhook.ops", &AlertState{Status: "critical"}); err != nil {
+		t.Fatal(err)
+	}
+	if url := <-requested; url != "http://alerts.example.invalid/hook" {
+		t.Errorf("expected the request to go through the proxy, got %s", url)
+	}
+
+	for _, proxy := range []string{"ftp://proxy.example.com", "proxy.example.com:3128"} {
+		if _, err := ParseConfig(`handler "webhook" "ops" { url = "http://example.com", proxy = "` + proxy + `" }`); err == nil {
+			t.Errorf("expected an error for proxy %s", proxy)
+		}
+	}
+}
+
+// Make sure handlers that were already sent a transition are skipped, and each new
+// delivery is reported
+func TestNotify_skipDelivered(t *testing.T) {
+	firstCh := make(chan *AlertState, 1)
+	secondCh := make(chan *AlertState, 1)
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"test.first":  testHandler{firstCh},
+			"test.second": testHandler{secondCh},
+		},
+	}
+
+	alert := &AlertState{EventID: "abc", Delivered: []string{"test.first"}}
+	delivered := make([]string, 0)
+	sendAlert(config, "", alert, func(handler string) {
+		delivered = append(delivered, handler)
+	})
+
+	if len(firstCh) != 0 || len(secondCh) != 1 {
+		t.Fatalf("expected only test.second to be sent the alert")
+	}
+	if len(delivered) != 1 || delivered[0] != "test.second" {
+		t.Fatalf("expected delivery to test.second to be recorded, got %v", delivered)
+	}
+}
+
+// Make sure a handler with min_severity only gets severe alerts, and the rest of the
+// incidents it was sent
+func TestNotify_minSeverity(t *testing.T) {
+	config, err := ParseConfig(`
+	handler "stdout" "chat" {}
+	handler "stdout" "pager" {
+		min_severity = "critical"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatCh := make(chan *AlertState, 10)
+	pagerCh := make(chan *AlertState, 10)
+	config.Handlers["stdout.chat"] = testHandler{chatCh}
+	config.Handlers["stdout.pager"] = testHandler{pagerCh}
+
+	// Send each transition the way a watch does, returning the number of alerts the pager got
+	alert := &AlertState{LastAlerted: api.HealthPassing}
+	transition := func(status string) int {
+		alert.Status = status
+		alert.updateIncident()
+		sendAlert(config, "", alert, func(string) {})
+		alert.LastAlerted = status
+		sent := len(pagerCh)
+		for len(pagerCh) > 0 {
+			<-pagerCh
+		}
+		return sent
+	}
+
+	// A warning that resolves never reaches the pager
+	if transition(api.HealthWarning) != 0 || transition(api.HealthPassing) != 0 {
+		t.Error("expected the pager not to be sent a warning incident")
+	}
+	// An incident that becomes critical does, along with its downgrade and resolution
+	if transition(api.HealthWarning) != 0 || transition(api.HealthCritical) != 1 {
+		t.Error("expected the pager to be sent the critical alert")
+	}
+	if transition(api.HealthWarning) != 1 || transition(api.HealthPassing) != 1 {
+		t.Error("expected the pager to be sent the rest of the critical incident")
+	}
+	if len(chatCh) != 6 {
+		t.Errorf("expected the chat handler to get every alert, got %d", len(chatCh))
+	}
+
+	// A warning raised to critical by a severity rule is severe enough
+	alert = &AlertState{Status: api.HealthWarning, Severity: severityCritical, LastAlerted: api.HealthPassing}
+	if !config.handlerOptions("stdout.pager").meetsSeverity(alert) {
+		t.Error("expected a critical severity warning to meet the pager's min_severity")
+	}
+	alert = &AlertState{Status: api.HealthCritical, Severity: severityWarning, LastAlerted: api.HealthPassing}
+	if config.handlerOptions("stdout.pager").meetsSeverity(alert) {
+		t.Error("expected a warning severity critical alert not to meet the pager's min_severity")
+	}
+
+	if _, err := ParseConfig(`handler "stdout" "pager" { min_severity = "passing" }`); err == nil {
+		t.Error("expected an error for an invalid min_severity")
+	}
+}
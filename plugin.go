@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Handler types that aren't built in are looked up as a plugin binary with this prefix
+const pluginPrefix = "consul-alerting-handler-"
+
+// How often running plugins are health-checked, and how long they have to answer a request
+// the handler's own timeout doesn't cover
+const pluginHealthInterval = 30 * time.Second
+const pluginHealthTimeout = 10 * time.Second
+
+// How long a restarted plugin has to run before its restart backoff resets
+const pluginStableTime = 1 * time.Minute
+
+// PluginHandler sends alerts to a handler plugin: a separate binary, maintained out of
+// tree, that we start and keep running. We talk to it with one JSON request per line on its
+// stdin, and it answers each with a JSON response on a line of its stdout. Plugins are
+// health-checked while they run and restarted if they exit or stop answering.
+type PluginHandler struct {
+	Command []string               `mapstructure:"command"`
+	Env     map[string]string      `mapstructure:"env"`
+	Config  map[string]interface{} `mapstructure:"config"`
+}
+
+// A request sent to a plugin. A configure request with the handler's config is sent when
+// it starts, before any alert or ping requests.
+type pluginRequest struct {
+	ID     uint64                 `json:"id"`
+	Type   string                 `json:"type"`
+	Config map[string]interface{} `json:"config,omitempty"`
+	Alert  *AlertState            `json:"alert,omitempty"`
+}
+
+// A plugin's response to the request with the same ID
+type pluginResponse struct {
+	ID    uint64 `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// The running plugins for each handler config, started when they're first used
+var pluginClients = struct {
+	sync.Mutex
+	clients map[string]*pluginClient
+}{clients: make(map[string]*pluginClient)}
+
+// Returns the path of the plugin binary for a handler type, looking in dir if it's set or
+// the PATH otherwise
+func findHandlerPlugin(dir string, handlerType string) (string, bool) {
+	name := pluginPrefix + handlerType
+	if dir != "" {
+		name = filepath.Join(dir, name)
+	}
+	path, err := exec.LookPath(name)
+	return path, err == nil
+}
+
+// Returns the client for the handler's plugin, starting the plugin if it isn't running
+func (p PluginHandler) client() (*pluginClient, error) {
+	key, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	pluginClients.Lock()
+	defer pluginClients.Unlock()
+	client, ok := pluginClients.clients[string(key)]
+	if !ok {
+		client = newPluginClient(p)
+		pluginClients.clients[string(key)] = client
+		go client.run()
+	}
+	return client, nil
+}
+
+func (p PluginHandler) Alert(ctx context.Context, alert *AlertState) error {
+	client, err := p.client()
+	if err != nil {
+		return err
+	}
+	if err := client.call(ctx, pluginRequest{Type: "alert", Alert: alert}); err != nil {
+		return fmt.Errorf("Error sending alert to plugin %s: %s", p.Command[0], err)
+	}
+	return nil
+}
+
+// Probes the plugin by starting it if needed and checking it answers a ping
+func (p PluginHandler) Probe(ctx context.Context) error {
+	client, err := p.client()
+	if err != nil {
+		return err
+	}
+	return client.call(ctx, pluginRequest{Type: "ping"})
+}
+
+func (p PluginHandler) Validate() error {
+	if len(p.Command) == 0 || p.Command[0] == "" {
+		return errors.New("no command given")
+	}
+	if _, err := exec.LookPath(p.Command[0]); err != nil {
+		return fmt.Errorf("plugin %s not found: %s", p.Command[0], err)
+	}
+	return nil
+}
+
+// A connection to a handler's running plugin
+type pluginClient struct {
+	handler PluginHandler
+
+	// Held while writing a request, so requests don't interleave
+	writeLock sync.Mutex
+
+	sync.Mutex
+	// The running plugin's stdin, once it's been configured
+	stdin io.Writer
+	// Closed when the plugin is next ready for requests
+	ready chan struct{}
+	// The last request ID used, and the requests waiting for a response by ID
+	lastID  uint64
+	pending map[uint64]chan pluginResponse
+}
+
+func newPluginClient(handler PluginHandler) *pluginClient {
+	return &pluginClient{
+		handler: handler,
+		ready:   make(chan struct{}),
+		pending: make(map[uint64]chan pluginResponse),
+	}
+}
+
+// Keeps the plugin running, restarting it with a backoff when it exits
+func (c *pluginClient) run() {
+	backoff := newBackoff()
+	for {
+		started := time.Now()
+		err := c.session()
+		if time.Since(started) > pluginStableTime {
+			backoff.reset()
+		}
+		wait := backoff.next()
+		log.Warnf("Handler plugin %s stopped: %s, restarting in %s", c.handler.Command[0], err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// Starts the plugin and configures it, then health-checks it until it exits or fails a check
+func (c *pluginClient) session() error {
+	cmd := exec.Command(c.handler.Command[0], c.handler.Command[1:]...)
+	cmd.Env = os.Environ()
+	for key, value := range c.handler.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Infof("Started handler plugin %s (pid %d)", c.handler.Command[0], cmd.Process.Pid)
+
+	go c.logOutput(stderr)
+	done := make(chan struct{})
+	go func() {
+		c.readResponses(stdout)
+		close(done)
+	}()
+
+	err = c.serve(stdin, done)
+
+	// Stop the plugin, and fail the requests it didn't answer
+	c.Lock()
+	c.stdin = nil
+	c.ready = make(chan struct{})
+	for id, response := range c.pending {
+		response <- pluginResponse{ID: id, Error: "plugin stopped"}
+		delete(c.pending, id)
+	}
+	c.Unlock()
+	cmd.Process.Kill()
+	<-done
+	if waitErr := cmd.Wait(); waitErr != nil {
+		err = fmt.Errorf("%s (%s)", err, waitErr)
+	}
+	return err
+}
+
+// Configures the running plugin and lets requests through to it, returning once its output
+// closes or it fails a health check
+func (c *pluginClient) serve(stdin io.Writer, done chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginHealthTimeout)
+	err := c.send(ctx, stdin, pluginRequest{Type: "configure", Config: c.handler.Config})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("error configuring plugin: %s", err)
+	}
+
+	c.Lock()
+	c.stdin = stdin
+	close(c.ready)
+	c.Unlock()
+
+	ticker := time.NewTicker(pluginHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return errors.New("plugin exited")
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), pluginHealthTimeout)
+			err := c.send(ctx, stdin, pluginRequest{Type: "ping"})
+			cancel()
+			if err != nil {
+				return fmt.Errorf("health check failed: %s", err)
+			}
+		}
+	}
+}
+
+// Sends a request to the plugin once it's running, and waits for its response
+func (c *pluginClient) call(ctx context.Context, request pluginRequest) error {
+	c.Lock()
+	ready := c.ready
+	c.Unlock()
+
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		return fmt.Errorf("plugin isn't running: %s", ctx.Err())
+	}
+
+	c.Lock()
+	stdin := c.stdin
+	c.Unlock()
+	if stdin == nil {
+		return errors.New("plugin isn't running")
+	}
+	return c.send(ctx, stdin, request)
+}
+
+// Writes a request to the plugin and waits for its response
+func (c *pluginClient) send(ctx context.Context, stdin io.Writer, request pluginRequest) error {
+	response := make(chan pluginResponse, 1)
+	c.Lock()
+	c.lastID++
+	request.ID = c.lastID
+	c.pending[request.ID] = response
+	c.Unlock()
+	defer func() {
+		c.Lock()
+		delete(c.pending, request.ID)
+		c.Unlock()
+	}()
+
+	line, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	// Write in the background, so a plugin that stops reading can't block us past the timeout
+	written := make(chan error, 1)
+	go func() {
+		c.writeLock.Lock()
+		defer c.writeLock.Unlock()
+		_, err := stdin.Write(append(line, '\n'))
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case resp := <-response:
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Passes the responses the plugin writes to the requests waiting for them, until its
+// output closes
+func (c *pluginClient) readResponses(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var resp pluginResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			log.Debugf("Ignoring invalid response from handler plugin %s: %s", c.handler.Command[0], scanner.Text())
+			continue
+		}
+
+		// Responses to requests that have timed out are dropped
+		c.Lock()
+		if response, ok := c.pending[resp.ID]; ok {
+			response <- resp
+			delete(c.pending, resp.ID)
+		}
+		c.Unlock()
+	}
+}
+
+// Logs the plugin's stderr, so plugins can log by writing to it
+func (c *pluginClient) logOutput(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		log.Infof("[plugin %s] %s", filepath.Base(c.handler.Command[0]), scanner.Text())
+	}
+}
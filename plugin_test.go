@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A plugin that logs each request next to itself, and answers with an error or exits for
+// alerts about the "fail" and "exit" services
+const testPluginScript = `#!/bin/sh
+while read -r line; do
+  echo "$line" >> "$(dirname "$0")/requests"
+  id=$(echo "$line" | sed 's/^{"id":\([0-9]*\).*/\1/')
+  case "$line" in
+    *'"service":"fail"'*) echo "{\"id\":$id,\"error\":\"pager unreachable\"}" ;;
+    *'"service":"exit"'*) exit 1 ;;
+    *) echo "{\"id\":$id}" ;;
+  esac
+done
+`
+
+func TestPlugin_alert(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, pluginPrefix+"echo")
+	if err := ioutil.WriteFile(path, []byte(testPluginScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Handler types that aren't built in are found in the plugin dir
+	config, err := ParseConfig(`
+plugin_dir = "` + dir + `"
+
+handler "echo" "ops" {
+  channel = "ops"
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, ok := config.Handlers["echo.ops"].(PluginHandler)
+	if !ok {
+		t.Fatalf("expected a plugin handler, got %#v", config.Handlers["echo.ops"])
+	}
+	if !reflect.DeepEqual(handler.Command, []string{path}) || handler.Config["channel"] != "ops" {
+		t.Fatalf("unexpected handler: %+v", handler)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := handler.Alert(ctx, &AlertState{Status: "critical", Service: "redis"}); err != nil {
+		t.Fatal(err)
+	}
+	err = handler.Alert(ctx, &AlertState{Status: "critical", Service: "fail"})
+	if err == nil || !strings.Contains(err.Error(), "pager unreachable") {
+		t.Errorf("expected the plugin's error, got %v", err)
+	}
+
+	// The plugin is restarted after it exits
+	if err := handler.Alert(ctx, &AlertState{Status: "critical", Service: "exit"}); err == nil {
+		t.Error("expected an error when the plugin exits")
+	}
+	if err := handler.Alert(ctx, &AlertState{Status: "passing", Service: "redis"}); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, _ := ioutil.ReadFile(filepath.Join(dir, "requests"))
+	lines := strings.Split(strings.TrimSpace(string(requests)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 requests, got %q", lines)
+	}
+	for i, expected := range []string{`"type":"configure","config":{"channel":"ops"}`, `"service":"redis"`, `"service":"fail"`, `"service":"exit"`, `"type":"configure"`, `"status":"passing"`} {
+		if !strings.Contains(lines[i], expected) {
+			t.Errorf("expected request %d to contain %s, got %s", i, expected, lines[i])
+		}
+	}
+}
+
+func TestPlugin_config(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+
+	config, err := ParseConfig(`
+handler "plugin" "ops" {
+  command = ["sh", "-c", "cat"]
+  config {
+    channel = "ops"
+  }
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handler := config.Handlers["plugin.ops"].(PluginHandler); handler.Config["channel"] != "ops" {
+		t.Errorf("unexpected plugin config: %+v", handler.Config)
+	}
+
+	if _, err := ParseConfig(`handler "missing" "ops" {}`); err == nil || !strings.Contains(err.Error(), "Unknown handler type: missing") {
+		t.Errorf("expected an unknown handler type error, got %v", err)
+	}
+}
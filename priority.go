@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/mapstructure"
+)
+
+// The service metadata key a service's criticality is read from, e.g. criticality=tier1
+const criticalityMetaKey = "criticality"
+
+// PriorityConfig sets how much each factor adds to an alert's priority score
+type PriorityConfig struct {
+	// The score for each severity
+	SeverityScores map[string]int `mapstructure:"severity_scores"`
+
+	// The score for each service criticality, e.g. tier1
+	CriticalityScores map[string]int `mapstructure:"criticality_scores"`
+
+	// The score for each node the alert is failing on, up to the maximum
+	NodeScore    int `mapstructure:"node_score"`
+	MaxNodeScore int `mapstructure:"max_node_score"`
+}
+
+// The default scores, used for any not set in the priority block, which add up to at most 100
+var defaultPriorityConfig = map[string]interface{}{
+	"severity_scores": map[string]int{
+		severityCritical: 60,
+		severityUnknown:  40,
+		severityWarning:  30,
+		severityOK:       0,
+	},
+	"criticality_scores": map[string]int{
+		"tier1": 30,
+		"tier2": 20,
+		"tier3": 10,
+	},
+	"node_score":     2,
+	"max_node_score": 10,
+}
+
+// A factor that adds to an alert's priority score. New factors can be added to
+// priorityFactors, and are summed with the others.
+type priorityFactor func(p *PriorityConfig, alert *AlertState) int
+
+var priorityFactors = []priorityFactor{
+	// How bad the alert is
+	func(p *PriorityConfig, alert *AlertState) int {
+		return p.SeverityScores[alert.Severity]
+	},
+
+	// How important the service is
+	func(p *PriorityConfig, alert *AlertState) int {
+		return p.CriticalityScores[alert.Criticality]
+	},
+
+	// How many nodes it's failing on
+	func(p *PriorityConfig, alert *AlertState) int {
+		nodes := make([]string, 0)
+		for _, check := range alert.Checks {
+			if !contains(nodes, check.Node) {
+				nodes = append(nodes, check.Node)
+			}
+		}
+		if score := p.NodeScore * len(nodes); score < p.MaxNodeScore {
+			return score
+		}
+		return p.MaxNodeScore
+	},
+}
+
+// Returns the default scores
+func defaultPriority() *PriorityConfig {
+	priority := &PriorityConfig{}
+	mapstructure.WeakDecode(defaultPriorityConfig, priority)
+	return priority
+}
+
+// Parses the raw priority block over the default scores, returning nil if there isn't one
+func parsePriority(raw interface{}) (*PriorityConfig, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	priority := defaultPriority()
+
+	// A priority block decodes as a list of objects, so unwrap it
+	blocks, ok := raw.([]map[string]interface{})
+	if !ok || len(blocks) != 1 {
+		return nil, fmt.Errorf("Only one priority block can be set")
+	}
+	if err := mapstructure.WeakDecode(blocks[0], priority); err != nil {
+		return nil, fmt.Errorf("Invalid priority block: %s", err)
+	}
+
+	for severity := range priority.SeverityScores {
+		if _, ok := severityOrder[severity]; !ok {
+			return nil, fmt.Errorf("Invalid severity in priority block: %q", severity)
+		}
+	}
+	if priority.NodeScore < 0 || priority.MaxNodeScore < 0 {
+		return nil, fmt.Errorf("Invalid priority block: node_score and max_node_score can't be negative")
+	}
+	return priority, nil
+}
+
+// Returns the criticality of a service: the one set in its service block, or the
+// criticality metadata of its instances in Consul. Returns "" if it has none.
+func serviceCriticality(config *Config, client *api.Client, service string) string {
+	if service == "" {
+		return ""
+	}
+	if serviceConfig := config.serviceConfig(service); serviceConfig != nil && serviceConfig.Criticality != "" {
+		return serviceConfig.Criticality
+	}
+
+	entries, err := catalogServiceMeta(client, service)
+	if err != nil {
+		log.Warnf("Error looking up the criticality of service %s: %s", service, err)
+		return ""
+	}
+	for _, entry := range entries {
+		if criticality := strings.TrimSpace(entry.ServiceMeta[criticalityMetaKey]); criticality != "" {
+			return criticality
+		}
+	}
+	return ""
+}
+
+// Looks up the criticality of the alert's service and sets its priority, before it's sent
+func prioritizeAlert(watchOpts *WatchOptions, alert *AlertState) {
+	alert.Criticality = serviceCriticality(watchOpts.config, watchOpts.client, watchOpts.service)
+	watchOpts.config.applyPriority(alert)
+}
+
+// Sets the alert's priority score: the sum of the priority factors, from its severity,
+// its service's criticality and the number of nodes it's failing on
+func (c *Config) applyPriority(alert *AlertState) {
+	priority := c.Priority
+	if priority == nil {
+		priority = defaultPriority()
+	}
+
+	alert.Priority = 0
+	if alert.Status == api.HealthPassing {
+		return
+	}
+	for _, factor := range priorityFactors {
+		alert.Priority += factor(priority, alert)
+	}
+}
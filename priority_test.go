@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPriority_applyPriority(t *testing.T) {
+	config, err := ParseConfig(`
+	priority {
+		criticality_scores {
+			internal = 5
+		}
+		max_node_score = 6
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := func(nodes ...string) []AlertCheck {
+		result := make([]AlertCheck, 0)
+		for _, node := range nodes {
+			result = append(result, AlertCheck{Node: node}, AlertCheck{Node: node})
+		}
+		return result
+	}
+
+	cases := []struct {
+		status, severity, criticality string
+		checks                        []AlertCheck
+		priority                      int
+	}{
+		{"critical", severityCritical, "tier1", checks("a"), 92},
+		{"critical", severityCritical, "internal", checks("a", "b"), 69},
+		{"warning", severityWarning, "", checks("a", "b", "c", "d", "e"), 36},
+		{"warning", severityCritical, "tier3", nil, 70},
+		{"passing", severityOK, "tier1", nil, 0},
+	}
+	for _, c := range cases {
+		alert := &AlertState{Status: c.status, Severity: c.severity, Criticality: c.criticality, Checks: c.checks}
+		config.applyPriority(alert)
+		if alert.Priority != c.priority {
+			t.Errorf("%s %s %q on %d checks: expected a priority of %d, got %d", c.status, c.severity, c.criticality, len(c.checks), c.priority, alert.Priority)
+		}
+	}
+
+	// Without a priority block, the default scores are used
+	alert := &AlertState{Status: "critical", Severity: severityCritical, Criticality: "tier2", Checks: checks("a", "b", "c", "d", "e", "f")}
+	DefaultConfig().applyPriority(alert)
+	if alert.Priority != 90 {
+		t.Errorf("expected a priority of 90 with the default scores, got %d", alert.Priority)
+	}
+
+	for _, raw := range []string{
+		`priority { severity_scores { bad = 1 } }`,
+		`priority { node_score = -1 }`,
+		"priority {}\npriority {}",
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("expected an error for %q", raw)
+		}
+	}
+}
+
+func TestPriority_minPriority(t *testing.T) {
+	matcher := &AlertMatcher{MinPriority: 80}
+	cases := []struct {
+		alert    *AlertState
+		expected bool
+	}{
+		{&AlertState{Status: "critical", Priority: 92}, true},
+		{&AlertState{Status: "warning", Priority: 36}, false},
+		{&AlertState{Status: "passing", LastPriority: 92}, true},
+		{&AlertState{Status: "passing", LastPriority: 36}, false},
+	}
+	for _, c := range cases {
+		if matched := matcher.matches("api", c.alert); matched != c.expected {
+			t.Errorf("%s with priority %d (last %d): expected %v, got %v", c.alert.Status, c.alert.Priority, c.alert.LastPriority, c.expected, matched)
+		}
+	}
+
+	if err := (&AlertMatcher{MinPriority: -1}).validate(); err == nil {
+		t.Error("expected an error for a negative min_priority")
+	}
+}
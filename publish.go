@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// The name of the Consul user event fired for each alert when publish_events is set
+const alertEventName = "consul-alerting"
+
+// A compact summary of an alert's state, published to Consul for other tools to consume.
+// Kept small to fit in a user event's payload.
+type AlertSummary struct {
+	Status  string `json:"status"`
+	Node    string `json:"node,omitempty"`
+	Service string `json:"service,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Job     string `json:"job,omitempty"`
+	EventID string `json:"event_id"`
+	Since   int64  `json:"since"`
+}
+
+func newAlertSummary(alert *AlertState, now time.Time) AlertSummary {
+	return AlertSummary{
+		Status:  alert.Status,
+		Node:    alert.Node,
+		Service: alert.Service,
+		Tag:     alert.Tag,
+		Job:     alert.Job,
+		EventID: alert.EventID,
+		Since:   now.Unix(),
+	}
+}
+
+// Returns the key under publish_kv_prefix to store the summary for an alert's node/service/tag
+// or Nomad job
+func summaryKVPath(prefix string, alert *AlertState) string {
+	prefix = strings.TrimRight(prefix, "/")
+	if alert.Job != "" {
+		return prefix + "/nomad/" + alert.Job
+	}
+	if alert.Service == "" {
+		return prefix + "/node/" + alert.Node
+	}
+	if alert.Tag == "" {
+		return prefix + "/service/" + alert.Service
+	}
+	return prefix + "/service/" + alert.Service + "/" + alert.Tag
+}
+
+// Publishes a sent alert back into Consul, as a user event and/or a summary in the KV store,
+// depending on the config. Errors are only logged, since the alert itself has been sent.
+func publishAlert(config *Config, client *api.Client, alert *AlertState) {
+	if !config.PublishEvents && config.PublishKVPrefix == "" {
+		return
+	}
+
+	payload, err := json.Marshal(newAlertSummary(alert, time.Now()))
+	if err != nil {
+		log.Error("Error forming alert summary: ", err)
+		return
+	}
+
+	if config.PublishEvents {
+		_, _, err := client.Event().Fire(&api.UserEvent{Name: alertEventName, Payload: payload}, nil)
+		if err != nil {
+			log.Errorf("Error firing Consul event for alert '%s': %s", alert.Message, err)
+		}
+	}
+
+	if config.PublishKVPrefix != "" {
+		_, err := client.KV().Put(&api.KVPair{Key: summaryKVPath(config.PublishKVPrefix, alert), Value: payload}, nil)
+		if err != nil {
+			log.Errorf("Error storing alert summary for '%s': %s", alert.Message, err)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestPublish_summaryKVPath(t *testing.T) {
+	cases := []struct {
+		alert    AlertState
+		expected string
+	}{
+		{AlertState{Node: "foo"}, "alerts/node/foo"},
+		{AlertState{Service: "redis"}, "alerts/service/redis"},
+		{AlertState{Service: "redis", Tag: "alpha"}, "alerts/service/redis/alpha"},
+		{AlertState{Service: "batch", Job: "batch"}, "alerts/nomad/batch"},
+	}
+
+	for _, c := range cases {
+		if path := summaryKVPath("alerts/", &c.alert); path != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, path)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	pubsubDefaultEndpoint = "https://pubsub.googleapis.com"
+	pubsubScope           = "https://www.googleapis.com/auth/pubsub"
+)
+
+// PubSubHandler publishes alerts as JSON messages to a Google Cloud Pub/Sub topic. It
+// authenticates with a service account key file if one is given, or with the application
+// default credentials otherwise, which covers GKE workload identity and the GCE metadata server.
+type PubSubHandler struct {
+	Project         string `mapstructure:"project"`
+	Topic           string `mapstructure:"topic"`
+	CredentialsFile string `mapstructure:"credentials_file"`
+
+	// Optional. The Pub/Sub API endpoint to use instead of the public one.
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+// The token sources for each credentials file (or the default credentials), kept between
+// alerts so tokens are only refreshed when they expire
+var pubsubTokenSources = struct {
+	sync.Mutex
+	sources map[string]oauth2.TokenSource
+}{sources: make(map[string]oauth2.TokenSource)}
+
+// The body of a publish request
+type pubsubMessage struct {
+	Data       string            `json:"data"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Returns the token source for the handler's credentials
+func (p PubSubHandler) tokenSource() (oauth2.TokenSource, error) {
+	pubsubTokenSources.Lock()
+	defer pubsubTokenSources.Unlock()
+	if source, ok := pubsubTokenSources.sources[p.CredentialsFile]; ok {
+		return source, nil
+	}
+
+	var credentials *google.Credentials
+	var err error
+	if p.CredentialsFile != "" {
+		var data []byte
+		if data, err = ioutil.ReadFile(p.CredentialsFile); err != nil {
+			return nil, err
+		}
+		credentials, err = google.CredentialsFromJSON(context.Background(), data, pubsubScope)
+	} else {
+		credentials, err = google.FindDefaultCredentials(context.Background(), pubsubScope)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pubsubTokenSources.sources[p.CredentialsFile] = credentials.TokenSource
+	return credentials.TokenSource, nil
+}
+
+// Returns the message to publish for an alert
+func pubsubAlertMessage(alert *AlertState) (pubsubMessage, error) {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return pubsubMessage{}, err
+	}
+
+	// Add the alert's fields as attributes, so subscriptions can filter on them
+	message := pubsubMessage{Data: base64.StdEncoding.EncodeToString(data), Attributes: make(map[string]string)}
+	for key, value := range map[string]string{
+		"status":     alert.Status,
+		"service":    alert.Service,
+		"node":       alert.Node,
+		"tag":        alert.Tag,
+		"datacenter": alert.Datacenter,
+	} {
+		if value != "" {
+			message.Attributes[key] = value
+		}
+	}
+	return message, nil
+}
+
+func (p PubSubHandler) Alert(ctx context.Context, alert *AlertState) error {
+	source, err := p.tokenSource()
+	if err != nil {
+		return fmt.Errorf("Error sending alert to Pub/Sub: error loading credentials: %s", err)
+	}
+	token, err := source.Token()
+	if err != nil {
+		return fmt.Errorf("Error sending alert to Pub/Sub: error getting access token: %s", err)
+	}
+
+	message, err := pubsubAlertMessage(alert)
+	if err != nil {
+		return err
+	}
+
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = pubsubDefaultEndpoint
+	}
+	endpoint = fmt.Sprintf("%s/v1/projects/%s/topics/%s:publish", strings.TrimRight(endpoint, "/"), url.PathEscape(p.Project), url.PathEscape(p.Topic))
+
+	headers := map[string]string{"Authorization": token.Type() + " " + token.AccessToken}
+	payload := map[string][]pubsubMessage{"messages": {message}}
+	if err := postJSON(ctx, endpoint, headers, payload); err != nil {
+		return fmt.Errorf("Error sending alert to Pub/Sub (%s): %s", p.Topic, err)
+	}
+	return nil
+}
+
+func (p PubSubHandler) Validate() error {
+	if p.Project == "" {
+		return errors.New("no project given")
+	}
+	if p.Topic == "" {
+		return errors.New("no topic given")
+	}
+	return nil
+}
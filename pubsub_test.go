@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPubSub_publish(t *testing.T) {
+	var auth string
+	var body map[string][]pubsubMessage
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token123","token_type":"Bearer","expires_in":3600}`))
+	})
+	mux.HandleFunc("/v1/projects/infra/topics/alerts:publish", func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"messageIds":["1"]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Write a service account key file that gets its tokens from the test server
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "infra",
+		"client_email": "alerting@infra.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+		"token_uri":    server.URL + "/token",
+	})
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	credentialsFile := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(credentialsFile, credentials, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := PubSubHandler{Project: "infra", Topic: "alerts", CredentialsFile: credentialsFile, Endpoint: server.URL}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	alert := &AlertState{Status: "critical", Service: "redis", Datacenter: "dc1", Message: "redis is down"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth != "Bearer token123" {
+		t.Errorf("unexpected authorization header: %q", auth)
+	}
+	if len(body["messages"]) != 1 {
+		t.Fatalf("expected one message, got %v", body)
+	}
+	message := body["messages"][0]
+	expected := map[string]string{"status": "critical", "service": "redis", "datacenter": "dc1"}
+	if len(message.Attributes) != len(expected) {
+		t.Errorf("expected attributes %v, got %v", expected, message.Attributes)
+	}
+	for name, value := range expected {
+		if message.Attributes[name] != value {
+			t.Errorf("expected attribute %s to be %q, got %q", name, value, message.Attributes[name])
+		}
+	}
+
+	var data AlertState
+	decoded, _ := base64.StdEncoding.DecodeString(message.Data)
+	if err := json.Unmarshal(decoded, &data); err != nil || data.Message != alert.Message {
+		t.Errorf("unexpected message data %q: %v", decoded, err)
+	}
+}
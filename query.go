@@ -0,0 +1,74 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// A blocking query that returns faster than this without a new index returned early
+const fastReturnTime = 100 * time.Millisecond
+
+// How long to wait before the next query after one returns early, so a misbehaving
+// query can't turn into a tight loop
+const minQueryInterval = 1 * time.Second
+
+// The number of early returns in a row before a query is considered stuck and its index
+// is reset
+const maxFastReturns = 5
+
+// The anomalies an IndexTracker can detect
+const indexBackwards = "went backwards"
+const indexFastReturn = "returned early"
+const indexStuck = "stuck"
+
+// IndexTracker follows the index of a blocking query across calls, picking the index to
+// wait on next and detecting the index resets and early returns that Consul's docs
+// warn clients about
+type IndexTracker struct {
+	name        string
+	waitIndex   uint64
+	fastReturns int
+}
+
+func newIndexTracker(name string) *IndexTracker {
+	return &IndexTracker{name: name}
+}
+
+// Records the index returned by a query and how long the query took. Returns the index
+// to wait on in the next query and any anomaly that was detected.
+func (t *IndexTracker) update(lastIndex uint64, elapsed time.Duration) (uint64, string) {
+	anomaly := ""
+
+	switch {
+	// The index can go backwards when a new leader is behind the old one or a snapshot is
+	// restored, so start over from 0 instead of blocking on an index that may never come
+	case lastIndex < t.waitIndex:
+		log.Warnf("Consul index for %s went backwards (%d -> %d), resetting it", t.name, t.waitIndex, lastIndex)
+		t.waitIndex = 0
+		t.fastReturns = 0
+		return 0, indexBackwards
+
+	case t.waitIndex != 0 && lastIndex == t.waitIndex && elapsed < fastReturnTime:
+		t.fastReturns++
+		anomaly = indexFastReturn
+		if t.fastReturns >= maxFastReturns {
+			log.Warnf("Blocking query for %s returned immediately %d times without its index changing, resetting it", t.name, t.fastReturns)
+			t.waitIndex = 0
+			t.fastReturns = 0
+			return 0, indexStuck
+		}
+
+	default:
+		t.fastReturns = 0
+	}
+
+	// The index should never be 0 after the first query, but if it is we'd skip blocking
+	// entirely, so wait on 1 instead
+	t.waitIndex = lastIndex
+	if t.waitIndex == 0 {
+		t.waitIndex = 1
+	}
+
+	return t.waitIndex, anomaly
+}
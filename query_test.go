@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuery_indexTracker(t *testing.T) {
+	tracker := newIndexTracker("test")
+
+	if index, anomaly := tracker.update(10, 0); index != 10 || anomaly != "" {
+		t.Fatalf("expected index 10 with no anomaly, got %d (%q)", index, anomaly)
+	}
+
+	// A blocking query timing out with the same index is normal
+	if index, anomaly := tracker.update(10, watchWaitTime); index != 10 || anomaly != "" {
+		t.Fatalf("expected index 10 with no anomaly, got %d (%q)", index, anomaly)
+	}
+
+	if index, anomaly := tracker.update(4, time.Second); index != 0 || anomaly != indexBackwards {
+		t.Fatalf("expected index to reset after going backwards, got %d (%q)", index, anomaly)
+	}
+
+	// An index of 0 after the first query should be waited on as 1
+	if index, _ := tracker.update(0, time.Second); index != 1 {
+		t.Fatalf("expected index 1, got %d", index)
+	}
+}
+
+// Make sure a query that keeps returning immediately with the same index gets reset
+func TestQuery_stuckQuery(t *testing.T) {
+	tracker := newIndexTracker("test")
+	tracker.update(10, 0)
+
+	for i := 1; i < maxFastReturns; i++ {
+		if index, anomaly := tracker.update(10, time.Millisecond); index != 10 || anomaly != indexFastReturn {
+			t.Fatalf("expected early return %d to be flagged, got %d (%q)", i, index, anomaly)
+		}
+	}
+
+	if index, anomaly := tracker.update(10, time.Millisecond); index != 0 || anomaly != indexStuck {
+		t.Fatalf("expected stuck query to be reset, got %d (%q)", index, anomaly)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// How often held alerts are checked for their quiet hours having ended
+const quietHoursCheckInterval = time.Minute
+
+// Returns the quiet hours for alerts about the service: the service's own if it has them,
+// or the global ones. Returns nil if there are none.
+func (c *Config) serviceQuietHours(service string) *Schedule {
+	if serviceConfig := c.serviceConfig(service); serviceConfig != nil && serviceConfig.QuietHours != nil {
+		return serviceConfig.QuietHours
+	}
+	return c.QuietHours
+}
+
+// Returns true if quiet hours hold the alert back: it's a warning, or the recovery from one
+func quietStatus(alert *AlertState) bool {
+	return alert.Status == api.HealthWarning || (alert.Status == api.HealthPassing && alert.LastAlerted == api.HealthWarning)
+}
+
+// An alert held back during quiet hours, and the quiet hours it's waiting out
+type heldAlert struct {
+	alert      *AlertState
+	quietHours *Schedule
+}
+
+// QuietHoldRegistry holds the alerts kept from each handler during quiet hours, so they can
+// be sent as a summary once the quiet hours end
+type QuietHoldRegistry struct {
+	sync.Mutex
+	held    map[string][]heldAlert
+	waiting bool
+}
+
+var quietHolds = &QuietHoldRegistry{held: make(map[string][]heldAlert)}
+
+// Holds the alert back from the handler until its quiet hours end, replacing any alert
+// already held for the same node/service
+func (r *QuietHoldRegistry) add(config *Config, name string, alert *AlertState, quietHours *Schedule) {
+	r.Lock()
+	defer r.Unlock()
+
+	alertCopy := *alert
+	alertCopy.Delivered = nil
+	r.remove(name, alert)
+	r.held[name] = append(r.held[name], heldAlert{alert: &alertCopy, quietHours: quietHours})
+
+	if !r.waiting {
+		r.waiting = true
+		go runRecovered("quiet hours timer", func() { r.wait(config) })
+	}
+}
+
+// Drops any alert held from the handler for the same node/service as the given one, since
+// the handler's been sent a newer alert for it
+func (r *QuietHoldRegistry) discard(name string, alert *AlertState) {
+	r.Lock()
+	defer r.Unlock()
+	r.remove(name, alert)
+}
+
+// Must be called with the lock held
+func (r *QuietHoldRegistry) remove(name string, alert *AlertState) {
+	kept := r.held[name][:0]
+	for _, held := range r.held[name] {
+		if alertTargetKey(held.alert) != alertTargetKey(alert) {
+			kept = append(kept, held)
+		}
+	}
+	r.held[name] = kept
+}
+
+// Takes the alerts whose quiet hours are over, or all of them if all is set, returning a
+// summary of them for each handler
+func (r *QuietHoldRegistry) take(now time.Time, all bool) map[string]*AlertState {
+	r.Lock()
+	defer r.Unlock()
+
+	summaries := make(map[string]*AlertState)
+	for name, held := range r.held {
+		due := make([]*AlertState, 0)
+		kept := held[:0]
+		for _, h := range held {
+			if all || !h.quietHours.active(now) {
+				due = append(due, h.alert)
+			} else {
+				kept = append(kept, h)
+			}
+		}
+		r.held[name] = kept
+		if len(due) > 0 {
+			message := fmt.Sprintf("%d alerts held during quiet hours", len(due))
+			summaries[name] = summarizeAlerts(due, message, "quiet/"+name, now)
+		}
+	}
+	return summaries
+}
+
+// Returns true if no alerts are being held. Clears waiting if so, so the next alert held
+// starts a new timer.
+func (r *QuietHoldRegistry) done() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, held := range r.held {
+		if len(held) > 0 {
+			return false
+		}
+	}
+	r.waiting = false
+	return true
+}
+
+// Sends each handler the alerts held from it once their quiet hours end, until none are left
+func (r *QuietHoldRegistry) wait(config *Config) {
+	for !r.done() {
+		time.Sleep(quietHoursCheckInterval)
+		r.flush(config, time.Now())
+	}
+}
+
+// Sends the summaries of the alerts whose quiet hours are over, unless they've been moved to
+// the retry queue for a handoff
+func (r *QuietHoldRegistry) flush(config *Config, now time.Time) {
+	handoffLock.RLock()
+	defer handoffLock.RUnlock()
+
+	summaries := r.take(now, false)
+	for _, name := range sortedKeys(summaries) {
+		log.Infof("Quiet hours are over, sending '%s' with handler %s", summaries[name].Message, name)
+		dispatchAlert(config, name, summaries[name])
+	}
+}
+
+// Returns when the quiet hours of the alerts held from each handler end, the latest of
+// them for handlers with several
+func (r *QuietHoldRegistry) ends(now time.Time) map[string]time.Time {
+	r.Lock()
+	defer r.Unlock()
+
+	ends := make(map[string]time.Time)
+	for name, held := range r.held {
+		for _, h := range held {
+			if end := h.quietHours.activeUntil(now); end.After(ends[name]) {
+				ends[name] = end
+			}
+		}
+	}
+	return ends
+}
+
+// Moves the alerts being held to the retry queue, to be sent once their quiet hours end, so
+// they're handed off with it or kept for the next run on shutdown. Must be called with
+// handoffLock held, so none can be sent meanwhile.
+func (r *QuietHoldRegistry) drain() {
+	now := time.Now()
+	ends := r.ends(now)
+	summaries := r.take(now, true)
+	for _, name := range sortedKeys(summaries) {
+		at := ends[name]
+		if at.Before(now) {
+			at = now
+		}
+		retryQueue.enqueueAt(name, summaries[name], "held during quiet hours", at)
+	}
+}
+
+// Returns the keys of the summaries, sorted
+func sortedKeys(summaries map[string]*AlertState) []string {
+	names := make([]string, 0, len(summaries))
+	for name := range summaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
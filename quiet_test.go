@@ -0,0 +1,184 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHours_config(t *testing.T) {
+	config, err := ParseConfig(`
+	quiet_hours {
+		hours = "22:00-07:00"
+		timezone = "UTC"
+	}
+	service "web" {
+		quiet_hours {
+			days = "sat,sun"
+			timezone = "UTC"
+		}
+	}
+	service "redis" {
+		change_threshold = 30
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Friday night and Saturday afternoon
+	night, _ := time.Parse(time.RFC3339, "2026-10-16T23:00:00Z")
+	weekend, _ := time.Parse(time.RFC3339, "2026-10-17T14:00:00Z")
+	if quiet := config.serviceQuietHours("redis"); !quiet.active(night) || quiet.active(weekend) {
+		t.Error("expected redis to use the global quiet hours")
+	}
+	if quiet := config.serviceQuietHours("web"); quiet.active(night) || !quiet.active(weekend) {
+		t.Error("expected web to use its own quiet hours")
+	}
+
+	if _, err := ParseConfig(`quiet_hours { hours = "22:00" }`); err == nil {
+		t.Error("expected invalid quiet_hours to be rejected")
+	}
+}
+
+func TestQuietHours_status(t *testing.T) {
+	cases := []struct {
+		status, lastAlerted string
+		quiet               bool
+	}{
+		{"warning", "passing", true},
+		{"critical", "passing", false},
+		{"critical", "warning", false},
+		{"passing", "warning", true},
+		{"passing", "critical", false},
+		{"warning", "critical", true},
+	}
+	for _, c := range cases {
+		alert := &AlertState{Status: c.status, LastAlerted: c.lastAlerted}
+		if quietStatus(alert) != c.quiet {
+			t.Errorf("%s after %s: expected quiet=%v", c.status, c.lastAlerted, c.quiet)
+		}
+	}
+}
+
+// Make sure warnings are held during quiet hours while criticals are sent right away
+func TestQuietHours_send(t *testing.T) {
+	alertCh := make(chan *AlertState, 3)
+	config, err := ParseConfig(`
+	quiet_hours {
+		days = "sun-sat"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Handlers = map[string]AlertHandler{"test.quiet": testHandler{alertCh}}
+	config.DefaultHandlers = []string{"test.quiet"}
+
+	// Keep the registry from starting its timer
+	saved := quietHolds
+	quietHolds = &QuietHoldRegistry{held: make(map[string][]heldAlert), waiting: true}
+	defer func() { quietHolds = saved }()
+
+	delivered := make([]string, 0)
+	for _, alert := range []*AlertState{
+		{Service: "redis", Status: "warning", LastAlerted: "passing", Message: "redis is warning"},
+		{Service: "db", Status: "critical", LastAlerted: "passing", Message: "db is critical"},
+	} {
+		sendAlert(config, alert.Service, alert, func(name string) { delivered = append(delivered, name) })
+	}
+	if len(delivered) != 2 {
+		t.Errorf("expected both alerts to count as delivered, got %v", delivered)
+	}
+	if sent := <-alertCh; sent.Message != "db is critical" {
+		t.Errorf("expected the critical to be sent right away, got %q", sent.Message)
+	}
+	if len(alertCh) != 0 || len(quietHolds.held["test.quiet"]) != 1 {
+		t.Fatal("expected the warning to be held")
+	}
+
+	// Once redis goes critical, the warning held for it is dropped
+	sendAlert(config, "redis", &AlertState{Service: "redis", Status: "critical", LastAlerted: "warning", Message: "redis is critical"}, func(string) {})
+	<-alertCh
+	if !quietHolds.done() {
+		t.Error("expected the held warning to be dropped")
+	}
+}
+
+// Make sure the alerts held are sent as a summary once the quiet hours end
+func TestQuietHours_flush(t *testing.T) {
+	alertCh := make(chan *AlertState, 3)
+	config, err := ParseConfig(`
+	quiet_hours {
+		hours = "22:00-07:00"
+		timezone = "UTC"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Handlers = map[string]AlertHandler{"test.quiet": testHandler{alertCh}}
+
+	registry := &QuietHoldRegistry{held: make(map[string][]heldAlert), waiting: true}
+	for _, alert := range []*AlertState{
+		{Service: "redis", Status: "warning", Message: "redis is warning"},
+		{Service: "web", Status: "warning", Message: "web is warning"},
+		{Service: "redis", Status: "passing", Message: "redis is passing"},
+	} {
+		registry.add(config, "test.quiet", alert, config.QuietHours)
+	}
+
+	// Nothing is sent while the quiet hours last
+	night, _ := time.Parse(time.RFC3339, "2026-10-16T23:00:00Z")
+	registry.flush(config, night)
+	if len(alertCh) != 0 {
+		t.Fatal("expected nothing to be sent during quiet hours")
+	}
+
+	morning, _ := time.Parse(time.RFC3339, "2026-10-17T07:30:00Z")
+	registry.flush(config, morning)
+	select {
+	case sent := <-alertCh:
+		if sent.Message != "2 alerts held during quiet hours" {
+			t.Errorf("unexpected summary %q", sent.Message)
+		}
+		if sent.Details != "[warning] web is warning\n[passing] redis is passing" {
+			t.Errorf("unexpected summary details %q", sent.Details)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the held alerts to be sent once quiet hours end")
+	}
+	if !registry.done() {
+		t.Error("expected no alerts to be held after the flush")
+	}
+}
+
+// Make sure alerts held when the process hands off or shuts down wait out their quiet hours
+func TestQuietHours_ends(t *testing.T) {
+	config, err := ParseConfig(`
+	quiet_hours {
+		hours = "22:00-07:00"
+		timezone = "UTC"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := &QuietHoldRegistry{held: make(map[string][]heldAlert), waiting: true}
+	registry.add(config, "test.quiet", &AlertState{Service: "redis", Status: "warning"}, config.QuietHours)
+
+	night, _ := time.Parse(time.RFC3339, "2026-10-16T23:10:30Z")
+	morning, _ := time.Parse(time.RFC3339, "2026-10-17T07:00:00Z")
+	if end := registry.ends(night)["test.quiet"]; !end.Equal(morning) {
+		t.Errorf("expected the held alert to wait until %s, got %s", morning, end)
+	}
+
+	// Quiet hours that never end don't hold alerts back forever
+	always := &Schedule{Days: "sun-sat"}
+	if err := always.parse("UTC"); err != nil {
+		t.Fatal(err)
+	}
+	if end := always.activeUntil(night); !end.IsZero() {
+		t.Errorf("expected no end to the schedule, got %s", end)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The ways a handler's alerts can be handled once it's over its rate limit
+const overflowDrop = "drop"
+const overflowQueue = "queue"
+const overflowSummary = "summary"
+
+// RateLimitError is returned for an alert held back by its handler's rate limit, so it can
+// be retried once the handler has room without it counting as a failed attempt
+type RateLimitError struct {
+	Handler string
+	Wait    time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit for handler %s reached, next send allowed in %s", e.Handler, e.Wait)
+}
+
+// Returned for an alert dropped or held for a summary by its handler's rate limit, which
+// wasn't sent but shouldn't be retried either
+var errRateLimitOverflow = errors.New("Rate limit reached, alert dropped or held for a summary")
+
+// RateLimiter is a token bucket limiting how many alerts a handler sends a minute, so an
+// outage that fails a lot of checks at once doesn't hammer the handler's API
+type RateLimiter struct {
+	sync.Mutex
+	name     string
+	rate     float64
+	burst    float64
+	overflow string
+
+	tokens float64
+	last   time.Time
+
+	// The alerts held back for the next summary, and whether it's been scheduled
+	suppressed []*AlertState
+	scheduled  bool
+}
+
+// Refills the bucket and takes a token if there is one. If there isn't, returns how long
+// until there will be. Must be called with the lock held.
+func (l *RateLimiter) take(now time.Time) (bool, time.Duration) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Returns true if the alert can be sent now. If it can't, the alert is dealt with according
+// to the handler's overflow setting, and the error to return for it is returned.
+func (l *RateLimiter) allow(config *Config, alert *AlertState, now time.Time) (bool, error) {
+	if l.rate <= 0 {
+		return true, nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	ok, wait := l.take(now)
+	if ok {
+		return true, nil
+	}
+	metrics.Add(metricNotificationsRateLimited, 1)
+
+	switch l.overflow {
+	case overflowDrop:
+		log.Warnf("Rate limit for handler %s reached, dropping alert '%s'", l.name, alert.Message)
+	case overflowSummary:
+		log.Infof("Rate limit for handler %s reached, holding alert '%s' for the next summary", l.name, alert.Message)
+		alertCopy := *alert
+		l.suppressed = append(l.suppressed, &alertCopy)
+		if !l.scheduled {
+			l.scheduled = true
+			time.AfterFunc(wait, func() { l.flush(config) })
+		}
+	default:
+		return false, &RateLimitError{Handler: l.name, Wait: wait}
+	}
+	return false, errRateLimitOverflow
+}
+
+// Sends the alerts held back since the handler went over its limit as a single summary alert,
+// waiting longer if there's still no room
+func (l *RateLimiter) flush(config *Config) {
+	l.Lock()
+	if ok, wait := l.take(time.Now()); !ok {
+		time.AfterFunc(wait, func() { l.flush(config) })
+		l.Unlock()
+		return
+	}
+	suppressed := l.suppressed
+	l.suppressed = nil
+	l.scheduled = false
+	l.Unlock()
+
+	message := fmt.Sprintf("%d alerts were held back by the rate limit for handler %s", len(suppressed), l.name)
+	summary := summarizeAlerts(suppressed, message, "ratelimit/"+l.name, time.Now())
+	if err := callHandler(config, l.name, summary); err != nil {
+		metrics.Add(metricNotificationsFailed, 1)
+		log.Errorf("Error sending rate limit summary with handler %s: %s", l.name, err)
+		retryQueue.add(l.name, summary, err, config.handlerOptions(l.name))
+		return
+	}
+	metrics.Add(metricNotificationsSent, 1)
+	log.Infof("Sent summary of %d alerts held back by the rate limit for handler %s", len(suppressed), l.name)
+}
+
+// Returns a single alert summarizing the given ones, with the worst of their statuses and
+// severities, the highest of their priorities, the given message and a line for each alert
+// as its details. The event key is used to give the summary an event ID of its own. A single
+// alert is returned as it is.
+func summarizeAlerts(alerts []*AlertState, message, eventKey string, now time.Time) *AlertState {
+	if len(alerts) == 1 {
+		return alerts[0]
+	}
+
+	summary := &AlertState{Status: "passing", Severity: severityOK, Datacenter: alerts[0].Datacenter, Summary: true}
+	lines := make([]string, 0, len(alerts))
+	for _, alert := range alerts {
+		if order, ok := statusOrder[alert.Status]; ok && order < statusOrder[summary.Status] {
+			summary.Status = alert.Status
+		}
+		if severity := alertSeverity(alert.Severity, alert.Status); severityOrder[severity] < severityOrder[summary.Severity] {
+			summary.Severity = severity
+		}
+		if alert.Priority > summary.Priority {
+			summary.Priority = alert.Priority
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s", alert.Status, alert.Message))
+	}
+	summary.Message = message
+	summary.Details = strings.Join(lines, "\n")
+	summary.EventID = alertEventID(eventKey, now.UnixNano(), summary.Status)
+	return summary
+}
+
+// RateLimiterRegistry holds the rate limiter for each handler
+type RateLimiterRegistry struct {
+	sync.Mutex
+	limiters map[string]*RateLimiter
+}
+
+var rateLimiters = &RateLimiterRegistry{limiters: make(map[string]*RateLimiter)}
+
+// Returns the rate limiter for the named handler, creating it with a full bucket if needed.
+// If the handler's rate limit options have changed since, e.g. on a reload, the limiter is
+// updated to them, keeping the tokens it has left up to the new burst.
+func (r *RateLimiterRegistry) get(name string, options HandlerOptions) *RateLimiter {
+	r.Lock()
+	defer r.Unlock()
+
+	burst := options.RateLimitBurst
+	if burst <= 0 {
+		burst = options.RateLimit
+	}
+	rate := float64(options.RateLimit) / 60
+
+	limiter, ok := r.limiters[name]
+	if !ok {
+		limiter = &RateLimiter{
+			name:     name,
+			rate:     rate,
+			burst:    float64(burst),
+			overflow: options.RateLimitOverflow,
+			tokens:   float64(burst),
+		}
+		r.limiters[name] = limiter
+		return limiter
+	}
+
+	limiter.Lock()
+	if limiter.rate != rate || limiter.burst != float64(burst) || limiter.overflow != options.RateLimitOverflow {
+		log.Debugf("Rate limit options for handler %s changed, updating its limiter", name)
+		limiter.rate, limiter.burst, limiter.overflow = rate, float64(burst), options.RateLimitOverflow
+		if limiter.tokens > limiter.burst {
+			limiter.tokens = limiter.burst
+		}
+	}
+	limiter.Unlock()
+	return limiter
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Make sure the bucket allows a burst, then refills at the configured rate
+func TestRateLimit_bucket(t *testing.T) {
+	limiter := (&RateLimiterRegistry{limiters: make(map[string]*RateLimiter)}).get("test.limited", HandlerOptions{RateLimit: 6, RateLimitBurst: 2})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.take(now); !ok {
+			t.Fatalf("expected send %d of the burst to be allowed", i+1)
+		}
+	}
+	ok, wait := limiter.take(now)
+	if ok || wait != 10*time.Second {
+		t.Fatalf("expected to wait 10s for the next send, got %v %s", ok, wait)
+	}
+	if ok, _ := limiter.take(now.Add(10 * time.Second)); !ok {
+		t.Fatal("expected a send to be allowed after the bucket refilled")
+	}
+}
+
+// Make sure queued alerts over the limit wait for room without using up an attempt
+func TestRateLimit_queue(t *testing.T) {
+	alertCh := make(chan *AlertState, 1)
+	config := &Config{
+		Handlers: map[string]AlertHandler{"test.limited": testHandler{alertCh}},
+		HandlerOptions: map[string]HandlerOptions{
+			"test.limited": HandlerOptions{RateLimit: 1},
+		},
+	}
+	defer func() { rateLimiters = &RateLimiterRegistry{limiters: make(map[string]*RateLimiter)} }()
+
+	if err := invokeHandler(config, "test.limited", &AlertState{Message: "redis is critical"}); err != nil {
+		t.Fatal(err)
+	}
+	<-alertCh
+
+	err := invokeHandler(config, "test.limited", &AlertState{Message: "redis is passing"})
+	limited, ok := err.(*RateLimitError)
+	if !ok {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+
+	now := time.Now()
+	notification := &QueuedNotification{FirstAttempt: now}
+	notification.failed(err, now, config.handlerOptions("test.limited"))
+	if notification.Attempts != 0 || !notification.NextAttempt.Equal(now.Add(limited.Wait)) {
+		t.Errorf("expected the retry to wait %s without an attempt, got %d attempts and %s", limited.Wait, notification.Attempts, notification.NextAttempt)
+	}
+}
+
+// Make sure alerts over the limit are collapsed into one summary once there's room
+func TestRateLimit_summary(t *testing.T) {
+	alertCh := make(chan *AlertState, 3)
+	config := &Config{
+		Handlers: map[string]AlertHandler{"test.limited": testHandler{alertCh}},
+		HandlerOptions: map[string]HandlerOptions{
+			"test.limited": HandlerOptions{RateLimit: 300, RateLimitBurst: 1, RateLimitOverflow: overflowSummary},
+		},
+	}
+	defer func() { rateLimiters = &RateLimiterRegistry{limiters: make(map[string]*RateLimiter)} }()
+
+	for _, alert := range []*AlertState{
+		{Status: "warning", Message: "redis is warning"},
+		{Status: "critical", Message: "web is critical", Priority: 70},
+		{Status: "warning", Message: "db is warning", Priority: 40},
+	} {
+		if err := invokeHandler(config, "test.limited", alert); err != nil && err != errRateLimitOverflow {
+			t.Fatal(err)
+		}
+	}
+	if alert := <-alertCh; alert.Message != "redis is warning" {
+		t.Fatalf("expected the first alert to be sent, got %q", alert.Message)
+	}
+
+	select {
+	case summary := <-alertCh:
+		if summary.Status != "critical" || !strings.HasPrefix(summary.Message, "2 alerts were held back") {
+			t.Errorf("unexpected summary: %s %q", summary.Status, summary.Message)
+		}
+		if summary.Details != "[critical] web is critical\n[warning] db is warning" {
+			t.Errorf("unexpected summary details: %q", summary.Details)
+		}
+		if summary.Severity != severityCritical || summary.Priority != 70 {
+			t.Errorf("expected the summary to have the worst severity and priority, got %s %d", summary.Severity, summary.Priority)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a summary of the held back alerts")
+	}
+}
+
+// Make sure an alert dropped by the rate limit isn't counted as sent, and doesn't supersede
+// the retries queued for its target
+func TestRateLimit_dropKeepsRetries(t *testing.T) {
+	alertCh := make(chan *AlertState, 1)
+	config := &Config{
+		Handlers: map[string]AlertHandler{"test.limited": testHandler{alertCh}},
+		HandlerOptions: map[string]HandlerOptions{
+			"test.limited": HandlerOptions{RateLimit: 1, RateLimitOverflow: overflowDrop},
+		},
+	}
+	defer func() { rateLimiters = &RateLimiterRegistry{limiters: make(map[string]*RateLimiter)} }()
+
+	handoffLock.Lock()
+	oldQueue := retryQueue
+	retryQueue = &RetryQueue{notifications: make([]*QueuedNotification, 0), maxAge: time.Hour}
+	handoffLock.Unlock()
+	defer func() {
+		handoffLock.Lock()
+		retryQueue = oldQueue
+		handoffLock.Unlock()
+	}()
+
+	deliverAlert(config, "test.limited", &AlertState{Service: "redis", Message: "web is critical", EventID: "1"})
+	<-alertCh
+	retryQueue.enqueue("test.limited", &AlertState{Service: "redis", Message: "redis is critical", EventID: "2"}, "test")
+
+	deliverAlert(config, "test.limited", &AlertState{Service: "redis", Message: "redis is passing", EventID: "3"})
+	if len(alertCh) != 0 {
+		t.Fatal("expected the alert over the limit to be dropped")
+	}
+	if len(retryQueue.notifications) != 1 {
+		t.Fatalf("expected the queued retry to be kept, got %d notifications", len(retryQueue.notifications))
+	}
+}
+
+// Make sure a handler's limiter follows changes to its rate limit options
+func TestRateLimit_optionsChanged(t *testing.T) {
+	registry := &RateLimiterRegistry{limiters: make(map[string]*RateLimiter)}
+	limiter := registry.get("test.limited", HandlerOptions{RateLimit: 6, RateLimitBurst: 5})
+	now := time.Now()
+	limiter.take(now)
+
+	limiter = registry.get("test.limited", HandlerOptions{RateLimit: 60, RateLimitBurst: 2, RateLimitOverflow: overflowDrop})
+	if limiter.rate != 1 || limiter.burst != 2 || limiter.overflow != overflowDrop {
+		t.Fatalf("expected the limiter to be updated, got rate %v burst %v overflow %q", limiter.rate, limiter.burst, limiter.overflow)
+	}
+	if limiter.tokens != 2 {
+		t.Errorf("expected the tokens left to be capped at the new burst, got %v", limiter.tokens)
+	}
+
+	// Turning the rate limit off lets everything through
+	limiter = registry.get("test.limited", HandlerOptions{})
+	if ok, err := limiter.allow(&Config{}, &AlertState{}, now); !ok || err != nil {
+		t.Errorf("expected an alert to be allowed with the rate limit off, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// WatchState holds the in-memory state of a running watch, so that it can be inspected
+// or handed off to a new process during a restart
+type WatchState struct {
+	sync.Mutex
+
+	Name    string
+	Node    string
+	Service string
+	Tag     string
+
+	// The last known status of each check, keyed by node/checkID
+	Checks map[string]string
+
+	// The last computed health of the node/service, and when it last changed
+	Status    string
+	changedAt time.Time
+
+	// When the node/service was first seen with no checks registered while failing, if it
+	// still has none
+	goneSince time.Time
+
+	// When the service was first seen with fewer healthy instances than its
+	// min_healthy_instances, if it still has
+	belowMinSince time.Time
+
+	// Alerts that are waiting out their change threshold, keyed by update index
+	Pending map[int64]*PendingAlert
+
+	// Whether the watch's alert is flapping, as of the last time this instance updated it
+	flapping bool
+
+	// Set if Consul is refusing our requests for the watch, e.g. because of ACLs
+	Error string
+
+	lock *LockHelper
+
+	// The errors from the requests for the watch Consul's ACLs are denying, keyed by what
+	// was denied (aclSourceLock or aclSourceQuery), and which of them this instance sent a
+	// meta-alert about, so it knows to send another when access is restored
+	denied        map[string]string
+	deniedAlerted map[string]bool
+
+	// Meta-alerts about the watch waiting to be sent, see sendMetaAlert
+	metaLock    sync.Mutex
+	metaQueue   []*AlertState
+	metaSending bool
+}
+
+// An alert waiting for its change threshold to pass before being sent
+type PendingAlert struct {
+	Path        string     `json:"path"`
+	Alert       AlertState `json:"alert"`
+	UpdateIndex int64      `json:"update_index"`
+
+	// When the alert is due to fire. Only for display; timing is based on the monotonic
+	// clock, and the time remaining is passed along instead during a handoff.
+	FireAt time.Time `json:"fire_at"`
+
+	// How long was left on the timer when the alert was handed off to a new process
+	Remaining time.Duration `json:"remaining,omitempty"`
+}
+
+// Returns a copy of the pending alert to hand off to a new process, with the time left on
+// its timer measured now. FireAt carries a monotonic clock reading in this process, so the
+// remaining time isn't affected by any wall clock changes since the timer started.
+func (p *PendingAlert) handoffCopy(now time.Time) *PendingAlert {
+	handoff := *p
+	handoff.Remaining = p.FireAt.Sub(now)
+	if handoff.Remaining < 0 {
+		handoff.Remaining = 0
+	}
+	return &handoff
+}
+
+func newWatchState(name, node, service, tag string) *WatchState {
+	return &WatchState{
+		Name:    name,
+		Node:    node,
+		Service: service,
+		Tag:     tag,
+		Checks:  make(map[string]string),
+		Status:  "passing",
+		Pending: make(map[int64]*PendingAlert),
+
+		denied:        make(map[string]string),
+		deniedAlerted: make(map[string]bool),
+	}
+}
+
+func (s *WatchState) addPending(pending *PendingAlert) {
+	s.Lock()
+	s.Pending[pending.UpdateIndex] = pending
+	s.Unlock()
+}
+
+func (s *WatchState) removePending(updateIndex int64) {
+	s.Lock()
+	delete(s.Pending, updateIndex)
+	s.Unlock()
+}
+
+func (s *WatchState) setFlapping(flapping bool) {
+	s.Lock()
+	s.flapping = flapping
+	s.Unlock()
+}
+
+// Returns true if the watch has an alert waiting out its change threshold, or its status
+// changed within the threshold
+func (s *WatchState) settling(threshold time.Duration, now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.Pending) > 0 || now.Sub(s.changedAt) < threshold
+}
+
+// Returns true if this process currently holds the lock for the watch
+func (s *WatchState) leader() bool {
+	return s.lock != nil && s.lock.acquired
+}
+
+// WatchRegistry keeps track of the watches running in this process
+type WatchRegistry struct {
+	sync.Mutex
+	watches map[string]*WatchState
+}
+
+var runningWatches = &WatchRegistry{watches: make(map[string]*WatchState)}
+
+func (r *WatchRegistry) add(state *WatchState) {
+	r.Lock()
+	r.watches[state.Name] = state
+	r.Unlock()
+}
+
+func (r *WatchRegistry) remove(name string) {
+	r.Lock()
+	delete(r.watches, name)
+	r.Unlock()
+}
+
+// Returns the running watches, sorted by name
+func (r *WatchRegistry) list() []*WatchState {
+	r.Lock()
+	defer r.Unlock()
+
+	names := make([]string, 0, len(r.watches))
+	for name := range r.watches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	states := make([]*WatchState, 0, len(names))
+	for _, name := range names {
+		states = append(states, r.watches[name])
+	}
+	return states
+}
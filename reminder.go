@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// Returns how often to remind the handlers about a critical alert for the service that's
+// still open, or 0 if they shouldn't be reminded
+func (c *Config) serviceReminderInterval(service string) time.Duration {
+	interval := c.ReminderInterval
+	if c.serviceConfig(service) != nil {
+		interval = c.serviceConfig(service).ReminderInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// Returns true if a reminder is due for the alert: it was sent as critical, is still
+// critical, hasn't been acknowledged (which is checked separately) and nothing has been sent
+// about it within the interval
+func reminderDue(alert *AlertState, interval time.Duration, now time.Time) bool {
+	if alert.Meta || alert.Flapping || alert.LastAlerted != api.HealthCritical || alert.Status != alert.LastAlerted {
+		return false
+	}
+	last := alert.LastAlertedAt
+	if alert.RemindedAt.After(last) {
+		last = alert.RemindedAt
+	}
+	return now.Sub(last) >= interval
+}
+
+// Sends the alert at the path to its handlers again if it's been critical and
+// unacknowledged for another reminder interval. Called by the watch after each query, while
+// it holds the lock for the node/service.
+func remindAlert(kvPath string, watchOpts *WatchOptions, now time.Time) {
+	interval := watchOpts.config.serviceReminderInterval(watchOpts.service)
+	if interval <= 0 {
+		return
+	}
+
+	// Hold off while we're handing off to a new process, which will send the reminder instead
+	handoffLock.RLock()
+	defer handoffLock.RUnlock()
+
+	watchOpts.alertLock.Lock()
+	defer watchOpts.alertLock.Unlock()
+
+	alert, err := getAlertState(kvPath, watchOpts.client)
+	if err != nil {
+		log.Error("Error fetching alert state: ", err)
+		return
+	}
+	if alert == nil || !reminderDue(alert, interval, now) {
+		return
+	}
+
+	if ack, err := getAlertAck(watchOpts.client, kvPath, alert); err != nil {
+		log.Error("Error fetching alert ack: ", err)
+		return
+	} else if ack != nil {
+		log.Debugf("Not reminding handlers about '%s', it was acknowledged by %s", alert.Message, ack.By)
+		return
+	}
+
+	alert.Reminders++
+	alert.RemindedAt = now
+	reminder := *alert
+	reminder.Delivered = nil
+	reminder.EventID = alertEventID(kvPath, alert.UpdateIndex, fmt.Sprintf("reminder-%d", alert.Reminders))
+	reminder.Message = fmt.Sprintf("%s (still %s after %s)", alert.Message, alert.Status, now.Sub(alert.LastAlertedAt).Round(time.Minute))
+
+	log.Infof("Reminding handlers about alert '%s'", alert.Message)
+	sendAlert(watchOpts.config, watchOpts.service, &reminder, func(string) {})
+	setAlertState(kvPath, alert, watchOpts.client)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestReminder_interval(t *testing.T) {
+	config, err := ParseConfig(`
+	reminder_interval = 1800
+	service "redis" {
+		reminder_interval = 600
+	}
+	service "webapp" {}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for service, expected := range map[string]time.Duration{
+		"redis":  10 * time.Minute,
+		"webapp": 30 * time.Minute,
+		"other":  30 * time.Minute,
+	} {
+		if interval := config.serviceReminderInterval(service); interval != expected {
+			t.Errorf("expected a reminder interval of %s for %s, got %s", expected, service, interval)
+		}
+	}
+}
+
+func TestReminder_reminderDue(t *testing.T) {
+	sent := time.Now()
+	critical := AlertState{Status: api.HealthCritical, LastAlerted: api.HealthCritical, LastAlertedAt: sent}
+	reminded := critical
+	reminded.RemindedAt = sent.Add(10 * time.Minute)
+	pending := critical
+	pending.Status = api.HealthPassing
+	warning := critical
+	warning.Status, warning.LastAlerted = api.HealthWarning, api.HealthWarning
+
+	cases := []struct {
+		alert AlertState
+		after time.Duration
+		due   bool
+	}{
+		{critical, 5 * time.Minute, false},
+		{critical, 10 * time.Minute, true},
+		{reminded, 15 * time.Minute, false},
+		{reminded, 20 * time.Minute, true},
+		{pending, time.Hour, false},
+		{warning, time.Hour, false},
+	}
+	for i, tc := range cases {
+		if due := reminderDue(&tc.alert, 10*time.Minute, sent.Add(tc.after)); due != tc.due {
+			t.Errorf("case %d: expected due to be %v", i, tc.due)
+		}
+	}
+}
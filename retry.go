@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// The bounds on how long to wait between attempts at resending a failed notification
+const minRetryWaitTime = 10 * time.Second
+const maxRetryWaitTime = 5 * time.Minute
+
+// How often to look for notifications that are due to be retried
+const retryCheckInterval = 5 * time.Second
+
+// A notification that failed to send and is waiting to be retried
+type QueuedNotification struct {
+	ID           string     `json:"id"`
+	Handler      string     `json:"handler"`
+	Alert        AlertState `json:"alert"`
+	Attempts     int        `json:"attempts"`
+	FirstAttempt time.Time  `json:"first_attempt"`
+	NextAttempt  time.Time  `json:"next_attempt"`
+	LastError    string     `json:"last_error"`
+}
+
+// Returns the key identifying the target of the notification, so we can keep notifications
+// about the same node/service going to the same handler in order. Each summary is a target of
+// its own, so summaries for the same handler don't supersede each other.
+func (n *QueuedNotification) target() string {
+	if n.Alert.Summary {
+		return n.Handler + " summary " + n.Alert.EventID
+	}
+	return n.Handler + " " + watchName(n.Alert.Node, n.Alert.Service, n.Alert.Tag)
+}
+
+// Records a failed attempt and schedules the next one, backing off between the handler's
+// min and max waits
+func (n *QueuedNotification) failed(err error, now time.Time, options HandlerOptions) {
+	// Alerts held back by a rate limit wait until the handler has room, without using up
+	// an attempt
+	if limited, ok := err.(*RateLimitError); ok {
+		n.NextAttempt = now.Add(limited.Wait)
+		if n.Attempts == 0 {
+			n.LastError = err.Error()
+		}
+		return
+	}
+
+	backoff := &Backoff{min: minRetryWaitTime, max: maxRetryWaitTime, failures: uint(n.Attempts)}
+	if options.RetryMinWait > 0 {
+		backoff.min = time.Duration(options.RetryMinWait) * time.Second
+	}
+	if options.RetryMaxWait > 0 {
+		backoff.max = time.Duration(options.RetryMaxWait) * time.Second
+	}
+	n.Attempts++
+	n.LastError = err.Error()
+	n.NextAttempt = now.Add(retryJitter(backoff.next()))
+}
+
+// Returns a random wait between half and all of the given one, so notifications that failed
+// together (like everything sent during an outage) don't all retry at the same moment
+func retryJitter(wait time.Duration) time.Duration {
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(wait-half)+1))
+}
+
+// Returns true if the notification has used up its attempts or passed its max age
+func (q *RetryQueue) expired(notification *QueuedNotification, options HandlerOptions, now time.Time) bool {
+	if options.RetryMaxAttempts > 0 && notification.Attempts >= options.RetryMaxAttempts {
+		return true
+	}
+	maxAge := q.maxAge
+	if options.RetryMaxAge > 0 {
+		maxAge = time.Duration(options.RetryMaxAge) * time.Second
+	}
+	return now.Sub(notification.FirstAttempt) > maxAge
+}
+
+// RetryQueue holds notifications that failed to send, retrying them with backoff until they
+// succeed, pass retry_max_age or use up their handler's attempts. If retry_queue_path is
+// set, the queue is persisted there so it survives restarts.
+type RetryQueue struct {
+	sync.Mutex
+	path          string
+	maxAge        time.Duration
+	notifications []*QueuedNotification
+
+	// The client to store dead letters in Consul with, if dead_letter_kv_prefix is set
+	client *api.Client
+}
+
+// The queue used by the daemon, set up on startup
+var retryQueue *RetryQueue
+
+// Creates a retry queue from the config, loading any notifications persisted by a
+// previous run or handed off by a previous process
+func newRetryQueue(config *Config, client *api.Client) (*RetryQueue, error) {
+	queue := &RetryQueue{
+		path:          config.RetryQueuePath,
+		maxAge:        time.Duration(config.RetryMaxAge) * time.Second,
+		notifications: make([]*QueuedNotification, 0),
+		client:        client,
+	}
+
+	if queue.path != "" {
+		contents, err := ioutil.ReadFile(queue.path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Error reading retry queue: %s", err)
+		}
+		if len(contents) > 0 {
+			if err := json.Unmarshal(contents, &queue.notifications); err != nil {
+				return nil, fmt.Errorf("Error parsing retry queue %s: %s", queue.path, err)
+			}
+		}
+	}
+
+	if inheritedState != nil {
+		for _, notification := range inheritedState.RetryQueue {
+			queue.push(notification)
+		}
+	}
+
+	if len(queue.notifications) > 0 {
+		log.Infof("Loaded %d notifications waiting to be retried", len(queue.notifications))
+	}
+
+	return queue, nil
+}
+
+// Adds a notification that failed to send to the queue
+func (q *RetryQueue) add(handler string, alert *AlertState, err error, options HandlerOptions) {
+	if q == nil {
+		return
+	}
+
+	now := time.Now()
+	notification := &QueuedNotification{
+		ID:           handler + " " + alert.EventID,
+		Handler:      handler,
+		Alert:        *alert,
+		FirstAttempt: now,
+	}
+	notification.failed(err, now, options)
+	q.insert(notification)
+
+	log.Infof("Queued alert '%s' for retry with handler %s at %s", alert.Message, handler, notification.NextAttempt.Format(time.RFC3339))
+}
+
+// Adds a notification that hasn't been attempted yet to the queue, to be sent on the
+// queue's next run, e.g. because the dispatcher couldn't take it
+func (q *RetryQueue) enqueue(handler string, alert *AlertState, reason string) {
+	q.enqueueAt(handler, alert, reason, time.Now())
+}
+
+// Adds a notification that hasn't been attempted yet to the queue, to be sent once the
+// given time has passed
+func (q *RetryQueue) enqueueAt(handler string, alert *AlertState, reason string, at time.Time) {
+	if q == nil {
+		return
+	}
+
+	q.insert(&QueuedNotification{
+		ID:           handler + " " + alert.EventID,
+		Handler:      handler,
+		Alert:        *alert,
+		FirstAttempt: at,
+		NextAttempt:  at,
+		LastError:    reason,
+	})
+}
+
+// Adds a notification to the queue, dropping any earlier ones for the same target
+func (q *RetryQueue) insert(notification *QueuedNotification) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.drop(notification.target(), notification.Alert.EventID)
+	q.push(notification)
+	q.save()
+}
+
+// Drops any queued notifications to the handler about earlier transitions of the alert's
+// node/service, since a newer one has been sent to it
+func (q *RetryQueue) supersede(handler string, alert *AlertState) {
+	if q == nil {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.drop((&QueuedNotification{Handler: handler, Alert: *alert}).target(), alert.EventID) {
+		q.save()
+	}
+}
+
+// Removes the notifications for the given target that aren't about the given event,
+// returning true if any were removed. Must be called with the lock held.
+func (q *RetryQueue) drop(target string, eventID string) bool {
+	remaining := make([]*QueuedNotification, 0, len(q.notifications))
+	for _, existing := range q.notifications {
+		if existing.target() == target && existing.Alert.EventID != eventID {
+			log.Infof("Dropping queued alert '%s' for handler %s, it's been superseded", existing.Alert.Message, existing.Handler)
+			continue
+		}
+		remaining = append(remaining, existing)
+	}
+
+	dropped := len(remaining) != len(q.notifications)
+	q.notifications = remaining
+	return dropped
+}
+
+// Appends the notification to the queue, replacing any existing entry with the same ID
+func (q *RetryQueue) push(notification *QueuedNotification) {
+	for i, existing := range q.notifications {
+		if existing.ID == notification.ID {
+			q.notifications[i] = notification
+			return
+		}
+	}
+	q.notifications = append(q.notifications, notification)
+}
+
+// Returns a copy of the notifications currently in the queue
+func (q *RetryQueue) list() []*QueuedNotification {
+	if q == nil {
+		return nil
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	notifications := make([]*QueuedNotification, len(q.notifications))
+	copy(notifications, q.notifications)
+	return notifications
+}
+
+// Returns the number of notifications waiting to be retried
+func (q *RetryQueue) len() int {
+	if q == nil {
+		return 0
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	return len(q.notifications)
+}
+
+// Writes the queue to retry_queue_path, if set. Must be called with the lock held.
+func (q *RetryQueue) save() {
+	if q.path == "" {
+		return
+	}
+
+	serialized, err := json.Marshal(q.notifications)
+	if err != nil {
+		log.Error("Error serializing retry queue: ", err)
+		return
+	}
+
+	// Write to a temporary file first so a crash can't leave a truncated queue behind
+	tmpPath := q.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, serialized, 0600); err != nil {
+		log.Error("Error writing retry queue: ", err)
+		return
+	}
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		log.Error("Error writing retry queue: ", err)
+	}
+}
+
+// Writes the queue to retry_queue_path before exiting, or logs how many notifications are
+// lost if it isn't set
+func (q *RetryQueue) persist() {
+	if q == nil {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	if q.path == "" {
+		if len(q.notifications) > 0 {
+			log.Warnf("Dropping %d notifications waiting to be retried, as retry_queue_path isn't set", len(q.notifications))
+		}
+		return
+	}
+	q.save()
+	log.Infof("Saved %d notifications waiting to be retried to %s", len(q.notifications), q.path)
+}
+
+// Retries due notifications until the process exits
+func (q *RetryQueue) run(config *Config) {
+	for range time.Tick(retryCheckInterval) {
+		q.retry(config, time.Now())
+	}
+}
+
+// Attempts to resend each notification that's due, dropping any that succeed or that their
+// handler's rate limit drops or summarizes, and dead-lettering any that have passed their max
+// age or attempts
+func (q *RetryQueue) retry(config *Config, now time.Time) {
+	// Don't send anything while handing off, the new process will pick up the queue
+	handoffLock.RLock()
+	defer handoffLock.RUnlock()
+
+	q.Lock()
+	defer q.Unlock()
+
+	changed := false
+	remaining := make([]*QueuedNotification, 0, len(q.notifications))
+	// Targets with a notification still waiting, so later ones don't get delivered out of order
+	blocked := make(map[string]bool)
+
+	for _, notification := range q.notifications {
+		target := notification.target()
+
+		options := config.handlerOptions(notification.Handler)
+		if q.expired(notification, options, now) {
+			changed = true
+			log.Errorf("Giving up on alert '%s' with handler %s after %d attempts: %s",
+				notification.Alert.Message, notification.Handler, notification.Attempts, notification.LastError)
+			deadLetter(config, q.client, notification)
+			continue
+		}
+
+		if _, ok := config.Handlers[notification.Handler]; !ok {
+			changed = true
+			log.Warnf("Handler %s no longer exists, giving up on queued alert '%s'", notification.Handler, notification.Alert.Message)
+			deadLetter(config, q.client, notification)
+			continue
+		}
+
+		if blocked[target] || now.Before(notification.NextAttempt) {
+			blocked[target] = true
+			remaining = append(remaining, notification)
+			continue
+		}
+
+		changed = true
+		err := invokeHandler(config, notification.Handler, &notification.Alert)
+		if err == errRateLimitOverflow {
+			continue
+		}
+		if err != nil {
+			notification.failed(err, now, options)
+			if _, limited := err.(*RateLimitError); limited {
+				blocked[target] = true
+				remaining = append(remaining, notification)
+				continue
+			}
+			metrics.Add(metricNotificationsFailed, 1)
+			log.Errorf("Retry %d of alert '%s' with handler %s failed: %s, next attempt at %s", notification.Attempts-1,
+				notification.Alert.Message, notification.Handler, err, notification.NextAttempt.Format(time.RFC3339))
+			blocked[target] = true
+			remaining = append(remaining, notification)
+			continue
+		}
+
+		metrics.Add(metricNotificationsSent, 1)
+		log.Infof("Sent queued alert '%s' with handler %s after %d attempts", notification.Alert.Message, notification.Handler, notification.Attempts+1)
+	}
+
+	if changed {
+		q.notifications = remaining
+		q.save()
+	}
+}
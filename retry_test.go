@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// A handler that fails a set number of times before succeeding
+type flakyHandler struct {
+	failures *int
+	sent     *int
+}
+
+func (f flakyHandler) Alert(ctx context.Context, alert *AlertState) error {
+	if *f.failures > 0 {
+		*f.failures--
+		return errors.New("service unavailable")
+	}
+	*f.sent++
+	return nil
+}
+
+// Make sure failed notifications are retried with backoff until they succeed
+func TestRetry_retryUntilSent(t *testing.T) {
+	failures, sent := 1, 0
+	config := &Config{Handlers: map[string]AlertHandler{"test": flakyHandler{&failures, &sent}}}
+	queue := &RetryQueue{maxAge: time.Hour}
+
+	alert := &AlertState{Service: "redis", Status: "critical", UpdateIndex: 1}
+	queue.add("test", alert, errors.New("service unavailable"), HandlerOptions{})
+	notification := queue.list()[0]
+
+	// Not due yet
+	queue.retry(config, notification.FirstAttempt)
+	if queue.len() != 1 || failures != 1 {
+		t.Fatalf("expected notification to wait for its next attempt")
+	}
+
+	// Due but fails again, so the wait should double (less up to half for jitter)
+	now := notification.NextAttempt
+	queue.retry(config, now)
+	if queue.len() != 1 || notification.Attempts != 2 {
+		t.Fatalf("expected notification to stay queued after failing, attempts: %d", notification.Attempts)
+	}
+	if wait := notification.NextAttempt.Sub(now); wait < minRetryWaitTime || wait > 2*minRetryWaitTime {
+		t.Fatalf("expected wait between %s and %s, got %s", minRetryWaitTime, 2*minRetryWaitTime, wait)
+	}
+
+	queue.retry(config, notification.NextAttempt)
+	if queue.len() != 0 || sent != 1 {
+		t.Fatalf("expected notification to be sent, queue length: %d, sent: %d", queue.len(), sent)
+	}
+}
+
+// Make sure notifications are dropped once they pass retry_max_age, and that later
+// notifications for the same target wait behind earlier ones
+func TestRetry_maxAgeAndOrdering(t *testing.T) {
+	failures, sent := 0, 0
+	config := &Config{Handlers: map[string]AlertHandler{"test": flakyHandler{&failures, &sent}}}
+	queue := &RetryQueue{maxAge: time.Minute}
+
+	// Load both directly, the way they would be from a queue persisted by an older version
+	now := time.Now()
+	queue.push(&QueuedNotification{ID: "test a1", Handler: "test", FirstAttempt: now, NextAttempt: now.Add(time.Second),
+		Alert: AlertState{Service: "redis", Status: "critical", UpdateIndex: 1, EventID: "a1"}})
+	queue.push(&QueuedNotification{ID: "test b2", Handler: "test", FirstAttempt: now, NextAttempt: now,
+		Alert: AlertState{Service: "redis", Status: "passing", UpdateIndex: 2, EventID: "b2"}})
+
+	// The second notification is due but the first isn't, so neither should be sent
+	queue.retry(config, now)
+	if sent != 0 {
+		t.Fatalf("expected later notification to wait behind the earlier one")
+	}
+
+	queue.retry(config, now.Add(2*time.Minute))
+	if queue.len() != 0 || sent != 0 {
+		t.Fatalf("expected notifications past the max age to be dropped, queue length: %d, sent: %d", queue.len(), sent)
+	}
+}
+
+// Make sure a handler's retry options override the defaults, and notifications are given up
+// on once they use up the handler's attempts
+func TestRetry_handlerOptions(t *testing.T) {
+	failures, sent := 10, 0
+	options := HandlerOptions{RetryMaxAttempts: 2, RetryMinWait: 1, RetryMaxWait: 1}
+	config := &Config{
+		Handlers:       map[string]AlertHandler{"test": flakyHandler{&failures, &sent}},
+		HandlerOptions: map[string]HandlerOptions{"test": options},
+	}
+	queue := &RetryQueue{maxAge: time.Hour}
+
+	queue.add("test", &AlertState{Service: "redis", Status: "critical"}, errors.New("service unavailable"), options)
+	notification := queue.list()[0]
+	if wait := notification.NextAttempt.Sub(notification.FirstAttempt); wait < 500*time.Millisecond || wait > time.Second {
+		t.Fatalf("expected a wait of at most retry_max_wait, got %s", wait)
+	}
+
+	queue.retry(config, notification.NextAttempt)
+	if queue.len() != 1 || notification.Attempts != 2 {
+		t.Fatalf("expected notification to stay queued after failing, attempts: %d", notification.Attempts)
+	}
+
+	// The next check gives up on it without waiting for another attempt
+	queue.retry(config, notification.FirstAttempt.Add(time.Second))
+	if queue.len() != 0 || failures != 9 {
+		t.Fatalf("expected notification to be given up on after 2 attempts, queue length: %d", queue.len())
+	}
+}
+
+// Make sure queued notifications are dropped once a later transition for the same target is
+// queued or sent to the handler
+func TestRetry_supersede(t *testing.T) {
+	queue := &RetryQueue{maxAge: time.Hour}
+
+	queue.add("test", &AlertState{Service: "redis", Status: "critical", EventID: "a1"}, errors.New("down"), HandlerOptions{})
+	queue.add("other", &AlertState{Service: "redis", Status: "critical", EventID: "a1"}, errors.New("down"), HandlerOptions{})
+	queue.add("test", &AlertState{Service: "redis", Status: "warning", EventID: "b2"}, errors.New("down"), HandlerOptions{})
+
+	notifications := queue.list()
+	if len(notifications) != 2 || notifications[0].Handler != "other" || notifications[1].Alert.EventID != "b2" {
+		t.Fatalf("expected the earlier transition for the handler to be replaced, got %#v", notifications)
+	}
+
+	queue.supersede("test", &AlertState{Service: "redis", Status: "passing", EventID: "c3"})
+	notifications = queue.list()
+	if len(notifications) != 1 || notifications[0].Handler != "other" {
+		t.Fatalf("expected the queued notification for the handler to be dropped, got %#v", notifications)
+	}
+
+	// Summaries don't supersede each other, or the handler's other notifications
+	now := time.Now()
+	alerts := []*AlertState{{Service: "redis", Status: "critical", Message: "redis"}, {Service: "web", Status: "warning", Message: "web"}}
+	queue.enqueue("test", summarizeAlerts(alerts, "2 alerts", "digest/test", now), "handed off")
+	queue.enqueue("test", summarizeAlerts(alerts, "2 alerts", "digest/test", now.Add(time.Minute)), "handed off")
+	queue.supersede("test", summarizeAlerts(alerts, "2 alerts", "digest/test", now.Add(2*time.Minute)))
+	if notifications = queue.list(); len(notifications) != 3 {
+		t.Fatalf("expected both summaries to be kept, got %#v", notifications)
+	}
+}
+
+// Make sure the queue is persisted to and loaded from retry_queue_path
+func TestRetry_persist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{RetryQueuePath: path.Join(dir, "queue.json"), RetryMaxAge: 3600}
+	queue, err := newRetryQueue(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.add("pagerduty.ops", &AlertState{Node: "node1", Status: "critical"}, errors.New("timeout"), HandlerOptions{})
+
+	loaded, err := newRetryQueue(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifications := loaded.list()
+	if len(notifications) != 1 || notifications[0].Handler != "pagerduty.ops" || notifications[0].LastError != "timeout" {
+		t.Fatalf("expected queued notification to be loaded, got %v", notifications)
+	}
+}
+
+// Make sure the queue is written out on shutdown, including notifications that weren't
+// saved as they were added
+func TestRetry_persistOnShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-alerting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{RetryQueuePath: path.Join(dir, "queue.json"), RetryMaxAge: 3600}
+	queue, err := newRetryQueue(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.push(&QueuedNotification{ID: "slack.ops 1", Handler: "slack.ops", Alert: AlertState{Service: "redis", EventID: "1"}})
+	queue.persist()
+
+	loaded, err := newRetryQueue(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notifications := loaded.list(); len(notifications) != 1 || notifications[0].Handler != "slack.ops" {
+		t.Fatalf("expected the queued notification to be saved, got %v", notifications)
+	}
+}
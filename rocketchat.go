@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// RocketChatHandler posts alerts to a Rocket.Chat incoming webhook, formatted the same way
+// as Slack messages
+type RocketChatHandler struct {
+	WebhookURL string `mapstructure:"webhook_url"`
+	Channel    string `mapstructure:"channel"`
+	Alias      string `mapstructure:"alias"`
+	Emoji      string `mapstructure:"emoji"`
+	Avatar     string `mapstructure:"avatar"`
+}
+
+// The body of a message posted to a Rocket.Chat incoming webhook. Everything but the text
+// overrides the webhook's own settings.
+type rocketChatMessage struct {
+	Text    string `json:"text"`
+	Channel string `json:"channel,omitempty"`
+	Alias   string `json:"alias,omitempty"`
+	Emoji   string `json:"emoji,omitempty"`
+	Avatar  string `json:"avatar,omitempty"`
+}
+
+func (r RocketChatHandler) Alert(ctx context.Context, alert *AlertState) error {
+	err := postJSON(ctx, r.WebhookURL, nil, rocketChatMessage{
+		Text:    fmt.Sprintf(slackMessageFormat, alert.Message, alert.Details),
+		Channel: r.Channel,
+		Alias:   r.Alias,
+		Emoji:   r.Emoji,
+		Avatar:  r.Avatar,
+	})
+	if err != nil {
+		return fmt.Errorf("Error sending alert to Rocket.Chat: %s", err)
+	}
+	return nil
+}
+
+func (r RocketChatHandler) Validate() error {
+	if r.WebhookURL == "" {
+		return errors.New("no webhook_url given")
+	}
+	if r.Emoji != "" && r.Avatar != "" {
+		return errors.New("only one of emoji and avatar can be given")
+	}
+	return nil
+}
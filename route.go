@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// AlertMatcher matches alerts by their service, tag, node, datacenter, status, severity,
+// category and priority. The service, tag, node and datacenter are glob patterns (e.g.
+// "payments-*"), and an empty one matches anything.
+type AlertMatcher struct {
+	Service    string   `mapstructure:"service"`
+	Tag        string   `mapstructure:"tag"`
+	Node       string   `mapstructure:"node"`
+	Datacenter string   `mapstructure:"datacenter"`
+	Status     []string `mapstructure:"status"`
+	Severity   []string `mapstructure:"severity"`
+	Category   []string `mapstructure:"category"`
+
+	// Only match alerts with at least this priority
+	MinPriority int `mapstructure:"min_priority"`
+}
+
+// RouteConfig sends the alerts it matches to a set of handlers, instead of the service's
+// handlers or default_handlers
+type RouteConfig struct {
+	AlertMatcher `mapstructure:",squash"`
+
+	Name     string
+	Handlers []string `mapstructure:"handlers"`
+
+	// If true, keep looking for matching routes after this one, sending the alert to the
+	// handlers of every route that matches
+	Continue bool `mapstructure:"continue"`
+
+	// Limits the route to certain hours, e.g. to send warnings nowhere at night
+	Schedule *Schedule `mapstructure:"schedule"`
+}
+
+// Returns true if the route applies to an alert for the given service at the given time
+func (r *RouteConfig) applies(service string, alert *AlertState, now time.Time) bool {
+	return r.matches(service, alert) && r.Schedule.active(now)
+}
+
+// Returns true if the matcher matches an alert for the given service. A passing alert is
+// matched by the status, severity, category and priority it's recovering from as well, so
+// recoveries go to the same handlers as the alert they resolve.
+func (r *AlertMatcher) matches(service string, alert *AlertState) bool {
+	for _, match := range []struct{ pattern, value string }{
+		{r.Service, service},
+		{r.Tag, alert.Tag},
+		{r.Node, alert.Node},
+		{r.Datacenter, alert.Datacenter},
+	} {
+		if match.pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(match.pattern, match.value); !ok {
+			return false
+		}
+	}
+
+	recovering := alert.Status == api.HealthPassing
+	if len(r.Status) > 0 && !contains(r.Status, alert.Status) && !(recovering && contains(r.Status, alert.LastAlerted)) {
+		return false
+	}
+	if len(r.Severity) > 0 && !contains(r.Severity, alert.Severity) && !(recovering && contains(r.Severity, alert.LastSeverity)) {
+		return false
+	}
+	if len(r.Category) > 0 && !contains(r.Category, alert.Category) && !(recovering && contains(r.Category, alert.LastCategory)) {
+		return false
+	}
+	if r.MinPriority > 0 && alert.Priority < r.MinPriority && !(recovering && alert.LastPriority >= r.MinPriority) {
+		return false
+	}
+	return true
+}
+
+// Checks the matcher's patterns and statuses are valid
+func (r *AlertMatcher) validate() error {
+	for _, pattern := range []string{r.Service, r.Tag, r.Node, r.Datacenter} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+	}
+	for _, status := range r.Status {
+		if !contains([]string{api.HealthPassing, api.HealthWarning, api.HealthCritical}, status) {
+			return fmt.Errorf("invalid status %q, must be passing, warning or critical", status)
+		}
+	}
+	for _, severity := range r.Severity {
+		if _, ok := severityOrder[severity]; !ok {
+			return fmt.Errorf("invalid severity %q, must be ok, warning, critical or unknown", severity)
+		}
+	}
+	if r.MinPriority < 0 {
+		return fmt.Errorf("invalid min_priority %d, can't be negative", r.MinPriority)
+	}
+	return nil
+}
+
+// Parse the raw route objects into the config, keeping them in the order they're defined
+func parseRoutes(list *ast.ObjectList, config *Config) error {
+	for _, r := range list.Items {
+		if len(r.Keys) != 1 {
+			return fmt.Errorf("route block must have a name")
+		}
+		name := r.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var route RouteConfig
+		if err := hcl.DecodeObject(&m, r.Val); err != nil {
+			return err
+		}
+
+		// A schedule block decodes as a list of objects, so unwrap it
+		if blocks, ok := m["schedule"].([]map[string]interface{}); ok {
+			if len(blocks) != 1 {
+				return fmt.Errorf("Route %s can only have one schedule block", name)
+			}
+			m["schedule"] = blocks[0]
+		}
+
+		if err := mapstructure.WeakDecode(m, &route); err != nil {
+			return fmt.Errorf("Invalid route %s: %s", name, err)
+		}
+		route.Name = name
+
+		if err := route.validate(); err != nil {
+			return fmt.Errorf("Invalid route %s: %s", name, err)
+		}
+		if route.Schedule != nil {
+			if err := route.Schedule.parse(config.Timezone); err != nil {
+				return fmt.Errorf("Invalid schedule for route %s: %s", name, err)
+			}
+		}
+
+		// An empty list of handlers sends the alerts the route matches nowhere
+		if _, ok := m["handlers"]; !ok {
+			return fmt.Errorf("Route %s has no handlers", name)
+		}
+
+		config.Routes = append(config.Routes, route)
+	}
+
+	return nil
+}
+
+// Returns the sorted names of the handlers the routes send an alert for the given service
+// to, and false if no route matches it
+func (c *Config) routeHandlerNames(service string, alert *AlertState) ([]string, bool) {
+	names := make([]string, 0)
+	matched := false
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if !route.applies(service, alert, time.Now()) {
+			continue
+		}
+		matched = true
+		for _, name := range route.Handlers {
+			if !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		if !route.Continue {
+			break
+		}
+	}
+	sort.Strings(names)
+	return names, matched
+}
+
+// Returns the sorted names of the handlers to send an alert for the given service to: the
+// handlers of the routes that match it, or the service's handlers if none do. A recovery
+// goes to the handlers that got the alert it resolves that still exist, whatever the routes
+// say now, so a schedule ending in between can't send it somewhere else.
+func (c *Config) alertHandlerNames(service string, alert *AlertState) []string {
+	recovering := alert.Status == api.HealthPassing && alert.LastAlerted != "" && alert.LastAlerted != api.HealthPassing
+	if recovering && alert.LastDelivered != nil {
+		names := make([]string, 0, len(alert.LastDelivered))
+		for _, name := range alert.LastDelivered {
+			if _, ok := c.Handlers[name]; ok && !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	if names, ok := c.routeHandlerNames(service, alert); ok {
+		return names
+	}
+	return c.serviceHandlerNames(service)
+}
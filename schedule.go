@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The day names a schedule's days can be given with, in time.Weekday order
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Schedule limits when a handler is sent alerts to a window of hours on certain days, e.g.
+// business hours for email, or the hours outside them for paging
+type Schedule struct {
+	// The days the window applies on, e.g. "mon-fri" or "sat,sun". Defaults to every day.
+	Days string `mapstructure:"days"`
+
+	// The window, e.g. "09:00-18:00". A window that ends before it starts runs past
+	// midnight, and counts as part of the day it started on. Defaults to all day.
+	Hours string `mapstructure:"hours"`
+
+	// The time zone to use, e.g. "Europe/London". Defaults to the global timezone, or the
+	// local time zone.
+	Timezone string `mapstructure:"timezone"`
+
+	// If true, the handler is sent alerts outside the window instead of during it
+	Outside bool `mapstructure:"outside"`
+
+	days     [7]bool
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+// Parses the schedule's settings, returning an error if any are invalid. The default time
+// zone is used if the schedule doesn't set one.
+func (s *Schedule) parse(defaultTimezone string) error {
+	if s.Timezone == "" {
+		s.Timezone = defaultTimezone
+	}
+	if s.Days == "" && s.Hours == "" {
+		return errors.New("must set days or hours")
+	}
+	if s.Days == "" {
+		for i := range s.days {
+			s.days[i] = true
+		}
+	}
+	for _, part := range strings.Split(s.Days, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := scheduleDay(bounds[0])
+		if !ok {
+			return fmt.Errorf("invalid day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = scheduleDay(bounds[1]); !ok {
+				return fmt.Errorf("invalid day %q", bounds[1])
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			s.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	if s.Hours != "" {
+		bounds := strings.SplitN(s.Hours, "-", 2)
+		if len(bounds) != 2 {
+			return fmt.Errorf("invalid hours %q, must be in the form 09:00-18:00", s.Hours)
+		}
+		var err error
+		if s.start, err = scheduleTime(bounds[0]); err != nil {
+			return err
+		}
+		if s.end, err = scheduleTime(bounds[1]); err != nil {
+			return err
+		}
+		if s.start == s.end {
+			return errors.New("hours can't start and end at the same time")
+		}
+	}
+
+	s.location = time.Local
+	if s.Timezone != "" {
+		location, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %s", s.Timezone, err)
+		}
+		s.location = location
+	}
+	return nil
+}
+
+// Returns the index of a day name, in time.Weekday order
+func scheduleDay(name string) (int, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, day := range scheduleDays {
+		if name == day {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Parses a time of day like 09:30 into the time since midnight
+func scheduleTime(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, must be in the form 15:04", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Returns true if the time falls in the schedule's window
+func (s *Schedule) inWindow(now time.Time) bool {
+	now = now.In(s.location)
+	day := int(now.Weekday())
+	if s.Hours == "" {
+		return s.days[day]
+	}
+
+	sinceMidnight := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	if s.start < s.end {
+		return s.days[day] && sinceMidnight >= s.start && sinceMidnight < s.end
+	}
+
+	// The window runs past midnight, so the early hours belong to the previous day's window
+	if sinceMidnight >= s.start {
+		return s.days[day]
+	}
+	return sinceMidnight < s.end && s.days[(day+6)%7]
+}
+
+// Describes when the schedule is active, e.g. "during 09:00-18:00 on mon-fri"
+func (s *Schedule) String() string {
+	description := "during"
+	if s.Outside {
+		description = "outside"
+	}
+	if s.Hours != "" {
+		description += " " + s.Hours
+	}
+	if s.Days != "" {
+		description += " on " + s.Days
+	}
+	if s.Timezone != "" {
+		description += " (" + s.Timezone + ")"
+	}
+	return description
+}
+
+// Returns the first minute after the given time that the schedule isn't active, or the zero
+// time if it stays active for the next week
+func (s *Schedule) activeUntil(now time.Time) time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	for t := now.Truncate(time.Minute).Add(time.Minute); t.Before(now.Add(7 * 24 * time.Hour)); t = t.Add(time.Minute) {
+		if !s.active(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Returns true if the handler with this schedule should be sent alerts at the given time
+func (s *Schedule) active(now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	return s.inWindow(now) != s.Outside
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSchedule_active(t *testing.T) {
+	config, err := ParseConfig(`
+	handler "stdout" "office" {
+		schedule {
+			days = "mon-fri"
+			hours = "09:00-18:00"
+			timezone = "UTC"
+		}
+	}
+	handler "stdout" "oncall" {
+		schedule {
+			days = "mon-fri"
+			hours = "09:00-18:00"
+			timezone = "UTC"
+			outside = true
+		}
+	}
+	handler "stdout" "nights" {
+		schedule {
+			days = "fri"
+			hours = "22:00-06:00"
+			timezone = "UTC"
+		}
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	office := config.handlerOptions("stdout.office").Schedule
+	oncall := config.handlerOptions("stdout.oncall").Schedule
+	nights := config.handlerOptions("stdout.nights").Schedule
+
+	cases := []struct {
+		time                   string
+		office, oncall, nights bool
+	}{
+		// Wednesday
+		{"2026-10-14T10:00:00Z", true, false, false},
+		{"2026-10-14T18:00:00Z", false, true, false},
+		// Friday night and the early hours of Saturday
+		{"2026-10-16T23:00:00Z", false, true, true},
+		{"2026-10-17T05:59:00Z", false, true, true},
+		{"2026-10-17T06:00:00Z", false, true, false},
+		// Saturday night isn't part of Friday's window
+		{"2026-10-18T03:00:00Z", false, true, false},
+	}
+	for _, c := range cases {
+		now, _ := time.Parse(time.RFC3339, c.time)
+		if office.active(now) != c.office || oncall.active(now) != c.oncall || nights.active(now) != c.nights {
+			t.Errorf("%s: expected office=%v oncall=%v nights=%v, got %v %v %v", c.time, c.office, c.oncall, c.nights,
+				office.active(now), oncall.active(now), nights.active(now))
+		}
+	}
+
+	if (*Schedule)(nil).active(time.Now()) != true {
+		t.Error("expected a handler without a schedule to always be active")
+	}
+
+	for _, schedule := range []string{
+		`days = "mon-funday"`,
+		`hours = "09:00"`,
+		`hours = "9am-5pm"`,
+		`days = "mon", timezone = "Mars/Olympus_Mons"`,
+		`outside = true`,
+	} {
+		if _, err := ParseConfig(`handler "stdout" "ops" { schedule { ` + schedule + ` } }`); err == nil {
+			t.Errorf("expected an error for schedule %s", schedule)
+		}
+	}
+}
+
+// Make sure a recovery goes to the handlers that got the alert it resolves, even once the
+// route that sent it there is outside its schedule
+func TestSchedule_routeRecovery(t *testing.T) {
+	// A day that isn't today, so the route is outside its schedule now
+	day := strings.ToLower(time.Now().UTC().Add(48 * time.Hour).Weekday().String()[:3])
+	config, err := ParseConfig(`
+	route "pages" {
+		handlers = ["stdout.pager"]
+		schedule {
+			days = "` + day + `"
+			timezone = "UTC"
+		}
+	}
+	handler "stdout" "pager" {}
+	handler "stdout" "chat" {}
+	default_handlers = ["stdout.chat"]
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Service: "redis", Status: api.HealthPassing, LastAlerted: api.HealthCritical, LastDelivered: []string{"stdout.pager"}}
+	if handlers := config.alertHandlerNames("redis", alert); !reflect.DeepEqual(handlers, []string{"stdout.pager"}) {
+		t.Errorf("expected the recovery to be sent to stdout.pager, got %v", handlers)
+	}
+
+	// Without a record of where the alert went, the routes decide
+	alert.LastDelivered = nil
+	if handlers := config.alertHandlerNames("redis", alert); !reflect.DeepEqual(handlers, []string{"stdout.chat"}) {
+		t.Errorf("expected the recovery to be sent to stdout.chat, got %v", handlers)
+	}
+}
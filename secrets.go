@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The sources handler settings can reference secrets from, by the scheme of the reference,
+// e.g. env://SLACK_WEBHOOK_URL. Each resolves the rest of the reference to the secret.
+var secretSources = map[string]func(config *Config, ref string) (string, error){
+	"env":   envSecret,
+	"vault": vaultSecret,
+}
+
+// Reads a secret from an environment variable
+func envSecret(config *Config, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s isn't set", name)
+	}
+	return value, nil
+}
+
+// Returns the scheme of the secret a setting references, if it's a reference
+func secretScheme(value string) (string, bool) {
+	for scheme := range secretSources {
+		if strings.HasPrefix(value, scheme+"://") {
+			return scheme, true
+		}
+	}
+	return "", false
+}
+
+// Returns the secret a setting references, or the setting unchanged if it isn't a reference
+func resolveSecret(config *Config, value string) (string, error) {
+	scheme, ok := secretScheme(value)
+	if !ok {
+		return value, nil
+	}
+	return secretSources[scheme](config, strings.TrimPrefix(value, scheme+"://"))
+}
+
+// Returns a copy of a decoded config block with every string setting that references a
+// secret replaced by the secret, including those in nested lists and blocks
+func resolveSecrets(config *Config, m map[string]interface{}) (map[string]interface{}, error) {
+	resolved, err := mapSecrets(m, func(value string) (string, error) {
+		return resolveSecret(config, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resolved.(map[string]interface{}), nil
+}
+
+// Returns true if any setting in a decoded config block references a secret with the scheme
+func referencesSecrets(m map[string]interface{}, scheme string) bool {
+	found := false
+	mapSecrets(m, func(value string) (string, error) {
+		if s, ok := secretScheme(value); ok && s == scheme {
+			found = true
+		}
+		return value, nil
+	})
+	return found
+}
+
+// Returns a copy of a decoded config value with fn applied to every string in it
+func mapSecrets(value interface{}, fn func(string) (string, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return fn(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			mapped, err := mapSecrets(item, fn)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			m[key] = mapped
+		}
+		return m, nil
+	case []map[string]interface{}:
+		list := make([]map[string]interface{}, len(v))
+		for i, item := range v {
+			mapped, err := mapSecrets(item, fn)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = mapped.(map[string]interface{})
+		}
+		return list, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			mapped, err := mapSecrets(item, fn)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = mapped
+		}
+		return list, nil
+	}
+	return value, nil
+}
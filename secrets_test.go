@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// Make sure env:// references in handler settings are replaced with the variable's value
+func TestSecrets_env(t *testing.T) {
+	os.Setenv("TEST_SLACK_WEBHOOK_URL", "https://hooks.slack.com/services/secret")
+	os.Setenv("TEST_TEAM", "storage")
+	defer os.Unsetenv("TEST_SLACK_WEBHOOK_URL")
+	defer os.Unsetenv("TEST_TEAM")
+
+	config, err := ParseConfig(`
+	handler "slack" "ops" {
+		webhook_url = "env://TEST_SLACK_WEBHOOK_URL"
+		middleware = [{ type = "inject", fields = { team = "env://TEST_TEAM" } }]
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url := config.Handlers["slack.ops"].(SlackHandler).WebhookURL; url != "https://hooks.slack.com/services/secret" {
+		t.Errorf("expected the webhook URL from the environment, got %q", url)
+	}
+	inject := config.handlerOptions("slack.ops").middleware[0].(*InjectMiddleware)
+	if inject.Fields["team"] != "storage" {
+		t.Errorf("expected the nested field from the environment, got %q", inject.Fields["team"])
+	}
+
+	if _, err := ParseConfig(`handler "slack" "ops" { webhook_url = "env://TEST_MISSING_VAR" }`); err == nil {
+		t.Error("expected an error for an unset variable")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// SentryHandler records critical alerts as Sentry events, one for each critical check.
+// Events are fingerprinted by the node/service and check, so each failing check becomes a
+// Sentry issue counting how often it has failed.
+type SentryHandler struct {
+	DSN         string `mapstructure:"dsn"`
+	Environment string `mapstructure:"environment"`
+}
+
+// An event in the format of Sentry's ingestion API
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	Message     string            `json:"message"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Fingerprint []string          `json:"fingerprint"`
+	Tags        map[string]string `json:"tags"`
+	Extra       map[string]string `json:"extra"`
+}
+
+// The parts of a Sentry DSN needed to send it events
+type sentryDSN struct {
+	endpoint  string
+	publicKey string
+}
+
+// Parses a DSN like https://<key>@o123.ingest.sentry.io/<project>, returning the
+// project's envelope endpoint and key
+func parseSentryDSN(dsn string) (sentryDSN, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		return sentryDSN{}, err
+	}
+	if parsed.User == nil || parsed.User.Username() == "" {
+		return sentryDSN{}, errors.New("no public key in dsn")
+	}
+	slash := strings.LastIndex(parsed.Path, "/")
+	if slash < 0 || parsed.Path[slash+1:] == "" {
+		return sentryDSN{}, errors.New("no project ID in dsn")
+	}
+
+	endpoint := fmt.Sprintf("%s://%s%s/api/%s/envelope/", parsed.Scheme, parsed.Host, parsed.Path[:slash], parsed.Path[slash+1:])
+	return sentryDSN{endpoint: endpoint, publicKey: parsed.User.Username()}, nil
+}
+
+// Returns the events to record for an alert, one for each of its critical checks
+func (s SentryHandler) alertEvents(alert *AlertState, now time.Time) []sentryEvent {
+	// Meta-alerts and alerts without check details get a single event for the alert itself
+	checks := alert.Checks
+	if len(checks) == 0 {
+		checks = []AlertCheck{{Node: alert.Node, Status: alert.Status, Output: alert.Details}}
+	}
+
+	var events []sentryEvent
+	for _, check := range checks {
+		if check.Status != api.HealthCritical {
+			continue
+		}
+
+		// Derive the event's ID from the alert's, so a retried alert doesn't count twice
+		sum := sha1.Sum([]byte(alert.EventID + "/" + check.Node + "/" + check.CheckID))
+		event := sentryEvent{
+			EventID:     fmt.Sprintf("%x", sum[:16]),
+			Timestamp:   now.UTC().Format(time.RFC3339),
+			Platform:    "other",
+			Level:       "error",
+			Logger:      "consul-alerting",
+			Message:     alert.Message,
+			ServerName:  check.Node,
+			Environment: s.Environment,
+			Fingerprint: []string{"consul-alerting", alertTargetKey(alert), check.CheckID},
+			Tags:        map[string]string{},
+			Extra:       map[string]string{"output": check.Output},
+		}
+		if check.Name != "" {
+			event.Message = fmt.Sprintf("%s: %s", alert.Message, check.Name)
+		}
+		for key, value := range map[string]string{
+			"datacenter": alert.Datacenter,
+			"service":    alert.Service,
+			"tag":        alert.Tag,
+			"node":       check.Node,
+			"check":      check.CheckID,
+		} {
+			if value != "" {
+				event.Tags[key] = value
+			}
+		}
+		if check.Link != "" {
+			event.Extra["link"] = check.Link
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// Sends an event to Sentry in an envelope
+func (s SentryHandler) send(ctx context.Context, dsn sentryDSN, event sentryEvent) error {
+	header, err := json.Marshal(map[string]string{"event_id": event.EventID, "dsn": s.DSN, "sent_at": event.Timestamp})
+	if err != nil {
+		return err
+	}
+	item, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	body.Write(header)
+	body.WriteString("\n{\"type\":\"event\"}\n")
+	body.Write(item)
+	body.WriteString("\n")
+
+	req, err := http.NewRequest("POST", dsn.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=consul-alerting, sentry_key=%s", dsn.publicKey))
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("got response code %d (%s)", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (s SentryHandler) Alert(ctx context.Context, alert *AlertState) error {
+	dsn, err := parseSentryDSN(s.DSN)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range s.alertEvents(alert, time.Now()) {
+		if err := s.send(ctx, dsn, event); err != nil {
+			return fmt.Errorf("Error sending alert to Sentry: %s", err)
+		}
+	}
+	return nil
+}
+
+func (s SentryHandler) Validate() error {
+	if s.DSN == "" {
+		return errors.New("no dsn given")
+	}
+	if _, err := parseSentryDSN(s.DSN); err != nil {
+		return fmt.Errorf("invalid dsn: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSentry_alert(t *testing.T) {
+	var path, auth string
+	var events []sentryEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("X-Sentry-Auth")
+		raw, _ := ioutil.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+		var event sentryEvent
+		if len(lines) == 3 && json.Unmarshal([]byte(lines[2]), &event) == nil {
+			events = append(events, event)
+		}
+	}))
+	defer server.Close()
+
+	handler := SentryHandler{DSN: "http://key123@" + strings.TrimPrefix(server.URL, "http://") + "/42", Environment: "prod"}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	alert := &AlertState{Status: "critical", Service: "redis", EventID: "abc123", Message: "redis is critical", Checks: []AlertCheck{
+		{Node: "node1", CheckID: "redis-ping", Name: "Redis ping", Status: "critical", Output: "timeout"},
+		{Node: "node2", CheckID: "redis-ping", Name: "Redis ping", Status: "warning"},
+	}}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/api/42/envelope/" || !strings.Contains(auth, "sentry_key=key123") {
+		t.Errorf("unexpected request to %s with auth %q", path, auth)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected an event for the critical check only, got %v", events)
+	}
+	event := events[0]
+	if strings.Join(event.Fingerprint, " ") != "consul-alerting redis-- redis-ping" || event.Tags["node"] != "node1" || event.Extra["output"] != "timeout" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	// Passing alerts aren't recorded
+	events = nil
+	if err := handler.Alert(context.Background(), &AlertState{Status: "passing", Service: "redis"}); err != nil || len(events) != 0 {
+		t.Errorf("expected no events for a passing alert, got %v (%v)", events, err)
+	}
+}
+
+func TestSentry_parseDSN(t *testing.T) {
+	dsn, err := parseSentryDSN("https://key123@sentry.example.com/sentry/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dsn.endpoint != "https://sentry.example.com/sentry/api/42/envelope/" || dsn.publicKey != "key123" {
+		t.Errorf("unexpected dsn: %+v", dsn)
+	}
+
+	if _, err := parseSentryDSN("https://sentry.example.com/42"); err == nil {
+		t.Error("expected an error for a dsn without a key")
+	}
+}
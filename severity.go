@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// The severities an alert or check can have. They start out mapped from the Consul status,
+// and can be changed by severity rules matching the check's output.
+const (
+	severityOK       = "ok"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+	severityUnknown  = "unknown"
+)
+
+// The severities in order, worst first
+var severityOrder = map[string]int{
+	severityCritical: 0,
+	severityUnknown:  1,
+	severityWarning:  2,
+	severityOK:       3,
+}
+
+// Returns an alert's severity, or the one its status maps to if it doesn't have one, e.g.
+// because it was saved before severities were tracked
+func alertSeverity(severity, status string) string {
+	if severity == "" {
+		return statusSeverity(status)
+	}
+	return severity
+}
+
+// Returns the severity a Consul health status maps to
+func statusSeverity(status string) string {
+	switch status {
+	case api.HealthPassing:
+		return severityOK
+	case api.HealthWarning:
+		return severityWarning
+	case api.HealthCritical:
+		return severityCritical
+	}
+	return severityUnknown
+}
+
+// SeverityRule changes the severity of failing checks whose output matches a regular
+// expression, e.g. to make a warning about a full disk critical
+type SeverityRule struct {
+	Name string
+
+	// The regular expression the check's output must match. Required.
+	Output string `mapstructure:"output"`
+
+	// Optional glob patterns the check's name and the alert's service must match
+	Check   string `mapstructure:"check"`
+	Service string `mapstructure:"service"`
+
+	// The severity to give the matching checks. Required.
+	Severity string `mapstructure:"severity"`
+
+	output *regexp.Regexp
+}
+
+// Returns true if the rule applies to the check, on an alert for the given service
+func (r *SeverityRule) matches(service string, check AlertCheck) bool {
+	if ok, _ := path.Match(r.Check, check.Name); r.Check != "" && !ok {
+		return false
+	}
+	if ok, _ := path.Match(r.Service, service); r.Service != "" && !ok {
+		return false
+	}
+	return r.output.MatchString(check.Output)
+}
+
+// Parse the raw severity rule objects into the config, keeping them in the order they're
+// defined
+func parseSeverityRules(list *ast.ObjectList, config *Config) error {
+	for _, r := range list.Items {
+		if len(r.Keys) != 1 {
+			return fmt.Errorf("severity_rule block must have a name")
+		}
+		name := r.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var rule SeverityRule
+		if err := hcl.DecodeObject(&m, r.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &rule); err != nil {
+			return fmt.Errorf("Invalid severity_rule %s: %s", name, err)
+		}
+		rule.Name = name
+
+		if rule.Output == "" {
+			return fmt.Errorf("Severity rule %s has no output", name)
+		}
+		var err error
+		if rule.output, err = regexp.Compile(rule.Output); err != nil {
+			return fmt.Errorf("Invalid output for severity_rule %s: %s", name, err)
+		}
+		if _, ok := severityOrder[rule.Severity]; !ok {
+			return fmt.Errorf("Invalid severity for severity_rule %s: %q, must be ok, warning, critical or unknown", name, rule.Severity)
+		}
+		for _, pattern := range []string{rule.Check, rule.Service} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid pattern %q for severity_rule %s: %s", pattern, name, err)
+			}
+		}
+
+		config.SeverityRules = append(config.SeverityRules, rule)
+	}
+
+	return nil
+}
+
+// Returns the severity of a failing check on an alert for the given service: that of the
+// service's first output rule matching it, if it sets one, or the first severity rule
+// matching it, or the one its status maps to
+func (c *Config) checkSeverity(service string, check AlertCheck) string {
+	if rule := c.outputRule(service, check.Name, check.Output); rule != nil && rule.Severity != "" {
+		return rule.Severity
+	}
+	for i := range c.SeverityRules {
+		if rule := &c.SeverityRules[i]; rule.matches(service, check) {
+			return rule.Severity
+		}
+	}
+	return statusSeverity(check.Status)
+}
+
+// Sets the severity and category of the alert's failing checks, and of the alert itself:
+// the worst of their severities, and the category of its worst categorized check. Passing
+// alerts are always ok, and alerts without any checks get the severity their status maps
+// to and no category.
+func (c *Config) applySeverity(service string, alert *AlertState) {
+	alert.Category = ""
+	if alert.Status == api.HealthPassing || len(alert.Checks) == 0 {
+		alert.Severity = statusSeverity(alert.Status)
+		return
+	}
+
+	alert.Severity = severityOK
+	worstCategorized := severityOK
+	for i := range alert.Checks {
+		check := &alert.Checks[i]
+		check.Severity = c.checkSeverity(service, *check)
+		check.Category = c.checkCategory(service, *check)
+		if severityOrder[check.Severity] < severityOrder[alert.Severity] {
+			alert.Severity = check.Severity
+		}
+		if check.Category != "" && (alert.Category == "" || severityOrder[check.Severity] < severityOrder[worstCategorized]) {
+			alert.Category, worstCategorized = check.Category, check.Severity
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSeverity_rules(t *testing.T) {
+	config, err := ParseConfig(`
+	severity_rule "disk-full" {
+		check = "Disk*"
+		output = "(9[5-9]|100)% used"
+		severity = "critical"
+	}
+	severity_rule "flaky-probe" {
+		service = "web"
+		output = "timeout"
+		severity = "warning"
+	}
+	route "pages" {
+		severity = ["critical"]
+		handlers = ["stdout.pager"]
+	}
+	handler "stdout" "pager" {}
+	handler "stdout" "chat" {}
+	default_handlers = ["stdout.chat"]
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service  string
+		status   string
+		checks   []AlertCheck
+		severity string
+		handlers []string
+	}{
+		{"redis", api.HealthWarning, []AlertCheck{{Name: "Disk usage", Status: api.HealthWarning, Output: "97% used"}}, severityCritical, []string{"stdout.pager"}},
+		{"redis", api.HealthWarning, []AlertCheck{{Name: "Disk usage", Status: api.HealthWarning, Output: "91% used"}}, severityWarning, []string{"stdout.chat"}},
+		{"redis", api.HealthWarning, []AlertCheck{{Name: "Memory", Status: api.HealthWarning, Output: "97% used"}}, severityWarning, []string{"stdout.chat"}},
+		{"web", api.HealthCritical, []AlertCheck{{Name: "HTTP", Status: api.HealthCritical, Output: "timeout"}}, severityWarning, []string{"stdout.chat"}},
+		{"web", api.HealthCritical, []AlertCheck{
+			{Name: "HTTP", Status: api.HealthCritical, Output: "timeout"},
+			{Name: "TCP", Status: api.HealthCritical, Output: "connection refused"},
+		}, severityCritical, []string{"stdout.pager"}},
+		{"web", api.HealthCritical, []AlertCheck{{Name: "Script", Status: "error"}}, severityUnknown, []string{"stdout.chat"}},
+		{"web", api.HealthPassing, nil, severityOK, []string{"stdout.chat"}},
+	}
+
+	for i, tc := range cases {
+		alert := &AlertState{Service: tc.service, Status: tc.status, Checks: tc.checks}
+		config.applySeverity(tc.service, alert)
+		if alert.Severity != tc.severity {
+			t.Errorf("case %d: expected severity %s, got %s", i, tc.severity, alert.Severity)
+		}
+		if handlers := config.alertHandlerNames(tc.service, alert); !reflect.DeepEqual(handlers, tc.handlers) {
+			t.Errorf("case %d: expected handlers %v, got %v", i, tc.handlers, handlers)
+		}
+	}
+
+	// A recovery goes to the same handlers as the alert it resolves
+	alert := &AlertState{Service: "redis", Status: api.HealthPassing, LastAlerted: api.HealthWarning, LastSeverity: severityCritical}
+	config.applySeverity("redis", alert)
+	if handlers := config.alertHandlerNames("redis", alert); !reflect.DeepEqual(handlers, []string{"stdout.pager"}) {
+		t.Errorf("expected the recovery to be sent to stdout.pager, got %v", handlers)
+	}
+}
+
+func TestSeverity_invalidRules(t *testing.T) {
+	for _, raw := range []string{
+		`severity_rule "a" { severity = "critical" }`,
+		`severity_rule "a" { output = "(" severity = "critical" }`,
+		`severity_rule "a" { output = "x" severity = "bad" }`,
+		`severity_rule "a" { output = "x" severity = "critical" check = "[" }`,
+		`route "a" { severity = ["bad"] handlers = ["stdout.a"] }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("expected an error parsing %s", raw)
+		}
+	}
+}
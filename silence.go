@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Silence suppresses the notifications for the alerts it matches between its start and end
+// times, e.g. for a planned deploy or maintenance window. Silenced alerts are still logged,
+// stored and counted, they just aren't sent to any handlers.
+type Silence struct {
+	AlertMatcher `mapstructure:",squash"`
+
+	Name string
+
+	// An optional regular expression the alert's display name must match, e.g.
+	// "service payments-.* \\(tag: canary\\)"
+	Match string `mapstructure:"match"`
+
+	// When the silence starts and ends, in RFC 3339 format. It starts right away if Start
+	// is empty.
+	Start string `mapstructure:"start"`
+	End   string `mapstructure:"end"`
+
+	// Why the alerts are silenced, for the logs
+	Comment string `mapstructure:"comment"`
+
+	match *regexp.Regexp
+	start time.Time
+	end   time.Time
+}
+
+// Parses and validates the silence's times and patterns
+func (s *Silence) parse() error {
+	if err := s.AlertMatcher.validate(); err != nil {
+		return err
+	}
+	if s.Match != "" {
+		var err error
+		if s.match, err = regexp.Compile(s.Match); err != nil {
+			return fmt.Errorf("invalid match: %s", err)
+		}
+	}
+
+	if s.End == "" {
+		return fmt.Errorf("end must be set")
+	}
+	var err error
+	if s.end, err = time.Parse(time.RFC3339, s.End); err != nil {
+		return fmt.Errorf("invalid end: %s", err)
+	}
+	if s.Start != "" {
+		if s.start, err = time.Parse(time.RFC3339, s.Start); err != nil {
+			return fmt.Errorf("invalid start: %s", err)
+		}
+		if !s.start.Before(s.end) {
+			return fmt.Errorf("start must be before end")
+		}
+	}
+	return nil
+}
+
+// Returns true if the silence is in effect at the given time
+func (s *Silence) active(now time.Time) bool {
+	return !now.Before(s.start) && now.Before(s.end)
+}
+
+// Returns true if the silence applies to an alert for the given service
+func (s *Silence) matches(service string, alert *AlertState) bool {
+	if !s.AlertMatcher.matches(service, alert) {
+		return false
+	}
+	return s.match == nil || s.match.MatchString(watchName(alert.Node, alert.Service, alert.Tag))
+}
+
+// Parse the raw silence objects into the config
+func parseSilences(list *ast.ObjectList, config *Config) error {
+	for _, s := range list.Items {
+		if len(s.Keys) != 1 {
+			return fmt.Errorf("silence block must have a name")
+		}
+		name := s.Keys[0].Token.Value().(string)
+
+		var m map[string]interface{}
+		var silence Silence
+		if err := hcl.DecodeObject(&m, s.Val); err != nil {
+			return err
+		}
+		if err := mapstructure.WeakDecode(m, &silence); err != nil {
+			return fmt.Errorf("Invalid silence %s: %s", name, err)
+		}
+		silence.Name = name
+
+		if err := silence.parse(); err != nil {
+			return fmt.Errorf("Invalid silence %s: %s", name, err)
+		}
+
+		config.Silences = append(config.Silences, silence)
+	}
+
+	return nil
+}
+
+// Returns the silence in effect for an alert for the given service, from the config or the
+// KV store, or nil if it isn't silenced
+func (c *Config) silencedBy(service string, alert *AlertState, now time.Time) *Silence {
+	silences := append(append([]Silence{}, c.Silences...), kvSilences.list()...)
+	for i := range silences {
+		if silence := &silences[i]; silence.active(now) && silence.matches(service, alert) {
+			return silence
+		}
+	}
+	return nil
+}
+
+// SilenceRegistry holds the silences stored in the Consul KV store, so deploy tooling can
+// add and remove them without a config reload
+type SilenceRegistry struct {
+	sync.Mutex
+	silences []Silence
+}
+
+var kvSilences = &SilenceRegistry{}
+
+func (r *SilenceRegistry) set(silences []Silence) {
+	r.Lock()
+	r.silences = silences
+	r.Unlock()
+}
+
+func (r *SilenceRegistry) list() []Silence {
+	r.Lock()
+	defer r.Unlock()
+	return r.silences
+}
+
+// Parses the silences stored as JSON documents under the KV prefix, each named after the
+// rest of its key. Invalid silences are logged and skipped, so one bad document can't stop
+// the others from applying.
+func parseKVSilences(prefix string, pairs api.KVPairs) []Silence {
+	silences := make([]Silence, 0, len(pairs))
+	for _, pair := range pairs {
+		name := strings.TrimPrefix(pair.Key, prefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		var m map[string]interface{}
+		var silence Silence
+		err := json.Unmarshal(pair.Value, &m)
+		if err == nil {
+			err = mapstructure.WeakDecode(m, &silence)
+		}
+		silence.Name = name
+		if err == nil {
+			err = silence.parse()
+		}
+		if err != nil {
+			log.Errorf("Ignoring invalid silence %s in the KV store: %s", pair.Key, err)
+			continue
+		}
+		silences = append(silences, silence)
+	}
+	return silences
+}
+
+// Watches the silence_kv_prefix in the KV store, applying the silences stored there as they
+// change. Runs until the process exits.
+func watchKVSilences(config *Config, client *api.Client) {
+	queryOpts := &api.QueryOptions{
+		AllowStale: true,
+		WaitTime:   watchWaitTime,
+	}
+	backoff := newBackoff()
+	tracker := newIndexTracker(silenceWatchLoop)
+
+	for {
+		queryStart := time.Now()
+		pairs, queryMeta, err := client.KV().List(config.SilenceKVPrefix, queryOpts)
+
+		heartbeats.beat(silenceWatchLoop)
+		consulHealth.record(silenceWatchLoop, err)
+		if err != nil {
+			wait := backoff.next()
+			log.Errorf("Error trying to watch silences: %s, retrying in %s...", err, wait)
+			time.Sleep(wait)
+			continue
+		}
+		backoff.reset()
+
+		changed := queryMeta.LastIndex != queryOpts.WaitIndex
+		var anomaly string
+		queryOpts.WaitIndex, anomaly = tracker.update(queryMeta.LastIndex, time.Since(queryStart))
+		if anomaly == indexFastReturn {
+			time.Sleep(minQueryInterval)
+		}
+
+		if changed {
+			silences := parseKVSilences(config.SilenceKVPrefix, pairs)
+			log.Infof("Loaded %d silences from %s", len(silences), config.SilenceKVPrefix)
+			kvSilences.set(silences)
+		}
+		heartbeats.markReady(silenceWatchLoop)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSilence_silencedBy(t *testing.T) {
+	config, err := ParseConfig(`
+	silence "payments-deploy" {
+		service = "payments-*"
+		start = "2026-10-20T22:00:00Z"
+		end = "2026-10-20T23:30:00Z"
+	}
+	silence "canaries" {
+		match = "\\(tag: canary\\)$"
+		end = "2026-10-21T00:00:00Z"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	during := time.Date(2026, 10, 20, 22, 30, 0, 0, time.UTC)
+	cases := []struct {
+		service, tag string
+		now          time.Time
+		silence      string
+	}{
+		{"payments-api", "", during, "payments-deploy"},
+		{"payments-api", "", during.Add(-time.Hour), ""},
+		{"payments-api", "", during.Add(time.Hour), ""},
+		{"redis", "", during, ""},
+		{"redis", "canary", during.Add(-48 * time.Hour), "canaries"},
+		{"redis", "canary", during.Add(2 * time.Hour), ""},
+	}
+	for i, tc := range cases {
+		alert := &AlertState{Service: tc.service, Tag: tc.tag, Status: api.HealthCritical}
+		name := ""
+		if silence := config.silencedBy(tc.service, alert, tc.now); silence != nil {
+			name = silence.Name
+		}
+		if name != tc.silence {
+			t.Errorf("case %d: expected silence %q, got %q", i, tc.silence, name)
+		}
+	}
+}
+
+// Make sure a silenced alert isn't sent to any handlers, and is counted
+func TestSilence_sendAlert(t *testing.T) {
+	config, err := ParseConfig(`
+	handler "stdout" "chat" {}
+	silence "all" {
+		end = "2100-01-01T00:00:00Z"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alertCh := make(chan *AlertState, 1)
+	config.Handlers["stdout.chat"] = testHandler{alertCh}
+
+	silenced := func() int64 {
+		if count, ok := metrics.Get(metricNotificationsSilenced).(*expvar.Int); ok {
+			return count.Value()
+		}
+		return 0
+	}
+	before := silenced()
+	sendAlert(config, "redis", &AlertState{Service: "redis", Status: api.HealthCritical}, func(string) {
+		t.Error("expected the alert not to be delivered")
+	})
+	if len(alertCh) != 0 {
+		t.Error("expected the handler not to be sent the alert")
+	}
+	if silenced() != before+1 {
+		t.Error("expected the silenced alert to be counted")
+	}
+}
+
+func TestSilence_invalid(t *testing.T) {
+	for _, raw := range []string{
+		`silence "a" { service = "redis" }`,
+		`silence "a" { end = "tomorrow" }`,
+		`silence "a" { start = "2026-10-21T00:00:00Z" end = "2026-10-20T00:00:00Z" }`,
+		`silence "a" { match = "(" end = "2026-10-20T00:00:00Z" }`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("expected an error parsing %s", raw)
+		}
+	}
+}
+
+// Make sure silences stored in the KV store are named after their keys, and invalid ones
+// are skipped
+func TestSilence_parseKVSilences(t *testing.T) {
+	prefix := "consul-alerting/silences/"
+	silences := parseKVSilences(prefix, api.KVPairs{
+		{Key: prefix},
+		{Key: prefix + "payments-deploy", Value: []byte(`{"service": "payments-*", "end": "2026-10-20T23:30:00Z"}`)},
+		{Key: prefix + "no-end", Value: []byte(`{"service": "redis"}`)},
+		{Key: prefix + "not-json", Value: []byte(`silence`)},
+	})
+	if len(silences) != 1 || silences[0].Name != "payments-deploy" {
+		t.Fatalf("expected only the payments-deploy silence, got %+v", silences)
+	}
+
+	kvSilences.set(silences)
+	defer kvSilences.set(nil)
+	alert := &AlertState{Service: "payments-api", Status: api.HealthCritical}
+	during := time.Date(2026, 10, 20, 22, 30, 0, 0, time.UTC)
+	if silence := DefaultConfig().silencedBy("payments-api", alert, during); silence == nil || silence.Name != "payments-deploy" {
+		t.Errorf("expected the alert to be silenced by the KV silence, got %v", silence)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// A single health check transition in a simulated scenario
+type SimulatedEvent struct {
+	// The offset from the start of the scenario, as a duration string (e.g. "90s")
+	At      string `json:"at"`
+	Node    string `json:"node"`
+	Service string `json:"service"`
+	Tag     string `json:"tag"`
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Output  string `json:"output"`
+
+	offset time.Duration
+}
+
+// A notification that would have been sent during a simulated scenario
+type SimulatedNotification struct {
+	At       time.Duration
+	Alert    AlertState
+	Handlers []string
+}
+
+// The simulated state of a single watch (node or service/tag)
+type simulatedWatch struct {
+	node, service, tag string
+	checks             map[string]string
+	lastAlertStatus    string
+	lastAlerted        string
+
+	// The alert waiting out its change threshold, if any
+	pending   *AlertState
+	pendingAt time.Duration
+}
+
+// Returns the time the watch's pending alert will fire if its status stays stable
+func (w *simulatedWatch) fireAt(config *Config) time.Duration {
+	return w.pendingAt + time.Duration(config.alertChangeThreshold(w.service, w.pending))*time.Second
+}
+
+// Returns the watch's failing checks, sorted by node and check
+func (w *simulatedWatch) failingChecks() []AlertCheck {
+	keys := make([]string, 0, len(w.checks))
+	for key := range w.checks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	failing := make([]AlertCheck, 0)
+	for _, key := range keys {
+		if status := w.checks[key]; status != api.HealthPassing {
+			parts := strings.SplitN(key, "/", 2)
+			failing = append(failing, AlertCheck{Node: parts[0], CheckID: parts[1], Name: parts[1], Status: status})
+		}
+	}
+	return failing
+}
+
+type eventsByOffset []*SimulatedEvent
+
+func (e eventsByOffset) Len() int           { return len(e) }
+func (e eventsByOffset) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e eventsByOffset) Less(i, j int) bool { return e[i].offset < e[j].offset }
+
+type watchesByFireTime struct {
+	watches []*simulatedWatch
+	config  *Config
+}
+
+func (w watchesByFireTime) Len() int      { return len(w.watches) }
+func (w watchesByFireTime) Swap(i, j int) { w.watches[i], w.watches[j] = w.watches[j], w.watches[i] }
+func (w watchesByFireTime) Less(i, j int) bool {
+	return w.watches[i].fireAt(w.config) < w.watches[j].fireAt(w.config)
+}
+
+// Replays the given events through the same health computation, change threshold and
+// routing logic the daemon uses, without touching Consul or calling any handlers.
+// Returns the notifications that would have been sent, in order.
+func simulate(config *Config, events []*SimulatedEvent) ([]*SimulatedNotification, error) {
+	for _, event := range events {
+		offset, err := time.ParseDuration(event.At)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'at' value %q: %s", event.At, err)
+		}
+		event.offset = offset
+
+		if event.Node == "" && event.Service == "" {
+			return nil, fmt.Errorf("Event at %s must specify a node or service", event.At)
+		}
+		if event.Status == "" {
+			event.Status = api.HealthPassing
+		}
+	}
+
+	sort.Stable(eventsByOffset(events))
+
+	// Keep the watches in the order they were first seen so ties are broken consistently
+	watches := make(map[string]*simulatedWatch)
+	watchOrder := make([]*simulatedWatch, 0)
+	notifications := make([]*SimulatedNotification, 0)
+
+	// Fire any pending alerts whose change threshold has elapsed by the given time, in the
+	// order they would have gone out
+	flush := func(now time.Duration) {
+		fired := make([]*simulatedWatch, 0)
+		for _, w := range watchOrder {
+			if w.pending != nil && w.fireAt(config) <= now {
+				fired = append(fired, w)
+			}
+		}
+		sort.Stable(watchesByFireTime{fired, config})
+
+		for _, w := range fired {
+			if w.pending.Status != w.lastAlerted {
+				notifications = append(notifications, &SimulatedNotification{
+					At:       w.fireAt(config),
+					Alert:    *w.pending,
+					Handlers: explainRouting(config, w.pending).Handlers,
+				})
+				w.lastAlerted = w.pending.Status
+			}
+			w.pending = nil
+		}
+	}
+
+	for _, event := range events {
+		flush(event.offset)
+
+		key := watchKVPath(event.Node, event.Service, event.Tag)
+		w, ok := watches[key]
+		if !ok {
+			w = &simulatedWatch{
+				node:            event.Node,
+				service:         event.Service,
+				tag:             event.Tag,
+				checks:          make(map[string]string),
+				lastAlertStatus: api.HealthPassing,
+				lastAlerted:     api.HealthPassing,
+			}
+			watches[key] = w
+			watchOrder = append(watchOrder, w)
+		}
+
+		w.checks[event.Node+"/"+event.Check] = event.Status
+		newStatus := config.serviceHealth(w.service, w.checks)
+
+		// A change in status resets the change threshold timer, like it does in tryAlert
+		if newStatus != w.lastAlertStatus {
+			w.lastAlertStatus = newStatus
+			w.pending = &AlertState{
+				Status:     newStatus,
+				Node:       event.Node,
+				Service:    event.Service,
+				Tag:        event.Tag,
+				Datacenter: config.ConsulDatacenter,
+				Message:    alertMessage(config.ConsulDatacenter, watchName(event.Node, event.Service, event.Tag), newStatus),
+				Details:    event.Output,
+				Checks:     w.failingChecks(),
+			}
+			w.pendingAt = event.offset
+		}
+	}
+
+	// Let any remaining timers run out
+	flush(time.Duration(1<<63 - 1))
+
+	return notifications, nil
+}
+
+const simulateUsage = `Usage: consul-alerting simulate [options] <scenario.json>
+
+  Replays a sequence of health check transitions through the alerting pipeline in
+  dry-run mode and prints the timeline of notifications that would be sent. No
+  Consul agent is needed and no handlers are called.
+
+  The scenario file is a JSON list of events, e.g.:
+
+    [
+      {"at": "0s", "service": "redis", "node": "web1", "check": "service:redis", "status": "critical"},
+      {"at": "90s", "service": "redis", "node": "web1", "check": "service:redis", "status": "passing"}
+    ]
+
+Options:
+
+    -config=<path>    The config file to use. Defaults to the default config.
+`
+
+func simulateCommand(args []string) int {
+	var configPath string
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, simulateUsage) }
+	flags.StringVar(&configPath, "config", "", "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, simulateUsage)
+		return 1
+	}
+
+	config := DefaultConfig()
+	if configPath != "" {
+		var err error
+		config, err = ParseConfigFile(configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if config.ConsulDatacenter == "" {
+		config.ConsulDatacenter = "simulated"
+	}
+
+	raw, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading scenario file: %s\n", err)
+		return 1
+	}
+
+	var events []*SimulatedEvent
+	if err := json.Unmarshal(raw, &events); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing scenario file: %s\n", err)
+		return 1
+	}
+
+	notifications, err := simulate(config, events)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, n := range notifications {
+		fmt.Printf("%-10s %s -> %s\n", "+"+n.At.String(), n.Alert.Message, strings.Join(n.Handlers, ", "))
+	}
+	if len(notifications) == 0 {
+		fmt.Println("No notifications would be sent")
+	}
+
+	return 0
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSimulate_changeThreshold(t *testing.T) {
+	config := DefaultConfig()
+	config.ConsulDatacenter = "dc1"
+	config.ChangeThreshold = 60
+
+	events := []*SimulatedEvent{
+		// A blip that recovers within the threshold shouldn't alert
+		{At: "0s", Service: "redis", Node: "node1", Check: "service:redis", Status: "critical"},
+		{At: "30s", Service: "redis", Node: "node1", Check: "service:redis", Status: "passing"},
+
+		// But one that stays failing should
+		{At: "2m", Service: "redis", Node: "node1", Check: "service:redis", Status: "critical"},
+		{At: "5m", Service: "redis", Node: "node1", Check: "service:redis", Status: "passing"},
+		{At: "5m", Node: "node2", Check: "memory", Status: "warning"},
+	}
+
+	notifications, err := simulate(config, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"3m0s [dc1] service redis is now critical",
+		"6m0s [dc1] service redis is now passing",
+		"6m0s [dc1] node node2 is now warning",
+	}
+	actual := make([]string, 0)
+	for _, n := range notifications {
+		actual = append(actual, n.At.String()+" "+n.Alert.Message)
+
+		if !reflect.DeepEqual(n.Handlers, []string{"stdout.default"}) {
+			t.Errorf("expected alert to go to stdout.default, got %v", n.Handlers)
+		}
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected \n%#v\n\n, got \n\n%#v\n\n", expected, actual)
+	}
+}
+
+func TestSimulate_invalidEvent(t *testing.T) {
+	_, err := simulate(DefaultConfig(), []*SimulatedEvent{{At: "soon", Service: "redis"}})
+	if err == nil {
+		t.Fatal("expected error, but nothing was returned")
+	}
+
+	_, err = simulate(DefaultConfig(), []*SimulatedEvent{{At: "1s"}})
+	if err == nil {
+		t.Fatal("expected error, but nothing was returned")
+	}
+}
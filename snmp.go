@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/hashicorp/consul/api"
+)
+
+// The OIDs from mibs/CONSUL-ALERTING-MIB.txt, under the enterprise number reserved for
+// examples since we don't have one of our own
+const (
+	snmpMIBOID               = "1.3.6.1.4.1.32473.1"
+	snmpAlertNotificationOID = snmpMIBOID + ".0.1"
+	snmpAlertObjectsOID      = snmpMIBOID + ".1"
+)
+
+// The standard OIDs at the start of every SNMPv2 trap
+const (
+	snmpSysUpTimeOID = "1.3.6.1.2.1.1.3.0"
+	snmpTrapOID      = "1.3.6.1.6.3.1.1.4.1.0"
+)
+
+const snmpDefaultPort = 162
+
+// When we started, for the sysUpTime sent with traps and the SNMPv3 engine time
+var snmpStartTime = time.Now()
+
+// SNMPv3 authentication and privacy protocols by name
+var (
+	snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+		"MD5": gosnmp.MD5, "SHA": gosnmp.SHA, "SHA-224": gosnmp.SHA224,
+		"SHA-256": gosnmp.SHA256, "SHA-384": gosnmp.SHA384, "SHA-512": gosnmp.SHA512,
+	}
+	snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+		"DES": gosnmp.DES, "AES": gosnmp.AES, "AES-192": gosnmp.AES192, "AES-256": gosnmp.AES256,
+	}
+)
+
+// SNMPHandler sends an SNMPv2c or SNMPv3 trap for each alert, with the alert's fields as
+// the varbinds defined in mibs/CONSUL-ALERTING-MIB.txt
+type SNMPHandler struct {
+	Address   string `mapstructure:"address"`
+	Version   string `mapstructure:"version"`
+	Community string `mapstructure:"community"`
+
+	// SNMPv3 user-based security settings. The engine ID is ours as the authoritative
+	// engine for the traps, and is given in hex.
+	Username     string `mapstructure:"username"`
+	AuthProtocol string `mapstructure:"auth_protocol"`
+	AuthPassword string `mapstructure:"auth_password"`
+	PrivProtocol string `mapstructure:"priv_protocol"`
+	PrivPassword string `mapstructure:"priv_password"`
+	EngineID     string `mapstructure:"engine_id"`
+}
+
+// Returns the severity sent in traps for a Consul health status, the same as the Nagios
+// return codes
+func snmpSeverity(status string) int {
+	switch status {
+	case api.HealthPassing:
+		return 0
+	case api.HealthWarning:
+		return 1
+	case api.HealthCritical:
+		return 2
+	}
+	return 3
+}
+
+// Returns the varbinds of the trap for an alert
+func snmpVarbinds(alert *AlertState, now time.Time) []gosnmp.SnmpPDU {
+	uptime := uint32(now.Sub(snmpStartTime) / (10 * time.Millisecond))
+	varbinds := []gosnmp.SnmpPDU{
+		{Name: snmpSysUpTimeOID, Type: gosnmp.TimeTicks, Value: uptime},
+		{Name: snmpTrapOID, Type: gosnmp.ObjectIdentifier, Value: snmpAlertNotificationOID},
+	}
+	for i, value := range []string{
+		alert.Status,
+		alert.Datacenter,
+		alert.Service,
+		alert.Tag,
+		alert.Node,
+		alert.Message,
+		alert.Details,
+		alert.EventID,
+	} {
+		varbinds = append(varbinds, gosnmp.SnmpPDU{Name: fmt.Sprintf("%s.%d", snmpAlertObjectsOID, i+1), Type: gosnmp.OctetString, Value: value})
+	}
+	return append(varbinds, gosnmp.SnmpPDU{Name: snmpAlertObjectsOID + ".9", Type: gosnmp.Integer, Value: snmpSeverity(alert.Status)})
+}
+
+// Returns the client to send traps with
+func (s SNMPHandler) client(ctx context.Context, now time.Time) (*gosnmp.GoSNMP, error) {
+	host, port := s.Address, snmpDefaultPort
+	if h, p, err := net.SplitHostPort(s.Address); err == nil {
+		host = h
+		if port, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("invalid port in address %s", s.Address)
+		}
+	}
+
+	timeout := 5 * time.Second
+	if connectTimeout, _ := ctx.Value(connectTimeoutKey).(time.Duration); connectTimeout > 0 {
+		timeout = connectTimeout
+	}
+	client := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(port),
+		Transport: "udp",
+		Community: s.Community,
+		Version:   gosnmp.Version2c,
+		Timeout:   timeout,
+		Context:   ctx,
+	}
+	if s.Version != "3" {
+		return client, nil
+	}
+
+	engineID, err := hex.DecodeString(s.EngineID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid engine_id: %s", err)
+	}
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:                 s.Username,
+		AuthoritativeEngineID:    string(engineID),
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  uint32(now.Sub(snmpStartTime) / time.Second),
+		AuthenticationProtocol:   gosnmp.NoAuth,
+		PrivacyProtocol:          gosnmp.NoPriv,
+	}
+	client.Version = gosnmp.Version3
+	client.SecurityModel = gosnmp.UserSecurityModel
+	client.MsgFlags = gosnmp.NoAuthNoPriv
+	if s.AuthProtocol != "" {
+		client.MsgFlags = gosnmp.AuthNoPriv
+		params.AuthenticationProtocol = snmpAuthProtocols[s.AuthProtocol]
+		params.AuthenticationPassphrase = s.AuthPassword
+	}
+	if s.PrivProtocol != "" {
+		client.MsgFlags = gosnmp.AuthPriv
+		params.PrivacyProtocol = snmpPrivProtocols[s.PrivProtocol]
+		params.PrivacyPassphrase = s.PrivPassword
+	}
+	client.SecurityParameters = params
+	return client, nil
+}
+
+func (s SNMPHandler) Alert(ctx context.Context, alert *AlertState) error {
+	now := time.Now()
+	client, err := s.client(ctx, now)
+	if err != nil {
+		return fmt.Errorf("Error sending alert to SNMP: %s", err)
+	}
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("Error sending alert to SNMP: %s", err)
+	}
+	defer client.Conn.Close()
+
+	if _, err := client.SendTrap(gosnmp.SnmpTrap{Variables: snmpVarbinds(alert, now)}); err != nil {
+		return fmt.Errorf("Error sending alert to SNMP (%s): %s", s.Address, err)
+	}
+	return nil
+}
+
+func (s SNMPHandler) Validate() error {
+	if s.Address == "" {
+		return errors.New("no address given")
+	}
+
+	switch s.Version {
+	case "2c":
+		if s.Community == "" {
+			return errors.New("no community given")
+		}
+		return nil
+	case "3":
+	default:
+		return fmt.Errorf("invalid version %q, must be 2c or 3", s.Version)
+	}
+
+	if s.Username == "" {
+		return errors.New("no username given")
+	}
+	// Receivers identify the user by the sender's engine ID, so it has to be configured
+	// on both ends
+	if engineID, err := hex.DecodeString(s.EngineID); err != nil || len(engineID) < 5 || len(engineID) > 32 {
+		return errors.New("engine_id must be given as 5 to 32 bytes in hex")
+	}
+	if s.AuthProtocol != "" {
+		if _, ok := snmpAuthProtocols[s.AuthProtocol]; !ok {
+			return fmt.Errorf("invalid auth_protocol %q", s.AuthProtocol)
+		}
+		if len(s.AuthPassword) < 8 {
+			return errors.New("auth_password must be at least 8 characters")
+		}
+	}
+	if s.PrivProtocol != "" {
+		if _, ok := snmpPrivProtocols[s.PrivProtocol]; !ok {
+			return fmt.Errorf("invalid priv_protocol %q", s.PrivProtocol)
+		}
+		if s.AuthProtocol == "" {
+			return errors.New("priv_protocol requires an auth_protocol")
+		}
+		if len(s.PrivPassword) < 8 {
+			return errors.New("priv_password must be at least 8 characters")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// Receives a single trap on a UDP socket, decoding it with the given receiver settings
+func receiveSNMPTrap(t *testing.T, conn net.PacketConn, receiver *gosnmp.GoSNMP) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := receiver.UnmarshalTrap(buf[:n], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	varbinds := make(map[string]interface{})
+	for _, pdu := range packet.Variables {
+		varbinds[pdu.Name] = pdu.Value
+	}
+	return varbinds
+}
+
+func TestSNMP_v2c(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	handler := SNMPHandler{Address: conn.LocalAddr().String(), Version: "2c", Community: "public"}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	alert := &AlertState{Status: "critical", Service: "redis", Datacenter: "dc1", Message: "redis is critical"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	varbinds := receiveSNMPTrap(t, conn, &gosnmp.GoSNMP{Version: gosnmp.Version2c, Community: "public", Logger: gosnmp.Default.Logger})
+	if varbinds["."+snmpTrapOID] != "."+snmpAlertNotificationOID {
+		t.Errorf("expected the alert notification, got %v", varbinds["."+snmpTrapOID])
+	}
+	if status, _ := varbinds["."+snmpAlertObjectsOID+".1"].([]byte); string(status) != "critical" {
+		t.Errorf("unexpected status varbind %q", status)
+	}
+	if service, _ := varbinds["."+snmpAlertObjectsOID+".3"].([]byte); string(service) != "redis" {
+		t.Errorf("unexpected service varbind %q", service)
+	}
+	if severity := varbinds["."+snmpAlertObjectsOID+".9"]; severity != 2 {
+		t.Errorf("expected critical severity, got %v", severity)
+	}
+}
+
+func TestSNMP_v3(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	handler := SNMPHandler{
+		Address:      conn.LocalAddr().String(),
+		Version:      "3",
+		Username:     "alerting",
+		AuthProtocol: "SHA",
+		AuthPassword: "authpassword",
+		PrivProtocol: "AES",
+		PrivPassword: "privpassword",
+		EngineID:     "8000000001020304",
+	}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.Alert(context.Background(), &AlertState{Status: "warning", Node: "node1", Message: "node1 is warning"}); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := &gosnmp.GoSNMP{
+		Version:       gosnmp.Version3,
+		SecurityModel: gosnmp.UserSecurityModel,
+		MsgFlags:      gosnmp.AuthPriv,
+		Logger:        gosnmp.Default.Logger,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{
+			UserName:                 "alerting",
+			AuthoritativeEngineID:    "\x80\x00\x00\x00\x01\x02\x03\x04",
+			AuthenticationProtocol:   gosnmp.SHA,
+			AuthenticationPassphrase: "authpassword",
+			PrivacyProtocol:          gosnmp.AES,
+			PrivacyPassphrase:        "privpassword",
+			Logger:                   gosnmp.Default.Logger,
+		},
+	}
+	varbinds := receiveSNMPTrap(t, conn, receiver)
+	if node, _ := varbinds["."+snmpAlertObjectsOID+".5"].([]byte); string(node) != "node1" {
+		t.Errorf("unexpected node varbind %q", node)
+	}
+	if severity := varbinds["."+snmpAlertObjectsOID+".9"]; severity != 1 {
+		t.Errorf("expected warning severity, got %v", severity)
+	}
+}
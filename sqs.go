@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQSHandler sends alerts as JSON messages to an AWS SQS queue
+type SQSHandler struct {
+	AWSCredentials `mapstructure:",squash"`
+
+	QueueURL string `mapstructure:"queue_url"`
+	Region   string `mapstructure:"region"`
+}
+
+// Returns the region of the queue, parsed from its URL if it isn't set
+func (s SQSHandler) region() string {
+	if s.Region != "" {
+		return s.Region
+	}
+
+	// Queue URLs look like https://sqs.us-east-1.amazonaws.com/123456789012/alerts
+	parsed, err := url.Parse(s.QueueURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(parsed.Host, ".")
+	if len(parts) >= 4 && parts[0] == "sqs" {
+		return parts[1]
+	}
+	return ""
+}
+
+// Returns the form-encoded SendMessage request for an alert
+func (s SQSHandler) sendMessageBody(alert *AlertState) (url.Values, error) {
+	message, err := json.Marshal(alert)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("Action", "SendMessage")
+	form.Set("Version", "2012-11-05")
+	form.Set("MessageBody", string(message))
+
+	// Add the alert's fields as attributes, so consumers can filter without parsing the body
+	attributes := [][2]string{
+		{"status", alert.Status},
+		{"service", alert.Service},
+		{"node", alert.Node},
+		{"tag", alert.Tag},
+		{"datacenter", alert.Datacenter},
+	}
+	index := 1
+	for _, attribute := range attributes {
+		if attribute[1] == "" {
+			continue
+		}
+		prefix := "MessageAttribute." + strconv.Itoa(index)
+		form.Set(prefix+".Name", attribute[0])
+		form.Set(prefix+".Value.DataType", "String")
+		form.Set(prefix+".Value.StringValue", attribute[1])
+		index++
+	}
+
+	// FIFO queues need a group, and can deduplicate on the event ID
+	if strings.HasSuffix(s.QueueURL, ".fifo") {
+		form.Set("MessageGroupId", alert.Service+"/"+alert.Tag+"/"+alert.Node)
+		if alert.EventID != "" {
+			form.Set("MessageDeduplicationId", alert.EventID)
+		}
+	}
+	return form, nil
+}
+
+func (s SQSHandler) Alert(ctx context.Context, alert *AlertState) error {
+	credentials, err := s.AWSCredentials.resolve()
+	if err != nil {
+		return err
+	}
+
+	form, err := s.sendMessageBody(alert)
+	if err != nil {
+		return err
+	}
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequest("POST", s.QueueURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signAWSRequest(req, body, credentials, s.region(), "sqs", time.Now())
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Error sending alert to SQS: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Error sending alert to SQS: got response code %d (%s)", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (s SQSHandler) Validate() error {
+	if s.QueueURL == "" {
+		return errors.New("no queue_url given")
+	}
+	if s.region() == "" {
+		return errors.New("no region given, and it couldn't be found from the queue_url")
+	}
+	if (s.AccessKey == "") != (s.SecretKey == "") {
+		return errors.New("access_key and secret_key must be given together")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSQS_sendMessage(t *testing.T) {
+	var form url.Values
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		r.ParseForm()
+		form = r.PostForm
+	}))
+	defer server.Close()
+
+	handler := SQSHandler{
+		AWSCredentials: AWSCredentials{AccessKey: "AKID", SecretKey: "secret"},
+		QueueURL:       server.URL + "/123456789012/alerts.fifo",
+		Region:         "us-east-1",
+	}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: "critical", Service: "redis", Tag: "alpha", EventID: "abc123", Message: "redis is down"}
+	if err := handler.Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-east-1/sqs/aws4_request") {
+		t.Errorf("unexpected authorization header: %s", auth)
+	}
+	if form.Get("Action") != "SendMessage" || form.Get("MessageDeduplicationId") != "abc123" || form.Get("MessageGroupId") != "redis/alpha/" {
+		t.Errorf("unexpected request: %v", form)
+	}
+
+	var body AlertState
+	if err := json.Unmarshal([]byte(form.Get("MessageBody")), &body); err != nil || body.Message != alert.Message {
+		t.Errorf("unexpected message body %q: %v", form.Get("MessageBody"), err)
+	}
+
+	expected := map[string]string{"status": "critical", "service": "redis", "tag": "alpha"}
+	for i := 1; i <= 3; i++ {
+		prefix := fmt.Sprintf("MessageAttribute.%d", i)
+		name := form.Get(prefix + ".Name")
+		if value := form.Get(prefix + ".Value.StringValue"); expected[name] != value {
+			t.Errorf("expected attribute %s to be %q, got %q", name, expected[name], value)
+		}
+	}
+}
+
+func TestSQS_region(t *testing.T) {
+	handler := SQSHandler{QueueURL: "https://sqs.eu-west-1.amazonaws.com/123456789012/alerts"}
+	if region := handler.region(); region != "eu-west-1" {
+		t.Errorf("expected region from queue url, got %q", region)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const squadcastDefaultURL = "https://api.squadcast.com/v2/incidents/api"
+
+// SquadcastHandler sends alerts to a Squadcast incident webhook. Alerts about the same
+// node/service share an event ID, so recoveries resolve the incident they triggered.
+type SquadcastHandler struct {
+	// The API token from the service's incident webhook URL
+	Token string `mapstructure:"token"`
+	URL   string `mapstructure:"url"`
+}
+
+// The body of a request to a Squadcast incident webhook
+type squadcastEvent struct {
+	Status      string            `json:"status"`
+	EventID     string            `json:"event_id"`
+	Message     string            `json:"message"`
+	Description string            `json:"description"`
+	Priority    string            `json:"priority,omitempty"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// Returns the Squadcast priority for a failing Consul health status
+func squadcastPriority(status string) string {
+	switch status {
+	case api.HealthCritical:
+		return "P1"
+	case api.HealthWarning:
+		return "P3"
+	}
+	return ""
+}
+
+// Returns the event to send to Squadcast for an alert
+func squadcastAlertEvent(alert *AlertState) squadcastEvent {
+	event := squadcastEvent{
+		Status:      "trigger",
+		EventID:     alert.Datacenter + "-" + alertTargetKey(alert),
+		Message:     alert.Message,
+		Description: alert.Details,
+		Priority:    squadcastPriority(alert.Status),
+		Tags:        map[string]string{"severity": alert.Status},
+	}
+	if alert.Status == api.HealthPassing {
+		event.Status = "resolve"
+	}
+	for key, value := range map[string]string{
+		"datacenter": alert.Datacenter,
+		"service":    alert.Service,
+		"tag":        alert.Tag,
+		"node":       alert.Node,
+		"consul_url": alert.Link,
+	} {
+		if value != "" {
+			event.Tags[key] = value
+		}
+	}
+	return event
+}
+
+func (s SquadcastHandler) Alert(ctx context.Context, alert *AlertState) error {
+	base := s.URL
+	if base == "" {
+		base = squadcastDefaultURL
+	}
+	endpoint := strings.TrimRight(base, "/") + "/" + url.PathEscape(s.Token)
+
+	if err := postJSON(ctx, endpoint, nil, squadcastAlertEvent(alert)); err != nil {
+		return fmt.Errorf("Error sending alert to Squadcast: %s", err)
+	}
+	return nil
+}
+
+func (s SquadcastHandler) Validate() error {
+	if s.Token == "" {
+		return errors.New("no token given")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// Resolves the watch's open alert once the node or service it's for has had no checks
+// registered for stale_alert_grace, which is what happens when it's deregistered from the
+// catalog. Without this its alert would stay open forever, since no check is left to pass.
+// The forgotten checks' stored states are deleted, so the node or service starts afresh if
+// it comes back.
+func (s *WatchState) resolveIfGone(name, alertPath string, checks []*api.HealthCheck, opts *WatchOptions, now time.Time) {
+	grace := time.Duration(opts.config.StaleAlertGrace) * time.Second
+	if grace <= 0 {
+		return
+	}
+
+	s.Lock()
+	if len(checks) > 0 || s.Status == api.HealthPassing {
+		s.goneSince = time.Time{}
+		s.Unlock()
+		return
+	}
+	if s.goneSince.IsZero() {
+		log.Warnf("%s has no checks registered while %s, resolving its alert in %s unless it comes back", name, s.Status, grace)
+		s.goneSince = now
+	}
+	if now.Sub(s.goneSince) < grace {
+		s.Unlock()
+		return
+	}
+
+	status := s.Status
+	s.Checks = make(map[string]string)
+	s.Status = api.HealthPassing
+	s.changedAt = now
+	s.goneSince = time.Time{}
+	s.Unlock()
+
+	log.Infof("%s was deregistered while %s, resolving its alert", name, status)
+	if err := deleteCheckStates(watchKVPath(opts.node, opts.service, opts.tag), opts.client); err != nil {
+		log.Errorf("Error deleting the stored check states for %s: %s", name, err)
+	}
+	resolveAlert(alertPath, fmt.Sprintf("[%s] %s was deregistered while %s, resolving its alert", opts.config.ConsulDatacenter, name, status), opts)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Make sure a failing service whose checks disappear has its alert resolved once the grace
+// period passes, and not before
+func TestStale_resolveIfGone(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	config := DefaultConfig()
+	config.ConsulDatacenter = "dc1"
+	alertCh := make(chan *AlertState, 1)
+	config.Handlers = map[string]AlertHandler{"test": testHandler{alertCh}}
+
+	opts := &WatchOptions{service: "redis", config: config, client: client, alertLock: &sync.Mutex{}}
+	alertPath := watchKVPath("", "redis", "") + "alert"
+	setAlertState(alertPath, &AlertState{Service: "redis", Status: api.HealthCritical, LastAlerted: api.HealthCritical}, client)
+
+	state := newWatchState("service redis", "", "redis", "")
+	state.Status = api.HealthCritical
+	state.Checks["node1/redis"] = api.HealthCritical
+	opts.state = state
+
+	start := time.Now()
+	state.resolveIfGone("service redis", alertPath, []*api.HealthCheck{{Node: "node1"}}, opts, start)
+	state.resolveIfGone("service redis", alertPath, nil, opts, start.Add(time.Minute))
+	if len(alertCh) != 0 || state.goneSince.IsZero() {
+		t.Fatal("expected the alert to be held open during the grace period")
+	}
+
+	state.resolveIfGone("service redis", alertPath, nil, opts, start.Add(10*time.Minute))
+	select {
+	case alert := <-alertCh:
+		if alert.Status != api.HealthPassing || !strings.Contains(alert.Message, "deregistered while critical") {
+			t.Errorf("unexpected alert %+v", alert)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the alert to be resolved")
+	}
+	if state.Status != api.HealthPassing || len(state.Checks) != 0 {
+		t.Errorf("expected the watch state to be cleared, got %s %v", state.Status, state.Checks)
+	}
+}
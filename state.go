@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const stateUsage = `Usage: consul-alerting state wipe [options]
+
+  Deletes stored alert state, check states and locks from the Consul KV store. Useful
+  for recovering from corrupted state or cleaning up after decommissioning a deployment.
+  Daemons should be stopped first, as any running watches will recreate their state.
+
+Options:
+
+    -config=<path>     The config file to read the Consul address/token from.
+    -all               Wipe all state.
+    -service=<name>    Only wipe state for the given service.
+    -tag=<tag>         Only wipe state for the given tag of the service.
+    -node=<name>       Only wipe state for the given node.
+    -dry-run           List the keys that would be deleted without deleting them.
+`
+
+func stateCommand(args []string) int {
+	if len(args) == 0 || args[0] != "wipe" {
+		fmt.Fprint(os.Stderr, stateUsage)
+		return 1
+	}
+
+	var configPath, service, tag, node string
+	var all, dryRun bool
+	flags := flag.NewFlagSet("state wipe", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, stateUsage) }
+	flags.StringVar(&configPath, "config", "", "")
+	flags.BoolVar(&all, "all", false, "")
+	flags.StringVar(&service, "service", "", "")
+	flags.StringVar(&tag, "tag", "", "")
+	flags.StringVar(&node, "node", "", "")
+	flags.BoolVar(&dryRun, "dry-run", false, "")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	prefix, err := statePrefix(all, node, service, tag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	config := DefaultConfig()
+	if configPath != "" {
+		config, err = ParseConfigFile(configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	client, err := newConsulClient(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing client: %s\n", err)
+		return 1
+	}
+
+	keys, err := wipeState(client, prefix, dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+	if dryRun {
+		fmt.Printf("Would delete %d keys under %s\n", len(keys), prefix)
+	} else {
+		fmt.Printf("Deleted %d keys under %s\n", len(keys), prefix)
+	}
+
+	return 0
+}
+
+// Returns the KV prefix to wipe for the given selection
+func statePrefix(all bool, node, service, tag string) (string, error) {
+	switch {
+	case all && (node != "" || service != ""):
+		return "", fmt.Errorf("-all can't be combined with -service or -node")
+	case node != "" && service != "":
+		return "", fmt.Errorf("Only one of -service or -node can be given")
+	case tag != "" && service == "":
+		return "", fmt.Errorf("-tag requires -service")
+	case all:
+		return alertingKVRoot + "/", nil
+	case node != "" || service != "":
+		return watchKVPath(node, service, tag), nil
+	default:
+		return "", fmt.Errorf("Must specify -all, -service or -node")
+	}
+}
+
+// Deletes all keys under the given prefix, returning the keys that were deleted
+func wipeState(client *api.Client, prefix string, dryRun bool) ([]string, error) {
+	keys, _, err := client.KV().Keys(prefix, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing state keys: %s", err)
+	}
+
+	if dryRun || len(keys) == 0 {
+		return keys, nil
+	}
+
+	if _, err := client.KV().DeleteTree(prefix, nil); err != nil {
+		return nil, fmt.Errorf("Error deleting state: %s", err)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestState_prefix(t *testing.T) {
+	cases := []struct {
+		all                bool
+		node, service, tag string
+		expected           string
+	}{
+		{true, "", "", "", alertingKVRoot + "/"},
+		{false, "node1", "", "", alertingKVRoot + "/node/node1/"},
+		{false, "", "redis", "", alertingKVRoot + "/service/redis/"},
+		{false, "", "redis", "alpha", alertingKVRoot + "/service/redis/alpha/"},
+	}
+
+	for _, c := range cases {
+		prefix, err := statePrefix(c.all, c.node, c.service, c.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prefix != c.expected {
+			t.Errorf("expected prefix %s, got %s", c.expected, prefix)
+		}
+	}
+
+	if _, err := statePrefix(false, "", "", "alpha"); err == nil {
+		t.Error("expected error for -tag without -service")
+	}
+	if _, err := statePrefix(false, "", "", ""); err == nil {
+		t.Error("expected error when nothing was selected")
+	}
+}
+
+// Make sure wiping a service's state leaves other services alone
+func TestState_wipeService(t *testing.T) {
+	client, server := testConsul(t)
+	defer server.Stop()
+
+	for _, service := range []string{"redis", "nginx"} {
+		testSetCheckState(CheckUpdate{
+			HealthCheck: &api.HealthCheck{
+				ServiceName: service,
+				ServiceID:   service,
+				Node:        "node1",
+				CheckID:     "testcheck",
+				Status:      "critical",
+			},
+		}, client, t)
+	}
+
+	keys, err := wipeState(client, watchKVPath("", "redis", ""), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 {
+		t.Errorf("expected 1 key to be deleted, got %v", keys)
+	}
+
+	remaining, _, err := client.KV().Keys(alertingKVRoot+"/", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(remaining) != 1 || remaining[0] != alertingKVRoot+"/service/nginx/node1/testcheck" {
+		t.Errorf("expected only nginx state to remain, got %v", remaining)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// The response body for the status endpoint
+type StatusResponse struct {
+	Watches    []*WatchStatus `json:"watches"`
+	RetryQueue int            `json:"retry_queue"`
+
+	// The number of notifications waiting for a dispatch worker
+	DispatchQueue int `json:"dispatch_queue"`
+
+	// The handlers whose circuit breakers are open or half-open
+	Breakers map[string]string `json:"breakers"`
+
+	// The last probe error of each handler failing its health probe
+	UnhealthyHandlers map[string]string `json:"unhealthy_handlers"`
+}
+
+// A snapshot of a single watch's state
+type WatchStatus struct {
+	Name          string          `json:"name"`
+	Leader        bool            `json:"leader"`
+	Status        string          `json:"status"`
+	FailingChecks []string        `json:"failing_checks"`
+	PendingAlerts []*PendingAlert `json:"pending_alerts"`
+	Flapping      bool            `json:"flapping"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// Returns a snapshot of the watch's current state
+func (s *WatchState) snapshot() *WatchStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	status := &WatchStatus{
+		Name:          s.Name,
+		Leader:        s.leader(),
+		Status:        s.Status,
+		Flapping:      s.flapping,
+		Error:         s.Error,
+		FailingChecks: make([]string, 0),
+		PendingAlerts: make([]*PendingAlert, 0),
+	}
+
+	for check, checkStatus := range s.Checks {
+		if checkStatus != "passing" {
+			status.FailingChecks = append(status.FailingChecks, check)
+		}
+	}
+	sort.Strings(status.FailingChecks)
+
+	// Sort the pending alerts oldest first; only the last one can still fire, the others
+	// were superseded by later changes
+	for _, pending := range s.Pending {
+		status.PendingAlerts = append(status.PendingAlerts, pending)
+	}
+	sort.Sort(pendingByIndex(status.PendingAlerts))
+
+	return status
+}
+
+type pendingByIndex []*PendingAlert
+
+func (p pendingByIndex) Len() int           { return len(p) }
+func (p pendingByIndex) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p pendingByIndex) Less(i, j int) bool { return p[i].UpdateIndex < p[j].UpdateIndex }
+
+// Returns the state of every watch running in this process
+func statusEndpoint(w http.ResponseWriter, r *http.Request) {
+	status := StatusResponse{
+		Watches:    make([]*WatchStatus, 0),
+		RetryQueue: retryQueue.len(),
+
+		DispatchQueue: dispatcher.len(),
+		Breakers:      circuitBreakers.tripped(),
+
+		UnhealthyHandlers: handlerHealth.unhealthy(),
+	}
+	for _, watch := range runningWatches.list() {
+		status.Watches = append(status.Watches, watch.snapshot())
+	}
+
+	writeJSON(w, status, true)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// How long a restarted watch has to run without panicking before its restart backoff resets
+const watchStableTime = 1 * time.Minute
+
+// Runs the watch, restarting it with backoff if it panics so a single bad response from
+// Consul can't stop monitoring for the node/service for good
+func superviseWatch(opts *WatchOptions) {
+	name := watchName(opts.node, opts.service, opts.tag)
+	backoff := newBackoff()
+
+	for {
+		started := time.Now()
+		if !runRecovered(name, func() { watch(opts) }) {
+			return
+		}
+		metrics.Add(metricWatchPanics, 1)
+
+		if time.Since(started) > watchStableTime {
+			backoff.reset()
+		}
+		wait := backoff.next()
+		log.Errorf("Restarting watch for %s in %s", name, wait)
+		time.Sleep(wait)
+	}
+}
+
+// Runs fn, recovering from and logging any panic. Returns true if fn panicked.
+func runRecovered(name string, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Recovered from panic in %s: %v\n%s", name, r, debug.Stack())
+			panicked = true
+		}
+	}()
+
+	fn()
+	return false
+}
+
+// Converts a panic into an error, for use in a deferred call
+func recoverError(name string, err *error) {
+	if r := recover(); r != nil {
+		log.Errorf("Recovered from panic in %s: %v\n%s", name, r, debug.Stack())
+		*err = fmt.Errorf("%s panicked: %v", name, r)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// A handler that panics when called
+type panickingHandler struct{}
+
+func (p panickingHandler) Alert(ctx context.Context, alert *AlertState) error {
+	var handlers map[string]AlertHandler
+	handlers["oops"] = p
+	return nil
+}
+
+func TestSupervise_runRecovered(t *testing.T) {
+	if runRecovered("test", func() {}) {
+		t.Error("expected no panic to be reported")
+	}
+
+	if !runRecovered("test", func() { panic("malformed response") }) {
+		t.Error("expected panic to be recovered and reported")
+	}
+}
+
+// Make sure a panicking handler is turned into an error instead of crashing the process
+func TestSupervise_handlerPanic(t *testing.T) {
+	config := &Config{Handlers: map[string]AlertHandler{"test.panic": panickingHandler{}}}
+
+	err := invokeHandler(config, "test.panic", &AlertState{})
+	if err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Fatalf("expected a panic error, got %v", err)
+	}
+}
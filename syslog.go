@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The structured data ID for alert fields, using the enterprise number reserved for
+// examples since we don't have one of our own
+const syslogSDID = "consul-alerting@32473"
+
+// Syslog facility codes by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Returns the syslog severity for a Consul health status
+func syslogSeverity(status string) int {
+	switch status {
+	case api.HealthCritical:
+		return 2 // crit
+	case api.HealthWarning:
+		return 4 // warning
+	case api.HealthPassing:
+		return 6 // info
+	}
+	return 5 // notice
+}
+
+// SyslogHandler sends alerts to a remote syslog server as RFC 5424 messages, with the
+// alert's fields as structured data
+type SyslogHandler struct {
+	Address       string `mapstructure:"address"`
+	Protocol      string `mapstructure:"protocol"`
+	Facility      string `mapstructure:"facility"`
+	TLSSkipVerify bool   `mapstructure:"tls_skip_verify"`
+}
+
+// Escapes the characters that are special in structured data parameter values
+var syslogSDEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// Returns the RFC 5424 message for an alert
+func (s SyslogHandler) message(alert *AlertState, hostname string, now time.Time) string {
+	priority := syslogFacilities[s.Facility]*8 + syslogSeverity(alert.Status)
+
+	params := make([]string, 0)
+	for _, param := range [][2]string{
+		{"status", alert.Status},
+		{"datacenter", alert.Datacenter},
+		{"service", alert.Service},
+		{"tag", alert.Tag},
+		{"node", alert.Node},
+		{"event_id", alert.EventID},
+	} {
+		if param[1] != "" {
+			params = append(params, fmt.Sprintf(`%s="%s"`, param[0], syslogSDEscaper.Replace(param[1])))
+		}
+	}
+
+	// Keep the message on one line, since most receivers split on newlines
+	text := alert.Message
+	if alert.Details != "" {
+		text = text + " - " + strings.Replace(strings.TrimSpace(alert.Details), "\n", " ", -1)
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s consul-alerting %d alert [%s %s] %s",
+		priority, now.UTC().Format("2006-01-02T15:04:05.000Z"), hostname, os.Getpid(), syslogSDID, strings.Join(params, " "), text)
+}
+
+func (s SyslogHandler) Alert(ctx context.Context, alert *AlertState) error {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	message := s.message(alert, hostname, time.Now())
+
+	if err := s.send(ctx, message); err != nil {
+		return fmt.Errorf("Error sending alert to syslog (%s): %s", s.Address, err)
+	}
+	return nil
+}
+
+func (s SyslogHandler) send(ctx context.Context, message string) error {
+	connectTimeout, _ := ctx.Value(connectTimeoutKey).(time.Duration)
+	dialer := &net.Dialer{Timeout: connectTimeout}
+
+	var conn net.Conn
+	var err error
+	switch s.Protocol {
+	case "tls":
+		host, _, _ := net.SplitHostPort(s.Address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.Address, &tls.Config{ServerName: host, InsecureSkipVerify: s.TLSSkipVerify})
+	default:
+		conn, err = dialer.Dial(s.Protocol, s.Address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Stream transports need framing; use octet counting (RFC 6587), which doesn't break
+	// if a message contains a newline
+	if s.Protocol != "udp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	_, err = conn.Write([]byte(message))
+	return err
+}
+
+func (s SyslogHandler) Validate() error {
+	if s.Address == "" {
+		return errors.New("no address given")
+	}
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		return fmt.Errorf("invalid address %q, must be host:port", s.Address)
+	}
+	switch s.Protocol {
+	case "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("invalid protocol %q, must be udp, tcp or tls", s.Protocol)
+	}
+	if _, ok := syslogFacilities[s.Facility]; !ok {
+		return fmt.Errorf("invalid facility %q", s.Facility)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslog_message(t *testing.T) {
+	handler := SyslogHandler{Facility: "local0"}
+	alert := &AlertState{
+		Status:     "critical",
+		Service:    "redis",
+		Tag:        "alpha",
+		Datacenter: "dc1",
+		EventID:    "abc",
+		Message:    `[dc1] service "redis" is now critical`,
+		Details:    "Failing checks:\nping]",
+	}
+
+	now := time.Date(2017, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	expected := fmt.Sprintf(`<130>1 2017-01-02T03:04:05.006Z host1 consul-alerting %d alert [consul-alerting@32473 status="critical" datacenter="dc1" service="redis" tag="alpha" event_id="abc"] [dc1] service "redis" is now critical - Failing checks: ping]`, os.Getpid())
+	if message := handler.message(alert, "host1", now); message != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, message)
+	}
+
+	alert.Status, alert.Service = "passing", `we"ird]`
+	message := handler.message(alert, "host1", now)
+	if !strings.HasPrefix(message, "<134>1 ") || !strings.Contains(message, `service="we\"ird\]"`) {
+		t.Errorf("expected info priority and escaped service name, got %s", message)
+	}
+}
+
+func TestSyslog_tcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var length int
+		reader := bufio.NewReader(conn)
+		fmt.Fscanf(reader, "%d ", &length)
+		message := make([]byte, length)
+		reader.Read(message)
+		received <- string(message)
+	}()
+
+	handler := SyslogHandler{Address: listener.Addr().String(), Protocol: "tcp", Facility: "daemon"}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.Alert(context.Background(), &AlertState{Status: "warning", Node: "node1", Message: "node1 is warning"}); err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-received
+	if !strings.HasPrefix(message, "<28>1 ") || !strings.HasSuffix(message, "] node1 is warning") {
+		t.Errorf("unexpected message %q", message)
+	}
+}
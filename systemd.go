@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The longest a loop can go without making progress before it's considered wedged. Blocking
+// queries can take up to watchWaitTime (plus Consul's jitter) and errors back off for up to
+// maxErrorWaitTime, so this leaves plenty of headroom.
+const heartbeatTimeout = 4 * watchWaitTime
+
+// Names used by the discovery loops when reporting heartbeats
+const serviceDiscoveryLoop = "service discovery"
+const nodeDiscoveryLoop = "node discovery"
+const silenceWatchLoop = "silence watch"
+
+// Heartbeats tracks the last time each long-running loop (discovery, watches) made progress,
+// so we can tell systemd or a health check when one of them has stopped responding
+type Heartbeats struct {
+	sync.Mutex
+	last  map[string]time.Time
+	ready map[string]bool
+}
+
+var heartbeats = &Heartbeats{last: make(map[string]time.Time), ready: make(map[string]bool)}
+
+// Records that the named loop is still making progress
+func (h *Heartbeats) beat(name string) {
+	h.Lock()
+	h.last[name] = time.Now()
+	h.Unlock()
+}
+
+// Stops tracking the named loop, used when a watch is shut down
+func (h *Heartbeats) remove(name string) {
+	h.Lock()
+	delete(h.last, name)
+	h.Unlock()
+}
+
+// Returns the names of any loops that haven't reported in within heartbeatTimeout
+func (h *Heartbeats) stalled() []string {
+	h.Lock()
+	defer h.Unlock()
+
+	stalled := make([]string, 0)
+	for name, last := range h.last {
+		if time.Since(last) > heartbeatTimeout {
+			stalled = append(stalled, name)
+		}
+	}
+	sort.Strings(stalled)
+
+	return stalled
+}
+
+// Records that the named loop has finished its first successful pass
+func (h *Heartbeats) markReady(name string) {
+	h.Lock()
+	h.ready[name] = true
+	h.Unlock()
+}
+
+// Blocks until each of the named loops has been marked as ready
+func (h *Heartbeats) waitFor(names ...string) {
+	for {
+		h.Lock()
+		ready := true
+		for _, name := range names {
+			if !h.ready[name] {
+				ready = false
+			}
+		}
+		h.Unlock()
+
+		if ready {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Sends the given state (READY=1, WATCHDOG=1, etc) to systemd's notification socket.
+// Does nothing if we weren't started by systemd with Type=notify.
+func sdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Returns the watchdog interval requested by systemd, or 0 if the watchdog isn't enabled
+// for this process
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	// If WATCHDOG_PID is set, the watchdog is meant for a specific process
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Pings the systemd watchdog at half the requested interval for as long as all of our loops
+// are making progress. If one wedges, we stop pinging and let systemd restart us.
+func sdWatchdog(interval time.Duration) {
+	log.Infof("Systemd watchdog enabled, pinging every %s", interval/2)
+
+	for range time.Tick(interval / 2) {
+		if stalled := heartbeats.stalled(); len(stalled) > 0 {
+			log.Errorf("Skipping watchdog ping, loops not responding: %v", stalled)
+			continue
+		}
+
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Error("Error sending watchdog ping to systemd: ", err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Make sure notifications are written to the socket given in NOTIFY_SOCKET
+func TestSystemd_sdNotify(t *testing.T) {
+	socketPath := path.Join(os.TempDir(), "consul-alerting-notify.sock")
+	os.Remove(socketPath)
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	defer os.Remove(socketPath)
+
+	os.Setenv("NOTIFY_SOCKET", socketPath)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf[:n]) != "READY=1" {
+		t.Errorf("expected READY=1, got %q", string(buf[:n]))
+	}
+}
+
+func TestSystemd_watchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	if interval := sdWatchdogInterval(); interval != 30*time.Second {
+		t.Errorf("expected 30s interval, got %s", interval)
+	}
+
+	// The watchdog is meant for another process
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if interval := sdWatchdogInterval(); interval != 0 {
+		t.Errorf("expected watchdog to be disabled, got %s", interval)
+	}
+}
+
+// Make sure loops that haven't reported recently are marked as stalled
+func TestSystemd_stalledHeartbeats(t *testing.T) {
+	h := &Heartbeats{last: make(map[string]time.Time), ready: make(map[string]bool)}
+
+	h.beat("service redis")
+	h.last["service nginx"] = time.Now().Add(-2 * heartbeatTimeout)
+
+	expected := []string{"service nginx"}
+	if stalled := h.stalled(); !reflect.DeepEqual(stalled, expected) {
+		t.Errorf("expected %v, got %v", expected, stalled)
+	}
+
+	h.remove("service nginx")
+	if stalled := h.stalled(); len(stalled) != 0 {
+		t.Errorf("expected no stalled loops, got %v", stalled)
+	}
+}
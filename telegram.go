@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const telegramDefaultURL = "https://api.telegram.org"
+
+// Telegram rejects messages longer than this
+const telegramMaxLength = 4096
+
+// TelegramHandler sends alerts to a Telegram chat through a bot
+type TelegramHandler struct {
+	BotToken       string `mapstructure:"bot_token"`
+	ChatID         string `mapstructure:"chat_id"`
+	SilentWarnings bool   `mapstructure:"silent_warnings"`
+	URL            string `mapstructure:"url"`
+}
+
+// The body of a request to the Bot API's sendMessage method
+type telegramMessage struct {
+	ChatID              string `json:"chat_id"`
+	Text                string `json:"text"`
+	ParseMode           string `json:"parse_mode"`
+	DisableNotification bool   `json:"disable_notification,omitempty"`
+}
+
+// Escapes the characters Telegram's Markdown treats as formatting
+var telegramEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+// Returns the Markdown text of a message for an alert, with the message in bold and the
+// details in a code block
+func telegramText(alert *AlertState) string {
+	text := "*" + telegramEscaper.Replace(alert.Message) + "*"
+	if alert.Details == "" {
+		return text
+	}
+
+	// Telegram's limit is in characters, so count (and cut) runes rather than bytes
+	const open, close = "\n```\n", "\n```"
+	details := []rune(strings.Replace(alert.Details, "`", "'", -1))
+	limit := telegramMaxLength - utf8.RuneCountInString(text+open+close)
+	if len(details) > limit {
+		// Leave the details out if there's no room for any of them
+		if limit <= len("...") {
+			return text
+		}
+		details = append(details[:limit-len("...")], []rune("...")...)
+	}
+	return text + open + string(details) + close
+}
+
+func (t TelegramHandler) Alert(ctx context.Context, alert *AlertState) error {
+	base := t.URL
+	if base == "" {
+		base = telegramDefaultURL
+	}
+	endpoint := fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimRight(base, "/"), t.BotToken)
+
+	err := postJSON(ctx, endpoint, nil, telegramMessage{
+		ChatID:              t.ChatID,
+		Text:                telegramText(alert),
+		ParseMode:           "Markdown",
+		DisableNotification: t.SilentWarnings && alert.Status == api.HealthWarning,
+	})
+	if err != nil {
+		// Don't leak the bot token, which is part of the URL, into the logs
+		return fmt.Errorf("Error sending alert to Telegram: %s", strings.Replace(err.Error(), t.BotToken, "<bot_token>", -1))
+	}
+	return nil
+}
+
+func (t TelegramHandler) Validate() error {
+	if t.BotToken == "" {
+		return errors.New("no bot_token given")
+	}
+	if t.ChatID == "" {
+		return errors.New("no chat_id given")
+	}
+	return nil
+}
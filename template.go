@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// A health check that was failing when an alert was triggered
+type AlertCheck struct {
+	Node    string `json:"node"`
+	CheckID string `json:"check_id"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Output  string `json:"output"`
+	Link    string `json:"link,omitempty"`
+
+	// The check's severity, from its status or a matching severity rule
+	Severity string `json:"severity,omitempty"`
+
+	// The check's category, from a matching output rule
+	Category string `json:"category,omitempty"`
+}
+
+// Returns the failing checks out of the given checks, ignoring service checks when
+// alerting on a node
+func failingChecks(config *Config, checks []*api.HealthCheck, nodeOnly bool) []AlertCheck {
+	failing := make([]AlertCheck, 0)
+	for _, check := range checks {
+		if nodeOnly && check.ServiceID != "" {
+			continue
+		}
+		if check.Status == api.HealthCritical || check.Status == api.HealthWarning {
+			failing = append(failing, AlertCheck{
+				Node:    check.Node,
+				CheckID: check.CheckID,
+				Name:    check.Name,
+				Status:  check.Status,
+				Output:  sanitizeOutput(check.Output, config.CheckOutputLimit),
+				Link:    consulUILink(config, check.Node, ""),
+			})
+		}
+	}
+	return failing
+}
+
+// The data available to title_template and body_template when rendering an alert
+type AlertTemplateData struct {
+	AlertState
+
+	// The display name of the watch, e.g. "service redis (tag: alpha)"
+	Name string
+
+	// The handler the alert is being rendered for
+	Handler string
+
+	// How long it's been since the previous alert for the node/service, e.g. how long it
+	// was failing for when it recovers. Zero if there wasn't one.
+	Duration time.Duration
+
+	// When the alert is being rendered
+	Time time.Time
+}
+
+func newAlertTemplateData(handler string, alert *AlertState, now time.Time) AlertTemplateData {
+	data := AlertTemplateData{
+		AlertState: *alert,
+		Name:       watchName(alert.Node, alert.Service, alert.Tag),
+		Handler:    handler,
+		Time:       now,
+	}
+	if !alert.LastAlertedAt.IsZero() {
+		data.Duration = now.Sub(alert.LastAlertedAt).Round(time.Second)
+	}
+	return data
+}
+
+// Renders a template, trimming any whitespace around the result
+func executeTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Helper functions available to templates, named after their equivalents in sprig
+var templateFuncs = template.FuncMap{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"title":      strings.Title,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"join":       func(sep string, list []string) string { return strings.Join(list, sep) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+	"quote":      func(s string) string { return fmt.Sprintf("%q", s) },
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+	},
+	"trunc": func(length int, s string) string {
+		if length >= 0 && len(s) > length {
+			return s[:length]
+		}
+		return s
+	},
+	"default": func(fallback, value interface{}) interface{} {
+		if value == nil || value == "" || value == 0 || value == false {
+			return fallback
+		}
+		return value
+	},
+	"now":  time.Now,
+	"date": func(layout string, t time.Time) string { return t.Format(layout) },
+	"ago":  func(t time.Time) string { return time.Since(t).Round(time.Second).String() },
+	"toJson": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+}
+
+// Parses a message template from a handler's config
+func parseAlertTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+}
+
+// Parses a message template given either inline or as the path to a file
+func loadAlertTemplate(name, text, path string) (*template.Template, error) {
+	if path == "" {
+		return parseAlertTemplate(name, text)
+	}
+	if text != "" {
+		return nil, fmt.Errorf("only one of %s and %s_file can be set", name, name)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseAlertTemplate(name, string(contents))
+}
+
+// Renders the handler's title and body templates (if it has them) into the alert's message
+// and details
+func (h HandlerOptions) render(handler string, alert *AlertState, now time.Time) error {
+	if h.titleTemplate == nil && h.bodyTemplate == nil {
+		return nil
+	}
+
+	data := newAlertTemplateData(handler, alert, now)
+	execute := func(tmpl *template.Template) (string, error) {
+		return executeTemplate(tmpl, data)
+	}
+
+	var title, body string
+	var err error
+	if h.titleTemplate != nil {
+		if title, err = execute(h.titleTemplate); err != nil {
+			return fmt.Errorf("Error rendering title_template: %s", err)
+		}
+	}
+	if h.bodyTemplate != nil {
+		if body, err = execute(h.bodyTemplate); err != nil {
+			return fmt.Errorf("Error rendering body_template: %s", err)
+		}
+	}
+
+	if h.titleTemplate != nil {
+		alert.Message = title
+	}
+	if h.bodyTemplate != nil {
+		alert.Details = body
+	}
+	return nil
+}
+
+// Renders the alert with the handler's templates, falling back to the default message if
+// they fail so the alert still gets through
+func renderForHandler(options HandlerOptions, handler string, alert *AlertState) {
+	if err := options.render(handler, alert, time.Now()); err != nil {
+		log.Warnf("Using the default message for handler %s: %s", handler, err)
+	}
+}
+
+const templateUsage = `Usage: consul-alerting template test [options]
+
+  Renders a sample alert through a handler's title/body templates and prints the result,
+  so mistakes in a template are caught before it's needed during an incident. Exits 1 if
+  a template fails to parse or render.
+
+Options:
+
+    -config=<path>       The config file to load the handler's templates from.
+    -handler=<name>      The handler (in the form type.name) whose templates to render.
+    -title-file=<path>   A title template file to render instead of a handler's.
+    -body-file=<path>    A body template file to render instead of a handler's.
+    -status=<status>     The status of the sample alert. Defaults to critical.
+    -service=<name>      The service of the sample alert. Defaults to "web".
+    -tag=<tag>           The tag of the sample alert.
+    -node=<name>         Alert on this node instead of a service.
+`
+
+func templateCommand(args []string) int {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprint(os.Stderr, templateUsage)
+		return 1
+	}
+
+	var configPath, handler, titleFile, bodyFile, status, service, tag, node string
+	flags := flag.NewFlagSet("template test", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, templateUsage) }
+	flags.StringVar(&configPath, "config", "", "")
+	flags.StringVar(&handler, "handler", "", "")
+	flags.StringVar(&titleFile, "title-file", "", "")
+	flags.StringVar(&bodyFile, "body-file", "", "")
+	flags.StringVar(&status, "status", api.HealthCritical, "")
+	flags.StringVar(&service, "service", "", "")
+	flags.StringVar(&tag, "tag", "", "")
+	flags.StringVar(&node, "node", "", "")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	var options HandlerOptions
+	config := DefaultConfig()
+	if configPath != "" {
+		var err error
+		config, err = ParseConfigFile(configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if handler != "" {
+		if _, ok := config.Handlers[handler]; !ok {
+			fmt.Fprintf(os.Stderr, "Handler %s not found\n", handler)
+			return 1
+		}
+		options = config.handlerOptions(handler)
+	}
+
+	var err error
+	if titleFile != "" {
+		if options.titleTemplate, err = loadAlertTemplate("title_template", "", titleFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid title template: %s\n", err)
+			return 1
+		}
+	}
+	if bodyFile != "" {
+		if options.bodyTemplate, err = loadAlertTemplate("body_template", "", bodyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid body template: %s\n", err)
+			return 1
+		}
+	}
+	if options.titleTemplate == nil && options.bodyTemplate == nil {
+		fmt.Fprintln(os.Stderr, "No templates to render, give a -handler with templates or a -title-file/-body-file")
+		return 1
+	}
+
+	now := time.Now()
+	alert := sampleAlert(config, status, node, service, tag, now)
+	if err := options.render(handler, alert, now); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Title:\n%s\n\nBody:\n%s\n", alert.Message, alert.Details)
+	return 0
+}
+
+// Returns an alert with every field filled in, to test templates with
+func sampleAlert(config *Config, status, node, service, tag string, now time.Time) *AlertState {
+	if node == "" && service == "" {
+		service = "web"
+	}
+	datacenter := config.ConsulDatacenter
+	if datacenter == "" {
+		datacenter = "dc1"
+	}
+	checkNode := node
+	if checkNode == "" {
+		checkNode = "node1"
+	}
+
+	name := watchName(node, service, tag)
+	alert := &AlertState{
+		Status:        status,
+		Node:          node,
+		Service:       service,
+		Tag:           tag,
+		Datacenter:    datacenter,
+		UpdateIndex:   1,
+		LastAlerted:   api.HealthPassing,
+		LastAlertedAt: now.Add(-5 * time.Minute),
+		Message:       alertMessage(datacenter, name, status),
+		Link:          consulUILink(config, node, service),
+		EventID:       alertEventID(watchKVPath(node, service, tag)+"alert", 1, status),
+	}
+	if status != api.HealthPassing {
+		alert.Checks = []AlertCheck{{
+			Node:    checkNode,
+			CheckID: "sample",
+			Name:    "Sample check",
+			Status:  status,
+			Output:  "connection refused",
+			Link:    consulUILink(config, checkNode, ""),
+		}}
+		alert.Details = fmt.Sprintf("Failing checks:\n=> (node) %s\n==> (check) Sample check:\nconnection refused", checkNode)
+	}
+	config.applySeverity(service, alert)
+	return alert
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTemplate_render(t *testing.T) {
+	title, err := parseAlertTemplate("title", `{{.Name}} {{.Status}} after {{.Duration}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := parseAlertTemplate("body", `{{range .Checks}}{{.Node}}/{{.Name}}: {{.Output}}
+{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := HandlerOptions{titleTemplate: title, bodyTemplate: body}
+
+	now := time.Now()
+	alert := &AlertState{
+		Status:        "passing",
+		Service:       "redis",
+		Message:       "[dc1] service redis is now passing",
+		LastAlertedAt: now.Add(-90 * time.Second),
+		Checks:        []AlertCheck{{Node: "foo", Name: "ping", Output: "timeout"}},
+	}
+	if err := options.render("slack.ops", alert, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "service redis passing after 1m30s"; alert.Message != expected {
+		t.Errorf("expected title %q, got %q", expected, alert.Message)
+	}
+	if expected := "foo/ping: timeout"; alert.Details != expected {
+		t.Errorf("expected body %q, got %q", expected, alert.Details)
+	}
+}
+
+// A template that fails to render should leave the default message in place
+func TestTemplate_renderError(t *testing.T) {
+	title, err := parseAlertTemplate("title", `{{.Missing}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := HandlerOptions{titleTemplate: title}
+
+	alert := &AlertState{Message: "default"}
+	renderForHandler(options, "slack.ops", alert)
+	if alert.Message != "default" {
+		t.Errorf("expected default message, got %q", alert.Message)
+	}
+
+	if _, err := parseAlertTemplate("title", `{{.Name`); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestTemplate_files(t *testing.T) {
+	file, err := ioutil.TempFile("", "consul-alerting-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{{.Status | upper}} {{.Name | trimPrefix "service " | quote}}{{if .Checks}}: {{(index .Checks 0).Output | trunc 10}}{{end}}`)
+	file.Close()
+
+	title, err := loadAlertTemplate("title_template", "", file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := sampleAlert(DefaultConfig(), "critical", "", "redis", "", time.Now())
+	if err := (HandlerOptions{titleTemplate: title}).render("email.ops", alert, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `CRITICAL "redis": connection`; alert.Message != expected {
+		t.Errorf("expected %q, got %q", expected, alert.Message)
+	}
+
+	if _, err := loadAlertTemplate("title_template", "{{.Name}}", file.Name()); err == nil {
+		t.Error("expected error when giving both a template and a file")
+	}
+}
+
+// Make sure message_template is accepted as the body template
+func TestTemplate_messageTemplate(t *testing.T) {
+	config, err := ParseConfig(`
+	handler "stdout" "ops" {
+		message_template = "{{.Name}} checked at {{date \"15:04\" .Time}}"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	alert := &AlertState{Status: "critical", Service: "redis"}
+	if err := config.handlerOptions("stdout.ops").render("stdout.ops", alert, now); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "service redis checked at 09:30"; alert.Details != expected {
+		t.Errorf("expected body %q, got %q", expected, alert.Details)
+	}
+
+	_, err = ParseConfig(`
+	handler "stdout" "ops" {
+		message_template = "{{.Name}}"
+		body_template = "{{.Status}}"
+	}
+	`)
+	if err == nil {
+		t.Error("expected an error when giving both message_template and body_template")
+	}
+}
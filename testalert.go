@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const testAlertUsage = `Usage: consul-alerting test-alert [options]
+
+  Sends a synthetic alert through the configured handlers, to check their credentials
+  and routing without breaking a real service. By default the alert goes to the handlers
+  it would be routed to; use -handler or -all to pick handlers directly. Exits 1 if any
+  handler fails.
+
+Options:
+
+    -config=<path>       The config file to load the handlers from. Required.
+    -handler=<name>      Send to this handler, either type.name or a type to send to
+                         every handler of that type (e.g. slack).
+    -all                 Send to every handler.
+    -status=<status>     The status of the test alert. Defaults to critical.
+    -service=<name>      The service of the test alert. Defaults to "web".
+    -tag=<tag>           The tag of the test alert.
+    -node=<name>         Alert on this node instead of a service.
+`
+
+func testAlertCommand(args []string) int {
+	var configPath, handler, status, service, tag, node string
+	var all bool
+	flags := flag.NewFlagSet("test-alert", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, testAlertUsage) }
+	flags.StringVar(&configPath, "config", "", "")
+	flags.StringVar(&handler, "handler", "", "")
+	flags.BoolVar(&all, "all", false, "")
+	flags.StringVar(&status, "status", api.HealthCritical, "")
+	flags.StringVar(&service, "service", "", "")
+	flags.StringVar(&tag, "tag", "", "")
+	flags.StringVar(&node, "node", "", "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if configPath == "" {
+		fmt.Fprintln(os.Stderr, "-config is required")
+		return 1
+	}
+	config, err := ParseConfigFile(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	alert := testAlert(config, status, node, service, tag, time.Now())
+	names, err := testAlertHandlers(config, handler, all, alert)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Sending test alert: %s\n\n", alert.Message)
+	failed := false
+	for _, name := range names {
+		if err := callHandler(config, name, alert); err != nil {
+			fmt.Printf("  %s: FAILED: %s\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("  %s: ok\n", name)
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// Returns a sample alert marked as a test, with an event ID of its own so receivers
+// don't deduplicate it against a real alert or an earlier test
+func testAlert(config *Config, status, node, service, tag string, now time.Time) *AlertState {
+	alert := sampleAlert(config, status, node, service, tag, now)
+	alert.Message = "[TEST] " + alert.Message
+	alert.Details = "This is a test alert sent by consul-alerting test-alert.\n\n" + alert.Details
+	alert.EventID = alertEventID("test-alert/"+watchKVPath(node, service, tag), now.UnixNano(), status)
+	alert.IncidentID = alert.EventID
+	return alert
+}
+
+// Returns the handlers to send a test alert to: every handler, the handlers matching the
+// given name or type, or the handlers the alert is routed to
+func testAlertHandlers(config *Config, handler string, all bool, alert *AlertState) ([]string, error) {
+	if all && handler != "" {
+		return nil, fmt.Errorf("Only one of -handler and -all can be given")
+	}
+
+	if !all && handler == "" {
+		names := config.alertHandlerNames(alert.Service, alert)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("The test alert isn't routed to any handlers")
+		}
+		return names, nil
+	}
+
+	names := make([]string, 0)
+	for name := range config.Handlers {
+		if all || name == handler || strings.HasPrefix(name, handler+".") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("No handlers found matching %s", handler)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTestAlert_handlers(t *testing.T) {
+	config, err := ParseConfig(`
+	handler "slack" "payments" { webhook_url = "https://hooks.slack.com/services/a" }
+	handler "slack" "search" { webhook_url = "https://hooks.slack.com/services/b" }
+	handler "stdout" "default" {}
+
+	route "payments" {
+		service = "payments*"
+		handlers = ["slack.payments"]
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := testAlert(config, "critical", "", "payments", "", time.Now())
+	if !strings.HasPrefix(alert.Message, "[TEST] ") {
+		t.Errorf("expected the test alert to be marked, got %q", alert.Message)
+	}
+
+	cases := []struct {
+		handler  string
+		all      bool
+		expected []string
+	}{
+		{"", false, []string{"slack.payments"}},
+		{"slack", false, []string{"slack.payments", "slack.search"}},
+		{"stdout.default", false, []string{"stdout.default"}},
+		{"", true, []string{"slack.payments", "slack.search", "stdout.default"}},
+	}
+	for _, c := range cases {
+		names, err := testAlertHandlers(config, c.handler, c.all, alert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%q/%v: expected %v, got %v", c.handler, c.all, c.expected, names)
+		}
+	}
+
+	if _, err := testAlertHandlers(config, "pagerduty", false, alert); err == nil {
+		t.Error("expected an error for a handler that doesn't exist")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const topUsage = `Usage: consul-alerting top [options]
+
+  Shows a live, top-style view of a running daemon's watches, refreshed periodically
+  from its status endpoint. Press Ctrl-C to exit.
+
+Options:
+
+    -config=<path>      The config file to read http_address from.
+    -address=<addr>     The address of the daemon's HTTP endpoint. Overrides the config.
+    -interval=<dur>     How often to refresh. Defaults to 2s.
+`
+
+// ANSI escape sequences used to draw the view
+const (
+	ansiClear  = "\033[H\033[2J"
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiRed    = "\033[31m"
+	ansiYellow = "\033[33m"
+	ansiGreen  = "\033[32m"
+)
+
+func topCommand(args []string) int {
+	var configPath, address string
+	var interval time.Duration
+	flags := flag.NewFlagSet("top", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, topUsage) }
+	flags.StringVar(&configPath, "config", "", "")
+	flags.StringVar(&address, "address", "", "")
+	flags.DurationVar(&interval, "interval", 2*time.Second, "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	address, err := daemonAddress(configPath, address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	client := &http.Client{Timeout: interval}
+	for {
+		status, err := fetchStatus(client, address)
+
+		fmt.Print(ansiClear)
+		fmt.Printf("%sconsul-alerting%s %s  %s\n\n", ansiBold, ansiReset, address, time.Now().Format("15:04:05"))
+		if err != nil {
+			fmt.Printf("%sError fetching status: %s%s\n", ansiRed, err, ansiReset)
+		} else {
+			fmt.Print(renderStatus(status, time.Now()))
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// Fetches the status of a running daemon
+func fetchStatus(client *http.Client, address string) (*StatusResponse, error) {
+	resp, err := client.Get("http://" + address + "/v1/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected response code: %d", resp.StatusCode)
+	}
+
+	status := &StatusResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// The order to show watches in, worst health first
+var statusOrder = map[string]int{
+	"critical": 0,
+	"warning":  1,
+	"passing":  2,
+}
+
+type watchesByHealth []*WatchStatus
+
+func (w watchesByHealth) Len() int      { return len(w) }
+func (w watchesByHealth) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+func (w watchesByHealth) Less(i, j int) bool {
+	if statusOrder[w[i].Status] != statusOrder[w[j].Status] {
+		return statusOrder[w[i].Status] < statusOrder[w[j].Status]
+	}
+	return w[i].Name < w[j].Name
+}
+
+// Renders the status as a table, with the least healthy watches at the top
+func renderStatus(status *StatusResponse, now time.Time) string {
+	watches := make([]*WatchStatus, 0)
+	counts := make(map[string]int)
+	flapping := 0
+	for _, watch := range status.Watches {
+		if watch.Leader {
+			watches = append(watches, watch)
+			counts[watch.Status]++
+			if watch.Flapping {
+				flapping++
+			}
+		}
+	}
+	sort.Sort(watchesByHealth(watches))
+
+	out := fmt.Sprintf("Watches: %d leading, %d of them failing (%d critical, %d warning), %d flapping\n\n",
+		len(watches), counts["critical"]+counts["warning"], counts["critical"], counts["warning"], flapping)
+	if status.RetryQueue > 0 {
+		out = strings.TrimSuffix(out, "\n") + fmt.Sprintf("Notifications waiting to be retried: %d\n\n", status.RetryQueue)
+	}
+	for _, name := range handlerNames(status.Breakers) {
+		out = strings.TrimSuffix(out, "\n") + fmt.Sprintf("%sCircuit breaker %s for handler %s%s\n\n", ansiRed, status.Breakers[name], name, ansiReset)
+	}
+	for _, name := range handlerNames(status.UnhealthyHandlers) {
+		out = strings.TrimSuffix(out, "\n") + fmt.Sprintf("%sHandler %s is failing its health probe: %s%s\n\n", ansiRed, name, status.UnhealthyHandlers[name], ansiReset)
+	}
+	out += fmt.Sprintf("%s%-40s %-10s %-14s %s%s\n", ansiBold, "WATCH", "STATUS", "ALERT IN", "FAILING CHECKS", ansiReset)
+
+	for _, watch := range watches {
+		color := ansiGreen
+		switch watch.Status {
+		case "critical":
+			color = ansiRed
+		case "warning":
+			color = ansiYellow
+		}
+
+		// Show when the latest pending alert will fire; any earlier ones were superseded
+		alertIn := "-"
+		if len(watch.PendingAlerts) > 0 {
+			pending := make([]*PendingAlert, len(watch.PendingAlerts))
+			copy(pending, watch.PendingAlerts)
+			sort.Sort(pendingByIndex(pending))
+
+			remaining := pending[len(pending)-1].FireAt.Sub(now)
+			if remaining < 0 {
+				remaining = 0
+			}
+			alertIn = remaining.String()
+		}
+
+		name := watch.Name
+		if watch.Flapping {
+			name = fmt.Sprintf("%s%-40s%s", ansiYellow, name+" (flapping)", ansiReset)
+		} else {
+			name = fmt.Sprintf("%-40s", name)
+		}
+
+		out += fmt.Sprintf("%s %s%-10s%s %-14s %s\n", name, color, watch.Status, ansiReset, alertIn, strings.Join(watch.FailingChecks, ", "))
+	}
+
+	return out
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Make sure failing watches are shown first and non-leader watches are left out
+func TestTop_renderStatus(t *testing.T) {
+	now := time.Now()
+	status := &StatusResponse{
+		Watches: []*WatchStatus{
+			{Name: "node node1", Leader: true, Status: "passing"},
+			{Name: "service nginx", Leader: false, Status: "critical"},
+			{Name: "service redis", Leader: true, Status: "critical", FailingChecks: []string{"node1/service:redis"},
+				PendingAlerts: []*PendingAlert{{FireAt: now.Add(30 * time.Second)}}},
+		},
+	}
+
+	out := renderStatus(status, now)
+
+	if strings.Contains(out, "service nginx") {
+		t.Errorf("expected non-leader watch to be left out:\n%s", out)
+	}
+
+	redis := strings.Index(out, "service redis")
+	node := strings.Index(out, "node node1")
+	if redis == -1 || node == -1 || redis > node {
+		t.Errorf("expected critical watch to be listed before passing one:\n%s", out)
+	}
+
+	if !strings.Contains(out, "30s") {
+		t.Errorf("expected pending alert time to be shown:\n%s", out)
+	}
+}
+
+// Make sure a flapping watch is shown as flapping, with the time left on its latest timer,
+// and superseded alert timers alone don't count as flapping
+func TestTop_renderStatusFlapping(t *testing.T) {
+	now := time.Now()
+	status := &StatusResponse{
+		Watches: []*WatchStatus{
+			{Name: "service redis", Leader: true, Status: "critical", Flapping: true, PendingAlerts: []*PendingAlert{
+				{UpdateIndex: 3, FireAt: now.Add(50 * time.Second)},
+				{UpdateIndex: 2, FireAt: now.Add(20 * time.Second)},
+			}},
+			{Name: "service web", Leader: true, Status: "warning", PendingAlerts: []*PendingAlert{
+				{UpdateIndex: 5, FireAt: now.Add(40 * time.Second)},
+				{UpdateIndex: 4, FireAt: now.Add(10 * time.Second)},
+			}},
+		},
+	}
+
+	out := renderStatus(status, now)
+
+	if !strings.Contains(out, "service redis (flapping)") || !strings.Contains(out, "1 flapping") {
+		t.Errorf("expected watch to be shown as flapping:\n%s", out)
+	}
+	if strings.Contains(out, "service web (flapping)") {
+		t.Errorf("expected watch with superseded timers not to be shown as flapping:\n%s", out)
+	}
+	if !strings.Contains(out, "50s") {
+		t.Errorf("expected latest pending alert time to be shown:\n%s", out)
+	}
+}
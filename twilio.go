@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const twilioDefaultURL = "https://api.twilio.com"
+
+// Twilio splits longer messages into segments, but won't send anything over this length
+const twilioMaxLength = 1600
+
+// TwilioHandler texts alerts to a list of phone numbers through Twilio's messaging API. By
+// default only critical alerts are sent, since every text pages someone.
+type TwilioHandler struct {
+	AccountSID string   `mapstructure:"account_sid"`
+	AuthToken  string   `mapstructure:"auth_token"`
+	From       string   `mapstructure:"from"`
+	To         []string `mapstructure:"to"`
+	Statuses   []string `mapstructure:"statuses"`
+	URL        string   `mapstructure:"url"`
+}
+
+// Returns the text of the SMS for an alert. Only the message is sent, since check output
+// doesn't read well on a phone.
+func twilioBody(alert *AlertState) string {
+	body := alert.Message
+	if alert.Link != "" {
+		body = body + "\n" + alert.Link
+	}
+	if len(body) > twilioMaxLength {
+		body = body[:twilioMaxLength]
+	}
+	return body
+}
+
+func (t TwilioHandler) Alert(ctx context.Context, alert *AlertState) error {
+	if !contains(t.Statuses, alert.Status) {
+		return nil
+	}
+
+	base := t.URL
+	if base == "" {
+		base = twilioDefaultURL
+	}
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimRight(base, "/"), url.PathEscape(t.AccountSID))
+
+	// Text every number even if one fails, so one bad number doesn't stop the page. Numbers
+	// are recorded in the alert's deliveries as they're sent, so a retry only texts the ones
+	// that failed.
+	failed := make([]string, 0)
+	for _, to := range t.To {
+		key := t.deliveryKey(to)
+		if contains(alert.Delivered, key) {
+			continue
+		}
+		if err := t.send(ctx, endpoint, to, twilioBody(alert)); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", to, err))
+			continue
+		}
+		alert.Delivered = append(alert.Delivered, key)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Error sending alert to Twilio: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Returns the key recording that an alert was texted to a number from this handler's number
+func (t TwilioHandler) deliveryKey(to string) string {
+	return fmt.Sprintf("twilio %s %s", t.From, to)
+}
+
+// Sends a single SMS
+func (t TwilioHandler) send(ctx context.Context, endpoint, to, body string) error {
+	form := url.Values{}
+	form.Set("From", t.From)
+	form.Set("To", to)
+	form.Set("Body", body)
+
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("got response code %d (%s)", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (t TwilioHandler) Validate() error {
+	if t.AccountSID == "" {
+		return errors.New("no account_sid given")
+	}
+	if t.AuthToken == "" {
+		return errors.New("no auth_token given")
+	}
+	if t.From == "" {
+		return errors.New("no from number given")
+	}
+	if len(t.To) == 0 {
+		return errors.New("no to numbers given")
+	}
+	for _, status := range t.Statuses {
+		switch status {
+		case api.HealthCritical, api.HealthWarning, api.HealthPassing:
+		default:
+			return fmt.Errorf("invalid status %q, must be critical, warning or passing", status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// How long to wait for a single handler's probe to finish
+const handlerProbeTimeout = 10 * time.Second
+
+// Handlers that can check their settings for obvious mistakes implement HandlerValidator.
+// Validate is called when the config is parsed and shouldn't make any network calls.
+type HandlerValidator interface {
+	Validate() error
+}
+
+// Handlers that can cheaply check their credentials or connectivity without sending an
+// alert implement HandlerProber
+type HandlerProber interface {
+	Probe(context.Context) error
+}
+
+// Probes each handler that supports it, returning an error describing every handler that
+// failed its probe
+func probeHandlers(config *Config) error {
+	failed := make([]string, 0)
+	for _, name := range sortedHandlerNames(config) {
+		prober, ok := unwrapHandler(config.Handlers[name]).(HandlerProber)
+		if !ok {
+			continue
+		}
+
+		if err := probeHandler(config, name, prober); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+		log.Infof("Probe succeeded for handler %s", name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Handler probes failed: %v", failed)
+	}
+	return nil
+}
+
+// Probes a single handler, giving up after handlerProbeTimeout
+func probeHandler(config *Config, name string, prober HandlerProber) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerProbeTimeout)
+	defer cancel()
+	ctx = handlerContext(ctx, config.handlerOptions(name))
+	return prober.Probe(ctx)
+}
+
+// Returns the names of the configured handlers, sorted
+func sortedHandlerNames(config *Config) []string {
+	names := make([]string, 0, len(config.Handlers))
+	for name := range config.Handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
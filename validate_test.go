@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A handler whose probe always fails
+type failingProbeHandler struct {
+	StdoutHandler
+}
+
+func (f failingProbeHandler) Probe(ctx context.Context) error {
+	return errors.New("invalid_auth")
+}
+
+// Make sure obviously broken handler configs are rejected when parsing
+func TestValidate_handlerConfig(t *testing.T) {
+	cases := map[string]string{
+		`handler "stdout" "bad" { log_level = "loud" }`:                                                                     `invalid log_level "loud"`,
+		`handler "email" "bad" { recipients = ["admin"] }`:                                                                  `invalid recipient "admin"`,
+		`handler "email" "bad" {}`:                                                                                          `no recipients given`,
+		`handler "pagerduty" "bad" { max_retries = 1 }`:                                                                     `no service_key given`,
+		`handler "slack" "bad" { api_token = "xoxb-1234" }`:                                                                 `no channel_name given`,
+		`handler "slack" "bad" { channel_name = "ops" }`:                                                                    `no api_token or webhook_url given`,
+		`handler "grafana" "bad" { url = "http://grafana" }`:                                                                `no api_key given`,
+		`handler "kafka" "bad" { brokers = ["kafka:9092"] }`:                                                                `no topic given`,
+		`handler "kafka" "bad" { brokers = ["kafka:9092"], topic = "a", sasl_mechanism = "GSSAPI" }`:                        `invalid sasl_mechanism "GSSAPI"`,
+		`handler "telegram" "bad" { bot_token = "123:abc" }`:                                                                `no chat_id given`,
+		`handler "twilio" "bad" { account_sid = "AC1", auth_token = "t", from = "+1", to = ["+2"], statuses = ["down"] }`:   `invalid status "down"`,
+		`handler "rocketchat" "bad" { channel = "#ops" }`:                                                                   `no webhook_url given`,
+		`handler "alertmanager" "bad" { alertname = "Consul" }`:                                                             `no url given`,
+		`handler "jira" "bad" { url = "https://jira", username = "a", api_token = "b" }`:                                    `no project given`,
+		`handler "jira" "bad" { url = "https://jira", username = "a", api_token = "b", project = "O", deployment = "x" }`:   `invalid deployment "x", must be cloud or server`,
+		`handler "nsca" "bad" { address = "nagios:5667", encryption = "des" }`:                                              `unsupported encryption "des"`,
+		`handler "syslog" "bad" { address = "syslog:514", facility = "local9" }`:                                            `invalid facility "local9"`,
+		`handler "exec" "bad" { command = ["no-such-alert-command"] }`:                                                      `command no-such-alert-command not found`,
+		`handler "json" "bad" { path = "/no/such/dir/alerts.log" }`:                                                         `directory for path /no/such/dir/alerts.log doesn't exist`,
+		`handler "zulip" "bad" { url = "https://zulip", email = "a", api_key = "b", stream = "ops", topic = "{{.Service" }`: `invalid topic template`,
+		`handler "irc" "bad" { server = "irc.libera.chat:6697", channel = "ops" }`:                                          `invalid channel "ops"`,
+		`handler "alerta" "bad" { api_key = "key" }`:                                                                        `no url given`,
+		`handler "bigpanda" "bad" { token = "token" }`:                                                                      `no app_key given`,
+		`handler "squadcast" "bad" { url = "https://squadcast" }`:                                                           `no token given`,
+		`handler "snmp" "bad" { address = "nms", version = "1" }`:                                                           `invalid version "1", must be 2c or 3`,
+		`handler "snmp" "bad" { address = "nms", version = "3", username = "a", engine_id = "80" }`:                         `engine_id must be given as 5 to 32 bytes in hex`,
+		`handler "eventbridge" "bad" { region = "us-east-1", source = "aws.health" }`:                                       `invalid source "aws.health", sources starting with aws. are reserved`,
+		`handler "pubsub" "bad" { project = "infra" }`:                                                                      `no topic given`,
+		`handler "eventgrid" "bad" { endpoint = "https://alerts.westus2-1.eventgrid.azure.net/api/events" }`:                `no key given`,
+		`handler "elasticsearch" "bad" { url = "http://es:9200", index = "Alerts" }`:                                        `index names must be lowercase`,
+		`handler "sentry" "bad" { dsn = "https://sentry.example.com/42" }`:                                                  `invalid dsn: no public key in dsn`,
+		`handler "zenduty" "bad" { url = "https://zenduty" }`:                                                               `no integration_key given`,
+		`handler "webex" "bad" { bot_token = "token" }`:                                                                     `no room_id given`,
+		`handler "plugin" "bad" { config { channel = "ops" } }`:                                                             `no command given`,
+		`handler "webhook" "bad" { url = "https://example.com", hmac_timestamp = true }`:                                    `hmac_header and hmac_timestamp require an hmac_secret`,
+	}
+
+	for raw, expected := range cases {
+		_, err := ParseConfig(raw)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q, got %v", raw, expected, err)
+		}
+	}
+}
+
+// Make sure probe failures are reported with the handler's name
+func TestValidate_probeHandlers(t *testing.T) {
+	config := &Config{
+		Handlers: map[string]AlertHandler{
+			"stdout.ok":    StdoutHandler{LogLevel: "warn"},
+			"slack.broken": failingProbeHandler{},
+		},
+	}
+
+	err := probeHandlers(config)
+	if err == nil || !strings.Contains(err.Error(), "slack.broken: invalid_auth") {
+		t.Fatalf("expected probe failure for slack.broken, got %v", err)
+	}
+}
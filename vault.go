@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// How long to wait before trying again to renew or read a secret after it fails
+const vaultRetryWait = 30 * time.Second
+
+// VaultClient reads handler secrets from Vault's HTTP API. Each secret it reads is kept, so
+// it can be renewed or read again before its lease runs out.
+type VaultClient struct {
+	sync.Mutex
+	address string
+	token   string
+	client  *http.Client
+	secrets map[string]*vaultSecretData
+}
+
+// A secret read from Vault, and when it next needs renewing or reading again
+type vaultSecretData struct {
+	data      map[string]interface{}
+	leaseID   string
+	renewable bool
+	due       time.Time
+}
+
+// The response to a read or renewal from Vault's HTTP API
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Errors        []string               `json:"errors"`
+}
+
+// Returns the Vault client for the config, creating it the first time a secret is read.
+// The address and token default to the VAULT_ADDR and VAULT_TOKEN env vars.
+func (c *Config) vaultClient() (*VaultClient, error) {
+	if c.vault != nil {
+		return c.vault, nil
+	}
+
+	address, token := c.VaultAddress, c.VaultToken
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if strings.HasPrefix(token, "env://") {
+		var err error
+		if token, err = envSecret(c, strings.TrimPrefix(token, "env://")); err != nil {
+			return nil, fmt.Errorf("Invalid vault_token: %s", err)
+		}
+	}
+	if address == "" {
+		return nil, fmt.Errorf("vault_address must be set to use secrets from Vault")
+	}
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+
+	c.vault = &VaultClient{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: time.Duration(c.HandlerTimeout) * time.Second},
+		secrets: make(map[string]*vaultSecretData),
+	}
+	return c.vault, nil
+}
+
+// Reads a secret from Vault, given as path#key, e.g. secret/data/alerting#slack_token
+func vaultSecret(config *Config, ref string) (string, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("Vault reference %q must be in the form vault://path#key", ref)
+	}
+	path, key := parts[0], parts[1]
+
+	vault, err := config.vaultClient()
+	if err != nil {
+		return "", err
+	}
+	data, err := vault.secret(path, config)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no key %s", path, key)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Returns the data of the secret at the path, reading it from Vault if it hasn't been yet
+func (v *VaultClient) secret(path string, config *Config) (map[string]interface{}, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if secret, ok := v.secrets[path]; ok {
+		return secret.data, nil
+	}
+	secret, err := v.read(path, config, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	v.secrets[path] = secret
+	return secret.data, nil
+}
+
+// Reads the secret at the path from Vault. Secrets from a KV version 2 engine are unwrapped,
+// and secrets without a lease are due to be read again after the refresh interval.
+func (v *VaultClient) read(path string, config *Config, now time.Time) (*vaultSecretData, error) {
+	resp, err := v.request("GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading Vault secret %s: %s", path, err)
+	}
+
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+
+	secret := &vaultSecretData{
+		data:      data,
+		leaseID:   resp.LeaseID,
+		renewable: resp.Renewable,
+	}
+	secret.due = vaultDue(resp.LeaseDuration, config, now)
+	return secret, nil
+}
+
+// Returns when a secret with the given lease (in seconds) needs renewing or reading again:
+// two thirds of the way through its lease, or after the refresh interval if it has none
+func vaultDue(lease int, config *Config, now time.Time) time.Time {
+	if lease > 0 {
+		return now.Add(time.Duration(lease) * time.Second * 2 / 3)
+	}
+	if config.VaultRefreshInterval > 0 {
+		return now.Add(time.Duration(config.VaultRefreshInterval) * time.Second)
+	}
+	return time.Time{}
+}
+
+// Makes a request to Vault's HTTP API, returning its decoded response
+func (v *VaultClient) request(method, path string, body interface{}) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, v.address+"/v1/"+strings.TrimPrefix(path, "/"), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var decoded vaultResponse
+	raw, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	json.Unmarshal(raw, &decoded)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(decoded.Errors) > 0 {
+			return nil, fmt.Errorf("got response code %d (%s)", resp.StatusCode, strings.Join(decoded.Errors, ", "))
+		}
+		return nil, fmt.Errorf("got response code %d", resp.StatusCode)
+	}
+	return &decoded, nil
+}
+
+// Renews or reads again each secret that's due, returning true if any of their values
+// changed. A renewable lease is renewed, and the secret is read again if that fails.
+func (v *VaultClient) refresh(config *Config, now time.Time) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	changed := false
+	for _, path := range v.paths() {
+		secret := v.secrets[path]
+		if secret.due.IsZero() || now.Before(secret.due) {
+			continue
+		}
+
+		if secret.renewable && secret.leaseID != "" {
+			resp, err := v.request("PUT", "sys/leases/renew", map[string]string{"lease_id": secret.leaseID})
+			if err == nil {
+				log.Debugf("Renewed the lease on Vault secret %s", path)
+				secret.due = vaultDue(resp.LeaseDuration, config, now)
+				continue
+			}
+			log.Warnf("Error renewing the lease on Vault secret %s, reading it again: %s", path, err)
+		}
+
+		updated, err := v.read(path, config, now)
+		if err != nil {
+			log.Errorf("%s, retrying in %s", err, vaultRetryWait)
+			secret.due = now.Add(vaultRetryWait)
+			continue
+		}
+		if !reflect.DeepEqual(updated.data, secret.data) {
+			log.Infof("Vault secret %s has changed", path)
+			changed = true
+		}
+		v.secrets[path] = updated
+	}
+	return changed
+}
+
+// Returns when the next secret is due to be renewed or read again, or the zero time if
+// none are
+func (v *VaultClient) nextDue() time.Time {
+	v.Lock()
+	defer v.Unlock()
+
+	var next time.Time
+	for _, secret := range v.secrets {
+		if !secret.due.IsZero() && (next.IsZero() || secret.due.Before(next)) {
+			next = secret.due
+		}
+	}
+	return next
+}
+
+// Returns the paths of the secrets that have been read, sorted. Must be called with the
+// client locked.
+func (v *VaultClient) paths() []string {
+	paths := make([]string, 0, len(v.secrets))
+	for path := range v.secrets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Keeps the Vault secrets the handlers use from expiring, rebuilding the handlers with the
+// new values when any of them change. Runs until the process exits.
+func refreshVaultSecrets(config *Config) {
+	for {
+		next := config.vault.nextDue()
+		if next.IsZero() {
+			return
+		}
+		time.Sleep(next.Sub(time.Now()))
+
+		if config.vault.refresh(config, time.Now()) {
+			reloadVaultHandlers(config)
+		}
+	}
+}
+
+// Rebuilds every handler that uses secrets from Vault with their current values
+func reloadVaultHandlers(config *Config) {
+	for _, name := range sortedHandlerNames(config) {
+		if handler, ok := config.Handlers[name].(*VaultHandler); ok {
+			if err := handler.reload(config); err != nil {
+				log.Errorf("Error reloading handler %s with its new Vault secrets, still using the old ones: %s", name, err)
+				continue
+			}
+			log.Infof("Reloaded handler %s with its new Vault secrets", name)
+		}
+	}
+}
+
+// VaultHandler wraps a handler whose settings reference secrets from Vault, so it can be
+// rebuilt without a restart when they're rotated
+type VaultHandler struct {
+	id          string
+	handlerType string
+	settings    map[string]interface{}
+	current     atomic.Value
+}
+
+func newVaultHandler(id, handlerType string, settings map[string]interface{}, handler AlertHandler) *VaultHandler {
+	h := &VaultHandler{id: id, handlerType: handlerType, settings: settings}
+	h.current.Store(&handler)
+	return h
+}
+
+// Returns the handler built with the current secrets
+func (h *VaultHandler) handler() AlertHandler {
+	return *h.current.Load().(*AlertHandler)
+}
+
+// Builds the handler again with the current secrets. If probe_handlers is set, the new
+// handler has to pass its probe before it replaces the old one.
+func (h *VaultHandler) reload(config *Config) error {
+	handler, err := newHandler(config, h.id, h.handlerType, h.settings)
+	if err != nil {
+		return err
+	}
+	if prober, ok := handler.(HandlerProber); ok && config.ProbeHandlers {
+		if err := probeHandler(config, h.id, prober); err != nil {
+			return err
+		}
+	}
+	h.current.Store(&handler)
+	return nil
+}
+
+func (h *VaultHandler) Alert(ctx context.Context, alert *AlertState) error {
+	return h.handler().Alert(ctx, alert)
+}
+
+// Returns the handler a VaultHandler wraps, or the handler itself if it isn't one, so
+// checks for the interfaces it implements see the real handler
+func unwrapHandler(handler AlertHandler) AlertHandler {
+	if h, ok := handler.(*VaultHandler); ok {
+		return h.handler()
+	}
+	return handler
+}
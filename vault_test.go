@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake Vault server with a KV version 2 secret and a leased secret
+type fakeVault struct {
+	sync.Mutex
+	webhookURL string
+	renewals   int
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != "root" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/secret/data/alerting":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"webhook_url": f.webhookURL},
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		})
+	case "/v1/database/creds/alerting":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       "database/creds/alerting/abc",
+			"lease_duration": 60,
+			"renewable":      true,
+			"data":           map[string]interface{}{"password": "hunter2"},
+		})
+	case "/v1/sys/leases/renew":
+		f.renewals++
+		json.NewEncoder(w).Encode(map[string]interface{}{"lease_duration": 60, "renewable": true})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVault_secrets(t *testing.T) {
+	vault := &fakeVault{webhookURL: "https://hooks.slack.com/services/old"}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	config, err := ParseConfig(`
+	vault_address = "` + server.URL + `"
+	vault_token = "root"
+	vault_refresh_interval = 300
+
+	handler "slack" "ops" {
+		webhook_url = "vault://secret/data/alerting#webhook_url"
+	}
+	handler "webhook" "db" {
+		url = "https://example.com"
+		headers = { Authorization = "vault://database/creds/alerting#password" }
+	}
+	handler "stdout" "plain" {}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookURL := func() string {
+		return unwrapHandler(config.Handlers["slack.ops"]).(SlackHandler).WebhookURL
+	}
+	if url := webhookURL(); url != "https://hooks.slack.com/services/old" {
+		t.Errorf("expected the webhook URL from Vault, got %q", url)
+	}
+	if _, ok := config.Handlers["stdout.plain"].(*VaultHandler); ok {
+		t.Error("expected a handler without Vault secrets not to be wrapped")
+	}
+
+	// The leased secret is renewed two thirds of the way through its lease, and the KV
+	// secret is read again after the refresh interval, picking up its new value
+	start := time.Now()
+	if config.vault.refresh(config, start.Add(30*time.Second)) || vault.renewals != 0 {
+		t.Fatal("expected nothing to be due yet")
+	}
+	if config.vault.refresh(config, start.Add(41*time.Second)) || vault.renewals != 1 {
+		t.Fatal("expected the lease to be renewed")
+	}
+
+	vault.Lock()
+	vault.webhookURL = "https://hooks.slack.com/services/new"
+	vault.Unlock()
+	if !config.vault.refresh(config, start.Add(301*time.Second)) {
+		t.Fatal("expected the KV secret's change to be noticed")
+	}
+	reloadVaultHandlers(config)
+	if url := webhookURL(); url != "https://hooks.slack.com/services/new" {
+		t.Errorf("expected the handler to be rebuilt with the new webhook URL, got %q", url)
+	}
+
+	for _, handler := range []string{
+		`webhook_url = "vault://secret/data/alerting"`,
+		`webhook_url = "vault://secret/data/alerting#missing"`,
+		`webhook_url = "vault://secret/data/unknown#webhook_url"`,
+	} {
+		if _, err := ParseConfig(`vault_address = "` + server.URL + `"
+			vault_token = "root"
+			handler "slack" "ops" { ` + handler + ` }`); err == nil {
+			t.Errorf("expected an error for %s", handler)
+		}
+	}
+}
+
+// Make sure a handler rebuilt with new secrets has to pass its probe before it replaces
+// the old one when probe_handlers is set
+func TestVault_reloadProbe(t *testing.T) {
+	alertmanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/up/api/v2/status" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer alertmanager.Close()
+
+	vault := &fakeVault{webhookURL: alertmanager.URL + "/up"}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	config, err := ParseConfig(`
+	vault_address = "` + server.URL + `"
+	vault_token = "root"
+	probe_handlers = true
+
+	handler "alertmanager" "ops" {
+		url = "vault://secret/data/alerting#webhook_url"
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlerURL := func() string {
+		return unwrapHandler(config.Handlers["alertmanager.ops"]).(AlertmanagerHandler).URL
+	}
+
+	vault.Lock()
+	vault.webhookURL = alertmanager.URL + "/down"
+	vault.Unlock()
+	if !config.vault.refresh(config, time.Now().Add(301*time.Second)) {
+		t.Fatal("expected the KV secret's change to be noticed")
+	}
+	reloadVaultHandlers(config)
+	if url := handlerURL(); url != alertmanager.URL+"/up" {
+		t.Errorf("expected the old handler to be kept when the new one fails its probe, got %q", url)
+	}
+
+	vault.Lock()
+	vault.webhookURL = alertmanager.URL + "/up/"
+	vault.Unlock()
+	if !config.vault.refresh(config, time.Now().Add(602*time.Second)) {
+		t.Fatal("expected the KV secret's change to be noticed")
+	}
+	reloadVaultHandlers(config)
+	if url := handlerURL(); url != alertmanager.URL+"/up/" {
+		t.Errorf("expected the handler to be rebuilt once its probe passes, got %q", url)
+	}
+}
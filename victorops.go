@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const victorOpsDefaultURL = "https://alert.victorops.com/integrations/generic/20131114/alert"
+
+// VictorOpsHandler sends alerts to VictorOps (Splunk On-Call) through its REST integration
+type VictorOpsHandler struct {
+	APIKey      string            `mapstructure:"api_key"`
+	RoutingKey  string            `mapstructure:"routing_key"`
+	RoutingKeys map[string]string `mapstructure:"routing_keys"`
+	URL         string            `mapstructure:"url"`
+}
+
+// The body of an alert sent to the VictorOps REST integration
+type victorOpsAlert struct {
+	MessageType       string `json:"message_type"`
+	EntityID          string `json:"entity_id"`
+	EntityDisplayName string `json:"entity_display_name"`
+	StateMessage      string `json:"state_message"`
+	StateStartTime    int64  `json:"state_start_time"`
+	MonitoringTool    string `json:"monitoring_tool"`
+	EventID           string `json:"event_id,omitempty"`
+	Node              string `json:"node,omitempty"`
+	Service           string `json:"service,omitempty"`
+	Tag               string `json:"tag,omitempty"`
+	Datacenter        string `json:"datacenter,omitempty"`
+}
+
+// Returns the VictorOps message type for a Consul health status
+func victorOpsMessageType(status string) string {
+	switch status {
+	case api.HealthCritical:
+		return "CRITICAL"
+	case api.HealthWarning:
+		return "WARNING"
+	case api.HealthPassing:
+		return "RECOVERY"
+	}
+	return "INFO"
+}
+
+// Returns the routing key for the alert's service, falling back to the default routing key
+func (v VictorOpsHandler) routingKey(alert *AlertState) string {
+	if key, ok := v.RoutingKeys[alert.Service]; ok && alert.Service != "" {
+		return key
+	}
+	return v.RoutingKey
+}
+
+func (v VictorOpsHandler) Alert(ctx context.Context, alert *AlertState) error {
+	base := v.URL
+	if base == "" {
+		base = victorOpsDefaultURL
+	}
+	endpoint := fmt.Sprintf("%s/%s/%s", strings.TrimRight(base, "/"), url.PathEscape(v.APIKey), url.PathEscape(v.routingKey(alert)))
+
+	err := postJSON(ctx, endpoint, nil, victorOpsAlert{
+		MessageType: victorOpsMessageType(alert.Status),
+		// The same entity ID for every alert about the target, so recoveries resolve the incident
+		EntityID:          alertTargetKey(alert),
+		EntityDisplayName: alert.Message,
+		StateMessage:      alert.Details,
+		StateStartTime:    time.Now().Unix(),
+		MonitoringTool:    "consul-alerting",
+		EventID:           alert.EventID,
+		Node:              alert.Node,
+		Service:           alert.Service,
+		Tag:               alert.Tag,
+		Datacenter:        alert.Datacenter,
+	})
+	if err != nil {
+		return fmt.Errorf("Error sending alert to VictorOps: %s", err)
+	}
+	return nil
+}
+
+func (v VictorOpsHandler) Validate() error {
+	if v.APIKey == "" {
+		return errors.New("no api_key given")
+	}
+	// The default routing key is needed for meta-alerts and services without their own key
+	if v.RoutingKey == "" {
+		return errors.New("no routing_key given")
+	}
+	for service, key := range v.RoutingKeys {
+		if key == "" {
+			return fmt.Errorf("empty routing key for service %q", service)
+		}
+	}
+	return nil
+}
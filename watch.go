@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -10,7 +11,6 @@ import (
 )
 
 const watchWaitTime = 15 * time.Second
-const errorWaitTime = 10 * time.Second
 
 // The settings to use when performing a watch on a service or node
 type WatchOptions struct {
@@ -24,6 +24,10 @@ type WatchOptions struct {
 	// the service will be used when checking its health.
 	tag string
 
+	// The Nomad job being watched. Only set for Nomad jobs, which are otherwise alerted on
+	// like a service of the same name.
+	job string
+
 	// The config to use for the watch
 	config *Config
 
@@ -33,8 +37,19 @@ type WatchOptions struct {
 	// A lock to use for avoiding race conditions with quiescence timers when alerting
 	alertLock *sync.Mutex
 
+	// The in-memory state of the watch. Set when the watch starts.
+	state *WatchState
+
 	// A channel to use in order to stop the watch and release its lock.
 	stopCh chan struct{}
+
+	// Optional. Closed when the node/service/tag being watched goes away, to stop the
+	// watch and resolve any alert it has open.
+	removeCh chan struct{}
+
+	// Optional. Counts the watch as running once its lock is set up, so shutdown knows how
+	// many watches to stop.
+	shutdown *ShutdownOpts
 }
 
 const ServiceWatch = "service"
@@ -61,6 +76,10 @@ This ensures that only one process can manage the alerts for a node/service at a
 that the check/alert state is persisted across restarts/lock acquisitions.
 */
 func watch(opts *WatchOptions) {
+	// Take a copy of the options so the state we attach below isn't shared with other watches
+	optsCopy := *opts
+	opts = &optsCopy
+
 	// Set wait time to make the consul query block until an update happens
 	client := opts.client
 	queryOpts := &api.QueryOptions{
@@ -72,31 +91,22 @@ func watch(opts *WatchOptions) {
 
 	// Figure out whether we're watching a node or service
 	mode := NodeWatch
-	diffCheckFunc := diffNodeChecks
+	var diffCheckFunc checkDiffFunc = diffNodeChecks
 	if opts.service != "" {
 		mode = ServiceWatch
 		diffCheckFunc = diffServiceChecks
 	}
 
-	name := mode + " " + opts.node
+	name := watchName(opts.node, opts.service, opts.tag)
 
 	// The base path in the consul KV store to keep the state for this watch
-	keyPath := alertingKVRoot + "/node/" + opts.node + "/"
-	if mode == ServiceWatch {
-		name = mode + " " + opts.service
-		tagPath := ""
-		if opts.tag != "" {
-			tagPath = opts.tag + "/"
-			name = name + fmt.Sprintf(" (tag: %s)", opts.tag)
-		}
-		keyPath = alertingKVRoot + "/service/" + opts.service + "/" + tagPath
-	}
+	keyPath := watchKVPath(opts.node, opts.service, opts.tag)
 	lockPath := keyPath + "leader"
 	alertPath := keyPath + "alert"
 
-	// Load previously stored check states for this watch from consul
-	lastCheckStatus := make(map[string]string)
-	lastAlertStatus := api.HealthPassing
+	// The last known check/alert states for this watch, shared with the registry
+	state := newWatchState(name, opts.node, opts.service, opts.tag)
+	opts.state = state
 
 	// Set up a callback to be run when we acquire the lock/gain leadership so we can
 	// load the last check/alert states
@@ -107,17 +117,26 @@ func watch(opts *WatchOptions) {
 			log.Error("Error loading previous check states from consul: ", err)
 		}
 
+		state.Lock()
 		for checkName, checkState := range storedCheckStates {
 			log.Debugf("Loaded check %s for %s, state: %s", checkName, name, checkState.Status)
-			lastCheckStatus[checkName] = checkState.Status
+			state.Checks[checkName] = checkState.Status
 		}
+		state.Unlock()
 	}
 
 	// Set up the lock this thread will use to determine leader status
 	apiLock, err := client.LockKey(lockPath)
 
 	if err != nil {
-		log.Fatalf("Error initializing lock for %s: %s", name, err)
+		log.Errorf("Error initializing lock for %s, not starting watch: %s", name, err)
+		return
+	}
+
+	// Getting the lock means the ACLs are allowing it again if they weren't
+	acquired := func() {
+		state.permissionRestored(aclSourceLock, opts)
+		loadCheckStates()
 	}
 
 	lock := LockHelper{
@@ -127,11 +146,50 @@ func watch(opts *WatchOptions) {
 		lock:     apiLock,
 		stopCh:   make(chan struct{}, 1),
 		lockCh:   make(chan struct{}, 1),
-		callback: loadCheckStates,
+		callback: acquired,
+		errorCallback: func(err error) {
+			if isPermissionDenied(err) {
+				state.permissionDenied(aclSourceLock, err, opts)
+			}
+		},
+	}
+	state.lock = &lock
+
+	// If a previous process handed this watch off to us, pick up where it left off
+	if inherited := inheritedState.take(name); inherited != nil {
+		log.Infof("Resuming %s from previous process", name)
+		state.Checks = inherited.Checks
+		state.Status = inherited.Status
+		lock.session = inherited.Session
+		lock.sessionDone = inherited.sessionDone
+		for _, pending := range inherited.Pending {
+			pending := pending
+			go runRecovered("alert timer for "+name, func() { resumeAlert(pending, opts) })
+		}
 	}
+
+	// Count the watch as running until it exits, however it exits
+	opts.shutdown.add(1)
+	defer opts.shutdown.add(-1)
+
+	runningWatches.add(state)
 	go lock.start()
 
+	// If the watch panics, release its lock and registrations so it can be restarted cleanly
+	defer func() {
+		if r := recover(); r != nil {
+			heartbeats.remove(name)
+			consulHealth.remove(name)
+			runningWatches.remove(name)
+			lock.stop()
+			panic(r)
+		}
+	}()
+
 	log.Debugf("Initialized watch for %s", name)
+	backoff := newBackoff()
+	grace := newElectionGrace(opts.config)
+	tracker := newIndexTracker(name)
 
 	// The main loop for the watch, do blocking queries to monitor the state of this service/node
 	// and read changes in the health status for potential alerts
@@ -140,11 +198,25 @@ func watch(opts *WatchOptions) {
 		select {
 		case <-opts.stopCh:
 			log.Infof("Shutting down watch for %s", name)
+			heartbeats.remove(name)
+			consulHealth.remove(name)
+			runningWatches.remove(name)
 			lock.stop()
 			<-opts.stopCh
-			break
+			return
+		case <-opts.removeCh:
+			log.Infof("Stopping watch for %s, it's no longer registered", name)
+			if lock.acquired {
+				resolveRemoved(alertPath, name, opts)
+			}
+			heartbeats.remove(name)
+			consulHealth.remove(name)
+			runningWatches.remove(name)
+			lock.stop()
+			return
 		default:
 		}
+		heartbeats.beat(name)
 
 		// Sleep if we don't hold the lock
 		if !lock.acquired {
@@ -157,63 +229,208 @@ func watch(opts *WatchOptions) {
 		var err error
 
 		// Do a blocking query (a consul watch) for the health checks
+		queryStart := time.Now()
 		if mode == NodeWatch {
 			checks, queryMeta, err = client.Health().Node(opts.node, queryOpts)
 		} else {
 			checks, queryMeta, err = client.Health().Checks(opts.service, queryOpts)
 		}
 
-		// Try again in 10s if we got an error during the blocking request
+		// Consul is up but our token can't read this node/service, so there's no point
+		// treating it as an outage
+		if isPermissionDenied(err) {
+			state.permissionDenied(aclSourceQuery, err, opts)
+			wait := backoff.next()
+			log.Errorf("Permission denied trying to watch %s, retrying in %s...", name, wait)
+			time.Sleep(wait)
+			continue
+		}
+
+		// Back off and try again if we got an error during the blocking request
+		consulHealth.record(name, err)
 		if err != nil {
-			log.Errorf("Error trying to watch %s: %s, retrying in 10s...", mode, err)
-			time.Sleep(errorWaitTime)
+			if isLeaderElectionError(err) {
+				grace.start()
+			}
+			wait := backoff.next()
+			log.Errorf("Error trying to watch %s: %s, retrying in %s...", name, err, wait)
+			time.Sleep(wait)
 			continue
 		}
+		backoff.reset()
+		state.permissionRestored(aclSourceQuery, opts)
+
+		// Update our WaitIndex for the next query. If the index went backwards the results
+		// may be incomplete, so give the cluster time to settle before trusting them.
+		var anomaly string
+		queryOpts.WaitIndex, anomaly = tracker.update(queryMeta.LastIndex, time.Since(queryStart))
+		switch anomaly {
+		case indexBackwards:
+			grace.start()
+			continue
+		case indexFastReturn:
+			time.Sleep(minQueryInterval)
+		}
 
-		// Update our WaitIndex for the next query
-		queryOpts.WaitIndex = queryMeta.LastIndex
+		// Results can be incomplete right after a leader election, so hold the current
+		// state steady until the cluster has had time to settle
+		if grace.active() {
+			log.Debugf("Ignoring update for %s during leader election grace period", name)
+			continue
+		}
 
-		// Filter out health checks whose statuses haven't changed
-		updates := diffCheckFunc(checks, lastCheckStatus, opts)
+		// Keep track of instances coming and going, in case the service is mid-rollout
+		if mode == ServiceWatch {
+			rollouts.observe(opts.service, checks, time.Now())
+		}
 
-		// If there's any health check status changes, try to update the remote/local check caches and
-		// see if the alert status changed. If it has, we start a quiescence timer that will alert if
-		// it lives past the changeThreshold
-		if len(updates) > 0 {
-			success := true
+		// Failing checks the service's output rules suppress are treated as passing
+		checks = opts.config.suppressChecks(opts.service, checks)
 
-			// Try to write the health updates to consul
-			for _, update := range updates {
-				log.Debugf("Got health check update for '%s' (%s) for %s", update.HealthCheck.Name, update.Status, name)
-				if !updateCheckState(update, client) {
-					success = false
-				}
-			}
+		processChecks(name, mode, alertPath, checks, diffCheckFunc, opts)
+		state.resolveIfGone(name, alertPath, checks, opts, time.Now())
+		state.checkCapacity(alertPath, opts, time.Now())
+		remindAlert(alertPath, opts, time.Now())
+	}
+}
 
-			// Update the alert details to include info about any failing checks
-			alert := AlertState{}
-			if mode == NodeWatch {
-				alert.Details = nodeDetails(checks)
-			} else {
-				alert.Details = serviceDetails(checks)
-			}
+// Returns the details for an alert about the watch's node or service: its failing checks,
+// plus the services affected for a node that's down, or how many instances are failing for
+// a service with a failing_percent, and the link to it in the Consul UI
+func alertDetails(opts *WatchOptions, checks []*api.HealthCheck, link string) string {
+	var details string
+	if opts.service == "" {
+		details = nodeDetails(checks, opts.config.CheckOutputLimit)
+		if affected := nodeDownDetails(opts.config, opts.client, opts.node, checks); affected != "" {
+			details = strings.TrimSpace(details + "\n\n" + affected)
+		}
+	} else {
+		details = serviceDetails(checks, opts.config.CheckOutputLimit)
+		if details != "" {
+			details = addK8sDetails(opts.config, opts.client, opts.service, checks, details)
+		}
+		if aggregate := aggregateDetails(opts.config, opts.service, checks); aggregate != "" {
+			details = strings.TrimSpace(aggregate + "\n\n" + details)
+		}
+	}
+	if link != "" {
+		details = strings.TrimSpace(details + "\n\nConsul UI: " + link)
+	}
+	return details
+}
 
-			if success {
-				for checkHash, update := range updates {
-					lastCheckStatus[checkHash] = update.Status
-				}
+// Processes the results of a query for a watch's health checks, storing any changes and
+// starting a timer to alert if the node/service's health changed. The locks are released
+// with defers, so a panic here can't leave them held when the watch restarts.
+func processChecks(name, mode, alertPath string, checks []*api.HealthCheck, diffCheckFunc checkDiffFunc, opts *WatchOptions) {
+	// Filter out health checks whose statuses haven't changed
+	updates := opts.state.checkUpdates(checks, diffCheckFunc, opts)
+	if len(updates) == 0 {
+		return
+	}
 
-				// If the alert status changed, try to trigger an alert
-				newStatus := computeHealth(lastCheckStatus)
-				if lastAlertStatus != newStatus {
-					lastAlertStatus = newStatus
-					alert.Status = newStatus
-					alert.Message = fmt.Sprintf("[%s] %s is now %s", opts.config.ConsulDatacenter, name, newStatus)
-					go tryAlert(alertPath, alert, opts)
-				}
-			}
+	// Build the alert details to include info about any failing checks. This can involve
+	// looking up the service in the catalog, so it's done before taking handoffLock.
+	alert := AlertState{
+		Checks: failingChecks(opts.config, checks, mode == NodeWatch),
+		Link:   consulUILink(opts.config, opts.node, opts.service),
+	}
+	alert.Details = alertDetails(opts, checks, alert.Link)
+
+	// Hold off on processing updates while we're handing off to a new process
+	handoffLock.RLock()
+	defer handoffLock.RUnlock()
+
+	// There's some health check status changes, so try to update the remote/local check caches
+	// and see if the alert status changed. If it has, we start a quiescence timer that will
+	// alert if it lives past the changeThreshold
+	success := true
+
+	// Try to write the health updates to consul
+	for _, update := range updates {
+		log.Debugf("Got health check update for '%s' (%s) for %s", update.HealthCheck.Name, update.Status, name)
+		if !updateCheckState(update, opts.client) {
+			success = false
 		}
 	}
+	if !success {
+		return
+	}
+
+	// If the alert status changed, try to trigger an alert. Store the alert and register it
+	// as pending before releasing handoffLock, so a handoff can't lose it; only the wait
+	// runs in the background.
+	newStatus, changed := opts.state.applyUpdates(opts.config, updates)
+	if !changed {
+		return
+	}
+	alert.Status = newStatus
+	opts.config.applySeverity(opts.service, &alert)
+	alert.Message = alertMessage(opts.config.ConsulDatacenter, name, newStatus)
+	if updateIndex, ok := queueAlert(alertPath, alert, opts); ok {
+		go runRecovered("alert timer for "+name, func() { waitAlert(alertPath, alert, updateIndex, opts) })
+	}
+}
+
+// Compares the results of a health check query to the last known statuses of the checks
+type checkDiffFunc func(checks []*api.HealthCheck, lastStatus map[string]string, opts *WatchOptions) map[string]CheckUpdate
+
+// Returns the checks whose statuses changed since they were last stored in the watch state
+func (s *WatchState) checkUpdates(checks []*api.HealthCheck, diffCheckFunc checkDiffFunc, opts *WatchOptions) map[string]CheckUpdate {
+	s.Lock()
+	defer s.Unlock()
+	return diffCheckFunc(checks, s.Checks, opts)
+}
+
+// Stores check updates in the watch state, returning the resulting health and whether it
+// changed
+func (s *WatchState) applyUpdates(config *Config, updates map[string]CheckUpdate) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	for checkHash, update := range updates {
+		s.Checks[checkHash] = update.Status
+	}
+
+	newStatus := config.serviceHealth(s.Service, s.Checks)
+	if s.Status == newStatus {
+		return newStatus, false
+	}
+	s.Status = newStatus
+	s.changedAt = time.Now()
+	return newStatus, true
+}
+
+// Returns the display name for a watch on the given service/tag, or node if service is empty
+func watchName(node, service, tag string) string {
+	if service == "" {
+		return NodeWatch + " " + node
+	}
+
+	name := ServiceWatch + " " + service
+	if tag != "" {
+		name = name + fmt.Sprintf(" (tag: %s)", tag)
+	}
+	return name
+}
+
+// Returns the base path in the Consul KV store used to keep the state for a watch on the
+// given service/tag, or node if service is empty
+func watchKVPath(node, service, tag string) string {
+	if service == "" {
+		return alertingKVRoot + "/node/" + node + "/"
+	}
+
+	tagPath := ""
+	if tag != "" {
+		tagPath = tag + "/"
+	}
+	return alertingKVRoot + "/service/" + service + "/" + tagPath
+}
+
+// Returns the message to use for an alert about the named watch changing status
+func alertMessage(datacenter, name, status string) string {
+	return fmt.Sprintf("[%s] %s is now %s", datacenter, name, status)
 }
 
 // Returns a map of checks whose status differs from their entry in lastStatus
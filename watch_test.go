@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -17,8 +18,9 @@ type testHandler struct {
 	alerts chan *AlertState
 }
 
-func (t testHandler) Alert(alert *AlertState) {
+func (t testHandler) Alert(ctx context.Context, alert *AlertState) error {
 	t.alerts <- alert
+	return nil
 }
 
 // Create a test Consul server and a client for making calls to it
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const webexDefaultURL = "https://webexapis.com/v1/messages"
+
+// Webex rejects messages with more markdown than this
+const webexMaxLength = 7439
+
+// WebexHandler posts alerts to a Webex room through a bot
+type WebexHandler struct {
+	BotToken string `mapstructure:"bot_token"`
+	RoomID   string `mapstructure:"room_id"`
+	URL      string `mapstructure:"url"`
+}
+
+// The body of a request to create a message
+type webexMessage struct {
+	RoomID   string `json:"roomId"`
+	Text     string `json:"text"`
+	Markdown string `json:"markdown"`
+}
+
+// Returns the emoji shown before an alert's message for its status
+func webexEmoji(status string) string {
+	switch status {
+	case api.HealthPassing:
+		return "✅"
+	case api.HealthWarning:
+		return "⚠️"
+	case api.HealthCritical:
+		return "\U0001f534"
+	}
+	return "❓"
+}
+
+// Returns the markdown of a message for an alert, with the message in bold and the details
+// in a code block
+func webexMarkdown(alert *AlertState) string {
+	text := webexEmoji(alert.Status) + " **" + alert.Message + "**"
+	if alert.Details == "" {
+		return text
+	}
+
+	const open, close = "\n```\n", "\n```"
+	details := strings.Replace(alert.Details, "```", "'''", -1)
+	if limit := webexMaxLength - len(text+open+close); len(details) > limit {
+		if limit <= len("...") {
+			return text
+		}
+		// Back up to the start of a rune, so we don't cut one in half
+		cut := limit - len("...")
+		for cut > 0 && !utf8.RuneStart(details[cut]) {
+			cut--
+		}
+		details = details[:cut] + "..."
+	}
+	return text + open + details + close
+}
+
+func (w WebexHandler) Alert(ctx context.Context, alert *AlertState) error {
+	endpoint := w.URL
+	if endpoint == "" {
+		endpoint = webexDefaultURL
+	}
+
+	err := postJSON(ctx, endpoint, map[string]string{"Authorization": "Bearer " + w.BotToken}, webexMessage{
+		RoomID:   w.RoomID,
+		Text:     alert.Message,
+		Markdown: webexMarkdown(alert),
+	})
+	if err != nil {
+		return fmt.Errorf("Error sending alert to Webex: %s", err)
+	}
+	return nil
+}
+
+func (w WebexHandler) Validate() error {
+	if w.BotToken == "" {
+		return errors.New("no bot_token given")
+	}
+	if w.RoomID == "" {
+		return errors.New("no room_id given")
+	}
+	return nil
+}
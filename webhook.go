@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// WebhookHandler sends alerts to an arbitrary HTTP endpoint, with a payload rendered from
+// a template
+type WebhookHandler struct {
+	URL         string            `mapstructure:"url"`
+	Method      string            `mapstructure:"method"`
+	Headers     map[string]string `mapstructure:"headers"`
+	ContentType string            `mapstructure:"content_type"`
+
+	// A template for the request body. If neither this nor Form is set, the alert is sent
+	// as JSON.
+	Payload string `mapstructure:"payload"`
+
+	// Templates for the fields of a form-encoded request body, instead of Payload
+	Form map[string]string `mapstructure:"form"`
+
+	// A shared secret to sign the body with, so the receiver can verify the request came
+	// from us. With HMACTimestamp the signature also covers the time it was sent, so old
+	// requests can't be replayed.
+	HMACSecret    string `mapstructure:"hmac_secret"`
+	HMACHeader    string `mapstructure:"hmac_header"`
+	HMACTimestamp bool   `mapstructure:"hmac_timestamp"`
+}
+
+const (
+	webhookSignatureHeader = "X-Consul-Alerting-Signature"
+	webhookTimestampHeader = "X-Consul-Alerting-Timestamp"
+)
+
+// Returns the signature header for a request body sent at the given time, as sha256=
+// followed by the hex HMAC-SHA256 of the body, or of "<timestamp>.<body>" with HMACTimestamp
+func (w WebhookHandler) signature(body string, now time.Time) string {
+	mac := hmac.New(sha256.New, []byte(w.HMACSecret))
+	if w.HMACTimestamp {
+		mac.Write([]byte(strconv.FormatInt(now.Unix(), 10) + "."))
+	}
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the request body and content type for an alert
+func (w WebhookHandler) body(alert *AlertState) (string, string, error) {
+	data := newAlertTemplateData("", alert, time.Now())
+
+	if len(w.Form) > 0 {
+		// Render the fields in order, so any error is reported consistently
+		keys := make([]string, 0, len(w.Form))
+		for key := range w.Form {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		form := url.Values{}
+		for _, key := range keys {
+			tmpl, err := parseAlertTemplate("form."+key, w.Form[key])
+			if err != nil {
+				return "", "", err
+			}
+			value := ""
+			if tmpl != nil {
+				if value, err = executeTemplate(tmpl, data); err != nil {
+					return "", "", err
+				}
+			}
+			form.Set(key, value)
+		}
+		return form.Encode(), w.contentType("application/x-www-form-urlencoded"), nil
+	}
+
+	if w.Payload != "" {
+		tmpl, err := parseAlertTemplate("payload", w.Payload)
+		if err != nil {
+			return "", "", err
+		}
+		payload, err := executeTemplate(tmpl, data)
+		return payload, w.contentType("application/json"), err
+	}
+
+	payload, err := json.Marshal(alert)
+	return string(payload), w.contentType("application/json"), err
+}
+
+func (w WebhookHandler) contentType(fallback string) string {
+	if w.ContentType != "" {
+		return w.ContentType
+	}
+	return fallback
+}
+
+func (w WebhookHandler) Alert(ctx context.Context, alert *AlertState) error {
+	body, contentType, err := w.body(alert)
+	if err != nil {
+		return fmt.Errorf("Error rendering webhook payload: %s", err)
+	}
+
+	method := w.Method
+	if method == "" {
+		method = "POST"
+	}
+	req, err := http.NewRequest(strings.ToUpper(method), w.URL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range w.Headers {
+		req.Header.Set(key, value)
+	}
+	if w.HMACSecret != "" {
+		now := time.Now()
+		header := w.HMACHeader
+		if header == "" {
+			header = webhookSignatureHeader
+		}
+		req.Header.Set(header, w.signature(body, now))
+		if w.HMACTimestamp {
+			req.Header.Set(webhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+		}
+	}
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Error sending alert to webhook: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Error sending alert to webhook: got response code %d (%s)", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (w WebhookHandler) Validate() error {
+	if w.URL == "" {
+		return errors.New("no url given")
+	}
+	if w.Payload != "" && len(w.Form) > 0 {
+		return errors.New("only one of payload and form can be given")
+	}
+	if w.HMACSecret == "" && (w.HMACHeader != "" || w.HMACTimestamp) {
+		return errors.New("hmac_header and hmac_timestamp require an hmac_secret")
+	}
+
+	templates := map[string]string{"payload": w.Payload}
+	for key, value := range w.Form {
+		templates["form."+key] = value
+	}
+	for name, text := range templates {
+		if _, err := template.New(name).Funcs(templateFuncs).Parse(text); err != nil {
+			return fmt.Errorf("invalid %s template: %s", name, err)
+		}
+	}
+	return nil
+}
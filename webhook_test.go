@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhook_payload(t *testing.T) {
+	var method, contentType, auth, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		contentType = r.Header.Get("Content-Type")
+		auth = r.Header.Get("Authorization")
+		raw, _ := ioutil.ReadAll(r.Body)
+		body = string(raw)
+	}))
+	defer server.Close()
+
+	config, err := ParseConfig(`
+handler "webhook" "incidents" {
+  url = "` + server.URL + `"
+  method = "put"
+  headers {
+    Authorization = "Bearer token"
+  }
+  payload = "{\"summary\": {{.Message | toJson}}, \"service\": {{.Service | toJson}}}"
+}
+
+handler "webhook" "form" {
+  url = "` + server.URL + `"
+  form {
+    status = "{{.Status | upper}}"
+    name = "{{.Name}}"
+  }
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &AlertState{Status: "critical", Service: "redis", Message: `redis is "down"`}
+	if err := config.Handlers["webhook.incidents"].Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if method != "PUT" || contentType != "application/json" || auth != "Bearer token" {
+		t.Errorf("unexpected request: %s %s %s", method, contentType, auth)
+	}
+	if expected := `{"summary": "redis is \"down\"", "service": "redis"}`; body != expected {
+		t.Errorf("expected body %s, got %s", expected, body)
+	}
+
+	if err := config.Handlers["webhook.form"].Alert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if method != "POST" || contentType != "application/x-www-form-urlencoded" {
+		t.Errorf("unexpected request: %s %s", method, contentType)
+	}
+	if expected := "name=service+redis&status=CRITICAL"; body != expected {
+		t.Errorf("expected body %s, got %s", expected, body)
+	}
+}
+
+func TestWebhook_hmac(t *testing.T) {
+	var signature, timestamp, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Signature")
+		timestamp = r.Header.Get("X-Consul-Alerting-Timestamp")
+		raw, _ := ioutil.ReadAll(r.Body)
+		body = string(raw)
+	}))
+	defer server.Close()
+
+	handler := WebhookHandler{URL: server.URL, HMACSecret: "secret", HMACHeader: "X-Signature", HMACTimestamp: true}
+	if err := handler.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.Alert(context.Background(), &AlertState{Status: "critical", Service: "redis"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the request the way a receiver would
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Fatalf("unexpected timestamp header %q", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(timestamp + "." + body))
+	if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != expected {
+		t.Errorf("expected signature %s, got %s", expected, signature)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const zendutyDefaultURL = "https://www.zenduty.com/api/events"
+
+// ZendutyHandler sends alerts to a Zenduty API integration. Alerts about the same
+// node/service share an entity ID, so recoveries resolve the incident they created.
+type ZendutyHandler struct {
+	IntegrationKey string `mapstructure:"integration_key"`
+	URL            string `mapstructure:"url"`
+}
+
+// The body of a request to a Zenduty integration
+type zendutyEvent struct {
+	AlertType string            `json:"alert_type"`
+	Message   string            `json:"message"`
+	Summary   string            `json:"summary"`
+	EntityID  string            `json:"entity_id"`
+	Payload   map[string]string `json:"payload"`
+	URLs      []zendutyURL      `json:"urls,omitempty"`
+}
+
+type zendutyURL struct {
+	LinkURL  string `json:"link_url"`
+	LinkText string `json:"link_text"`
+}
+
+// Returns the Zenduty alert type for a Consul health status
+func zendutyAlertType(status string) string {
+	switch status {
+	case api.HealthPassing:
+		return "resolved"
+	case api.HealthWarning:
+		return "warning"
+	}
+	return "critical"
+}
+
+// Returns the event to send to Zenduty for an alert
+func zendutyAlertEvent(alert *AlertState) zendutyEvent {
+	event := zendutyEvent{
+		AlertType: zendutyAlertType(alert.Status),
+		Message:   alert.Message,
+		Summary:   alert.Details,
+		EntityID:  alert.Datacenter + "-" + alertTargetKey(alert),
+		Payload:   map[string]string{"status": alert.Status},
+	}
+	for key, value := range map[string]string{
+		"datacenter": alert.Datacenter,
+		"service":    alert.Service,
+		"tag":        alert.Tag,
+		"node":       alert.Node,
+		"event_id":   alert.EventID,
+	} {
+		if value != "" {
+			event.Payload[key] = value
+		}
+	}
+	if alert.Link != "" {
+		event.URLs = []zendutyURL{{LinkURL: alert.Link, LinkText: "Consul UI"}}
+	}
+	return event
+}
+
+func (z ZendutyHandler) Alert(ctx context.Context, alert *AlertState) error {
+	base := z.URL
+	if base == "" {
+		base = zendutyDefaultURL
+	}
+	// Zenduty redirects requests without the trailing slash
+	endpoint := strings.TrimRight(base, "/") + "/" + url.PathEscape(z.IntegrationKey) + "/"
+
+	if err := postJSON(ctx, endpoint, nil, zendutyAlertEvent(alert)); err != nil {
+		return fmt.Errorf("Error sending alert to Zenduty: %s", err)
+	}
+	return nil
+}
+
+func (z ZendutyHandler) Validate() error {
+	if z.IntegrationKey == "" {
+		return errors.New("no integration_key given")
+	}
+	return nil
+}
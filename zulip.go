@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The topic alerts are posted under by default, so each service (or node) gets its own thread
+const zulipDefaultTopic = `{{if .Service}}{{.Service}}{{else}}{{.Node}}{{end}}`
+
+// Zulip truncates longer topic names
+const zulipMaxTopicLength = 60
+
+// ZulipHandler posts alerts to a Zulip stream through a bot
+type ZulipHandler struct {
+	URL    string `mapstructure:"url"`
+	Email  string `mapstructure:"email"`
+	APIKey string `mapstructure:"api_key"`
+	Stream string `mapstructure:"stream"`
+
+	// A template for the topic to post alerts under, rendered with AlertTemplateData
+	Topic string `mapstructure:"topic"`
+}
+
+// Returns the topic to post an alert under
+func (z ZulipHandler) topic(alert *AlertState) (string, error) {
+	text := z.Topic
+	if text == "" {
+		text = zulipDefaultTopic
+	}
+	tmpl, err := parseAlertTemplate("topic", text)
+	if err != nil {
+		return "", err
+	}
+	topic, err := executeTemplate(tmpl, newAlertTemplateData("", alert, time.Now()))
+	if err != nil {
+		return "", err
+	}
+
+	if runes := []rune(topic); len(runes) > zulipMaxTopicLength {
+		topic = string(runes[:zulipMaxTopicLength])
+	}
+	if topic == "" {
+		topic = "consul-alerting"
+	}
+	return topic, nil
+}
+
+func (z ZulipHandler) Alert(ctx context.Context, alert *AlertState) error {
+	topic, err := z.topic(alert)
+	if err != nil {
+		return fmt.Errorf("Error rendering Zulip topic: %s", err)
+	}
+
+	content := "**" + alert.Message + "**"
+	if alert.Details != "" {
+		content = content + "\n```\n" + alert.Details + "\n```"
+	}
+
+	form := url.Values{}
+	form.Set("type", "stream")
+	form.Set("to", z.Stream)
+	form.Set("topic", topic)
+	form.Set("content", content)
+
+	req, err := http.NewRequest("POST", strings.TrimRight(z.URL, "/")+"/api/v1/messages", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(z.Email, z.APIKey)
+
+	resp, err := handlerHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Error sending alert to Zulip: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Error sending alert to Zulip: got response code %d (%s)", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (z ZulipHandler) Validate() error {
+	if z.URL == "" {
+		return errors.New("no url given")
+	}
+	if z.Email == "" || z.APIKey == "" {
+		return errors.New("email and api_key must be given")
+	}
+	if z.Stream == "" {
+		return errors.New("no stream given")
+	}
+	if _, err := parseAlertTemplate("topic", z.Topic); err != nil {
+		return fmt.Errorf("invalid topic template: %s", err)
+	}
+	return nil
+}